@@ -1,12 +1,16 @@
 package ecs
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/endpoints"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 
 	//"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
 	//"github.com/aliyun/alibaba-cloud-sdk-go/sdk/endpoints"
@@ -17,6 +21,9 @@ import (
 	"time"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
 	"github.com/hashicorp/packer/template/interpolate"
 	"github.com/hashicorp/packer/version"
 	"github.com/mitchellh/go-homedir"
@@ -33,9 +40,16 @@ type ApsaraStackAccessConfig struct {
 	// ApsaraStack region must be provided unless `profile` is set, but it can
 	// also be sourced from the `APSARASTACK_REGION` environment variable.
 	ApsaraStackRegion string `mapstructure:"region" required:"true"`
-	// The region validation can be skipped if this value is true, the default
-	// value is false.
+	// Packer checks that `region` and the copy regions are among the regions
+	// the credentials can see, through `DescribeRegions`, when the template
+	// is validated. The check can be skipped if this value is true, for stacks
+	// which don't serve `DescribeRegions`, a wrong region then only fails once
+	// the build talks to it. The default value is false.
 	ApsaraStackSkipValidation bool `mapstructure:"skip_region_validation" required:"false"`
+	// The check that `resource_group` and `department` exist, through the
+	// ASCM API, can be skipped if this value is true. The default value is
+	// false.
+	ApsaraStackSkipResourceGroupValidation bool `mapstructure:"skip_resource_group_validation" required:"false"`
 	// The image validation can be skipped if this value is true, the default
 	// value is false.
 	ApsaraStackSkipImageValidation bool `mapstructure:"skip_image_validation" required:"true"`
@@ -45,8 +59,10 @@ type ApsaraStackAccessConfig struct {
 	// ApsaraStack shared credentials file path. If this file exists, access and
 	// secret keys will be read from this file.
 	ApsaraStackSharedCredentialsFile string `mapstructure:"shared_credentials_file" required:"false"`
-	// STS access token, can be set through template or by exporting as
-	// environment variable such as `export SECURITY_TOKEN=value`.
+	// STS access token of temporary credentials, such as the ones of an
+	// assumed RAM role. It requires `access_key` and `secret_key` to be the
+	// temporary keys issued along with it. It can also be sourced from the
+	// `APSARASTACK_SECURITY_TOKEN` or `SECURITY_TOKEN` environment variable.
 	SecurityToken string   `mapstructure:"security_token" required:"false"`
 	AS_Insecure   bool     `mapstructure:"insecure" required:"false"`
 	Proxy         string   `mapstructure:"proxy" required:"false"`
@@ -56,22 +72,98 @@ type ApsaraStackAccessConfig struct {
 	Department    string   `mapstructure:"department" required:"false"`
 	ResourceGroup string   `mapstructure:"resource_group" required:"false"`
 	BootCommand   []string `mapstructure:"boot_command" required:"false"`
+	// The ECS API version, such as `2014-05-26`, sent with every request.
+	// Older ApsaraStack stacks may not recognize the parameters of the
+	// version the SDK speaks by default. If this option is not set, the
+	// version compiled into the SDK is used.
+	EcsApiVersion string `mapstructure:"ecs_api_version" required:"false"`
+	// Endpoints of the services, keyed by the service code `ecs`, `vpc`,
+	// `ram`, `ascm`, `slb` or `sts`, for stacks whose internal endpoints aren't
+	// resolved on their own. The `ecs` endpoint takes precedence over `endpoint`, the other
+	// services talk to the ECS endpoint when they aren't listed.
+	Endpoints map[string]string `mapstructure:"endpoints" required:"false"`
+	// The proxy the API requests over HTTP go through, such as
+	// `http://proxy:3128`. It can also be sourced from the `HTTP_PROXY`
+	// environment variable, `proxy` is used when neither is set.
+	HttpProxy string `mapstructure:"http_proxy" required:"false"`
+	// The proxy the API requests over HTTPS go through. It can also be
+	// sourced from the `HTTPS_PROXY` environment variable.
+	HttpsProxy string `mapstructure:"https_proxy" required:"false"`
+	// Comma separated hosts which are reached without the proxy. It can also
+	// be sourced from the `NO_PROXY` environment variable.
+	NoProxy string `mapstructure:"no_proxy" required:"false"`
+	// The backoff before retrying a throttled API request, such as `2s`. It
+	// doubles with each retry, up to a minute, and is spread by a random
+	// jitter. The default value is `1s`.
+	ThrottlingRetryBaseDelay time.Duration `mapstructure:"throttling_retry_base_delay" required:"false"`
+	// How many times a throttled API request is retried. The default value
+	// is 8.
+	ThrottlingRetryTimes int `mapstructure:"throttling_retry_times" required:"false"`
+	// How long to wait for the connection of an API request, such as `10s`.
+	// The default value is `5s`.
+	ApiConnectTimeout time.Duration `mapstructure:"api_connect_timeout" required:"false"`
+	// How long to wait for the response of an API request once connected,
+	// such as `30s`. Requests which time out are retried like other failed
+	// requests. The default value is `10s`.
+	ApiReadTimeout time.Duration `mapstructure:"api_read_timeout" required:"false"`
+	// The number of API requests which may wait for their response at the
+	// same time, across all the steps of the build. Requests beyond the
+	// limit wait for one to finish, which keeps builds copying the image to
+	// many regions from tripping the throttling of the API. By default the
+	// number of requests isn't limited.
+	MaxConcurrentApiCalls int `mapstructure:"max_concurrent_api_calls" required:"false"`
+	// How many idle connections to the API are kept open for reuse. The
+	// default value is 10.
+	ApiMaxIdleConns int `mapstructure:"api_max_idle_conns" required:"false"`
+	// How long an idle connection to the API is kept open, such as `30s`.
+	// The default value is `90s`.
+	ApiIdleConnTimeout time.Duration `mapstructure:"api_idle_conn_timeout" required:"false"`
+	// Open a new connection for every API request instead of reusing them.
+	// The default value is false.
+	ApiDisableKeepAlives bool `mapstructure:"api_disable_keep_alives" required:"false"`
+	// Assume a RAM role through STS, such as one of another account, and
+	// sign every request of the build with its temporary credentials
+	// instead of the keys above. The block supports:
+	//
+	// -   `role_arn` (string) - The ARN of the role, such as
+	//     `acs:ram::1234567890:role/packer`.
+	//
+	// -   `session_name` (string) - The name of the role session. The
+	//     default value is `packer-` followed by the current unix time.
+	//
+	// -   `policy` (string) - A JSON policy document which further narrows
+	//     the permissions of the role.
+	//
+	// -   `duration_seconds` (int) - How long the temporary credentials are
+	//     valid, they are renewed before they expire. The default value is
+	//     3600.
+	//
+	// This is unrelated to `ram_role_name`, which is the role of the
+	// instance.
+	AssumeRole ApsaraStackAssumeRole `mapstructure:"assume_role" required:"false"`
 
 	client *ClientWrapper
+	// The regions DescribeRegions returned, they are looked up once.
+	supportedRegions []string
 }
 
+// The service codes whose endpoint can be set through endpoints.
+var EndpointServices = []string{"ecs", "vpc", "ram", "ascm", "slb", "sts"}
+
 const Packer = "HashiCorp-Packer"
 const DefaultRequestReadTimeout = 10 * time.Second
+const DefaultRequestConnectTimeout = 5 * time.Second
+
+const (
+	defaultApiMaxIdleConns    = 10
+	defaultApiIdleConnTimeout = 90 * time.Second
+)
 
 // Client for ApsaraStackClient
 func (c *ApsaraStackAccessConfig) Client() (*ClientWrapper, error) {
 	if c.client != nil {
 		return c.client, nil
 	}
-	if c.SecurityToken == "" {
-		c.SecurityToken = os.Getenv("SECURITY_TOKEN")
-	}
-
 	var getProviderConfig = func(str string, key string) string {
 		value, err := getConfigFromProfile(c, key)
 		if err == nil && value != nil {
@@ -116,28 +208,133 @@ func (c *ApsaraStackAccessConfig) Client() (*ClientWrapper, error) {
 	if c.client != nil {
 		return c.client, nil
 	}
-	endpoints.AddEndpointMapping(c.ApsaraStackRegion, "ECS", c.Endpoint)
+	ecsEndpoint := c.serviceEndpoint("ecs", c.Endpoint)
+	endpoints.AddEndpointMapping(c.ApsaraStackRegion, "ECS", ecsEndpoint)
 	//endpoints.AddEndpointMapping(c.ApsaraStackRegion,"OSS","oss-cn-qingdao-env66-d01-a.intra.env66.shuguang.com")
 	//	client, err := ecs.NewClientWithAccessKey(c.ApsaraStackRegion,c.ApsaraStackAccessKey,c.ApsaraStackSecretKey)
 	//	client, err := ecs.NewClientWithOptions(c.ApsaraStackRegion, c.getSdkConfig().WithTimeout(time.Duration(60)*time.Second), credentials.NewAccessKeyCredential(c.ApsaraStackRegion, c.ApsaraStackAccessKey))
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize the ECS client: %#v", err)
 	}
-	client.Domain = c.Endpoint
+	client.Domain = ecsEndpoint
 	//client.Domain = "oss-cn-qingdao-env66-d01-a.intra.env66.shuguang.com"
 	//c.OSS_Endpoint= "oss-cn-qingdao-env66-d01-a.intra.env66.shuguang.com"
 	//c.Product = "ecs"
 
 	client.SetHTTPSInsecure(true)
-	if c.Proxy != "" {
+	if c.HttpProxy != "" {
+		client.SetHttpProxy(c.HttpProxy)
+	} else if c.Proxy != "" {
 		client.SetHttpProxy(c.Proxy)
 	}
+	client.SetHttpsProxy(c.HttpsProxy)
+	client.SetNoProxy(c.NoProxy)
 	client.AppendUserAgent(Packer, version.FormattedVersion())
-	client.SetReadTimeout(DefaultRequestReadTimeout)
-	c.client = &ClientWrapper{client}
+	client.SetConnectTimeout(c.ApiConnectTimeout)
+	client.SetReadTimeout(c.ApiReadTimeout)
+	enableAPILogging(&client.Client, "ECS")
+	c.client = &ClientWrapper{
+		Client:                   client,
+		ThrottlingRetryBaseDelay: c.ThrottlingRetryBaseDelay,
+		ThrottlingRetryTimes:     c.ThrottlingRetryTimes,
+		transport:                c.apiTransport(),
+	}
+	// The transport is the template of the clients, none of them uses it
+	// as such since the SDK changes it on every request.
+	client.SetTransport(cloneTransport(c.client.transport))
+	if c.AssumeRole.RoleArn != "" {
+		signer, err := c.assumeRole(c.client)
+		if err != nil {
+			c.client = nil
+			return nil, err
+		}
+		client.SetSigner(signer)
+		c.client.signer = signer
+	}
+	if c.EcsApiVersion != "" {
+		c.client.SetApiVersion(c.EcsApiVersion)
+	}
 	return c.client, nil
 }
 
+// VpcClient returns a VPC client signing with the same credentials, the STS
+// token included, and talking to the same endpoint as the ECS client.
+func (c *ApsaraStackAccessConfig) VpcClient(client *ClientWrapper) (*VpcClientWrapper, error) {
+	vpcClient, err := vpc.NewClientWithStsToken(c.ApsaraStackRegion, c.ApsaraStackAccessKey, c.ApsaraStackSecretKey, c.SecurityToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize the VPC client: %w", err)
+	}
+	vpcClient.Domain = c.serviceEndpoint("vpc", client.Domain)
+	copyConnectionSettings(&vpcClient.Client, client)
+	enableAPILogging(&vpcClient.Client, "VPC")
+
+	return &VpcClientWrapper{vpcClient}, nil
+}
+
+// SlbClient returns an SLB client signing with the same credentials, the STS
+// token included, and talking to the same endpoint as the ECS client.
+func (c *ApsaraStackAccessConfig) SlbClient(client *ClientWrapper) (*SlbClientWrapper, error) {
+	slbClient, err := slb.NewClientWithStsToken(c.ApsaraStackRegion, c.ApsaraStackAccessKey, c.ApsaraStackSecretKey, c.SecurityToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize the SLB client: %w", err)
+	}
+	slbClient.Domain = c.serviceEndpoint("slb", client.Domain)
+	copyConnectionSettings(&slbClient.Client, client)
+	enableAPILogging(&slbClient.Client, "SLB")
+
+	return &SlbClientWrapper{slbClient}, nil
+}
+
+// assumeRole assumes assume_role once up front, so a role which can't be
+// assumed fails the build before it starts. The STS client signs with the
+// configured keys.
+func (c *ApsaraStackAccessConfig) assumeRole(client *ClientWrapper) (*assumeRoleSigner, error) {
+	stsClient, err := sts.NewClientWithStsToken(c.ApsaraStackRegion, c.ApsaraStackAccessKey, c.ApsaraStackSecretKey, c.SecurityToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize the STS client: %w", err)
+	}
+	stsClient.Domain = c.serviceEndpoint("sts", client.Domain)
+	copyConnectionSettings(&stsClient.Client, client)
+	enableAPILogging(&stsClient.Client, "STS")
+
+	signer := &assumeRoleSigner{config: c, client: stsClient, clock: client.Clock}
+	if err := signer.refresh(); err != nil {
+		return nil, fmt.Errorf("unable to assume role %s: %w", c.AssumeRole.RoleArn, err)
+	}
+
+	return signer, nil
+}
+
+// copyConnectionSettings makes target go through the same proxies and
+// transport settings as client, with the same timeouts, and sign with the credentials
+// of the assumed role if there is one.
+func copyConnectionSettings(target *sdk.Client, client *ClientWrapper) {
+	target.SetHttpProxy(client.GetHttpProxy())
+	target.SetHttpsProxy(client.GetHttpsProxy())
+	target.SetNoProxy(client.GetNoProxy())
+	target.SetConnectTimeout(client.GetConnectTimeout())
+	target.SetReadTimeout(client.GetReadTimeout())
+	// The SDK changes the transport of a client on every request, so every
+	// client gets a copy of its own. The copies share the limit of
+	// max_concurrent_api_calls.
+	if client.transport != nil {
+		target.SetTransport(cloneTransport(client.transport))
+	}
+	if client.signer != nil {
+		target.SetSigner(client.signer)
+	}
+}
+
+// serviceEndpoint returns the endpoint set for the service through
+// endpoints, or fallback when the service isn't listed.
+func (c *ApsaraStackAccessConfig) serviceEndpoint(service string, fallback string) string {
+	if endpoint := c.Endpoints[service]; endpoint != "" {
+		return endpoint
+	}
+
+	return fallback
+}
+
 func (c *ApsaraStackAccessConfig) Prepare(ctx *interpolate.Context) []error {
 	var errs []error
 	if err := c.Config(); err != nil {
@@ -152,6 +349,69 @@ func (c *ApsaraStackAccessConfig) Prepare(ctx *interpolate.Context) []error {
 		errs = append(errs, fmt.Errorf("region option or APSARASTACK_REGION must be provided in template file or environment variables."))
 	}
 
+	for service, endpoint := range c.Endpoints {
+		known := false
+		for _, s := range EndpointServices {
+			if service == s {
+				known = true
+			}
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("endpoints only supports the services %s, got %q", strings.Join(EndpointServices, ", "), service))
+		} else if endpoint == "" {
+			errs = append(errs, fmt.Errorf("endpoints.%s can't be empty", service))
+		}
+	}
+
+	for name, proxy := range map[string]string{"http_proxy": c.HttpProxy, "https_proxy": c.HttpsProxy} {
+		if proxy == "" {
+			continue
+		}
+		if u, err := url.Parse(proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be a URL such as http://proxy:3128, got %q", name, proxy))
+		}
+	}
+
+	if c.ThrottlingRetryBaseDelay < 0 {
+		errs = append(errs, fmt.Errorf("throttling_retry_base_delay can't be negative"))
+	}
+	if c.ThrottlingRetryTimes < 0 {
+		errs = append(errs, fmt.Errorf("throttling_retry_times can't be negative"))
+	}
+
+	if c.ApiConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("api_connect_timeout can't be negative"))
+	} else if c.ApiConnectTimeout == 0 {
+		c.ApiConnectTimeout = DefaultRequestConnectTimeout
+	}
+	if c.ApiReadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("api_read_timeout can't be negative"))
+	} else if c.ApiReadTimeout == 0 {
+		c.ApiReadTimeout = DefaultRequestReadTimeout
+	}
+
+	if c.MaxConcurrentApiCalls < 0 {
+		errs = append(errs, fmt.Errorf("max_concurrent_api_calls can't be negative"))
+	}
+	if c.ApiMaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("api_max_idle_conns can't be negative"))
+	} else if c.ApiMaxIdleConns == 0 {
+		c.ApiMaxIdleConns = defaultApiMaxIdleConns
+	}
+	if c.ApiIdleConnTimeout < 0 {
+		errs = append(errs, fmt.Errorf("api_idle_conn_timeout can't be negative"))
+	} else if c.ApiIdleConnTimeout == 0 {
+		c.ApiIdleConnTimeout = defaultApiIdleConnTimeout
+	}
+
+	errs = append(errs, c.AssumeRole.Prepare()...)
+
+	if c.EcsApiVersion != "" {
+		if _, err := time.Parse("2006-01-02", c.EcsApiVersion); err != nil {
+			errs = append(errs, fmt.Errorf("ecs_api_version must be a date such as %s, got %q", KnownEcsApiVersions[0], c.EcsApiVersion))
+		}
+	}
+
 	if len(errs) > 0 {
 		return errs
 	}
@@ -159,6 +419,21 @@ func (c *ApsaraStackAccessConfig) Prepare(ctx *interpolate.Context) []error {
 	return nil
 }
 
+// isKnownEcsApiVersion reports whether ecs_api_version is unset or one of the
+// versions known to be served by ApsaraStack.
+func (c *ApsaraStackAccessConfig) isKnownEcsApiVersion() bool {
+	if c.EcsApiVersion == "" {
+		return true
+	}
+	for _, version := range KnownEcsApiVersions {
+		if c.EcsApiVersion == version {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *ApsaraStackAccessConfig) Config() error {
 	if c.ApsaraStackAccessKey == "" {
 		c.ApsaraStackAccessKey = os.Getenv("APSARASTACK_ACCESS_KEY")
@@ -172,7 +447,25 @@ func (c *ApsaraStackAccessConfig) Config() error {
 	if c.ApsaraStackSharedCredentialsFile == "" {
 		c.ApsaraStackSharedCredentialsFile = os.Getenv("APSARASTACK_SHARED_CREDENTIALS_FILE")
 	}
+	if c.SecurityToken == "" {
+		c.SecurityToken = os.Getenv("APSARASTACK_SECURITY_TOKEN")
+	}
+	if c.HttpProxy == "" {
+		c.HttpProxy = os.Getenv("HTTP_PROXY")
+	}
+	if c.HttpsProxy == "" {
+		c.HttpsProxy = os.Getenv("HTTPS_PROXY")
+	}
+	if c.NoProxy == "" {
+		c.NoProxy = os.Getenv("NO_PROXY")
+	}
+	if c.SecurityToken == "" {
+		c.SecurityToken = os.Getenv("SECURITY_TOKEN")
+	}
 	if (c.ApsaraStackAccessKey == "" || c.ApsaraStackSecretKey == "") && c.ApsaraStackProfile == "" {
+		if c.SecurityToken != "" {
+			return fmt.Errorf("security_token requires access_key and secret_key, the temporary keys issued along with the token, to be set.")
+		}
 		return fmt.Errorf("APSARASTACK_ACCESS_KEY and APSARASTACK_SECRET_KEY must be set in template file or environment variables.")
 	}
 	return nil
@@ -183,7 +476,7 @@ func (c *ApsaraStackAccessConfig) ValidateRegion(region string) error {
 
 	supportedRegions, err := c.getSupportedRegions()
 	if err != nil {
-		return err
+		return fmt.Errorf("Error querying the regions to validate %s, set skip_region_validation if the stack doesn't serve DescribeRegions: %w", region, err)
 	}
 
 	for _, supportedRegion := range supportedRegions {
@@ -192,10 +485,14 @@ func (c *ApsaraStackAccessConfig) ValidateRegion(region string) error {
 		}
 	}
 
-	return fmt.Errorf("Not a valid ApsaraStack region: %s", region)
+	return fmt.Errorf("Not a valid ApsaraStack region: %s, the available regions are %s", region, strings.Join(supportedRegions, ", "))
 }
 
 func (c *ApsaraStackAccessConfig) getSupportedRegions() ([]string, error) {
+	if c.supportedRegions != nil {
+		return c.supportedRegions, nil
+	}
+
 	client, err := c.Client()
 	if err != nil {
 		return nil, err
@@ -210,10 +507,11 @@ func (c *ApsaraStackAccessConfig) getSupportedRegions() ([]string, error) {
 		return nil, err
 	}
 
-	validRegions := make([]string, len(regionsResponse.Regions.Region))
+	validRegions := make([]string, 0, len(regionsResponse.Regions.Region))
 	for _, valid := range regionsResponse.Regions.Region {
 		validRegions = append(validRegions, valid.RegionId)
 	}
+	c.supportedRegions = validRegions
 
 	return validRegions, nil
 }
@@ -292,6 +590,59 @@ func (c *ApsaraStackAccessConfig) getSdkConfig() *sdk.Config {
 		WithScheme("http")
 }
 
+// apiTransport returns the transport of the API clients. The SDK only sets
+// the connect timeout, TLS and proxy settings up on a bare *http.Transport,
+// so they are set up front for the transport it gets wrapped by the limit of
+// max_concurrent_api_calls.
+func (c *ApsaraStackAccessConfig) apiTransport() http.RoundTripper {
+	transport := c.getTransport()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConns = c.ApiMaxIdleConns
+	transport.MaxIdleConnsPerHost = c.ApiMaxIdleConns
+	transport.IdleConnTimeout = c.ApiIdleConnTimeout
+	transport.DisableKeepAlives = c.ApiDisableKeepAlives
+	transport.DialContext = sdk.Timeout(c.ApiConnectTimeout)
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport.Proxy = c.apiProxy
+
+	if c.MaxConcurrentApiCalls > 0 {
+		return newLimitedTransport(transport, c.MaxConcurrentApiCalls)
+	}
+	return transport
+}
+
+// apiProxy picks the proxy of an API request the way the SDK does.
+func (c *ApsaraStackAccessConfig) apiProxy(request *http.Request) (*url.URL, error) {
+	proxy := c.HttpsProxy
+	if request.URL.Scheme != "https" {
+		proxy = c.HttpProxy
+		if proxy == "" {
+			proxy = c.Proxy
+		}
+	}
+	if proxy == "" {
+		return nil, nil
+	}
+
+	for _, host := range strings.Split(c.NoProxy, ",") {
+		if host == "" {
+			continue
+		}
+		if strings.HasPrefix(host, "*") {
+			host = "." + host
+		}
+		noProxy, err := regexp.Compile(host)
+		if err != nil {
+			return nil, err
+		}
+		if noProxy.MatchString(request.URL.Host) {
+			return nil, nil
+		}
+	}
+
+	return url.Parse(proxy)
+}
+
 func (c *ApsaraStackAccessConfig) getTransport() *http.Transport {
 	handshakeTimeout, err := strconv.Atoi(os.Getenv("TLSHandshakeTimeout"))
 	if err != nil {
@@ -1,8 +1,20 @@
 package ecs
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/signers"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
 )
 
 func testApsaraStackAccessConfig() *ApsaraStackAccessConfig {
@@ -50,3 +62,516 @@ func TestApsaraStackAccessConfigPrepareRegion(t *testing.T) {
 
 	c.ApsaraStackSkipValidation = false
 }
+
+func TestApsaraStackAccessConfigPrepareEcsApiVersion(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-beijing"
+
+	c.EcsApiVersion = "2014-05-26"
+	if err := c.Prepare(nil); err != nil {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+	if !c.isKnownEcsApiVersion() {
+		t.Fatalf("%s should be a known version", c.EcsApiVersion)
+	}
+
+	c.EcsApiVersion = "2016-03-14"
+	if err := c.Prepare(nil); err != nil {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+	if c.isKnownEcsApiVersion() {
+		t.Fatalf("%s shouldn't be a known version", c.EcsApiVersion)
+	}
+
+	c.EcsApiVersion = "latest"
+	if err := c.Prepare(nil); err == nil {
+		t.Fatalf("should have err")
+	}
+}
+
+func TestApsaraStackAccessConfigPrepareSecurityToken(t *testing.T) {
+	os.Unsetenv("APSARASTACK_PROFILE")
+	os.Unsetenv("APSARASTACK_ACCESS_KEY")
+	os.Unsetenv("APSARASTACK_SECRET_KEY")
+
+	c := &ApsaraStackAccessConfig{
+		ApsaraStackRegion: "cn-beijing",
+		SecurityToken:     "token",
+	}
+	if err := c.Prepare(nil); err == nil {
+		t.Fatalf("should have err without access_key and secret_key")
+	}
+
+	c = testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-beijing"
+	os.Setenv("APSARASTACK_SECURITY_TOKEN", "env-token")
+	defer os.Unsetenv("APSARASTACK_SECURITY_TOKEN")
+	if err := c.Prepare(nil); err != nil {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+	if c.SecurityToken != "env-token" {
+		t.Fatalf("security_token should be sourced from the environment, actual: %q", c.SecurityToken)
+	}
+}
+
+func TestApsaraStackAccessConfigVpcClient_securityToken(t *testing.T) {
+	var token string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		token = params.Get("SecurityToken")
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.SecurityToken = "token"
+	vpcClient, err := c.VpcClient(client)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := vpcClient.DescribeVpcs(vpc.CreateDescribeVpcsRequest()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if token != "token" {
+		t.Fatalf("the VPC request should be signed with the STS token, actual: %q", token)
+	}
+}
+
+func TestApsaraStackAccessConfigPrepareEndpoints(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-beijing"
+
+	c.Endpoints = map[string]string{"ecs": "ecs.internal", "vpc": "vpc.internal"}
+	if err := c.Prepare(nil); err != nil {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+
+	c.Endpoints = map[string]string{"oss": "oss.internal", "ram": ""}
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("should have 2 errors: %s", err)
+	}
+}
+
+func TestApsaraStackAccessConfigServiceEndpoint(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.Endpoints = map[string]string{"vpc": "vpc.internal"}
+
+	if endpoint := c.serviceEndpoint("vpc", "ecs.internal"); endpoint != "vpc.internal" {
+		t.Fatalf("the listed endpoint should be used, actual: %s", endpoint)
+	}
+	if endpoint := c.serviceEndpoint("ram", "ecs.internal"); endpoint != "ecs.internal" {
+		t.Fatalf("the fallback should be used, actual: %s", endpoint)
+	}
+}
+
+func TestApsaraStackAccessConfigPrepareProxy(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-beijing"
+
+	c.HttpProxy = "http://proxy:3128"
+	c.HttpsProxy = "proxy:3128"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should have 1 error: %s", err)
+	}
+}
+
+func TestApsaraStackAccessConfigClient_proxy(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.Endpoint = "ecs.internal"
+	c.Proxy = "http://legacy-proxy:3128"
+	c.HttpsProxy = "http://proxy:3128"
+	c.NoProxy = "vpc.internal"
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if client.GetHttpProxy() != c.Proxy {
+		t.Fatalf("proxy should be used without http_proxy, actual: %q", client.GetHttpProxy())
+	}
+	if client.GetHttpsProxy() != c.HttpsProxy || client.GetNoProxy() != c.NoProxy {
+		t.Fatalf("bad proxies: %q, %q", client.GetHttpsProxy(), client.GetNoProxy())
+	}
+
+	vpcClient, err := c.VpcClient(client)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if vpcClient.GetHttpProxy() != c.Proxy || vpcClient.GetHttpsProxy() != c.HttpsProxy || vpcClient.GetNoProxy() != c.NoProxy {
+		t.Fatalf("the VPC client should use the same proxies")
+	}
+}
+
+func TestApsaraStackAccessConfigClient_timeouts(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.ApiReadTimeout = 30 * time.Second
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.ApiConnectTimeout != DefaultRequestConnectTimeout {
+		t.Fatalf("bad default api_connect_timeout: %s", c.ApiConnectTimeout)
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if client.GetConnectTimeout() != DefaultRequestConnectTimeout || client.GetReadTimeout() != 30*time.Second {
+		t.Fatalf("bad timeouts: %s, %s", client.GetConnectTimeout(), client.GetReadTimeout())
+	}
+
+	vpcClient, err := c.VpcClient(client)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if vpcClient.GetReadTimeout() != 30*time.Second {
+		t.Fatalf("the VPC client should use the same timeouts")
+	}
+
+	c.ApiConnectTimeout = -time.Second
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("a negative api_connect_timeout should error: %s", err)
+	}
+}
+
+func TestApsaraStackAccessConfigClient_maxConcurrentApiCalls(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"RequestId":"test-request"}`)
+	}))
+	defer server.Close()
+
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.MaxConcurrentApiCalls = 3
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	c.Endpoint = strings.TrimPrefix(server.URL, "http://")
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A client isn't safe for concurrent requests, every goroutine has
+	// clients of its own which share the limit.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		vpcClient, err := c.VpcClient(client)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		slbClient, err := c.SlbClient(client)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := vpcClient.DescribeVpcs(vpc.CreateDescribeVpcsRequest()); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := slbClient.DescribeLoadBalancers(slb.CreateDescribeLoadBalancersRequest()); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}()
+	}
+	if _, err := client.DescribeRegions(ecs.CreateDescribeRegionsRequest()); err != nil {
+		t.Errorf("err: %s", err)
+	}
+	wg.Wait()
+
+	if maxInFlight > 3 {
+		t.Fatalf("at most 3 requests should be in flight, got %d", maxInFlight)
+	}
+	if maxInFlight == 0 {
+		t.Fatalf("the requests should reach the server")
+	}
+}
+
+func TestApsaraStackAccessConfigApiProxy(t *testing.T) {
+	c := &ApsaraStackAccessConfig{
+		HttpProxy:  "http://proxy:3128",
+		HttpsProxy: "http://secure-proxy:3128",
+		NoProxy:    "internal.example.com,*.local",
+	}
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{url: "http://ecs.example.com/", expected: "http://proxy:3128"},
+		{url: "https://ecs.example.com/", expected: "http://secure-proxy:3128"},
+		{url: "http://internal.example.com/", expected: ""},
+		{url: "https://ecs.stack.local/", expected: ""},
+	} {
+		request, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+		proxy, err := c.apiProxy(request)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		actual := ""
+		if proxy != nil {
+			actual = proxy.String()
+		}
+		if actual != tc.expected {
+			t.Fatalf("%s: expected proxy %q, got %q", tc.url, tc.expected, actual)
+		}
+	}
+}
+
+func TestApsaraStackAccessConfigPrepareAssumeRole(t *testing.T) {
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-beijing"
+	c.AssumeRole.RoleArn = "acs:ram::1234567890:role/packer"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+	if !strings.HasPrefix(c.AssumeRole.SessionName, "packer-") || c.AssumeRole.DurationSeconds != defaultAssumeRoleDuration {
+		t.Fatalf("bad defaults: %q, %d", c.AssumeRole.SessionName, c.AssumeRole.DurationSeconds)
+	}
+
+	c.AssumeRole = ApsaraStackAssumeRole{
+		RoleArn:         "packer",
+		SessionName:     "a",
+		Policy:          "{",
+		DurationSeconds: 60,
+	}
+	if err := c.Prepare(nil); len(err) != 4 {
+		t.Fatalf("should have 4 errors: %s", err)
+	}
+
+	c.AssumeRole = ApsaraStackAssumeRole{SessionName: "packer"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("session_name without role_arn should error: %s", err)
+	}
+}
+
+func TestApsaraStackAccessConfigClient_assumeRole(t *testing.T) {
+	var lock sync.Mutex
+	assumed := 0
+	var accessKeyIds, tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("err: %s", err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Form.Get("Action") == "AssumeRole" {
+			if r.Form.Get("AccessKeyId") != "ak" || r.Form.Get("RoleArn") != "acs:ram::1234567890:role/packer" || r.Form.Get("DurationSeconds") != "900" {
+				t.Errorf("bad AssumeRole request: %v", r.Form)
+			}
+			assumed++
+			fmt.Fprintf(w, `{"RequestId":"test-request","Credentials":{"AccessKeyId":"sts-ak-%d","AccessKeySecret":"sts-secret","SecurityToken":"sts-token-%d","Expiration":"1970-01-01T00:15:00Z"}}`, assumed, assumed)
+			return
+		}
+		accessKeyIds = append(accessKeyIds, r.Form.Get("AccessKeyId"))
+		tokens = append(tokens, r.Form.Get("SecurityToken"))
+		fmt.Fprint(w, `{"RequestId":"test-request"}`)
+	}))
+	defer server.Close()
+
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.AssumeRole.RoleArn = "acs:ram::1234567890:role/packer"
+	c.AssumeRole.DurationSeconds = 900
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	c.Endpoint = strings.TrimPrefix(server.URL, "http://")
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	clock := &testClock{now: time.Unix(0, 0)}
+	client.signer.clock = clock
+	vpcClient, err := c.VpcClient(client)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := client.DescribeRegions(ecs.CreateDescribeRegionsRequest()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := vpcClient.DescribeVpcs(vpc.CreateDescribeVpcsRequest()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	clock.now = clock.now.Add(11 * time.Minute)
+	if _, err := client.DescribeRegions(ecs.CreateDescribeRegionsRequest()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if assumed != 2 {
+		t.Fatalf("the role should be assumed up front and once more before the credentials expire, actual: %d", assumed)
+	}
+	expectedIds := []string{"sts-ak-1", "sts-ak-1", "sts-ak-2"}
+	expectedTokens := []string{"sts-token-1", "sts-token-1", "sts-token-2"}
+	if fmt.Sprint(accessKeyIds) != fmt.Sprint(expectedIds) || fmt.Sprint(tokens) != fmt.Sprint(expectedTokens) {
+		t.Fatalf("the requests should be signed with the credentials of the role, actual: %v, %v", accessKeyIds, tokens)
+	}
+}
+
+func TestApsaraStackAccessConfigClient_assumeRoleConcurrentRefresh(t *testing.T) {
+	var lock sync.Mutex
+	assumed := 0
+	var signed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("err: %s", err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Form.Get("Action") == "AssumeRole" {
+			assumed++
+			// The credentials of each role session are valid for 15 minutes.
+			fmt.Fprintf(w, `{"RequestId":"test-request","Credentials":{"AccessKeyId":"sts-ak-%d","AccessKeySecret":"sts-secret-%d","SecurityToken":"sts-token-%d","Expiration":"1970-01-01T00:%02d:00Z"}}`, assumed, assumed, assumed, 15*assumed)
+			return
+		}
+		signed = append(signed, r.Form.Get("AccessKeyId")+" "+r.Form.Get("SecurityToken"))
+		fmt.Fprint(w, `{"RequestId":"test-request"}`)
+	}))
+	defer server.Close()
+
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.AssumeRole.RoleArn = "acs:ram::1234567890:role/packer"
+	c.AssumeRole.DurationSeconds = 900
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	c.Endpoint = strings.TrimPrefix(server.URL, "http://")
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	signer := client.signer
+	clock := &testClock{now: time.Unix(0, 0)}
+	signer.clock = clock
+
+	cases := []struct {
+		name   string
+		expire time.Duration
+		// The calls made before Sign, in the order of the request style.
+		calls []string
+	}{
+		{"rpc", 11 * time.Minute, []string{"GetAccessKeyId", "GetExtraParam"}},
+		{"roa", 26 * time.Minute, []string{"GetExtraParam", "GetAccessKeyId"}},
+	}
+	for i, tc := range cases {
+		generation := i + 1
+		signed = nil
+
+		// A request being signed while the credentials expire.
+		var accessKeyId, token string
+		for index, call := range tc.calls {
+			switch call {
+			case "GetAccessKeyId":
+				if accessKeyId, err = signer.GetAccessKeyId(); err != nil {
+					t.Fatalf("%s: err: %s", tc.name, err)
+				}
+			case "GetExtraParam":
+				token = signer.GetExtraParam()["SecurityToken"]
+			}
+			if index == 0 {
+				clock.now = time.Unix(0, 0).Add(tc.expire)
+				// Another request meanwhile keeps the expiring credentials.
+				if _, err := client.DescribeRegions(ecs.CreateDescribeRegionsRequest()); err != nil {
+					t.Fatalf("%s: err: %s", tc.name, err)
+				}
+			}
+		}
+		signature := signer.Sign("string-to-sign", "&")
+
+		current := fmt.Sprintf("sts-ak-%d sts-token-%d", generation, generation)
+		if accessKeyId+" "+token != current || signature != signers.ShaHmac1("string-to-sign", fmt.Sprintf("sts-secret-%d&", generation)) {
+			t.Fatalf("%s: the request should be signed with the same credentials, actual: %s, %s, %s", tc.name, accessKeyId, token, signature)
+		}
+
+		// The credentials are renewed once no request is being signed.
+		if _, err := client.DescribeRegions(ecs.CreateDescribeRegionsRequest()); err != nil {
+			t.Fatalf("%s: err: %s", tc.name, err)
+		}
+		renewed := fmt.Sprintf("sts-ak-%d sts-token-%d", generation+1, generation+1)
+		if assumed != generation+1 || fmt.Sprint(signed) != fmt.Sprintf("[%s %s]", current, renewed) {
+			t.Fatalf("%s: the credentials should be renewed after the request is signed, actual: %d, %v", tc.name, assumed, signed)
+		}
+	}
+}
+
+func TestApsaraStackAccessConfigClient_assumeRoleError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, testErrorBody("NoPermission"))
+	}))
+	defer server.Close()
+
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.AssumeRole.RoleArn = "acs:ram::1234567890:role/packer"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	c.Endpoint = strings.TrimPrefix(server.URL, "http://")
+
+	_, err := c.Client()
+	if err == nil || !strings.Contains(err.Error(), "unable to assume role acs:ram::1234567890:role/packer") {
+		t.Fatalf("the role should fail to be assumed, actual: %v", err)
+	}
+	if c.client != nil {
+		t.Fatalf("the client shouldn't be kept")
+	}
+}
+
+func TestApsaraStackAccessConfigValidateRegion(t *testing.T) {
+	calls := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeRegions" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		calls++
+		return http.StatusOK, `{"RequestId":"test-request","Regions":{"Region":[{"RegionId":"cn-test"},{"RegionId":"cn-other"}]}}`
+	})
+
+	c := testApsaraStackAccessConfig()
+	c.ApsaraStackRegion = "cn-test"
+	c.client = client
+	if err := c.ValidateRegion("cn-test"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := c.ValidateRegion("cn-tset")
+	if err == nil || !strings.Contains(err.Error(), "cn-test, cn-other") {
+		t.Fatalf("the error should list the available regions: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("the regions should be looked up once, got %d calls", calls)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/hashicorp/packer/packer"
@@ -14,6 +15,28 @@ type Artifact struct {
 	// A map of regions to ApsaraStack image IDs.
 	ApsaraStackImages map[string]string
 
+	// A map of data disk devices to the snapshots retained for them.
+	ApsaraStackDataDiskSnapshots map[string]string
+
+	// The OSS object keys the image was exported to.
+	ApsaraStackExportedObjects []string
+
+	// The name of the images, including the suffix of image_name_auto_append.
+	ImageName string
+
+	// The description of the images.
+	ImageDescription string
+
+	// The image family the image in the build region was added to.
+	ImageFamily string
+
+	// The source image and instance type the image was built from.
+	SourceImageId string
+	InstanceType  string
+
+	// When the build started.
+	BuildTime time.Time
+
 	// BuilderId is the unique ID for the builder that created this ApsaraStack image
 	BuilderIdValue string
 
@@ -49,6 +72,10 @@ func (a *Artifact) String() string {
 	}
 
 	sort.Strings(ApsaraStackImageStrings)
+	if len(a.ApsaraStackExportedObjects) > 0 {
+		return fmt.Sprintf("ApsaraStack images were created:\n\n%s\n\nThe image was exported to:\n\n%s",
+			strings.Join(ApsaraStackImageStrings, "\n"), strings.Join(a.ApsaraStackExportedObjects, "\n"))
+	}
 	return fmt.Sprintf("ApsaraStack images were created:\n\n%s", strings.Join(ApsaraStackImageStrings, "\n"))
 }
 
@@ -56,96 +83,77 @@ func (a *Artifact) State(name string) interface{} {
 	switch name {
 	case "atlas.artifact.metadata":
 		return a.stateAtlasMetadata()
+	case "data_disk_snapshots":
+		return a.ApsaraStackDataDiskSnapshots
+	case "exported_objects":
+		return a.ApsaraStackExportedObjects
+	case "image_name":
+		return a.ImageName
+	case "image_description":
+		return a.ImageDescription
+	case "image_family":
+		return a.ImageFamily
+	case "image_ids":
+		return a.ApsaraStackImages
+	case "generated_data":
+		return a.stateGeneratedData()
 	default:
 		return nil
 	}
 }
 
+// Destroy deletes the images in every region along with the snapshots they
+// are backed by, copies which are still in progress are cancelled instead.
+// Images which are already gone count as deleted, shared images are unshared
+// before they are deleted.
 func (a *Artifact) Destroy() error {
-
 	errors := make([]error, 0)
-	//config := state.Get("config").(*Config)
 
-	copyingImages := make(map[string]string, len(a.ApsaraStackImages))
-	sourceImage := make(map[string]*ecs.Image, 1)
-	for regionId, imageId := range a.ApsaraStackImages {
+	regions := make([]string, 0, len(a.ApsaraStackImages))
+	for regionId := range a.ApsaraStackImages {
+		regions = append(regions, regionId)
+	}
+	sort.Strings(regions)
+
+	for _, regionId := range regions {
+		imageId := a.ApsaraStackImages[regionId]
 		describeImagesRequest := ecs.CreateDescribeImagesRequest()
 		describeImagesRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
 		describeImagesRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs"}
 
 		describeImagesRequest.RegionId = regionId
 		describeImagesRequest.ImageId = imageId
+		describeImagesRequest.ImageOwnerAlias = ImageOwnerSelf
 		describeImagesRequest.Status = ImageStatusQueried
 
 		imagesResponse, err := a.Client.DescribeImages(describeImagesRequest)
 		if err != nil {
-			errors = append(errors, err)
+			errors = append(errors, fmt.Errorf("Error retrieving details for ApsaraStack image(%s) in %s: %w", imageId, regionId, err))
+			continue
 		}
 
 		images := imagesResponse.Images.Image
 		if len(images) == 0 {
-			err := fmt.Errorf("Error retrieving details for ApsaraStack image(%s), no ApsaraStack images found", imageId)
-			errors = append(errors, err)
+			log.Printf("ApsaraStack image (%s) in region (%s) is already deleted", imageId, regionId)
 			continue
 		}
 
-		if images[0].IsCopied && images[0].Status != ImageStatusAvailable {
-			copyingImages[regionId] = imageId
-		} else {
-			sourceImage[regionId] = &images[0]
-		}
-	}
-
-	for regionId, imageId := range copyingImages {
-		log.Printf("Cancel copying ApsaraStack image (%s) from region (%s)", imageId, regionId)
-
-		errs := a.unsharedAccountsOnImages(regionId, imageId)
-		if errs != nil {
-			errors = append(errors, errs...)
-		}
-
-		cancelImageCopyRequest := ecs.CreateCancelCopyImageRequest()
-		cancelImageCopyRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
-		cancelImageCopyRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs", "Department": a.Config.Department, "ResourceGroup": a.Config.ResourceGroup}
-
-		cancelImageCopyRequest.RegionId = regionId
-		cancelImageCopyRequest.ImageId = imageId
-		if _, err := a.Client.CancelCopyImage(cancelImageCopyRequest); err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	for regionId, image := range sourceImage {
-		imageId := image.ImageId
-		log.Printf("Delete ApsaraStack image (%s) from region (%s)", imageId, regionId)
-
-		errs := a.unsharedAccountsOnImages(regionId, imageId)
-		if errs != nil {
+		if errs := a.unsharedAccountsOnImages(regionId, imageId); errs != nil {
+			// A shared image can't be deleted.
 			errors = append(errors, errs...)
+			continue
 		}
 
-		deleteImageRequest := ecs.CreateDeleteImageRequest()
-		deleteImageRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
-		deleteImageRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs", "Department": a.Config.Department, "ResourceGroup": a.Config.ResourceGroup}
-
-		deleteImageRequest.RegionId = regionId
-		deleteImageRequest.ImageId = imageId
-		if _, err := a.Client.DeleteImage(deleteImageRequest); err != nil {
-			errors = append(errors, err)
-		}
-
-		//Delete the snapshot of this images
-		for _, diskDevices := range image.DiskDeviceMappings.DiskDeviceMapping {
-			deleteSnapshotRequest := ecs.CreateDeleteSnapshotRequest()
-			deleteSnapshotRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
-			deleteSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs", "Department": a.Config.Department, "ResourceGroup": a.Config.ResourceGroup}
-
-			deleteSnapshotRequest.SnapshotId = diskDevices.SnapshotId
-			_, err := a.Client.DeleteSnapshot(deleteSnapshotRequest)
-			if err != nil {
+		if images[0].IsCopied && images[0].Status != ImageStatusAvailable {
+			log.Printf("Cancel copying ApsaraStack image (%s) from region (%s)", imageId, regionId)
+			if err := a.cancelCopyImage(regionId, imageId); err != nil && !isNotFoundError(err) {
 				errors = append(errors, err)
 			}
+			continue
 		}
+
+		log.Printf("Delete ApsaraStack image (%s) from region (%s)", imageId, regionId)
+		errors = append(errors, a.deleteImage(regionId, &images[0])...)
 	}
 
 	if len(errors) > 0 {
@@ -159,6 +167,50 @@ func (a *Artifact) Destroy() error {
 	return nil
 }
 
+func (a *Artifact) cancelCopyImage(regionId string, imageId string) error {
+	cancelImageCopyRequest := ecs.CreateCancelCopyImageRequest()
+	cancelImageCopyRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
+	cancelImageCopyRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs", "Department": a.Config.Department, "ResourceGroup": a.Config.ResourceGroup}
+
+	cancelImageCopyRequest.RegionId = regionId
+	cancelImageCopyRequest.ImageId = imageId
+	_, err := a.Client.CancelCopyImage(cancelImageCopyRequest)
+	return err
+}
+
+// deleteImage deletes the image and then its snapshots, which can't be
+// deleted while the image uses them.
+func (a *Artifact) deleteImage(regionId string, image *ecs.Image) []error {
+	deleteImageRequest := ecs.CreateDeleteImageRequest()
+	deleteImageRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
+	deleteImageRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs", "Department": a.Config.Department, "ResourceGroup": a.Config.ResourceGroup}
+
+	deleteImageRequest.RegionId = regionId
+	deleteImageRequest.ImageId = image.ImageId
+	if _, err := a.Client.DeleteImage(deleteImageRequest); err != nil && !isNotFoundError(err) {
+		return []error{err}
+	}
+
+	var errors []error
+	for _, diskDevices := range image.DiskDeviceMappings.DiskDeviceMapping {
+		if diskDevices.SnapshotId == "" {
+			continue
+		}
+
+		deleteSnapshotRequest := ecs.CreateDeleteSnapshotRequest()
+		deleteSnapshotRequest.Headers = map[string]string{"RegionId": a.Config.ApsaraStackRegion}
+		deleteSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": a.Config.ApsaraStackSecretKey, "Product": "ecs", "Department": a.Config.Department, "ResourceGroup": a.Config.ResourceGroup}
+
+		deleteSnapshotRequest.RegionId = regionId
+		deleteSnapshotRequest.SnapshotId = diskDevices.SnapshotId
+		if _, err := a.Client.DeleteSnapshot(deleteSnapshotRequest); err != nil && !isNotFoundError(err) {
+			errors = append(errors, err)
+		}
+	}
+
+	return errors
+}
+
 func (a *Artifact) unsharedAccountsOnImages(regionId string, imageId string) []error {
 	var errors []error
 	//ig := state.Get("config").(*Config)
@@ -206,3 +258,30 @@ func (a *Artifact) stateAtlasMetadata() interface{} {
 
 	return metadata
 }
+
+// stateGeneratedData returns the build data the manifest post-processor can
+// put in its custom_data, such as `{{ .SourceImageId }}`.
+func (a *Artifact) stateGeneratedData() map[string]interface{} {
+	regions := make([]string, 0, len(a.ApsaraStackImages))
+	for region := range a.ApsaraStackImages {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	data := map[string]interface{}{
+		"ImageIds":      a.ApsaraStackImages,
+		"ImageName":     a.ImageName,
+		"Regions":       strings.Join(regions, ","),
+		"SourceImageId": a.SourceImageId,
+		"InstanceType":  a.InstanceType,
+		"ImageFamily":   a.ImageFamily,
+	}
+	if a.ImageDescription != "" {
+		data["ImageDescription"] = a.ImageDescription
+	}
+	if !a.BuildTime.IsZero() {
+		data["BuildTime"] = a.BuildTime.UTC().Format(time.RFC3339)
+	}
+
+	return data
+}
@@ -1,8 +1,11 @@
 package ecs
 
 import (
+	"net/http"
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/packer/packer"
 )
@@ -45,3 +48,112 @@ func TestArtifactState_atlasMetadata(t *testing.T) {
 		t.Fatalf("bad: %#v", actual)
 	}
 }
+
+func TestArtifactState_generatedData(t *testing.T) {
+	a := &Artifact{
+		ApsaraStackImages: map[string]string{
+			"west": "bar",
+			"east": "foo",
+		},
+		ImageName:        "packer-test",
+		SourceImageId:    "m-source",
+		InstanceType:     "ecs.n1.tiny",
+		ImageFamily:      "base-centos",
+		ImageDescription: "Base image",
+		BuildTime:        time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	if actual := a.State("image_ids"); !reflect.DeepEqual(actual, a.ApsaraStackImages) {
+		t.Fatalf("bad image ids: %#v", actual)
+	}
+
+	actual := a.State("generated_data")
+	expected := map[string]interface{}{
+		"ImageIds":         a.ApsaraStackImages,
+		"ImageName":        "packer-test",
+		"Regions":          "east,west",
+		"SourceImageId":    "m-source",
+		"InstanceType":     "ecs.n1.tiny",
+		"ImageFamily":      "base-centos",
+		"ImageDescription": "Base image",
+		"BuildTime":        "2020-10-01T12:00:00Z",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestArtifactDestroy(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action+" "+params.Get("RegionId"))
+		switch action {
+		case "DescribeImages":
+			if params.Get("RegionId") == "cn-gone" {
+				return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-test","Status":"Available",` +
+				`"DiskDeviceMappings":{"DiskDeviceMapping":[{"SnapshotId":"s-system"},{"SnapshotId":"s-gone"}]}}]}}`
+		case "DescribeImageSharePermission":
+			return http.StatusOK, `{"RequestId":"test-request","Accounts":{"Account":[{"AliyunId":"1234"}]}}`
+		case "ModifyImageSharePermission":
+			if params.Get("RemoveAccount.1") != "1234" {
+				t.Errorf("the image should be unshared: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DeleteImage":
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DeleteSnapshot":
+			if params.Get("SnapshotId") == "s-gone" {
+				return http.StatusNotFound, testErrorBody("InvalidSnapshotId.NotFound")
+			}
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+
+	a := &Artifact{
+		ApsaraStackImages: map[string]string{"cn-test": "m-test", "cn-gone": "m-gone"},
+		Config:            &Config{},
+		Client:            client,
+	}
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{
+		"DescribeImages cn-gone",
+		"DescribeImages cn-test",
+		"DescribeImageSharePermission cn-test",
+		"ModifyImageSharePermission cn-test",
+		"DeleteImage cn-test",
+		"DeleteSnapshot cn-test",
+		"DeleteSnapshot cn-test",
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("bad actions: %v", actions)
+	}
+}
+
+func TestArtifactDestroy_error(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeImages":
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-test","Status":"Available"}]}}`
+		case "DescribeImageSharePermission":
+			return http.StatusOK, `{"RequestId":"test-request","Accounts":{"Account":[]}}`
+		}
+		return http.StatusForbidden, testErrorBody("Forbidden.RAM")
+	})
+
+	a := &Artifact{
+		ApsaraStackImages: map[string]string{"cn-test": "m-test"},
+		Config:            &Config{},
+		Client:            client,
+	}
+	if err := a.Destroy(); err == nil {
+		t.Fatalf("failing to delete the image should be reported")
+	}
+}
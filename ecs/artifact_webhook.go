@@ -0,0 +1,65 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type artifactWebhookPayload struct {
+	BuildName       string            `json:"build_name,omitempty"`
+	Images          map[string]string `json:"images"`
+	Regions         []string          `json:"regions"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	SourceImage     string            `json:"source_image"`
+	DurationSeconds int64             `json:"duration_seconds"`
+}
+
+// notifyArtifactWebhook POSTs the metadata of a successfully built artifact
+// to the configured artifact_webhook_url.
+func notifyArtifactWebhook(ctx context.Context, config *Config, artifact *Artifact, duration time.Duration) error {
+	payload := artifactWebhookPayload{
+		BuildName:       config.PackerBuildName,
+		Images:          artifact.ApsaraStackImages,
+		Tags:            config.ApsaraStackImageTags,
+		SourceImage:     config.ApsaraStackSourceImage,
+		DurationSeconds: int64(duration.Seconds()),
+	}
+	for region := range artifact.ApsaraStackImages {
+		payload.Regions = append(payload.Regions, region)
+	}
+	sort.Strings(payload.Regions)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.ArtifactWebhookTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, config.ArtifactWebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if config.ArtifactWebhookAuthHeader != "" {
+		request.Header.Set("Authorization", config.ArtifactWebhookAuthHeader)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifyArtifactWebhook(t *testing.T) {
+	var payload artifactWebhookPayload
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("err: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{}
+	config.ApsaraStackSourceImage = "m-source"
+	config.ApsaraStackImageTags = map[string]string{"team": "infra"}
+	config.ArtifactWebhookUrl = server.URL
+	config.ArtifactWebhookAuthHeader = "Bearer token"
+	config.ArtifactWebhookTimeout = time.Second
+	artifact := &Artifact{
+		ApsaraStackImages: map[string]string{"cn-beijing": "m-foo", "cn-hangzhou": "m-bar"},
+	}
+
+	if err := notifyArtifactWebhook(context.Background(), config, artifact, 90*time.Second); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if auth != "Bearer token" {
+		t.Fatalf("bad auth header: %q", auth)
+	}
+	if payload.SourceImage != "m-source" || payload.DurationSeconds != 90 || payload.Tags["team"] != "infra" ||
+		len(payload.Regions) != 2 || payload.Regions[0] != "cn-beijing" || payload.Images["cn-hangzhou"] != "m-bar" {
+		t.Fatalf("bad payload: %#v", payload)
+	}
+}
+
+func TestNotifyArtifactWebhook_errorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := &Config{}
+	config.ArtifactWebhookUrl = server.URL
+	config.ArtifactWebhookTimeout = time.Second
+
+	if err := notifyArtifactWebhook(context.Background(), config, &Artifact{}, time.Second); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -0,0 +1,206 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/signers"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+)
+
+// The RAM role assumed through STS before the build talks to the other
+// services.
+type ApsaraStackAssumeRole struct {
+	// The ARN of the role, such as `acs:ram::1234567890:role/packer`.
+	RoleArn string `mapstructure:"role_arn" required:"true"`
+	// The name of the role session, 2 to 64 letters, digits or `.@-_`. The
+	// default value is `packer-` followed by the current unix time.
+	SessionName string `mapstructure:"session_name" required:"false"`
+	// A JSON policy document which further narrows the permissions of the
+	// role for the build.
+	Policy string `mapstructure:"policy" required:"false"`
+	// How long the temporary credentials are valid, from 900 up to the
+	// maximum session duration of the role. They are renewed before they
+	// expire. The default value is 3600.
+	DurationSeconds int `mapstructure:"duration_seconds" required:"false"`
+}
+
+const (
+	defaultAssumeRoleDuration = 3600
+	minAssumeRoleDuration     = 900
+	maxAssumeRoleDuration     = 43200
+)
+
+// The temporary credentials are renewed this long before they expire, so a
+// request signed with them doesn't reach the API after they expired.
+const assumeRoleRefreshMargin = 5 * time.Minute
+
+var (
+	roleArnPattern     = regexp.MustCompile(`^acs:ram::[0-9]+:role/[^/\s]+$`)
+	sessionNamePattern = regexp.MustCompile(`^[\w.@-]{2,64}$`)
+)
+
+func (c *ApsaraStackAssumeRole) Prepare() []error {
+	if c.RoleArn == "" {
+		if c.SessionName != "" || c.Policy != "" || c.DurationSeconds != 0 {
+			return []error{fmt.Errorf("assume_role.role_arn must be specified")}
+		}
+		return nil
+	}
+
+	var errs []error
+	if !roleArnPattern.MatchString(c.RoleArn) {
+		errs = append(errs, fmt.Errorf("assume_role.role_arn must be a role ARN such as acs:ram::1234567890:role/packer, got %q", c.RoleArn))
+	}
+
+	if c.SessionName == "" {
+		c.SessionName = fmt.Sprintf("packer-%d", time.Now().Unix())
+	} else if !sessionNamePattern.MatchString(c.SessionName) {
+		errs = append(errs, fmt.Errorf("assume_role.session_name must be 2 to 64 letters, digits or .@-_, got %q", c.SessionName))
+	}
+
+	if c.Policy != "" && !json.Valid([]byte(c.Policy)) {
+		errs = append(errs, fmt.Errorf("assume_role.policy must be a JSON policy document"))
+	}
+
+	if c.DurationSeconds == 0 {
+		c.DurationSeconds = defaultAssumeRoleDuration
+	} else if c.DurationSeconds < minAssumeRoleDuration || c.DurationSeconds > maxAssumeRoleDuration {
+		errs = append(errs, fmt.Errorf("assume_role.duration_seconds must be between %d and %d, got %d", minAssumeRoleDuration, maxAssumeRoleDuration, c.DurationSeconds))
+	}
+
+	return errs
+}
+
+// assumeRoleSigner signs requests with the temporary credentials of an
+// assumed role, and assumes the role again when they are about to expire.
+//
+// The clients of every service share the signer, and requests are signed
+// concurrently. Every request calls GetAccessKeyId and GetExtraParam, in an
+// order depending on its style, and then Sign. The credentials are only
+// renewed while no request is between its first call and Sign, so the access
+// key, the security token and the signature of a request always belong to the
+// same credentials. A request signed meanwhile uses the expiring ones, which
+// are still valid for assumeRoleRefreshMargin.
+type assumeRoleSigner struct {
+	config *ApsaraStackAccessConfig
+	client *sts.Client
+	// The time source deciding when to renew, the wall clock when nil.
+	clock Clock
+
+	lock        sync.Mutex
+	credentials sts.Credentials
+	expiration  time.Time
+	// The number of calls to GetAccessKeyId and GetExtraParam whose request
+	// isn't signed yet.
+	pending int
+}
+
+func (s *assumeRoleSigner) now() time.Time {
+	if s.clock == nil {
+		return wallClock{}.Now()
+	}
+	return s.clock.Now()
+}
+
+// refresh assumes the role, the credentials are kept when it fails.
+func (s *assumeRoleSigner) refresh() error {
+	role := s.config.AssumeRole
+
+	request := sts.CreateAssumeRoleRequest()
+	request.Headers = map[string]string{"RegionId": s.config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": s.config.ApsaraStackSecretKey, "Product": "sts", "Department": s.config.Department, "ResourceGroup": s.config.ResourceGroup}
+
+	request.RoleArn = role.RoleArn
+	request.RoleSessionName = role.SessionName
+	request.Policy = role.Policy
+	request.DurationSeconds = requests.NewInteger(role.DurationSeconds)
+
+	requested := s.now()
+	response, err := s.client.AssumeRole(request)
+	if err != nil {
+		return err
+	}
+	if response.Credentials.AccessKeyId == "" || response.Credentials.SecurityToken == "" {
+		return fmt.Errorf("AssumeRole returned no credentials")
+	}
+
+	s.credentials = response.Credentials
+	s.expiration, err = time.Parse(time.RFC3339, response.Credentials.Expiration)
+	if err != nil {
+		s.expiration = requested.Add(time.Duration(role.DurationSeconds) * time.Second)
+	}
+
+	return nil
+}
+
+func (s *assumeRoleSigner) GetName() string {
+	return "HMAC-SHA1"
+}
+
+func (s *assumeRoleSigner) GetType() string {
+	return ""
+}
+
+func (s *assumeRoleSigner) GetVersion() string {
+	return "1.0"
+}
+
+func (s *assumeRoleSigner) GetAccessKeyId() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.renew(); err != nil {
+		return "", fmt.Errorf("unable to renew the credentials of role %s: %w", s.config.AssumeRole.RoleArn, err)
+	}
+	s.pending++
+
+	return s.credentials.AccessKeyId, nil
+}
+
+// GetExtraParam can't fail the request, the credentials are kept when they
+// can't be renewed and GetAccessKeyId reports the error of the next request.
+func (s *assumeRoleSigner) GetExtraParam() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.renew(); err != nil {
+		log.Printf("[WARN] Unable to renew the credentials of role %s: %s", s.config.AssumeRole.RoleArn, err)
+	}
+	s.pending++
+
+	return map[string]string{"SecurityToken": s.credentials.SecurityToken}
+}
+
+// Sign is the last step of signing a request, which is then done with the
+// credentials.
+func (s *assumeRoleSigner) Sign(stringToSign, secretSuffix string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// GetAccessKeyId and GetExtraParam were called for the request.
+	s.pending -= 2
+	if s.pending < 0 {
+		s.pending = 0
+	}
+
+	return signers.ShaHmac1(stringToSign, s.credentials.AccessKeySecret+secretSuffix)
+}
+
+func (s *assumeRoleSigner) expiring() bool {
+	return s.now().Add(assumeRoleRefreshMargin).After(s.expiration)
+}
+
+// renew assumes the role when the credentials are about to expire, unless a
+// request is being signed with them. It's called with the lock held.
+func (s *assumeRoleSigner) renew() error {
+	if !s.expiring() || s.pending > 0 {
+		return nil
+	}
+	return s.refresh()
+}
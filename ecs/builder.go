@@ -2,9 +2,17 @@ package ecs
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/hashicorp/hcl/v2/hcldec"
 
 	"github.com/hashicorp/packer/common"
+	"github.com/hashicorp/packer/helper/communicator"
 	"github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
@@ -61,41 +69,279 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	// Accumulate any errors
 	var errs *packer.MultiError
 	errs = packer.MultiErrorAppend(errs, b.config.ApsaraStackAccessConfig.Prepare(&b.config.ctx)...)
+
+	// The communicator is picked before the run config is prepared, which
+	// sets its defaults and validates it.
+	var warnings []string
+	if errs == nil || len(errs.Errors) == 0 {
+		if warning := b.detectCommunicator(); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
 	errs = packer.MultiErrorAppend(errs, b.config.ApsaraStackImageConfig.Prepare(&b.config.ctx)...)
 	errs = packer.MultiErrorAppend(errs, b.config.RunConfig.Prepare(&b.config.ctx)...)
 
+	if b.config.SourceDestCheck != config.TriUnset && b.chooseNetworkType() != InstanceNetworkVpc {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("source_dest_check only works in a VPC, set vpc_id or vswitch_id"))
+	}
+
+	if b.config.ForbidClassicNetwork && b.chooseNetworkType() != InstanceNetworkVpc {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("forbid_classic_network is set but the instance would be "+
+			"created in the classic network, set vpc_id or vswitch_id to build in a VPC"))
+	}
+
+	// A dry run creates no resource, the ones the instance needs have to
+	// exist.
+	if b.config.DryRun {
+		if b.config.SourceSnapshotId != "" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("dry_run can't be used with source_snapshot_id, the source image would be created from the snapshot"))
+		}
+		if b.config.SecurityGroupId == "" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("dry_run requires security_group_id, a dry run doesn't create the security group"))
+		}
+		if b.chooseNetworkType() == InstanceNetworkVpc && b.config.VSwitchId == "" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("dry_run requires vswitch_id to build in a VPC, a dry run doesn't create the VPC and the vSwitch"))
+		}
+	}
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return nil, nil, errs
 	}
 
+	// A typo in a region would otherwise only show with the first API call
+	// of the build.
+	if !b.config.ApsaraStackSkipValidation {
+		for _, region := range append([]string{b.config.ApsaraStackRegion}, b.config.ApsaraStackImageDestinationRegions...) {
+			if err := b.config.ValidateRegion(region); err != nil {
+				errs = packer.MultiErrorAppend(errs, err)
+			}
+		}
+		if errs != nil && len(errs.Errors) > 0 {
+			return nil, nil, errs
+		}
+	}
+
+	if b.config.FailIfNoop {
+		if b.config.ApsaraStackImageTags == nil {
+			b.config.ApsaraStackImageTags = make(map[string]string)
+		}
+		b.config.ApsaraStackImageTags[TagKeySourceImage] = b.config.ApsaraStackSourceImage
+		// The image created from the snapshot is new for every build.
+		if b.config.SourceSnapshotId != "" {
+			b.config.ApsaraStackImageTags[TagKeySourceImage] = b.config.SourceSnapshotId
+		}
+	}
+
+	if b.config.AutoRenew && (b.config.PackerOnError == "abort" || b.config.PackerOnError == "ask") {
+		warnings = append(warnings, "auto_renew is enabled and the instance may be kept after a failed build "+
+			"because of -on-error, it will renew automatically until it's released.")
+	}
+	if b.config.InstanceChargeType == InstanceChargeTypePrePaid {
+		warnings = append(warnings, fmt.Sprintf("instance_charge_type is %s, the build instance is released at the end "+
+			"of the build but a minimum charge for its subscription period may still apply.", InstanceChargeTypePrePaid))
+	}
+	if b.config.SecurityGroupId == "" && len(b.config.SecurityGroupSourceCidrs) == 0 && b.config.Comm.Type != "none" {
+		warnings = append(warnings, fmt.Sprintf("security_group_source_cidrs isn't set, the security group Packer creates "+
+			"opens the port of the communicator to %s.", DefaultCidrIp))
+	}
+	if !b.config.isKnownEcsApiVersion() {
+		warnings = append(warnings, fmt.Sprintf("ecs_api_version %s is not one of the known ECS API versions %s, "+
+			"requests may be rejected if the stack doesn't serve it.", b.config.EcsApiVersion, strings.Join(KnownEcsApiVersions, ", ")))
+	}
+
 	packer.LogSecretFilter.Set(b.config.ApsaraStackAccessKey, b.config.ApsaraStackSecretKey)
-	return nil, nil, nil
+	return nil, warnings, nil
+}
+
+// detectCommunicator picks the communicator from the OS type of
+// source_image when none is configured, the returned warning tells which one.
+// With source_image_filter, source_snapshot_id or skip_region_validation, or
+// when the image can't be described, it is picked at build time instead.
+func (b *Builder) detectCommunicator() string {
+	if b.config.Comm.Type != "" || b.config.ApsaraStackSourceImage == "" || b.config.ApsaraStackSkipValidation {
+		return ""
+	}
+
+	client, err := b.config.Client()
+	if err != nil {
+		log.Printf("[WARN] Error creating the client to describe the source image: %s", err)
+		return ""
+	}
+
+	return detectSourceImageCommunicator(client, &b.config)
+}
+
+// detectSourceImageCommunicator sets the communicator for the OS type of
+// source_image.
+func detectSourceImageCommunicator(client *ClientWrapper, config *Config) string {
+	images, err := describeSourceImage(client, config)
+	if err != nil {
+		log.Printf("[WARN] The communicator is picked at build time: %s", err)
+		return ""
+	}
+
+	config.Comm.Type = communicatorForImage(&images[0])
+	return fmt.Sprintf("No communicator was specified, using %s for %s source image %s.",
+		config.Comm.Type, images[0].OSType, images[0].ImageId)
 }
 
 func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
+	startTime := time.Now()
 
 	client, err := b.config.Client()
 
 	if err != nil {
 		return nil, err
 	}
-	state := new(multistep.BasicStateBag)
-	state.Put("config", &b.config)
-	state.Put("client", client)
-	state.Put("hook", hook)
-	state.Put("ui", ui)
-	state.Put("networktype", b.chooseNetworkType())
+	for _, service := range EndpointServices {
+		if endpoint, ok := b.config.Endpoints[service]; ok {
+			ui.Say(fmt.Sprintf("Using the %s endpoint %s", service, endpoint))
+		}
+	}
+
+	var state multistep.StateBag
+	for attempt := 0; ; attempt++ {
+		state = new(multistep.BasicStateBag)
+		state.Put("config", &b.config)
+		state.Put("client", client)
+		state.Put("hook", hook)
+		state.Put("ui", ui)
+		state.Put("networktype", b.chooseNetworkType())
+		summary := &cleanupSummary{}
+		state.Put("cleanup_summary", summary)
+		tokenBase := ""
+		if b.config.ClientToken != "" {
+			tokenBase = fmt.Sprintf("%s/%d", b.config.ClientToken, attempt)
+		}
+		state.Put("clienttokens", newClientTokens(tokenBase))
+
+		// A retried run resumes from the images its failed run kept as
+		// well.
+		steps := b.buildSteps()
+		if images := b.resumeImages(client, ui); images != nil {
+			state.Put("ApsaraStackimage", images[b.config.ApsaraStackRegion])
+			state.Put("ApsaraStackimages", images)
+			state.Put("resume_state", &resumeState{ImageName: b.config.ApsaraStackImageName, BaseName: b.config.imageBaseName, Images: copyImageIds(images)})
+			steps = b.distributeImageSteps()
+		}
+
+		// Run!
+		b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
+		b.runner.Run(ctx, state)
+		summary.report(ui)
+
+		rawErr, ok := state.GetOk("error")
+		if !ok || attempt >= b.config.BuildRetries || !b.shouldRetryBuild(state, rawErr.(error)) {
+			break
+		}
+
+		delay := buildRetryDelay(attempt)
+		ui.Say(fmt.Sprintf("Build failed because of a transient error, retrying in %s (%d/%d)...",
+			delay, attempt+1, b.config.BuildRetries))
+		select {
+		case <-ctx.Done():
+			return nil, rawErr.(error)
+		case <-time.After(delay):
+		}
+	}
+
+	// If there was an error, return that
+	if rawErr, ok := state.GetOk("error"); ok {
+		return nil, rawErr.(error)
+	}
+
+	if b.config.ResumeStateFile != "" {
+		if err := os.Remove(b.config.ResumeStateFile); err != nil && !os.IsNotExist(err) {
+			ui.Error(fmt.Sprintf("Failed to remove resume_state_file, the next build would resume from it: %s", err))
+		}
+	}
+
+	// If there are no ECS images, then just return
+	if _, ok := state.GetOk("ApsaraStackimages"); !ok {
+		return nil, nil
+	}
+
+	// Build the artifact and return it
+	artifact := &Artifact{
+		ApsaraStackImages: state.Get("ApsaraStackimages").(map[string]string),
+		BuilderIdValue:    BuilderId,
+		SourceImageId:     b.config.ApsaraStackSourceImage,
+		InstanceType:      b.config.InstanceType,
+		ImageName:         b.config.ApsaraStackImageName,
+		ImageFamily:       b.config.ApsaraStackImageFamily,
+		ImageDescription:  b.config.ApsaraStackImageDescription,
+		BuildTime:         startTime,
+		Client:            client,
+		Config:            &b.config,
+	}
+	if image, ok := state.GetOk("source_image"); ok {
+		artifact.SourceImageId = image.(*ecs.Image).ImageId
+	}
+	if snapshots, ok := state.GetOk("ApsaraStackdatadisksnapshots"); ok {
+		artifact.ApsaraStackDataDiskSnapshots = snapshots.(map[string]string)
+	}
+	if objects, ok := state.GetOk("ApsaraStackexportedobjects"); ok {
+		artifact.ApsaraStackExportedObjects = objects.([]string)
+	}
+
+	if b.config.ArtifactWebhookUrl != "" {
+		ui.Say(fmt.Sprintf("Notifying artifact webhook: %s", b.config.ArtifactWebhookUrl))
+		if err := notifyArtifactWebhook(ctx, &b.config, artifact, time.Since(startTime)); err != nil {
+			ui.Error(fmt.Sprintf("Failed to notify artifact webhook, ignoring: %s", err))
+		}
+	}
+
+	return artifact, nil
+}
+
+// buildSteps returns fresh steps for a run of the build, steps keep the
+// resources they created in their fields.
+func (b *Builder) buildSteps() []multistep.Step {
 	var steps []multistep.Step
 
 	// Build the steps
 	steps = []multistep.Step{
-		&stepPreValidate{
-			ApsaraStackDestImageName: b.config.ApsaraStackImageName,
-			ForceDelete:              b.config.ApsaraStackImageForceDelete,
+		&stepValidateResourceGroup{
+			Skip: b.config.ApsaraStackSkipResourceGroupValidation,
 		},
+	}
+	// A dry run creates no resource, source_snapshot_id can't be used with
+	// it.
+	if !b.config.DryRun {
+		steps = append(steps, &stepCreateSourceImage{
+			SnapshotId:               b.config.SourceSnapshotId,
+			Keep:                     b.config.KeepSourceSnapshotImage,
+			WaitSnapshotReadyTimeout: b.getSnapshotReadyTimeout(),
+		})
+	}
+	steps = append(steps,
 		&stepCheckApsaraStackSourceImage{
 			SourceECSImageId: b.config.ApsaraStackSourceImage,
 		},
+		&stepPreValidate{
+			ApsaraStackDestImageName:   b.config.ApsaraStackImageName,
+			ForceDelete:                b.config.ApsaraStackImageForceDelete,
+			FailIfNoop:                 b.config.FailIfNoop,
+			SourceImageId:              b.config.ApsaraStackSourceImage,
+			RamRoleName:                b.config.RamRoleName,
+			VerifyRamRole:              b.config.VerifyRamRole,
+			InstanceType:               b.config.InstanceType,
+			ZoneId:                     b.config.ZoneId,
+			SkipInstanceTypeValidation: b.config.SkipInstanceTypeValidation,
+			KeyPairName:                b.config.Comm.SSHKeyPairName,
+			DedicatedHostId:            b.config.DedicatedHostId,
+			DataDisks:                  b.config.ECSImagesDiskMappings,
+		})
+	if b.config.ZoneSelection != ZoneSelectionExplicit {
+		steps = append(steps, &stepSelectZone{
+			ZoneSelection: b.config.ZoneSelection,
+		})
+	}
+	if b.config.DryRun {
+		return append(steps, &stepDryRunInstance{
+			Instance: b.createInstanceStep(),
+		})
 	}
 	if b.chooseNetworkType() == InstanceNetworkVpc {
 		steps = append(steps,
@@ -112,29 +358,64 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 			})
 	}
 	steps = append(steps,
+		&stepConfigApsaraStackKeyPair{
+			Comm:     &b.config.RunConfig.Comm,
+			RegionId: b.config.ApsaraStackRegion,
+		},
 		&stepConfigApsaraStackSecurityGroup{
 			SecurityGroupId:   b.config.SecurityGroupId,
-			SecurityGroupName: b.config.SecurityGroupId,
+			SecurityGroupName: b.config.SecurityGroupName,
 			RegionId:          b.config.ApsaraStackRegion,
+			Comm:              &b.config.RunConfig.Comm,
+			IngressPorts:      b.config.SecurityGroupIngressPorts,
+			SourceCidrs:       b.config.SecurityGroupSourceCidrs,
 		},
-		&stepCreateApsaraStackInstance{
-			IOOptimized:             b.config.IOOptimized,
-			InstanceType:            b.config.InstanceType,
-			UserData:                b.config.UserData,
-			UserDataFile:            b.config.UserDataFile,
+		b.createInstanceStep())
+	if len(b.config.DiskTags) > 0 {
+		steps = append(steps, &stepTagDisks{
+			Tags:     b.config.DiskTags,
+			RegionId: b.config.ApsaraStackRegion,
+		})
+	}
+	if b.config.ECSSystemDiskMapping.SnapshotPolicyId != "" {
+		steps = append(steps, &stepApplySnapshotPolicy{
+			SnapshotPolicyId: b.config.ECSSystemDiskMapping.SnapshotPolicyId,
+			RegionId:         b.config.ApsaraStackRegion,
+		})
+	}
+	if b.config.SourceDestCheck != config.TriUnset {
+		steps = append(steps, &stepConfigSourceDestCheck{
+			SourceDestCheck: b.config.SourceDestCheck.True(),
+			RegionId:        b.config.ApsaraStackRegion,
+		})
+	}
+	if b.config.SecondaryNetworkInterface != (ApsaraStackNetworkInterface{}) {
+		steps = append(steps, &stepAttachNetworkInterface{
+			NetworkInterface: b.config.SecondaryNetworkInterface,
+			RegionId:         b.config.ApsaraStackRegion,
+		})
+	}
+	if b.isDiskEncryptionRequested() {
+		steps = append(steps, &stepVerifyDiskEncryption{
+			SystemDisk: b.config.ECSSystemDiskMapping,
+			Disks:      b.config.ECSImagesDiskMappings,
+			Strict:     b.config.StrictDiskEncryption,
+		})
+	}
+	steps = append(steps, &stepCheckImageEncryption{
+		ImageEncrypted:  b.config.ImageEncrypted,
+		IgnoreDataDisks: b.config.ApsaraStackImageIgnoreDataDisks,
+	})
+	// A VPC instance with associate_public_ip_address gets its public IP
+	// the way classic instances do, instead of an EIP.
+	if b.chooseNetworkType() == InstanceNetworkVpc && !b.config.AssociatePublicIpAddress {
+		steps = append(steps, &stepConfigApsaraStackEIP{
 			RegionId:                b.config.ApsaraStackRegion,
 			InternetChargeType:      b.config.InternetChargeType,
 			InternetMaxBandwidthOut: b.config.InternetMaxBandwidthOut,
-			InstanceName:            b.config.InstanceName,
-			ZoneId:                  b.config.ZoneId,
-		})
-	if b.chooseNetworkType() == InstanceNetworkVpc {
-		steps = append(steps, &stepConfigApsaraStackEIP{
-			AssociatePublicIpAddress: b.config.AssociatePublicIpAddress,
-			RegionId:                 b.config.ApsaraStackRegion,
-			InternetChargeType:       b.config.InternetChargeType,
-			InternetMaxBandwidthOut:  b.config.InternetMaxBandwidthOut,
-			SSHPrivateIp:             b.config.SSHPrivateIp,
+			EipBandwidth:            b.config.EipBandwidth,
+			EipInternetChargeType:   b.config.EipInternetChargeType,
+			SSHPrivateIp:            b.config.SSHPrivateIp,
 		})
 	} else {
 		steps = append(steps, &stepConfigApsaraStackPublicIP{
@@ -144,10 +425,34 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	}
 	steps = append(steps,
 		&stepRunApsaraStackInstance{},
-		&stepStopApsaraStackInstance{
-			ForceStop:   b.config.ForceStopInstance,
+		&stepConsoleOutputOnFailure{},
+		&communicator.StepConnect{
+			Config:    &b.config.RunConfig.Comm,
+			Host:      SSHHost(&b.config.RunConfig.Comm, b.config.SSHPrivateIp),
+			SSHConfig: b.config.RunConfig.Comm.SSHConfigFunc(),
+		},
+		&stepWaitForCloudInit{
+			Enabled: b.config.WaitForCloudInit,
+			Timeout: b.config.CloudInitTimeout,
+		},
+		&common.StepProvision{})
+	if b.config.LoadBalancerId != "" {
+		steps = append(steps, &stepAttachLoadBalancer{
+			LoadBalancerId: b.config.LoadBalancerId,
+			Timeout:        b.config.LoadBalancerHealthCheckTimeout,
+		})
+	}
+	steps = append(steps,
+		&stepRunPreImageShutdownCommands{
+			Commands:    b.config.PreImageShutdownCommands,
 			DisableStop: b.config.DisableStopInstance,
 		},
+		&stepStopApsaraStackInstance{
+			ForceStop:        b.config.ForceStopInstance,
+			DisableStop:      b.config.DisableStopInstance,
+			ShutdownBehavior: b.config.ShutdownBehavior,
+			StoppedMode:      b.config.StoppedMode,
+		},
 		&stepDeleteApsaraStackImageSnapshots{
 			ApsaraStackImageForceDeleteSnapshots: b.config.ApsaraStackImageForceDeleteSnapshots,
 			ApsaraStackImageForceDelete:          b.config.ApsaraStackImageForceDelete,
@@ -156,54 +461,127 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 			ApsaraStackImageDestinationNames:     b.config.ApsaraStackImageConfig.ApsaraStackImageDestinationNames,
 		})
 
-	if b.config.ApsaraStackImageIgnoreDataDisks {
+	// The image is built from the snapshot of the system disk, when data
+	// disks are snapshotted separately too.
+	if b.config.ApsaraStackImageIgnoreDataDisks || len(b.config.ApsaraStackImageDataDiskSnapshots) > 0 {
 		steps = append(steps, &stepCreateApsaraStackSnapshot{
 			WaitSnapshotReadyTimeout: b.getSnapshotReadyTimeout(),
 		})
 	}
 
+	if len(b.config.ApsaraStackImageDataDiskSnapshots) > 0 {
+		steps = append(steps, &stepCreateApsaraStackDataDiskSnapshots{
+			Devices:                  b.config.ApsaraStackImageDataDiskSnapshots,
+			WaitSnapshotReadyTimeout: b.getSnapshotReadyTimeout(),
+		})
+	}
+
 	steps = append(steps,
 		&stepCreateApsaraStackImage{
 			ApsaraStackImageIgnoreDataDisks: b.config.ApsaraStackImageIgnoreDataDisks,
 			WaitSnapshotReadyTimeout:        b.getSnapshotReadyTimeout(),
+			RetryErrorCodes:                 b.config.CreateImageRetryCodes,
+			CleanupSnapshotsOnFailure:       !b.config.CleanupSnapshotsOnFailure.False(),
 		},
 		&stepCreateTags{
-			Tags: b.config.ApsaraStackImageTags,
-		},
+			Tags:                   b.config.ApsaraStackImageTags,
+			InheritSourceImageTags: b.config.InheritSourceImageTags,
+			SourceImageTagKeys:     b.config.SourceImageTagKeys,
+			Concurrency:            b.config.TagConcurrency,
+			TagSnapshots:           !b.config.ImageTagSnapshots.False(),
+		})
+	// The image is recorded once it's tagged, a resumed run doesn't tag it.
+	if b.config.ResumeStateFile != "" {
+		steps = append(steps, &stepSaveResumeState{})
+	}
+	steps = append(steps, b.distributeImageSteps()...)
+
+	return steps
+}
+
+// createInstanceStep returns the step creating the instance of the build.
+func (b *Builder) createInstanceStep() *stepCreateApsaraStackInstance {
+	return &stepCreateApsaraStackInstance{
+		IOOptimized:             b.config.IOOptimized,
+		InstanceType:            b.config.InstanceType,
+		UserData:                b.config.UserData,
+		UserDataFile:            b.config.UserDataFile,
+		UserDataParts:           b.config.UserDataParts,
+		BootstrapCommands:       b.config.BootstrapCommands,
+		UserDataCompress:        b.config.UserDataCompress,
+		RegionId:                b.config.ApsaraStackRegion,
+		InternetChargeType:      b.config.InternetChargeType,
+		InternetMaxBandwidthOut: b.config.InternetMaxBandwidthOut,
+		InstanceName:            b.config.InstanceName,
+		ZoneId:                  b.config.ZoneId,
+		RetryErrorCodes:         b.config.CreateInstanceRetryCodes,
+		CreateTimeout:           b.config.InstanceCreateTimeout,
+		CleanupRetryTimes:       b.config.CleanupRetryTimes,
+		CleanupRetryInterval:    b.config.CleanupRetryInterval,
+		CleanupRetryErrorCodes:  b.config.CleanupRetryCodes,
+		CleanupForceStop:        !b.config.CleanupForceStop.False(),
+		CleanupOrphanedDisks:    b.config.CleanupOrphanedDisks,
+		SnapshotOnCleanup:       b.config.SnapshotOnCleanup,
+		SnapshotRetentionDays:   b.config.SnapshotOnCleanupRetentionDays,
+		WaitSnapshotTimeout:     b.getSnapshotReadyTimeout(),
+	}
+}
+
+// distributeImageSteps returns the steps which copy, export and share the
+// image once it's created.
+func (b *Builder) distributeImageSteps() []multistep.Step {
+	steps := []multistep.Step{
 		&stepRegionCopyApsaraStackImage{
 			ApsaraStackImageDestinationRegions: b.config.ApsaraStackImageDestinationRegions,
 			ApsaraStackImageDestinationNames:   b.config.ApsaraStackImageDestinationNames,
 			RegionId:                           b.config.ApsaraStackRegion,
+			WaitTimeout:                        b.getSnapshotReadyTimeout(),
+			Concurrency:                        b.config.ImageCopyConcurrency,
 		},
+	}
+	if b.config.ImageExport.OSSBucket != "" {
+		steps = append(steps, &stepExportApsaraStackImage{
+			OSSBucket:   b.config.ImageExport.OSSBucket,
+			OSSPrefix:   b.config.ImageExport.OSSPrefix,
+			WaitTimeout: b.getSnapshotReadyTimeout(),
+		})
+	}
+	steps = append(steps,
 		&stepShareApsaraStackImage{
 			ApsaraStackImageShareAccounts:   b.config.ApsaraStackImageShareAccounts,
 			ApsaraStackImageUNShareAccounts: b.config.ApsaraStackImageUNShareAccounts,
 			RegionId:                        b.config.ApsaraStackRegion,
 		})
+	if b.config.ImageIdFile != "" {
+		steps = append(steps, &stepWriteImageIdFile{
+			Path: b.config.ImageIdFile,
+		})
+	}
 
-	// Run!
-	b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
-	b.runner.Run(ctx, state)
+	return steps
+}
 
-	// If there was an error, return that
-	if rawErr, ok := state.GetOk("error"); ok {
-		return nil, rawErr.(error)
+// shouldRetryBuild reports whether a failed run of the build is worth
+// retrying. The partial resources must have been cleaned up, which isn't the
+// case when -on-error asks to keep them.
+func (b *Builder) shouldRetryBuild(state multistep.StateBag, err error) bool {
+	if _, cancelled := state.GetOk(multistep.StateCancelled); cancelled {
+		return false
 	}
-
-	// If there are no ECS images, then just return
-	if _, ok := state.GetOk("ApsaraStackimages"); !ok {
-		return nil, nil
+	if b.config.PackerOnError != "" && b.config.PackerOnError != "cleanup" {
+		return false
 	}
-
-	// Build the artifact and return it
-	artifact := &Artifact{
-		ApsaraStackImages: state.Get("ApsaraStackimages").(map[string]string),
-		BuilderIdValue:    BuilderId,
-		Client:            client,
-		Config:            &b.config,
+	if b.config.KeepInstanceOnError {
+		return false
 	}
 
-	return artifact, nil
+	return isTransientError(err)
+}
+
+// buildRetryDelay returns the exponential backoff before the retry following
+// the given attempt.
+func buildRetryDelay(attempt int) time.Duration {
+	return buildRetryBaseDelay << uint(attempt)
 }
 
 func (b *Builder) chooseNetworkType() InstanceNetWork {
@@ -229,10 +607,28 @@ func (b *Builder) isUserDataNeeded() bool {
 		return true
 	}
 
-	return b.config.UserData != "" || b.config.UserDataFile != ""
+	return b.config.UserData != "" || b.config.UserDataFile != "" || len(b.config.UserDataParts) > 0 || len(b.config.BootstrapCommands) > 0
+}
+
+func (b *Builder) isDiskEncryptionRequested() bool {
+	if b.config.ECSSystemDiskMapping.Encrypted.True() {
+		return true
+	}
+	for _, disk := range b.config.ECSImagesDiskMappings {
+		if disk.Encrypted.True() {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (b *Builder) isKeyPairNeeded() bool {
+	// A communicator picked at build time may need a temporary key pair.
+	if b.config.detectCommunicator && !b.config.hasCredentials() {
+		return true
+	}
+
 	return b.config.Comm.SSHKeyPairName != "" || b.config.Comm.SSHTemporaryKeyPairName != ""
 }
 
@@ -6,6 +6,35 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+// FlatApsaraStackAssumeRole is an auto-generated flat version of ApsaraStackAssumeRole.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatApsaraStackAssumeRole struct {
+	RoleArn         *string `mapstructure:"role_arn" required:"true" cty:"role_arn" hcl:"role_arn"`
+	SessionName     *string `mapstructure:"session_name" required:"false" cty:"session_name" hcl:"session_name"`
+	Policy          *string `mapstructure:"policy" required:"false" cty:"policy" hcl:"policy"`
+	DurationSeconds *int    `mapstructure:"duration_seconds" required:"false" cty:"duration_seconds" hcl:"duration_seconds"`
+}
+
+// FlatMapstructure returns a new FlatApsaraStackAssumeRole.
+// FlatApsaraStackAssumeRole is an auto-generated flat version of ApsaraStackAssumeRole.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ApsaraStackAssumeRole) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatApsaraStackAssumeRole)
+}
+
+// HCL2Spec returns the hcl spec of a ApsaraStackAssumeRole.
+// This spec is used by HCL to read the fields of ApsaraStackAssumeRole.
+// The decoded values from this spec will then be applied to a FlatApsaraStackAssumeRole.
+func (*FlatApsaraStackAssumeRole) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"role_arn":         &hcldec.AttrSpec{Name: "role_arn", Type: cty.String, Required: false},
+		"session_name":     &hcldec.AttrSpec{Name: "session_name", Type: cty.String, Required: false},
+		"policy":           &hcldec.AttrSpec{Name: "policy", Type: cty.String, Required: false},
+		"duration_seconds": &hcldec.AttrSpec{Name: "duration_seconds", Type: cty.Number, Required: false},
+	}
+	return s
+}
+
 // FlatApsaraStackDiskDevice is an auto-generated flat version of ApsaraStackDiskDevice.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatApsaraStackDiskDevice struct {
@@ -17,6 +46,9 @@ type FlatApsaraStackDiskDevice struct {
 	DeleteWithInstance *bool   `mapstructure:"disk_delete_with_instance" required:"false" cty:"disk_delete_with_instance" hcl:"disk_delete_with_instance"`
 	Device             *string `mapstructure:"disk_device" required:"false" cty:"disk_device" hcl:"disk_device"`
 	Encrypted          *bool   `mapstructure:"disk_encrypted" required:"false" cty:"disk_encrypted" hcl:"disk_encrypted"`
+	KMSKeyId           *string `mapstructure:"disk_kms_key_id" required:"false" cty:"disk_kms_key_id" hcl:"disk_kms_key_id"`
+	PerformanceLevel   *string `mapstructure:"disk_performance_level" required:"false" cty:"disk_performance_level" hcl:"disk_performance_level"`
+	SnapshotPolicyId   *string `mapstructure:"disk_snapshot_policy_id" required:"false" cty:"disk_snapshot_policy_id" hcl:"disk_snapshot_policy_id"`
 }
 
 // FlatMapstructure returns a new FlatApsaraStackDiskDevice.
@@ -39,6 +71,119 @@ func (*FlatApsaraStackDiskDevice) HCL2Spec() map[string]hcldec.Spec {
 		"disk_delete_with_instance": &hcldec.AttrSpec{Name: "disk_delete_with_instance", Type: cty.Bool, Required: false},
 		"disk_device":               &hcldec.AttrSpec{Name: "disk_device", Type: cty.String, Required: false},
 		"disk_encrypted":            &hcldec.AttrSpec{Name: "disk_encrypted", Type: cty.Bool, Required: false},
+		"disk_kms_key_id":           &hcldec.AttrSpec{Name: "disk_kms_key_id", Type: cty.String, Required: false},
+		"disk_performance_level":    &hcldec.AttrSpec{Name: "disk_performance_level", Type: cty.String, Required: false},
+		"disk_snapshot_policy_id":   &hcldec.AttrSpec{Name: "disk_snapshot_policy_id", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatApsaraStackImageExport is an auto-generated flat version of ApsaraStackImageExport.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatApsaraStackImageExport struct {
+	OSSBucket *string `mapstructure:"oss_bucket" required:"true" cty:"oss_bucket" hcl:"oss_bucket"`
+	OSSPrefix *string `mapstructure:"oss_prefix" required:"false" cty:"oss_prefix" hcl:"oss_prefix"`
+}
+
+// FlatMapstructure returns a new FlatApsaraStackImageExport.
+// FlatApsaraStackImageExport is an auto-generated flat version of ApsaraStackImageExport.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ApsaraStackImageExport) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatApsaraStackImageExport)
+}
+
+// HCL2Spec returns the hcl spec of a ApsaraStackImageExport.
+// This spec is used by HCL to read the fields of ApsaraStackImageExport.
+// The decoded values from this spec will then be applied to a FlatApsaraStackImageExport.
+func (*FlatApsaraStackImageExport) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"oss_bucket": &hcldec.AttrSpec{Name: "oss_bucket", Type: cty.String, Required: false},
+		"oss_prefix": &hcldec.AttrSpec{Name: "oss_prefix", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatApsaraStackNetworkInterface is an auto-generated flat version of ApsaraStackNetworkInterface.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatApsaraStackNetworkInterface struct {
+	NetworkInterfaceId *string `mapstructure:"network_interface_id" required:"false" cty:"network_interface_id" hcl:"network_interface_id"`
+	VSwitchId          *string `mapstructure:"vswitch_id" required:"false" cty:"vswitch_id" hcl:"vswitch_id"`
+	SecurityGroupId    *string `mapstructure:"security_group_id" required:"false" cty:"security_group_id" hcl:"security_group_id"`
+}
+
+// FlatMapstructure returns a new FlatApsaraStackNetworkInterface.
+// FlatApsaraStackNetworkInterface is an auto-generated flat version of ApsaraStackNetworkInterface.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ApsaraStackNetworkInterface) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatApsaraStackNetworkInterface)
+}
+
+// HCL2Spec returns the hcl spec of a ApsaraStackNetworkInterface.
+// This spec is used by HCL to read the fields of ApsaraStackNetworkInterface.
+// The decoded values from this spec will then be applied to a FlatApsaraStackNetworkInterface.
+func (*FlatApsaraStackNetworkInterface) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"network_interface_id": &hcldec.AttrSpec{Name: "network_interface_id", Type: cty.String, Required: false},
+		"vswitch_id":           &hcldec.AttrSpec{Name: "vswitch_id", Type: cty.String, Required: false},
+		"security_group_id":    &hcldec.AttrSpec{Name: "security_group_id", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatApsaraStackSourceImageFilter is an auto-generated flat version of ApsaraStackSourceImageFilter.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatApsaraStackSourceImageFilter struct {
+	ImageName       *string `mapstructure:"image_name" required:"false" cty:"image_name" hcl:"image_name"`
+	ImageOwnerAlias *string `mapstructure:"image_owner_alias" required:"false" cty:"image_owner_alias" hcl:"image_owner_alias"`
+	OSType          *string `mapstructure:"os_type" required:"false" cty:"os_type" hcl:"os_type"`
+	Architecture    *string `mapstructure:"architecture" required:"false" cty:"architecture" hcl:"architecture"`
+	MostRecent      *bool   `mapstructure:"most_recent" required:"false" cty:"most_recent" hcl:"most_recent"`
+}
+
+// FlatMapstructure returns a new FlatApsaraStackSourceImageFilter.
+// FlatApsaraStackSourceImageFilter is an auto-generated flat version of ApsaraStackSourceImageFilter.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ApsaraStackSourceImageFilter) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatApsaraStackSourceImageFilter)
+}
+
+// HCL2Spec returns the hcl spec of a ApsaraStackSourceImageFilter.
+// This spec is used by HCL to read the fields of ApsaraStackSourceImageFilter.
+// The decoded values from this spec will then be applied to a FlatApsaraStackSourceImageFilter.
+func (*FlatApsaraStackSourceImageFilter) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"image_name":        &hcldec.AttrSpec{Name: "image_name", Type: cty.String, Required: false},
+		"image_owner_alias": &hcldec.AttrSpec{Name: "image_owner_alias", Type: cty.String, Required: false},
+		"os_type":           &hcldec.AttrSpec{Name: "os_type", Type: cty.String, Required: false},
+		"architecture":      &hcldec.AttrSpec{Name: "architecture", Type: cty.String, Required: false},
+		"most_recent":       &hcldec.AttrSpec{Name: "most_recent", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatApsaraStackUserDataPart is an auto-generated flat version of ApsaraStackUserDataPart.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatApsaraStackUserDataPart struct {
+	Content *string `mapstructure:"content" required:"false" cty:"content" hcl:"content"`
+	File    *string `mapstructure:"file" required:"false" cty:"file" hcl:"file"`
+	Type    *string `mapstructure:"type" required:"false" cty:"type" hcl:"type"`
+}
+
+// FlatMapstructure returns a new FlatApsaraStackUserDataPart.
+// FlatApsaraStackUserDataPart is an auto-generated flat version of ApsaraStackUserDataPart.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ApsaraStackUserDataPart) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatApsaraStackUserDataPart)
+}
+
+// HCL2Spec returns the hcl spec of a ApsaraStackUserDataPart.
+// This spec is used by HCL to read the fields of ApsaraStackUserDataPart.
+// The decoded values from this spec will then be applied to a FlatApsaraStackUserDataPart.
+func (*FlatApsaraStackUserDataPart) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"content": &hcldec.AttrSpec{Name: "content", Type: cty.String, Required: false},
+		"file":    &hcldec.AttrSpec{Name: "file", Type: cty.String, Required: false},
+		"type":    &hcldec.AttrSpec{Name: "type", Type: cty.String, Required: false},
 	}
 	return s
 }
@@ -46,106 +191,206 @@ func (*FlatApsaraStackDiskDevice) HCL2Spec() map[string]hcldec.Spec {
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName                      *string                     `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType                    *string                     `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerDebug                          *bool                       `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce                          *bool                       `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError                        *string                     `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars                       map[string]string           `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars                  []string                    `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	ApsaraStackAccessKey                 *string                     `mapstructure:"access_key" required:"true" cty:"access_key" hcl:"access_key"`
-	ApsaraStackSecretKey                 *string                     `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
-	ApsaraStackRegion                    *string                     `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
-	ApsaraStackSkipValidation            *bool                       `mapstructure:"skip_region_validation" required:"false" cty:"skip_region_validation" hcl:"skip_region_validation"`
-	ApsaraStackSkipImageValidation       *bool                       `mapstructure:"skip_image_validation" required:"false" cty:"skip_image_validation" hcl:"skip_image_validation"`
-	ApsaraStackProfile                   *string                     `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
-	ApsaraStackSharedCredentialsFile     *string                     `mapstructure:"shared_credentials_file" required:"false" cty:"shared_credentials_file" hcl:"shared_credentials_file"`
-	SecurityToken                        *string                     `mapstructure:"security_token" required:"false" cty:"security_token" hcl:"security_token"`
-	ApsaraStackImageName                 *string                     `mapstructure:"image_name" required:"true" cty:"image_name" hcl:"image_name"`
-	ApsaraStackImageVersion              *string                     `mapstructure:"image_version" required:"false" cty:"image_version" hcl:"image_version"`
-	ApsaraStackImageDescription          *string                     `mapstructure:"image_description" required:"false" cty:"image_description" hcl:"image_description"`
-	ApsaraStackImageShareAccounts        []string                    `mapstructure:"image_share_account" required:"false" cty:"image_share_account" hcl:"image_share_account"`
-	ApsaraStackImageUNShareAccounts      []string                    `mapstructure:"image_unshare_account" cty:"image_unshare_account" hcl:"image_unshare_account"`
-	ApsaraStackImageDestinationRegions   []string                    `mapstructure:"image_copy_regions" required:"false" cty:"image_copy_regions" hcl:"image_copy_regions"`
-	ApsaraStackImageDestinationNames     []string                    `mapstructure:"image_copy_names" required:"false" cty:"image_copy_names" hcl:"image_copy_names"`
-	ImageEncrypted                       *bool                       `mapstructure:"image_encrypted" required:"false" cty:"image_encrypted" hcl:"image_encrypted"`
-	ApsaraStackImageForceDelete          *bool                       `mapstructure:"image_force_delete" required:"false" cty:"image_force_delete" hcl:"image_force_delete"`
-	ApsaraStackImageForceDeleteSnapshots *bool                       `mapstructure:"image_force_delete_snapshots" required:"false" cty:"image_force_delete_snapshots" hcl:"image_force_delete_snapshots"`
-	ApsaraStackImageForceDeleteInstances *bool                       `mapstructure:"image_force_delete_instances" cty:"image_force_delete_instances" hcl:"image_force_delete_instances"`
-	ApsaraStackImageIgnoreDataDisks      *bool                       `mapstructure:"image_ignore_data_disks" required:"false" cty:"image_ignore_data_disks" hcl:"image_ignore_data_disks"`
-	ApsaraStackImageTags                 map[string]string           `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
-	ApsaraStackImageTag                  []hcl2template.FlatKeyValue `mapstructure:"tag" required:"false" cty:"tag" hcl:"tag"`
-	ECSSystemDiskMapping                 *FlatApsaraStackDiskDevice  `mapstructure:"system_disk_mapping" required:"false" cty:"system_disk_mapping" hcl:"system_disk_mapping"`
-	ECSImagesDiskMappings                []FlatApsaraStackDiskDevice `mapstructure:"image_disk_mappings" required:"false" cty:"image_disk_mappings" hcl:"image_disk_mappings"`
-	AssociatePublicIpAddress             *bool                       `mapstructure:"associate_public_ip_address" cty:"associate_public_ip_address" hcl:"associate_public_ip_address"`
-	ZoneId                               *string                     `mapstructure:"zone_id" required:"false" cty:"zone_id" hcl:"zone_id"`
-	IOOptimized                          *bool                       `mapstructure:"io_optimized" required:"false" cty:"io_optimized" hcl:"io_optimized"`
-	InstanceType                         *string                     `mapstructure:"instance_type" required:"true" cty:"instance_type" hcl:"instance_type"`
-	Description                          *string                     `mapstructure:"description" cty:"description" hcl:"description"`
-	ApsaraStackSourceImage               *string                     `mapstructure:"source_image" required:"true" cty:"source_image" hcl:"source_image"`
-	ForceStopInstance                    *bool                       `mapstructure:"force_stop_instance" required:"false" cty:"force_stop_instance" hcl:"force_stop_instance"`
-	DisableStopInstance                  *bool                       `mapstructure:"disable_stop_instance" required:"false" cty:"disable_stop_instance" hcl:"disable_stop_instance"`
-	SecurityGroupId                      *string                     `mapstructure:"security_group_id" required:"false" cty:"security_group_id" hcl:"security_group_id"`
-	SecurityGroupName                    *string                     `mapstructure:"security_group_name" required:"false" cty:"security_group_name" hcl:"security_group_name"`
-	UserData                             *string                     `mapstructure:"user_data" required:"false" cty:"user_data" hcl:"user_data"`
-	UserDataFile                         *string                     `mapstructure:"user_data_file" required:"false" cty:"user_data_file" hcl:"user_data_file"`
-	VpcId                                *string                     `mapstructure:"vpc_id" required:"false" cty:"vpc_id" hcl:"vpc_id"`
-	VpcName                              *string                     `mapstructure:"vpc_name" required:"false" cty:"vpc_name" hcl:"vpc_name"`
-	CidrBlock                            *string                     `mapstructure:"vpc_cidr_block" required:"false" cty:"vpc_cidr_block" hcl:"vpc_cidr_block"`
-	VSwitchId                            *string                     `mapstructure:"vswitch_id" required:"false" cty:"vswitch_id" hcl:"vswitch_id"`
-	VSwitchName                          *string                     `mapstructure:"vswitch_name" required:"false" cty:"vswitch_name" hcl:"vswitch_name"`
-	InstanceName                         *string                     `mapstructure:"instance_name" required:"false" cty:"instance_name" hcl:"instance_name"`
-	InternetChargeType                   *string                     `mapstructure:"internet_charge_type" required:"false" cty:"internet_charge_type" hcl:"internet_charge_type"`
-	InternetMaxBandwidthOut              *int                        `mapstructure:"internet_max_bandwidth_out" required:"false" cty:"internet_max_bandwidth_out" hcl:"internet_max_bandwidth_out"`
-	WaitSnapshotReadyTimeout             *int                        `mapstructure:"wait_snapshot_ready_timeout" required:"false" cty:"wait_snapshot_ready_timeout" hcl:"wait_snapshot_ready_timeout"`
-	Type                                 *string                     `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
-	PauseBeforeConnect                   *string                     `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
-	SSHHost                              *string                     `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
-	SSHPort                              *int                        `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
-	SSHUsername                          *string                     `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword                          *string                     `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
-	SSHKeyPairName                       *string                     `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
-	SSHTemporaryKeyPairName              *string                     `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
-	SSHCiphers                           []string                    `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
-	SSHClearAuthorizedKeys               *bool                       `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
-	SSHKEXAlgos                          []string                    `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
-	SSHPrivateKeyFile                    *string                     `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
-	SSHCertificateFile                   *string                     `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
-	SSHPty                               *bool                       `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
-	SSHTimeout                           *string                     `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
-	SSHWaitTimeout                       *string                     `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	SSHAgentAuth                         *bool                       `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
-	SSHDisableAgentForwarding            *bool                       `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
-	SSHHandshakeAttempts                 *int                        `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
-	SSHBastionHost                       *string                     `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
-	SSHBastionPort                       *int                        `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
-	SSHBastionAgentAuth                  *bool                       `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
-	SSHBastionUsername                   *string                     `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
-	SSHBastionPassword                   *string                     `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
-	SSHBastionInteractive                *bool                       `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
-	SSHBastionPrivateKeyFile             *string                     `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
-	SSHBastionCertificateFile            *string                     `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
-	SSHFileTransferMethod                *string                     `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
-	SSHProxyHost                         *string                     `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
-	SSHProxyPort                         *int                        `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
-	SSHProxyUsername                     *string                     `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
-	SSHProxyPassword                     *string                     `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
-	SSHKeepAliveInterval                 *string                     `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
-	SSHReadWriteTimeout                  *string                     `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
-	SSHRemoteTunnels                     []string                    `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
-	SSHLocalTunnels                      []string                    `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
-	SSHPublicKey                         []byte                      `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
-	SSHPrivateKey                        []byte                      `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
-	WinRMUser                            *string                     `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword                        *string                     `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMHost                            *string                     `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMNoProxy                         *bool                       `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
-	WinRMPort                            *int                        `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
-	WinRMTimeout                         *string                     `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
-	WinRMUseSSL                          *bool                       `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
-	WinRMInsecure                        *bool                       `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
-	WinRMUseNTLM                         *bool                       `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
-	SSHPrivateIp                         *bool                       `mapstructure:"ssh_private_ip" required:"false" cty:"ssh_private_ip" hcl:"ssh_private_ip"`
+	PackerBuildName                        *string                           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType                      *string                           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerDebug                            *bool                             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce                            *bool                             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError                          *string                           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars                         map[string]string                 `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars                    []string                          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	ApsaraStackAccessKey                   *string                           `mapstructure:"access_key" required:"true" cty:"access_key" hcl:"access_key"`
+	ApsaraStackSecretKey                   *string                           `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
+	ApsaraStackRegion                      *string                           `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
+	ApsaraStackSkipValidation              *bool                             `mapstructure:"skip_region_validation" required:"false" cty:"skip_region_validation" hcl:"skip_region_validation"`
+	ApsaraStackSkipResourceGroupValidation *bool                             `mapstructure:"skip_resource_group_validation" required:"false" cty:"skip_resource_group_validation" hcl:"skip_resource_group_validation"`
+	ApsaraStackSkipImageValidation         *bool                             `mapstructure:"skip_image_validation" required:"false" cty:"skip_image_validation" hcl:"skip_image_validation"`
+	ApsaraStackProfile                     *string                           `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
+	ApsaraStackSharedCredentialsFile       *string                           `mapstructure:"shared_credentials_file" required:"false" cty:"shared_credentials_file" hcl:"shared_credentials_file"`
+	SecurityToken                          *string                           `mapstructure:"security_token" required:"false" cty:"security_token" hcl:"security_token"`
+	EcsApiVersion                          *string                           `mapstructure:"ecs_api_version" required:"false" cty:"ecs_api_version" hcl:"ecs_api_version"`
+	Endpoints                              map[string]string                 `mapstructure:"endpoints" required:"false" cty:"endpoints" hcl:"endpoints"`
+	HttpProxy                              *string                           `mapstructure:"http_proxy" required:"false" cty:"http_proxy" hcl:"http_proxy"`
+	HttpsProxy                             *string                           `mapstructure:"https_proxy" required:"false" cty:"https_proxy" hcl:"https_proxy"`
+	NoProxy                                *string                           `mapstructure:"no_proxy" required:"false" cty:"no_proxy" hcl:"no_proxy"`
+	ThrottlingRetryBaseDelay               *string                           `mapstructure:"throttling_retry_base_delay" required:"false" cty:"throttling_retry_base_delay" hcl:"throttling_retry_base_delay"`
+	ThrottlingRetryTimes                   *int                              `mapstructure:"throttling_retry_times" required:"false" cty:"throttling_retry_times" hcl:"throttling_retry_times"`
+	ApiConnectTimeout                      *string                           `mapstructure:"api_connect_timeout" required:"false" cty:"api_connect_timeout" hcl:"api_connect_timeout"`
+	ApiReadTimeout                         *string                           `mapstructure:"api_read_timeout" required:"false" cty:"api_read_timeout" hcl:"api_read_timeout"`
+	MaxConcurrentApiCalls                  *int                              `mapstructure:"max_concurrent_api_calls" required:"false" cty:"max_concurrent_api_calls" hcl:"max_concurrent_api_calls"`
+	ApiMaxIdleConns                        *int                              `mapstructure:"api_max_idle_conns" required:"false" cty:"api_max_idle_conns" hcl:"api_max_idle_conns"`
+	ApiIdleConnTimeout                     *string                           `mapstructure:"api_idle_conn_timeout" required:"false" cty:"api_idle_conn_timeout" hcl:"api_idle_conn_timeout"`
+	ApiDisableKeepAlives                   *bool                             `mapstructure:"api_disable_keep_alives" required:"false" cty:"api_disable_keep_alives" hcl:"api_disable_keep_alives"`
+	AssumeRole                             *FlatApsaraStackAssumeRole        `mapstructure:"assume_role" required:"false" cty:"assume_role" hcl:"assume_role"`
+	ApsaraStackImageName                   *string                           `mapstructure:"image_name" required:"true" cty:"image_name" hcl:"image_name"`
+	ApsaraStackImageNameAutoAppend         *string                           `mapstructure:"image_name_auto_append" required:"false" cty:"image_name_auto_append" hcl:"image_name_auto_append"`
+	ApsaraStackImageVersion                *string                           `mapstructure:"image_version" required:"false" cty:"image_version" hcl:"image_version"`
+	ApsaraStackImageDescription            *string                           `mapstructure:"image_description" required:"false" cty:"image_description" hcl:"image_description"`
+	ApsaraStackImageShareAccounts          []string                          `mapstructure:"image_share_account" required:"false" cty:"image_share_account" hcl:"image_share_account"`
+	ApsaraStackImageUNShareAccounts        []string                          `mapstructure:"image_unshare_account" cty:"image_unshare_account" hcl:"image_unshare_account"`
+	ApsaraStackImageDestinationRegions     []string                          `mapstructure:"image_copy_regions" required:"false" cty:"image_copy_regions" hcl:"image_copy_regions"`
+	ImageCopyConcurrency                   *int                              `mapstructure:"image_copy_concurrency" required:"false" cty:"image_copy_concurrency" hcl:"image_copy_concurrency"`
+	ApsaraStackImageDestinationNames       []string                          `mapstructure:"image_copy_names" required:"false" cty:"image_copy_names" hcl:"image_copy_names"`
+	ApsaraStackImageFamily                 *string                           `mapstructure:"image_family" required:"false" cty:"image_family" hcl:"image_family"`
+	ApsaraStackImageBootMode               *string                           `mapstructure:"boot_mode" required:"false" cty:"boot_mode" hcl:"boot_mode"`
+	ApsaraStackImageLicenseType            *string                           `mapstructure:"image_license_type" required:"false" cty:"image_license_type" hcl:"image_license_type"`
+	ImageEncrypted                         *bool                             `mapstructure:"image_encrypted" required:"false" cty:"image_encrypted" hcl:"image_encrypted"`
+	ImageKMSKeyId                          *string                           `mapstructure:"image_kms_key_id" required:"false" cty:"image_kms_key_id" hcl:"image_kms_key_id"`
+	StrictDiskEncryption                   *bool                             `mapstructure:"strict_disk_encryption" required:"false" cty:"strict_disk_encryption" hcl:"strict_disk_encryption"`
+	ApsaraStackImageForceDelete            *bool                             `mapstructure:"image_force_delete" required:"false" cty:"image_force_delete" hcl:"image_force_delete"`
+	FailIfNoop                             *bool                             `mapstructure:"fail_if_noop" required:"false" cty:"fail_if_noop" hcl:"fail_if_noop"`
+	ApsaraStackImageForceDeleteSnapshots   *bool                             `mapstructure:"image_force_delete_snapshots" required:"false" cty:"image_force_delete_snapshots" hcl:"image_force_delete_snapshots"`
+	ApsaraStackImageForceDeleteInstances   *bool                             `mapstructure:"image_force_delete_instances" cty:"image_force_delete_instances" hcl:"image_force_delete_instances"`
+	ApsaraStackImageIgnoreDataDisks        *bool                             `mapstructure:"image_ignore_data_disks" required:"false" cty:"image_ignore_data_disks" hcl:"image_ignore_data_disks"`
+	ApsaraStackImageDataDiskSnapshots      []string                          `mapstructure:"image_data_disk_snapshots" required:"false" cty:"image_data_disk_snapshots" hcl:"image_data_disk_snapshots"`
+	ApsaraStackImageTags                   map[string]string                 `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
+	ImageTagSnapshots                      *bool                             `mapstructure:"image_tag_snapshots" required:"false" cty:"image_tag_snapshots" hcl:"image_tag_snapshots"`
+	ApsaraStackImageTag                    []hcl2template.FlatKeyValue       `mapstructure:"tag" required:"false" cty:"tag" hcl:"tag"`
+	VerifyTagsVisible                      *bool                             `mapstructure:"verify_tags_visible" required:"false" cty:"verify_tags_visible" hcl:"verify_tags_visible"`
+	TagVisibilityTimeout                   *string                           `mapstructure:"tag_visibility_timeout" required:"false" cty:"tag_visibility_timeout" hcl:"tag_visibility_timeout"`
+	TagConcurrency                         *int                              `mapstructure:"tag_concurrency" required:"false" cty:"tag_concurrency" hcl:"tag_concurrency"`
+	InheritSourceImageTags                 *bool                             `mapstructure:"inherit_source_image_tags" required:"false" cty:"inherit_source_image_tags" hcl:"inherit_source_image_tags"`
+	SourceImageTagKeys                     []string                          `mapstructure:"source_image_tag_keys" required:"false" cty:"source_image_tag_keys" hcl:"source_image_tag_keys"`
+	ArtifactWebhookUrl                     *string                           `mapstructure:"artifact_webhook_url" required:"false" cty:"artifact_webhook_url" hcl:"artifact_webhook_url"`
+	ArtifactWebhookAuthHeader              *string                           `mapstructure:"artifact_webhook_auth_header" required:"false" cty:"artifact_webhook_auth_header" hcl:"artifact_webhook_auth_header"`
+	ArtifactWebhookTimeout                 *string                           `mapstructure:"artifact_webhook_timeout" required:"false" cty:"artifact_webhook_timeout" hcl:"artifact_webhook_timeout"`
+	ImageIdFile                            *string                           `mapstructure:"image_id_file" required:"false" cty:"image_id_file" hcl:"image_id_file"`
+	ResumeStateFile                        *string                           `mapstructure:"resume_state_file" required:"false" cty:"resume_state_file" hcl:"resume_state_file"`
+	ImageExport                            *FlatApsaraStackImageExport       `mapstructure:"image_export" required:"false" cty:"image_export" hcl:"image_export"`
+	ECSSystemDiskMapping                   *FlatApsaraStackDiskDevice        `mapstructure:"system_disk_mapping" required:"false" cty:"system_disk_mapping" hcl:"system_disk_mapping"`
+	ECSImagesDiskMappings                  []FlatApsaraStackDiskDevice       `mapstructure:"image_disk_mappings" required:"false" cty:"image_disk_mappings" hcl:"image_disk_mappings"`
+	AssociatePublicIpAddress               *bool                             `mapstructure:"associate_public_ip_address" cty:"associate_public_ip_address" hcl:"associate_public_ip_address"`
+	ZoneId                                 *string                           `mapstructure:"zone_id" required:"false" cty:"zone_id" hcl:"zone_id"`
+	ZoneSelection                          *string                           `mapstructure:"zone_selection" required:"false" cty:"zone_selection" hcl:"zone_selection"`
+	IOOptimized                            *bool                             `mapstructure:"io_optimized" required:"false" cty:"io_optimized" hcl:"io_optimized"`
+	InstanceType                           *string                           `mapstructure:"instance_type" required:"true" cty:"instance_type" hcl:"instance_type"`
+	Cpu                                    *int                              `mapstructure:"cpu" required:"false" cty:"cpu" hcl:"cpu"`
+	Memory                                 *int                              `mapstructure:"memory" required:"false" cty:"memory" hcl:"memory"`
+	SkipInstanceTypeValidation             *bool                             `mapstructure:"skip_instance_type_validation" required:"false" cty:"skip_instance_type_validation" hcl:"skip_instance_type_validation"`
+	DryRun                                 *bool                             `mapstructure:"dry_run" required:"false" cty:"dry_run" hcl:"dry_run"`
+	Description                            *string                           `mapstructure:"description" cty:"description" hcl:"description"`
+	ApsaraStackSourceImage                 *string                           `mapstructure:"source_image" required:"true" cty:"source_image" hcl:"source_image"`
+	SourceImageFilter                      *FlatApsaraStackSourceImageFilter `mapstructure:"source_image_filter" required:"false" cty:"source_image_filter" hcl:"source_image_filter"`
+	SourceSnapshotId                       *string                           `mapstructure:"source_snapshot_id" required:"false" cty:"source_snapshot_id" hcl:"source_snapshot_id"`
+	KeepSourceSnapshotImage                *bool                             `mapstructure:"keep_source_snapshot_image" required:"false" cty:"keep_source_snapshot_image" hcl:"keep_source_snapshot_image"`
+	ForceStopInstance                      *bool                             `mapstructure:"force_stop_instance" required:"false" cty:"force_stop_instance" hcl:"force_stop_instance"`
+	ShutdownBehavior                       *string                           `mapstructure:"shutdown_behavior" required:"false" cty:"shutdown_behavior" hcl:"shutdown_behavior"`
+	StoppedMode                            *string                           `mapstructure:"stopped_mode" required:"false" cty:"stopped_mode" hcl:"stopped_mode"`
+	DisableStopInstance                    *bool                             `mapstructure:"disable_stop_instance" required:"false" cty:"disable_stop_instance" hcl:"disable_stop_instance"`
+	PreImageShutdownCommands               []string                          `mapstructure:"pre_image_shutdown_commands" required:"false" cty:"pre_image_shutdown_commands" hcl:"pre_image_shutdown_commands"`
+	SecurityGroupId                        *string                           `mapstructure:"security_group_id" required:"false" cty:"security_group_id" hcl:"security_group_id"`
+	SecurityGroupName                      *string                           `mapstructure:"security_group_name" required:"false" cty:"security_group_name" hcl:"security_group_name"`
+	SecurityGroupIds                       []string                          `mapstructure:"security_group_ids" required:"false" cty:"security_group_ids" hcl:"security_group_ids"`
+	SecurityGroupIngressPorts              []int                             `mapstructure:"security_group_ingress_ports" required:"false" cty:"security_group_ingress_ports" hcl:"security_group_ingress_ports"`
+	SecurityGroupSourceCidrs               []string                          `mapstructure:"security_group_source_cidrs" required:"false" cty:"security_group_source_cidrs" hcl:"security_group_source_cidrs"`
+	SourceDestCheck                        *bool                             `mapstructure:"source_dest_check" required:"false" cty:"source_dest_check" hcl:"source_dest_check"`
+	SecondaryNetworkInterface              *FlatApsaraStackNetworkInterface  `mapstructure:"secondary_network_interface" required:"false" cty:"secondary_network_interface" hcl:"secondary_network_interface"`
+	DedicatedHostId                        *string                           `mapstructure:"dedicated_host_id" required:"false" cty:"dedicated_host_id" hcl:"dedicated_host_id"`
+	DeploymentSetId                        *string                           `mapstructure:"deployment_set_id" required:"false" cty:"deployment_set_id" hcl:"deployment_set_id"`
+	UserData                               *string                           `mapstructure:"user_data" required:"false" cty:"user_data" hcl:"user_data"`
+	UserDataFile                           *string                           `mapstructure:"user_data_file" required:"false" cty:"user_data_file" hcl:"user_data_file"`
+	UserDataParts                          []FlatApsaraStackUserDataPart     `mapstructure:"user_data_parts" required:"false" cty:"user_data_parts" hcl:"user_data_parts"`
+	UserDataCompress                       *bool                             `mapstructure:"user_data_compress" required:"false" cty:"user_data_compress" hcl:"user_data_compress"`
+	BootstrapCommands                      []string                          `mapstructure:"bootstrap_commands" required:"false" cty:"bootstrap_commands" hcl:"bootstrap_commands"`
+	WinRMHttpsBootstrap                    *bool                             `mapstructure:"winrm_https_bootstrap" required:"false" cty:"winrm_https_bootstrap" hcl:"winrm_https_bootstrap"`
+	WinRMHttpsCertDnsName                  *string                           `mapstructure:"winrm_https_cert_dns_name" required:"false" cty:"winrm_https_cert_dns_name" hcl:"winrm_https_cert_dns_name"`
+	WinRMHttpsCertValidityDays             *int                              `mapstructure:"winrm_https_cert_validity_days" required:"false" cty:"winrm_https_cert_validity_days" hcl:"winrm_https_cert_validity_days"`
+	RamRoleName                            *string                           `mapstructure:"ram_role_name" required:"false" cty:"ram_role_name" hcl:"ram_role_name"`
+	VerifyRamRole                          *bool                             `mapstructure:"verify_ram_role" required:"false" cty:"verify_ram_role" hcl:"verify_ram_role"`
+	VpcId                                  *string                           `mapstructure:"vpc_id" required:"false" cty:"vpc_id" hcl:"vpc_id"`
+	VpcName                                *string                           `mapstructure:"vpc_name" required:"false" cty:"vpc_name" hcl:"vpc_name"`
+	CidrBlock                              *string                           `mapstructure:"vpc_cidr_block" required:"false" cty:"vpc_cidr_block" hcl:"vpc_cidr_block"`
+	ForbidClassicNetwork                   *bool                             `mapstructure:"forbid_classic_network" required:"false" cty:"forbid_classic_network" hcl:"forbid_classic_network"`
+	VSwitchId                              *string                           `mapstructure:"vswitch_id" required:"false" cty:"vswitch_id" hcl:"vswitch_id"`
+	VSwitchName                            *string                           `mapstructure:"vswitch_name" required:"false" cty:"vswitch_name" hcl:"vswitch_name"`
+	PrivateIp                              *string                           `mapstructure:"private_ip" required:"false" cty:"private_ip" hcl:"private_ip"`
+	Ipv6AddressCount                       *int                              `mapstructure:"ipv6_address_count" required:"false" cty:"ipv6_address_count" hcl:"ipv6_address_count"`
+	MetadataTokenMode                      *string                           `mapstructure:"metadata_token_mode" required:"false" cty:"metadata_token_mode" hcl:"metadata_token_mode"`
+	MetadataHopLimit                       *int                              `mapstructure:"metadata_http_put_response_hop_limit" required:"false" cty:"metadata_http_put_response_hop_limit" hcl:"metadata_http_put_response_hop_limit"`
+	RunTags                                map[string]string                 `mapstructure:"run_tags" required:"false" cty:"run_tags" hcl:"run_tags"`
+	DiskTags                               map[string]string                 `mapstructure:"disk_tags" required:"false" cty:"disk_tags" hcl:"disk_tags"`
+	InstanceName                           *string                           `mapstructure:"instance_name" required:"false" cty:"instance_name" hcl:"instance_name"`
+	InstanceDescription                    *string                           `mapstructure:"instance_description" required:"false" cty:"instance_description" hcl:"instance_description"`
+	HostName                               *string                           `mapstructure:"host_name" required:"false" cty:"host_name" hcl:"host_name"`
+	InternetChargeType                     *string                           `mapstructure:"internet_charge_type" required:"false" cty:"internet_charge_type" hcl:"internet_charge_type"`
+	InternetMaxBandwidthOut                *int                              `mapstructure:"internet_max_bandwidth_out" required:"false" cty:"internet_max_bandwidth_out" hcl:"internet_max_bandwidth_out"`
+	EipBandwidth                           *int                              `mapstructure:"eip_bandwidth" required:"false" cty:"eip_bandwidth" hcl:"eip_bandwidth"`
+	EipInternetChargeType                  *string                           `mapstructure:"eip_internet_charge_type" required:"false" cty:"eip_internet_charge_type" hcl:"eip_internet_charge_type"`
+	InstanceChargeType                     *string                           `mapstructure:"instance_charge_type" required:"false" cty:"instance_charge_type" hcl:"instance_charge_type"`
+	SpotStrategy                           *string                           `mapstructure:"spot_strategy" required:"false" cty:"spot_strategy" hcl:"spot_strategy"`
+	SpotPriceLimit                         *float64                          `mapstructure:"spot_price_limit" required:"false" cty:"spot_price_limit" hcl:"spot_price_limit"`
+	AutoRenew                              *bool                             `mapstructure:"auto_renew" required:"false" cty:"auto_renew" hcl:"auto_renew"`
+	InstanceAutoReleaseTime                *string                           `mapstructure:"instance_auto_release_time" required:"false" cty:"instance_auto_release_time" hcl:"instance_auto_release_time"`
+	Period                                 *int                              `mapstructure:"period" required:"false" cty:"period" hcl:"period"`
+	PeriodUnit                             *string                           `mapstructure:"period_unit" required:"false" cty:"period_unit" hcl:"period_unit"`
+	WaitSnapshotReadyTimeout               *int                              `mapstructure:"wait_snapshot_ready_timeout" required:"false" cty:"wait_snapshot_ready_timeout" hcl:"wait_snapshot_ready_timeout"`
+	BuildRetries                           *int                              `mapstructure:"build_retries" required:"false" cty:"build_retries" hcl:"build_retries"`
+	ClientToken                            *string                           `mapstructure:"client_token" required:"false" cty:"client_token" hcl:"client_token"`
+	CreateInstanceRetryCodes               []string                          `mapstructure:"create_instance_retry_codes" required:"false" cty:"create_instance_retry_codes" hcl:"create_instance_retry_codes"`
+	CreateImageRetryCodes                  []string                          `mapstructure:"create_image_retry_codes" required:"false" cty:"create_image_retry_codes" hcl:"create_image_retry_codes"`
+	InstanceCreateTimeout                  *string                           `mapstructure:"instance_create_timeout" required:"false" cty:"instance_create_timeout" hcl:"instance_create_timeout"`
+	StatusPollInterval                     *string                           `mapstructure:"status_poll_interval" required:"false" cty:"status_poll_interval" hcl:"status_poll_interval"`
+	WaitForCloudInit                       *bool                             `mapstructure:"wait_for_cloud_init" required:"false" cty:"wait_for_cloud_init" hcl:"wait_for_cloud_init"`
+	CloudInitTimeout                       *string                           `mapstructure:"cloud_init_timeout" required:"false" cty:"cloud_init_timeout" hcl:"cloud_init_timeout"`
+	LoadBalancerId                         *string                           `mapstructure:"load_balancer_id" required:"false" cty:"load_balancer_id" hcl:"load_balancer_id"`
+	LoadBalancerHealthCheckTimeout         *string                           `mapstructure:"load_balancer_health_check_timeout" required:"false" cty:"load_balancer_health_check_timeout" hcl:"load_balancer_health_check_timeout"`
+	CleanupRetryTimes                      *int                              `mapstructure:"cleanup_retry_times" required:"false" cty:"cleanup_retry_times" hcl:"cleanup_retry_times"`
+	CleanupRetryInterval                   *string                           `mapstructure:"cleanup_retry_interval" required:"false" cty:"cleanup_retry_interval" hcl:"cleanup_retry_interval"`
+	CleanupRetryCodes                      []string                          `mapstructure:"cleanup_retry_codes" required:"false" cty:"cleanup_retry_codes" hcl:"cleanup_retry_codes"`
+	CleanupForceStop                       *bool                             `mapstructure:"cleanup_force_stop" required:"false" cty:"cleanup_force_stop" hcl:"cleanup_force_stop"`
+	CleanupOrphanedDisks                   *bool                             `mapstructure:"cleanup_orphaned_disks" required:"false" cty:"cleanup_orphaned_disks" hcl:"cleanup_orphaned_disks"`
+	SnapshotOnCleanup                      *bool                             `mapstructure:"snapshot_on_cleanup" required:"false" cty:"snapshot_on_cleanup" hcl:"snapshot_on_cleanup"`
+	SnapshotOnCleanupRetentionDays         *int                              `mapstructure:"snapshot_on_cleanup_retention_days" required:"false" cty:"snapshot_on_cleanup_retention_days" hcl:"snapshot_on_cleanup_retention_days"`
+	KeepInstanceOnError                    *bool                             `mapstructure:"keep_instance_on_error" required:"false" cty:"keep_instance_on_error" hcl:"keep_instance_on_error"`
+	CleanupSnapshotsOnFailure              *bool                             `mapstructure:"cleanup_snapshots_on_failure" required:"false" cty:"cleanup_snapshots_on_failure" hcl:"cleanup_snapshots_on_failure"`
+	SSHPasswordAutoGenerate                *bool                             `mapstructure:"ssh_password_auto_generate" required:"false" cty:"ssh_password_auto_generate" hcl:"ssh_password_auto_generate"`
+	Type                                   *string                           `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect                     *string                           `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                                *string                           `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                                *int                              `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername                            *string                           `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword                            *string                           `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName                         *string                           `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName                *string                           `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHCiphers                             []string                          `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys                 *bool                             `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos                            []string                          `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile                      *string                           `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile                     *string                           `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                                 *bool                             `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                             *string                           `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout                         *string                           `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth                           *bool                             `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding              *bool                             `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts                   *int                              `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost                         *string                           `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort                         *int                              `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth                    *bool                             `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername                     *string                           `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword                     *string                           `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive                  *bool                             `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile               *string                           `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile              *string                           `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod                  *string                           `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost                           *string                           `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort                           *int                              `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername                       *string                           `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword                       *string                           `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval                   *string                           `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout                    *string                           `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels                       []string                          `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels                        []string                          `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey                           []byte                            `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey                          []byte                            `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                              *string                           `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword                          *string                           `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                              *string                           `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy                           *bool                             `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                              *int                              `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout                           *string                           `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL                            *bool                             `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure                          *bool                             `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM                           *bool                             `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	SSHPrivateIp                           *bool                             `mapstructure:"ssh_private_ip" required:"false" cty:"ssh_private_ip" hcl:"ssh_private_ip"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -160,106 +405,206 @@ func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec }
 // The decoded values from this spec will then be applied to a FlatConfig.
 func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"packer_build_name":            &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
-		"packer_builder_type":          &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
-		"packer_debug":                 &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
-		"packer_force":                 &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
-		"packer_on_error":              &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
-		"packer_user_variables":        &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
-		"packer_sensitive_variables":   &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
-		"access_key":                   &hcldec.AttrSpec{Name: "access_key", Type: cty.String, Required: false},
-		"secret_key":                   &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
-		"region":                       &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
-		"skip_region_validation":       &hcldec.AttrSpec{Name: "skip_region_validation", Type: cty.Bool, Required: false},
-		"skip_image_validation":        &hcldec.AttrSpec{Name: "skip_image_validation", Type: cty.Bool, Required: false},
-		"profile":                      &hcldec.AttrSpec{Name: "profile", Type: cty.String, Required: false},
-		"shared_credentials_file":      &hcldec.AttrSpec{Name: "shared_credentials_file", Type: cty.String, Required: false},
-		"security_token":               &hcldec.AttrSpec{Name: "security_token", Type: cty.String, Required: false},
-		"image_name":                   &hcldec.AttrSpec{Name: "image_name", Type: cty.String, Required: false},
-		"image_version":                &hcldec.AttrSpec{Name: "image_version", Type: cty.String, Required: false},
-		"image_description":            &hcldec.AttrSpec{Name: "image_description", Type: cty.String, Required: false},
-		"image_share_account":          &hcldec.AttrSpec{Name: "image_share_account", Type: cty.List(cty.String), Required: false},
-		"image_unshare_account":        &hcldec.AttrSpec{Name: "image_unshare_account", Type: cty.List(cty.String), Required: false},
-		"image_copy_regions":           &hcldec.AttrSpec{Name: "image_copy_regions", Type: cty.List(cty.String), Required: false},
-		"image_copy_names":             &hcldec.AttrSpec{Name: "image_copy_names", Type: cty.List(cty.String), Required: false},
-		"image_encrypted":              &hcldec.AttrSpec{Name: "image_encrypted", Type: cty.Bool, Required: false},
-		"image_force_delete":           &hcldec.AttrSpec{Name: "image_force_delete", Type: cty.Bool, Required: false},
-		"image_force_delete_snapshots": &hcldec.AttrSpec{Name: "image_force_delete_snapshots", Type: cty.Bool, Required: false},
-		"image_force_delete_instances": &hcldec.AttrSpec{Name: "image_force_delete_instances", Type: cty.Bool, Required: false},
-		"image_ignore_data_disks":      &hcldec.AttrSpec{Name: "image_ignore_data_disks", Type: cty.Bool, Required: false},
-		"tags":                         &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
-		"tag":                          &hcldec.BlockListSpec{TypeName: "tag", Nested: hcldec.ObjectSpec((*hcl2template.FlatKeyValue)(nil).HCL2Spec())},
-		"system_disk_mapping":          &hcldec.BlockSpec{TypeName: "system_disk_mapping", Nested: hcldec.ObjectSpec((*FlatApsaraStackDiskDevice)(nil).HCL2Spec())},
-		"image_disk_mappings":          &hcldec.BlockListSpec{TypeName: "image_disk_mappings", Nested: hcldec.ObjectSpec((*FlatApsaraStackDiskDevice)(nil).HCL2Spec())},
-		"associate_public_ip_address":  &hcldec.AttrSpec{Name: "associate_public_ip_address", Type: cty.Bool, Required: false},
-		"zone_id":                      &hcldec.AttrSpec{Name: "zone_id", Type: cty.String, Required: false},
-		"io_optimized":                 &hcldec.AttrSpec{Name: "io_optimized", Type: cty.Bool, Required: false},
-		"instance_type":                &hcldec.AttrSpec{Name: "instance_type", Type: cty.String, Required: false},
-		"description":                  &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
-		"source_image":                 &hcldec.AttrSpec{Name: "source_image", Type: cty.String, Required: false},
-		"force_stop_instance":          &hcldec.AttrSpec{Name: "force_stop_instance", Type: cty.Bool, Required: false},
-		"disable_stop_instance":        &hcldec.AttrSpec{Name: "disable_stop_instance", Type: cty.Bool, Required: false},
-		"security_group_id":            &hcldec.AttrSpec{Name: "security_group_id", Type: cty.String, Required: false},
-		"security_group_name":          &hcldec.AttrSpec{Name: "security_group_name", Type: cty.String, Required: false},
-		"user_data":                    &hcldec.AttrSpec{Name: "user_data", Type: cty.String, Required: false},
-		"user_data_file":               &hcldec.AttrSpec{Name: "user_data_file", Type: cty.String, Required: false},
-		"vpc_id":                       &hcldec.AttrSpec{Name: "vpc_id", Type: cty.String, Required: false},
-		"vpc_name":                     &hcldec.AttrSpec{Name: "vpc_name", Type: cty.String, Required: false},
-		"vpc_cidr_block":               &hcldec.AttrSpec{Name: "vpc_cidr_block", Type: cty.String, Required: false},
-		"vswitch_id":                   &hcldec.AttrSpec{Name: "vswitch_id", Type: cty.String, Required: false},
-		"vswitch_name":                 &hcldec.AttrSpec{Name: "vswitch_name", Type: cty.String, Required: false},
-		"instance_name":                &hcldec.AttrSpec{Name: "instance_name", Type: cty.String, Required: false},
-		"internet_charge_type":         &hcldec.AttrSpec{Name: "internet_charge_type", Type: cty.String, Required: false},
-		"internet_max_bandwidth_out":   &hcldec.AttrSpec{Name: "internet_max_bandwidth_out", Type: cty.Number, Required: false},
-		"wait_snapshot_ready_timeout":  &hcldec.AttrSpec{Name: "wait_snapshot_ready_timeout", Type: cty.Number, Required: false},
-		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
-		"pause_before_connecting":      &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
-		"ssh_host":                     &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
-		"ssh_port":                     &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
-		"ssh_username":                 &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
-		"ssh_password":                 &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
-		"ssh_keypair_name":             &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
-		"temporary_key_pair_name":      &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
-		"ssh_ciphers":                  &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
-		"ssh_clear_authorized_keys":    &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
-		"ssh_key_exchange_algorithms":  &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
-		"ssh_private_key_file":         &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
-		"ssh_certificate_file":         &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
-		"ssh_pty":                      &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
-		"ssh_timeout":                  &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
-		"ssh_wait_timeout":             &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
-		"ssh_agent_auth":               &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
-		"ssh_disable_agent_forwarding": &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
-		"ssh_handshake_attempts":       &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
-		"ssh_bastion_host":             &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
-		"ssh_bastion_port":             &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
-		"ssh_bastion_agent_auth":       &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
-		"ssh_bastion_username":         &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
-		"ssh_bastion_password":         &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
-		"ssh_bastion_interactive":      &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
-		"ssh_bastion_private_key_file": &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
-		"ssh_bastion_certificate_file": &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
-		"ssh_file_transfer_method":     &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
-		"ssh_proxy_host":               &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
-		"ssh_proxy_port":               &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
-		"ssh_proxy_username":           &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
-		"ssh_proxy_password":           &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
-		"ssh_keep_alive_interval":      &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
-		"ssh_read_write_timeout":       &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
-		"ssh_remote_tunnels":           &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
-		"ssh_local_tunnels":            &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
-		"ssh_public_key":               &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
-		"ssh_private_key":              &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
-		"winrm_username":               &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
-		"winrm_password":               &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
-		"winrm_host":                   &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
-		"winrm_no_proxy":               &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
-		"winrm_port":                   &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
-		"winrm_timeout":                &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
-		"winrm_use_ssl":                &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
-		"winrm_insecure":               &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
-		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
-		"ssh_private_ip":               &hcldec.AttrSpec{Name: "ssh_private_ip", Type: cty.Bool, Required: false},
+		"packer_build_name":                    &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
+		"packer_builder_type":                  &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
+		"packer_debug":                         &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
+		"packer_force":                         &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
+		"packer_on_error":                      &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
+		"packer_user_variables":                &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
+		"packer_sensitive_variables":           &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
+		"access_key":                           &hcldec.AttrSpec{Name: "access_key", Type: cty.String, Required: false},
+		"secret_key":                           &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
+		"region":                               &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
+		"skip_region_validation":               &hcldec.AttrSpec{Name: "skip_region_validation", Type: cty.Bool, Required: false},
+		"skip_resource_group_validation":       &hcldec.AttrSpec{Name: "skip_resource_group_validation", Type: cty.Bool, Required: false},
+		"skip_image_validation":                &hcldec.AttrSpec{Name: "skip_image_validation", Type: cty.Bool, Required: false},
+		"profile":                              &hcldec.AttrSpec{Name: "profile", Type: cty.String, Required: false},
+		"shared_credentials_file":              &hcldec.AttrSpec{Name: "shared_credentials_file", Type: cty.String, Required: false},
+		"security_token":                       &hcldec.AttrSpec{Name: "security_token", Type: cty.String, Required: false},
+		"ecs_api_version":                      &hcldec.AttrSpec{Name: "ecs_api_version", Type: cty.String, Required: false},
+		"endpoints":                            &hcldec.AttrSpec{Name: "endpoints", Type: cty.Map(cty.String), Required: false},
+		"http_proxy":                           &hcldec.AttrSpec{Name: "http_proxy", Type: cty.String, Required: false},
+		"https_proxy":                          &hcldec.AttrSpec{Name: "https_proxy", Type: cty.String, Required: false},
+		"no_proxy":                             &hcldec.AttrSpec{Name: "no_proxy", Type: cty.String, Required: false},
+		"throttling_retry_base_delay":          &hcldec.AttrSpec{Name: "throttling_retry_base_delay", Type: cty.String, Required: false},
+		"throttling_retry_times":               &hcldec.AttrSpec{Name: "throttling_retry_times", Type: cty.Number, Required: false},
+		"api_connect_timeout":                  &hcldec.AttrSpec{Name: "api_connect_timeout", Type: cty.String, Required: false},
+		"api_read_timeout":                     &hcldec.AttrSpec{Name: "api_read_timeout", Type: cty.String, Required: false},
+		"max_concurrent_api_calls":             &hcldec.AttrSpec{Name: "max_concurrent_api_calls", Type: cty.Number, Required: false},
+		"api_max_idle_conns":                   &hcldec.AttrSpec{Name: "api_max_idle_conns", Type: cty.Number, Required: false},
+		"api_idle_conn_timeout":                &hcldec.AttrSpec{Name: "api_idle_conn_timeout", Type: cty.String, Required: false},
+		"api_disable_keep_alives":              &hcldec.AttrSpec{Name: "api_disable_keep_alives", Type: cty.Bool, Required: false},
+		"assume_role":                          &hcldec.BlockSpec{TypeName: "assume_role", Nested: hcldec.ObjectSpec((*FlatApsaraStackAssumeRole)(nil).HCL2Spec())},
+		"image_name":                           &hcldec.AttrSpec{Name: "image_name", Type: cty.String, Required: false},
+		"image_name_auto_append":               &hcldec.AttrSpec{Name: "image_name_auto_append", Type: cty.String, Required: false},
+		"image_version":                        &hcldec.AttrSpec{Name: "image_version", Type: cty.String, Required: false},
+		"image_description":                    &hcldec.AttrSpec{Name: "image_description", Type: cty.String, Required: false},
+		"image_share_account":                  &hcldec.AttrSpec{Name: "image_share_account", Type: cty.List(cty.String), Required: false},
+		"image_unshare_account":                &hcldec.AttrSpec{Name: "image_unshare_account", Type: cty.List(cty.String), Required: false},
+		"image_copy_regions":                   &hcldec.AttrSpec{Name: "image_copy_regions", Type: cty.List(cty.String), Required: false},
+		"image_copy_concurrency":               &hcldec.AttrSpec{Name: "image_copy_concurrency", Type: cty.Number, Required: false},
+		"image_copy_names":                     &hcldec.AttrSpec{Name: "image_copy_names", Type: cty.List(cty.String), Required: false},
+		"image_family":                         &hcldec.AttrSpec{Name: "image_family", Type: cty.String, Required: false},
+		"boot_mode":                            &hcldec.AttrSpec{Name: "boot_mode", Type: cty.String, Required: false},
+		"image_license_type":                   &hcldec.AttrSpec{Name: "image_license_type", Type: cty.String, Required: false},
+		"image_encrypted":                      &hcldec.AttrSpec{Name: "image_encrypted", Type: cty.Bool, Required: false},
+		"image_kms_key_id":                     &hcldec.AttrSpec{Name: "image_kms_key_id", Type: cty.String, Required: false},
+		"strict_disk_encryption":               &hcldec.AttrSpec{Name: "strict_disk_encryption", Type: cty.Bool, Required: false},
+		"image_force_delete":                   &hcldec.AttrSpec{Name: "image_force_delete", Type: cty.Bool, Required: false},
+		"fail_if_noop":                         &hcldec.AttrSpec{Name: "fail_if_noop", Type: cty.Bool, Required: false},
+		"image_force_delete_snapshots":         &hcldec.AttrSpec{Name: "image_force_delete_snapshots", Type: cty.Bool, Required: false},
+		"image_force_delete_instances":         &hcldec.AttrSpec{Name: "image_force_delete_instances", Type: cty.Bool, Required: false},
+		"image_ignore_data_disks":              &hcldec.AttrSpec{Name: "image_ignore_data_disks", Type: cty.Bool, Required: false},
+		"image_data_disk_snapshots":            &hcldec.AttrSpec{Name: "image_data_disk_snapshots", Type: cty.List(cty.String), Required: false},
+		"tags":                                 &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
+		"image_tag_snapshots":                  &hcldec.AttrSpec{Name: "image_tag_snapshots", Type: cty.Bool, Required: false},
+		"tag":                                  &hcldec.BlockListSpec{TypeName: "tag", Nested: hcldec.ObjectSpec((*hcl2template.FlatKeyValue)(nil).HCL2Spec())},
+		"verify_tags_visible":                  &hcldec.AttrSpec{Name: "verify_tags_visible", Type: cty.Bool, Required: false},
+		"tag_visibility_timeout":               &hcldec.AttrSpec{Name: "tag_visibility_timeout", Type: cty.String, Required: false},
+		"tag_concurrency":                      &hcldec.AttrSpec{Name: "tag_concurrency", Type: cty.Number, Required: false},
+		"inherit_source_image_tags":            &hcldec.AttrSpec{Name: "inherit_source_image_tags", Type: cty.Bool, Required: false},
+		"source_image_tag_keys":                &hcldec.AttrSpec{Name: "source_image_tag_keys", Type: cty.List(cty.String), Required: false},
+		"artifact_webhook_url":                 &hcldec.AttrSpec{Name: "artifact_webhook_url", Type: cty.String, Required: false},
+		"artifact_webhook_auth_header":         &hcldec.AttrSpec{Name: "artifact_webhook_auth_header", Type: cty.String, Required: false},
+		"artifact_webhook_timeout":             &hcldec.AttrSpec{Name: "artifact_webhook_timeout", Type: cty.String, Required: false},
+		"image_id_file":                        &hcldec.AttrSpec{Name: "image_id_file", Type: cty.String, Required: false},
+		"resume_state_file":                    &hcldec.AttrSpec{Name: "resume_state_file", Type: cty.String, Required: false},
+		"image_export":                         &hcldec.BlockSpec{TypeName: "image_export", Nested: hcldec.ObjectSpec((*FlatApsaraStackImageExport)(nil).HCL2Spec())},
+		"system_disk_mapping":                  &hcldec.BlockSpec{TypeName: "system_disk_mapping", Nested: hcldec.ObjectSpec((*FlatApsaraStackDiskDevice)(nil).HCL2Spec())},
+		"image_disk_mappings":                  &hcldec.BlockListSpec{TypeName: "image_disk_mappings", Nested: hcldec.ObjectSpec((*FlatApsaraStackDiskDevice)(nil).HCL2Spec())},
+		"associate_public_ip_address":          &hcldec.AttrSpec{Name: "associate_public_ip_address", Type: cty.Bool, Required: false},
+		"zone_id":                              &hcldec.AttrSpec{Name: "zone_id", Type: cty.String, Required: false},
+		"zone_selection":                       &hcldec.AttrSpec{Name: "zone_selection", Type: cty.String, Required: false},
+		"io_optimized":                         &hcldec.AttrSpec{Name: "io_optimized", Type: cty.Bool, Required: false},
+		"instance_type":                        &hcldec.AttrSpec{Name: "instance_type", Type: cty.String, Required: false},
+		"cpu":                                  &hcldec.AttrSpec{Name: "cpu", Type: cty.Number, Required: false},
+		"memory":                               &hcldec.AttrSpec{Name: "memory", Type: cty.Number, Required: false},
+		"skip_instance_type_validation":        &hcldec.AttrSpec{Name: "skip_instance_type_validation", Type: cty.Bool, Required: false},
+		"dry_run":                              &hcldec.AttrSpec{Name: "dry_run", Type: cty.Bool, Required: false},
+		"description":                          &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
+		"source_image":                         &hcldec.AttrSpec{Name: "source_image", Type: cty.String, Required: false},
+		"source_image_filter":                  &hcldec.BlockSpec{TypeName: "source_image_filter", Nested: hcldec.ObjectSpec((*FlatApsaraStackSourceImageFilter)(nil).HCL2Spec())},
+		"source_snapshot_id":                   &hcldec.AttrSpec{Name: "source_snapshot_id", Type: cty.String, Required: false},
+		"keep_source_snapshot_image":           &hcldec.AttrSpec{Name: "keep_source_snapshot_image", Type: cty.Bool, Required: false},
+		"force_stop_instance":                  &hcldec.AttrSpec{Name: "force_stop_instance", Type: cty.Bool, Required: false},
+		"shutdown_behavior":                    &hcldec.AttrSpec{Name: "shutdown_behavior", Type: cty.String, Required: false},
+		"stopped_mode":                         &hcldec.AttrSpec{Name: "stopped_mode", Type: cty.String, Required: false},
+		"disable_stop_instance":                &hcldec.AttrSpec{Name: "disable_stop_instance", Type: cty.Bool, Required: false},
+		"pre_image_shutdown_commands":          &hcldec.AttrSpec{Name: "pre_image_shutdown_commands", Type: cty.List(cty.String), Required: false},
+		"security_group_id":                    &hcldec.AttrSpec{Name: "security_group_id", Type: cty.String, Required: false},
+		"security_group_name":                  &hcldec.AttrSpec{Name: "security_group_name", Type: cty.String, Required: false},
+		"security_group_ids":                   &hcldec.AttrSpec{Name: "security_group_ids", Type: cty.List(cty.String), Required: false},
+		"security_group_ingress_ports":         &hcldec.AttrSpec{Name: "security_group_ingress_ports", Type: cty.List(cty.Number), Required: false},
+		"security_group_source_cidrs":          &hcldec.AttrSpec{Name: "security_group_source_cidrs", Type: cty.List(cty.String), Required: false},
+		"source_dest_check":                    &hcldec.AttrSpec{Name: "source_dest_check", Type: cty.Bool, Required: false},
+		"secondary_network_interface":          &hcldec.BlockSpec{TypeName: "secondary_network_interface", Nested: hcldec.ObjectSpec((*FlatApsaraStackNetworkInterface)(nil).HCL2Spec())},
+		"dedicated_host_id":                    &hcldec.AttrSpec{Name: "dedicated_host_id", Type: cty.String, Required: false},
+		"deployment_set_id":                    &hcldec.AttrSpec{Name: "deployment_set_id", Type: cty.String, Required: false},
+		"user_data":                            &hcldec.AttrSpec{Name: "user_data", Type: cty.String, Required: false},
+		"user_data_file":                       &hcldec.AttrSpec{Name: "user_data_file", Type: cty.String, Required: false},
+		"user_data_parts":                      &hcldec.BlockListSpec{TypeName: "user_data_parts", Nested: hcldec.ObjectSpec((*FlatApsaraStackUserDataPart)(nil).HCL2Spec())},
+		"user_data_compress":                   &hcldec.AttrSpec{Name: "user_data_compress", Type: cty.Bool, Required: false},
+		"bootstrap_commands":                   &hcldec.AttrSpec{Name: "bootstrap_commands", Type: cty.List(cty.String), Required: false},
+		"winrm_https_bootstrap":                &hcldec.AttrSpec{Name: "winrm_https_bootstrap", Type: cty.Bool, Required: false},
+		"winrm_https_cert_dns_name":            &hcldec.AttrSpec{Name: "winrm_https_cert_dns_name", Type: cty.String, Required: false},
+		"winrm_https_cert_validity_days":       &hcldec.AttrSpec{Name: "winrm_https_cert_validity_days", Type: cty.Number, Required: false},
+		"ram_role_name":                        &hcldec.AttrSpec{Name: "ram_role_name", Type: cty.String, Required: false},
+		"verify_ram_role":                      &hcldec.AttrSpec{Name: "verify_ram_role", Type: cty.Bool, Required: false},
+		"vpc_id":                               &hcldec.AttrSpec{Name: "vpc_id", Type: cty.String, Required: false},
+		"vpc_name":                             &hcldec.AttrSpec{Name: "vpc_name", Type: cty.String, Required: false},
+		"vpc_cidr_block":                       &hcldec.AttrSpec{Name: "vpc_cidr_block", Type: cty.String, Required: false},
+		"forbid_classic_network":               &hcldec.AttrSpec{Name: "forbid_classic_network", Type: cty.Bool, Required: false},
+		"vswitch_id":                           &hcldec.AttrSpec{Name: "vswitch_id", Type: cty.String, Required: false},
+		"vswitch_name":                         &hcldec.AttrSpec{Name: "vswitch_name", Type: cty.String, Required: false},
+		"private_ip":                           &hcldec.AttrSpec{Name: "private_ip", Type: cty.String, Required: false},
+		"ipv6_address_count":                   &hcldec.AttrSpec{Name: "ipv6_address_count", Type: cty.Number, Required: false},
+		"metadata_token_mode":                  &hcldec.AttrSpec{Name: "metadata_token_mode", Type: cty.String, Required: false},
+		"metadata_http_put_response_hop_limit": &hcldec.AttrSpec{Name: "metadata_http_put_response_hop_limit", Type: cty.Number, Required: false},
+		"run_tags":                             &hcldec.AttrSpec{Name: "run_tags", Type: cty.Map(cty.String), Required: false},
+		"disk_tags":                            &hcldec.AttrSpec{Name: "disk_tags", Type: cty.Map(cty.String), Required: false},
+		"instance_name":                        &hcldec.AttrSpec{Name: "instance_name", Type: cty.String, Required: false},
+		"instance_description":                 &hcldec.AttrSpec{Name: "instance_description", Type: cty.String, Required: false},
+		"host_name":                            &hcldec.AttrSpec{Name: "host_name", Type: cty.String, Required: false},
+		"internet_charge_type":                 &hcldec.AttrSpec{Name: "internet_charge_type", Type: cty.String, Required: false},
+		"internet_max_bandwidth_out":           &hcldec.AttrSpec{Name: "internet_max_bandwidth_out", Type: cty.Number, Required: false},
+		"eip_bandwidth":                        &hcldec.AttrSpec{Name: "eip_bandwidth", Type: cty.Number, Required: false},
+		"eip_internet_charge_type":             &hcldec.AttrSpec{Name: "eip_internet_charge_type", Type: cty.String, Required: false},
+		"instance_charge_type":                 &hcldec.AttrSpec{Name: "instance_charge_type", Type: cty.String, Required: false},
+		"spot_strategy":                        &hcldec.AttrSpec{Name: "spot_strategy", Type: cty.String, Required: false},
+		"spot_price_limit":                     &hcldec.AttrSpec{Name: "spot_price_limit", Type: cty.Number, Required: false},
+		"auto_renew":                           &hcldec.AttrSpec{Name: "auto_renew", Type: cty.Bool, Required: false},
+		"instance_auto_release_time":           &hcldec.AttrSpec{Name: "instance_auto_release_time", Type: cty.String, Required: false},
+		"period":                               &hcldec.AttrSpec{Name: "period", Type: cty.Number, Required: false},
+		"period_unit":                          &hcldec.AttrSpec{Name: "period_unit", Type: cty.String, Required: false},
+		"wait_snapshot_ready_timeout":          &hcldec.AttrSpec{Name: "wait_snapshot_ready_timeout", Type: cty.Number, Required: false},
+		"build_retries":                        &hcldec.AttrSpec{Name: "build_retries", Type: cty.Number, Required: false},
+		"client_token":                         &hcldec.AttrSpec{Name: "client_token", Type: cty.String, Required: false},
+		"create_instance_retry_codes":          &hcldec.AttrSpec{Name: "create_instance_retry_codes", Type: cty.List(cty.String), Required: false},
+		"create_image_retry_codes":             &hcldec.AttrSpec{Name: "create_image_retry_codes", Type: cty.List(cty.String), Required: false},
+		"instance_create_timeout":              &hcldec.AttrSpec{Name: "instance_create_timeout", Type: cty.String, Required: false},
+		"status_poll_interval":                 &hcldec.AttrSpec{Name: "status_poll_interval", Type: cty.String, Required: false},
+		"wait_for_cloud_init":                  &hcldec.AttrSpec{Name: "wait_for_cloud_init", Type: cty.Bool, Required: false},
+		"cloud_init_timeout":                   &hcldec.AttrSpec{Name: "cloud_init_timeout", Type: cty.String, Required: false},
+		"load_balancer_id":                     &hcldec.AttrSpec{Name: "load_balancer_id", Type: cty.String, Required: false},
+		"load_balancer_health_check_timeout":   &hcldec.AttrSpec{Name: "load_balancer_health_check_timeout", Type: cty.String, Required: false},
+		"cleanup_retry_times":                  &hcldec.AttrSpec{Name: "cleanup_retry_times", Type: cty.Number, Required: false},
+		"cleanup_retry_interval":               &hcldec.AttrSpec{Name: "cleanup_retry_interval", Type: cty.String, Required: false},
+		"cleanup_retry_codes":                  &hcldec.AttrSpec{Name: "cleanup_retry_codes", Type: cty.List(cty.String), Required: false},
+		"cleanup_force_stop":                   &hcldec.AttrSpec{Name: "cleanup_force_stop", Type: cty.Bool, Required: false},
+		"cleanup_orphaned_disks":               &hcldec.AttrSpec{Name: "cleanup_orphaned_disks", Type: cty.Bool, Required: false},
+		"snapshot_on_cleanup":                  &hcldec.AttrSpec{Name: "snapshot_on_cleanup", Type: cty.Bool, Required: false},
+		"snapshot_on_cleanup_retention_days":   &hcldec.AttrSpec{Name: "snapshot_on_cleanup_retention_days", Type: cty.Number, Required: false},
+		"keep_instance_on_error":               &hcldec.AttrSpec{Name: "keep_instance_on_error", Type: cty.Bool, Required: false},
+		"cleanup_snapshots_on_failure":         &hcldec.AttrSpec{Name: "cleanup_snapshots_on_failure", Type: cty.Bool, Required: false},
+		"ssh_password_auto_generate":           &hcldec.AttrSpec{Name: "ssh_password_auto_generate", Type: cty.Bool, Required: false},
+		"communicator":                         &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
+		"pause_before_connecting":              &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
+		"ssh_host":                             &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
+		"ssh_port":                             &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
+		"ssh_username":                         &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
+		"ssh_password":                         &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
+		"ssh_keypair_name":                     &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_name":              &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
+		"ssh_ciphers":                          &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
+		"ssh_clear_authorized_keys":            &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
+		"ssh_key_exchange_algorithms":          &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
+		"ssh_private_key_file":                 &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
+		"ssh_certificate_file":                 &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
+		"ssh_pty":                              &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
+		"ssh_timeout":                          &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
+		"ssh_wait_timeout":                     &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
+		"ssh_agent_auth":                       &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_disable_agent_forwarding":         &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
+		"ssh_handshake_attempts":               &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
+		"ssh_bastion_host":                     &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
+		"ssh_bastion_port":                     &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
+		"ssh_bastion_agent_auth":               &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_bastion_username":                 &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
+		"ssh_bastion_password":                 &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
+		"ssh_bastion_interactive":              &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
+		"ssh_bastion_private_key_file":         &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
+		"ssh_bastion_certificate_file":         &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
+		"ssh_file_transfer_method":             &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
+		"ssh_proxy_host":                       &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
+		"ssh_proxy_port":                       &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
+		"ssh_proxy_username":                   &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
+		"ssh_proxy_password":                   &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
+		"ssh_keep_alive_interval":              &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
+		"ssh_read_write_timeout":               &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
+		"ssh_remote_tunnels":                   &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_local_tunnels":                    &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_public_key":                       &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
+		"ssh_private_key":                      &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
+		"winrm_username":                       &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
+		"winrm_password":                       &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
+		"winrm_host":                           &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
+		"winrm_no_proxy":                       &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
+		"winrm_port":                           &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
+		"winrm_timeout":                        &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
+		"winrm_use_ssl":                        &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
+		"winrm_insecure":                       &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
+		"winrm_use_ntlm":                       &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
+		"ssh_private_ip":                       &hcldec.AttrSpec{Name: "ssh_private_ip", Type: cty.Bool, Required: false},
 	}
 	return s
 }
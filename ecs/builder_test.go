@@ -1,9 +1,15 @@
 package ecs
 
 import (
+	"net/http"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/hashicorp/packer/helper/multistep"
 	//"github.com/hashicorp/packer/packer"
 	"github.com/hashicorp/packer/packer"
 )
@@ -18,6 +24,30 @@ func testBuilderConfig() map[string]interface{} {
 		"ssh_username":  "root",
 		"image_name":    "foo",
 		"io_optimized":  true,
+		// The tests run without an ECS endpoint to look the regions up.
+		"skip_region_validation": true,
+		// The security group would be opened to anywhere with a warning.
+		"security_group_source_cidrs": []string{"10.0.0.0/8"},
+	}
+}
+
+func TestBuilderPrepare_SecurityGroupSourceCidrs(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	delete(config, "security_group_source_cidrs")
+
+	_, warnings, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("opening the security group to anywhere should warn: %#v", warnings)
+	}
+
+	b = Builder{}
+	config["security_group_source_cidrs"] = []string{"10.0.0.0/33"}
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatalf("should have error")
 	}
 }
 
@@ -233,3 +263,197 @@ func TestBuilderPrepare_WaitSnapshotReadyTimeout(t *testing.T) {
 		t.Fatalf("default timeout is not set properly, expect: %d, actual: %d", APSARASTACK_DEFAULT_TIMEOUT, b.getSnapshotReadyTimeout())
 	}
 }
+
+func TestBuilderPrepare_FailIfNoop(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	config["fail_if_noop"] = true
+
+	_, warnings, err := b.Prepare(config)
+	if len(warnings) > 0 {
+		t.Fatalf("bad: %#v", warnings)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.ApsaraStackImageTags[TagKeySourceImage] != "foo" {
+		t.Fatalf("the source image should be tagged, actual: %#v", b.config.ApsaraStackImageTags)
+	}
+}
+
+func TestBuilderPrepare_InternetMaxBandwidthOut(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if b.config.InternetMaxBandwidthOut != nil {
+		t.Fatalf("internet_max_bandwidth_out should be unset, actual: %d", *b.config.InternetMaxBandwidthOut)
+	}
+
+	b = Builder{}
+	config["internet_max_bandwidth_out"] = 0
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if b.config.InternetMaxBandwidthOut == nil || *b.config.InternetMaxBandwidthOut != 0 {
+		t.Fatalf("an explicit 0 should be kept for internet_max_bandwidth_out")
+	}
+}
+
+func TestBuilderPrepare_BuildRetries(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	config["build_retries"] = -1
+
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if buildRetryDelay(0) != 30*time.Second || buildRetryDelay(2) != 2*time.Minute {
+		t.Fatalf("bad backoff: %s, %s", buildRetryDelay(0), buildRetryDelay(2))
+	}
+}
+
+func TestBuilderPrepare_SourceDestCheck(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	config["source_dest_check"] = false
+	// A password doesn't need a VPC, unlike a key pair.
+	config["ssh_password"] = "Passw0rd"
+
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatal("source_dest_check should need a VPC")
+	}
+
+	b = Builder{}
+	config["vpc_id"] = "vpc-test"
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestBuilderPrepare_ForbidClassicNetwork(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	config["forbid_classic_network"] = true
+	config["ssh_password"] = "Passw0rd"
+
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatal("classic network should be forbidden")
+	}
+
+	b = Builder{}
+	config["vpc_id"] = "vpc-test"
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestBuilderPrepare_DryRun(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	config["dry_run"] = true
+	config["vpc_id"] = "vpc-test"
+
+	if _, _, err := b.Prepare(config); err == nil || !strings.Contains(err.Error(), "security_group_id") || !strings.Contains(err.Error(), "vswitch_id") {
+		t.Fatalf("a dry run should need the existing network resources: %v", err)
+	}
+
+	b = Builder{}
+	config["security_group_id"] = "sg-test"
+	config["vswitch_id"] = "vsw-test"
+	delete(config, "security_group_source_cidrs")
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Only the steps which create nothing run before the dry run.
+	steps := b.buildSteps()
+	if _, ok := steps[len(steps)-1].(*stepDryRunInstance); !ok {
+		t.Fatalf("the dry run should be the last step: %#v", steps[len(steps)-1])
+	}
+	for _, step := range steps {
+		switch step.(type) {
+		case *stepCreateSourceImage, *stepConfigApsaraStackVPC, *stepConfigApsaraStackVSwitch,
+			*stepConfigApsaraStackKeyPair, *stepConfigApsaraStackSecurityGroup, *stepCreateApsaraStackInstance:
+			t.Fatalf("a dry run shouldn't run %T", step)
+		}
+	}
+}
+
+func TestBuilder_shouldRetryBuild(t *testing.T) {
+	var b Builder
+	transient := errors.NewServerError(http.StatusServiceUnavailable, testErrorBody("ServiceUnavailable"), "")
+
+	state := new(multistep.BasicStateBag)
+	if !b.shouldRetryBuild(state, transient) {
+		t.Fatal("a transient error should be retried")
+	}
+
+	b.config.PackerOnError = "abort"
+	if b.shouldRetryBuild(state, transient) {
+		t.Fatal("resources kept by -on-error=abort shouldn't be retried over")
+	}
+
+	b.config.PackerOnError = ""
+	state.Put(multistep.StateCancelled, true)
+	if b.shouldRetryBuild(state, transient) {
+		t.Fatal("a cancelled build shouldn't be retried")
+	}
+}
+
+func TestBuilderPrepare_RunTags(t *testing.T) {
+	var b Builder
+	config := testBuilderConfig()
+	config["packer_build_name"] = "nightly"
+	config["run_tags"] = map[string]string{"build": "{{build_name}}"}
+
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if b.config.RunTags["build"] != "nightly" {
+		t.Fatalf("run_tags should be interpolated, actual: %#v", b.config.RunTags)
+	}
+}
+
+func TestDetectSourceImageCommunicator(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeImages" || params.Get("ImageId") != "m-source" {
+			t.Errorf("bad describe request: %s %v", action, params)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		if params.Get("ImageOwnerAlias") == "system" {
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-source","OSType":"windows"}]}}`
+	})
+
+	config := &Config{}
+	config.ApsaraStackSourceImage = "m-source"
+	warning := detectSourceImageCommunicator(client, config)
+	if config.Comm.Type != "winrm" {
+		t.Fatalf("a windows source image should use winrm: %s", config.Comm.Type)
+	}
+	if !strings.Contains(warning, "winrm") || !strings.Contains(warning, "m-source") {
+		t.Fatalf("the warning should tell the communicator: %s", warning)
+	}
+
+	if errs := config.RunConfig.Prepare(&config.ctx); config.detectCommunicator {
+		t.Fatalf("a detected communicator shouldn't be detected again at build time: %s", errs)
+	}
+}
+
+func TestDetectSourceImageCommunicator_notFound(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		return http.StatusBadRequest, testErrorBody("InvalidImageId.NotFound")
+	})
+
+	config := &Config{}
+	config.ApsaraStackSourceImage = "m-source"
+	if warning := detectSourceImageCommunicator(client, config); warning != "" || config.Comm.Type != "" {
+		t.Fatalf("the communicator should be left to the build: %q %s", warning, config.Comm.Type)
+	}
+}
@@ -0,0 +1,86 @@
+package ecs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// cleanupSummary collects how cleaning up the temporary resources of a build
+// went, so that the resources left behind are reported in one place at the
+// end of the build instead of between the cleanup messages.
+type cleanupSummary struct {
+	lock    sync.Mutex
+	results []cleanupResult
+}
+
+type cleanupResult struct {
+	kind string
+	id   string
+	err  error
+}
+
+// recordCleanup adds the outcome of cleaning up a resource to the summary in
+// state, err is nil when the resource was removed.
+func recordCleanup(state multistep.StateBag, kind string, id string, err error) {
+	raw, ok := state.GetOk("cleanup_summary")
+	if !ok {
+		return
+	}
+
+	summary := raw.(*cleanupSummary)
+	summary.lock.Lock()
+	defer summary.lock.Unlock()
+	summary.results = append(summary.results, cleanupResult{kind: kind, id: id, err: err})
+}
+
+// leaked returns the resources which failed to be cleaned up, in the order
+// they were cleaned up.
+func (s *cleanupSummary) leaked() []cleanupResult {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var leaked []cleanupResult
+	for _, result := range s.results {
+		if result.err != nil {
+			leaked = append(leaked, result)
+		}
+	}
+	return leaked
+}
+
+// report tells the user which resources were cleaned up, the ones left
+// behind stand out as an error listing their ids.
+func (s *cleanupSummary) report(ui packer.Ui) {
+	s.lock.Lock()
+	results := append([]cleanupResult{}, s.results...)
+	s.lock.Unlock()
+
+	if len(results) == 0 {
+		return
+	}
+
+	ui.Say("Cleanup summary:")
+	for _, result := range results {
+		if result.err != nil {
+			ui.Message(fmt.Sprintf("%s %s: failed, %s", result.kind, result.id, result.err))
+		} else {
+			ui.Message(fmt.Sprintf("%s %s: removed", result.kind, result.id))
+		}
+	}
+
+	leaked := s.leaked()
+	if len(leaked) == 0 {
+		return
+	}
+
+	resources := make([]string, 0, len(leaked))
+	for _, result := range leaked {
+		resources = append(resources, fmt.Sprintf("  %s %s", result.kind, result.id))
+	}
+	ui.Error(fmt.Sprintf("The following resources were left behind and have to be removed by hand:\n%s",
+		strings.Join(resources, "\n")))
+}
@@ -0,0 +1,37 @@
+package ecs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestCleanupSummary(t *testing.T) {
+	summary := &cleanupSummary{}
+	state := testState(nil, &Config{})
+	state.Put("cleanup_summary", summary)
+
+	recordCleanup(state, "EIP", "eip-test", nil)
+	recordCleanup(state, "instance", "i-test", fmt.Errorf("test error"))
+	recordCleanup(state, "security group", "sg-test", nil)
+
+	leaked := summary.leaked()
+	if len(leaked) != 1 || leaked[0].id != "i-test" {
+		t.Fatalf("only the instance should be left behind: %#v", leaked)
+	}
+
+	var out, errOut bytes.Buffer
+	summary.report(&packer.BasicUi{Reader: new(bytes.Buffer), Writer: &out, ErrorWriter: &errOut})
+	if !strings.Contains(out.String(), "EIP eip-test: removed") || !strings.Contains(out.String(), "instance i-test: failed, test error") {
+		t.Fatalf("every resource should be in the summary: %s", out.String())
+	}
+	if !strings.Contains(errOut.String(), "removed by hand:\n  instance i-test") || strings.Contains(errOut.String(), "sg-test") {
+		t.Fatalf("only the leaked resources should be reported as errors: %s", errOut.String())
+	}
+
+	// Steps run without a summary outside of full builds.
+	recordCleanup(testState(nil, &Config{}), "instance", "i-test", nil)
+}
@@ -1,22 +1,211 @@
 package ecs
 
 import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
 	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+	"github.com/hashicorp/packer/version"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 )
 
 type ClientWrapper struct {
 	*ecs.Client
+
+	// The backoff before the first retry of a throttled request, it doubles
+	// with every following retry.
+	ThrottlingRetryBaseDelay time.Duration
+	// How many times a throttled request is retried before its error is
+	// handed to the caller.
+	ThrottlingRetryTimes int
+	// The time source of the retries, the wall clock when nil.
+	Clock Clock
+	// The transport the clients of the other services get a copy of, the
+	// transport of the SDK when nil.
+	transport http.RoundTripper
+	// The signer of assume_role the clients of the other services share with
+	// this one, nil when no role is assumed.
+	signer *assumeRoleSigner
+}
+
+// limitedTransport caps the number of requests waiting for their response at
+// the same time, the requests beyond the limit wait for a slot. A request
+// gives its slot back once its response arrives, it doesn't hold it while
+// the body is read.
+type limitedTransport struct {
+	http.RoundTripper
+	slots chan struct{}
+}
+
+func newLimitedTransport(transport http.RoundTripper, limit int) *limitedTransport {
+	return &limitedTransport{
+		RoundTripper: transport,
+		slots:        make(chan struct{}, limit),
+	}
+}
+
+// cloneTransport returns a copy of transport with the same settings, the
+// copy of a limitedTransport shares the slots of the original.
+func cloneTransport(transport http.RoundTripper) http.RoundTripper {
+	switch transport := transport.(type) {
+	case *http.Transport:
+		return transport.Clone()
+	case *limitedTransport:
+		return &limitedTransport{
+			RoundTripper: cloneTransport(transport.RoundTripper),
+			slots:        transport.slots,
+		}
+	}
+
+	return transport
+}
+
+func (t *limitedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-request.Context().Done():
+		return nil, request.Context().Err()
+	}
+	defer func() { <-t.slots }()
+
+	return t.RoundTripper.RoundTrip(request)
+}
+
+// Clone returns a client of its own talking to the same endpoint with the
+// same credentials and settings. The SDK changes its client on every request,
+// so the goroutines calling the API at the same time each need one.
+func (c *ClientWrapper) Clone() (*ClientWrapper, error) {
+	config := *c.GetConfig()
+	if config.HttpTransport != nil {
+		config.HttpTransport = config.HttpTransport.Clone()
+	}
+	config.Transport = cloneTransport(config.Transport)
+
+	// The credentials are replaced by the signer of c.
+	client, err := ecs.NewClientWithOptions("", &config, credentials.NewAccessKeyCredential("", ""))
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize the ECS client: %w", err)
+	}
+	client.SetSigner(c.GetSigner())
+	client.Domain = c.Domain
+	client.SetEndpointRules(c.EndpointMap, c.EndpointType, c.Network)
+	client.SetHTTPSInsecure(c.GetHTTPSInsecure())
+	client.SetHttpProxy(c.GetHttpProxy())
+	client.SetHttpsProxy(c.GetHttpsProxy())
+	client.SetNoProxy(c.GetNoProxy())
+	client.SetConnectTimeout(c.GetConnectTimeout())
+	client.SetReadTimeout(c.GetReadTimeout())
+	client.AppendUserAgent(Packer, version.FormattedVersion())
+	enableAPILogging(&client.Client, "ECS")
+	if c.transport != nil {
+		client.SetTransport(cloneTransport(c.transport))
+	}
+
+	return &ClientWrapper{
+		Client:                   client,
+		ThrottlingRetryBaseDelay: c.ThrottlingRetryBaseDelay,
+		ThrottlingRetryTimes:     c.ThrottlingRetryTimes,
+		Clock:                    c.Clock,
+		transport:                c.transport,
+		signer:                   c.signer,
+	}, nil
 }
+
 type VpcClientWrapper struct {
 	*vpc.Client
 }
+type SlbClientWrapper struct {
+	*slb.Client
+}
+
+// apiVersionSigner wraps the signer of a client so that every signed request
+// carries the given API version instead of the one compiled into the SDK.
+type apiVersionSigner struct {
+	auth.Signer
+	version string
+}
+
+func (s *apiVersionSigner) GetExtraParam() map[string]string {
+	params := map[string]string{}
+	for key, value := range s.Signer.GetExtraParam() {
+		params[key] = value
+	}
+	params["Version"] = s.version
+
+	return params
+}
+
+// SetApiVersion pins the ECS API version sent with every request of the
+// client.
+func (c *ClientWrapper) SetApiVersion(version string) {
+	if signer, ok := c.GetSigner().(*apiVersionSigner); ok {
+		signer.version = version
+		return
+	}
+	c.SetSigner(&apiVersionSigner{Signer: c.GetSigner(), version: version})
+}
+
+// The SDK logs every API call through a template, the fields are split again
+// by apiLogSeparator so only the action, the status and the request id end up
+// in the log. The URI isn't logged as such since it carries the access key
+// secret.
+const apiLogSeparator = "\x1f"
+
+var apiLogTemplate = apiLogSeparator + strings.Join([]string{"{uri}", "{code}", "{cost}", "{res_body}"}, apiLogSeparator)
+
+// apiLogWriter receives the log lines of the SDK and writes them to the
+// packer log at debug level.
+type apiLogWriter struct {
+	product string
+}
+
+func (w *apiLogWriter) Write(p []byte) (int, error) {
+	fields := strings.SplitN(strings.TrimSuffix(string(p), "\n"), apiLogSeparator, 5)
+	if len(fields) != 5 {
+		return len(p), nil
+	}
+
+	action := ""
+	if uri, err := url.Parse(fields[1]); err == nil {
+		action = uri.Query().Get("Action")
+	}
+	var body struct {
+		RequestId string
+	}
+	json.Unmarshal([]byte(fields[4]), &body)
+
+	log.Printf("[DEBUG] %s %s: status %s in %s, RequestId: %s", w.product, action, fields[2], fields[3], body.RequestId)
+	return len(p), nil
+}
+
+// enableAPILogging logs the action and the request id of every API call made
+// by client. It only does so when PACKER_LOG is set, normal runs skip it.
+func enableAPILogging(client *sdk.Client, product string) {
+	if value := os.Getenv("PACKER_LOG"); value == "" || value == "0" {
+		return
+	}
+
+	client.SetLogger("debug", "", &apiLogWriter{product: product}, apiLogTemplate)
+}
 
 const (
 	InstanceStatusRunning  = "Running"
@@ -34,6 +223,23 @@ const (
 
 var ImageStatusQueried = fmt.Sprintf("%s,%s,%s,%s", ImageStatusWaiting, ImageStatusCreating, ImageStatusCreateFailed, ImageStatusAvailable)
 
+const (
+	TaskStatusWaiting    = "Waiting"
+	TaskStatusProcessing = "Processing"
+	TaskStatusFinished   = "Finished"
+	TaskStatusFailed     = "Failed"
+	TaskStatusDeleted    = "Deleted"
+)
+
+const (
+	BackendServerHealthNormal      = "normal"
+	BackendServerHealthAbnormal    = "abnormal"
+	BackendServerHealthUnavailable = "unavailable"
+)
+
+// The longest retention of a snapshot ECS accepts.
+const maxSnapshotRetentionDays = 65536
+
 const (
 	SnapshotStatusAll          = "all"
 	SnapshotStatusProgressing  = "progressing"
@@ -74,11 +280,86 @@ const (
 	ImageOwnerMarketplace = "marketplace"
 )
 
+const (
+	ShutdownBehaviorStop    = "stop"
+	ShutdownBehaviorRelease = "release"
+)
+
+const (
+	StoppedModeKeepCharging = "KeepCharging"
+	StoppedModeStopCharging = "StopCharging"
+)
+
+const (
+	SpotStrategyNoSpot             = "NoSpot"
+	SpotStrategySpotWithPriceLimit = "SpotWithPriceLimit"
+	SpotStrategySpotAsPriceGo      = "SpotAsPriceGo"
+)
+
+// The lock set on an instance which is about to be released, such as a
+// reclaimed spot instance.
+const InstanceLockReasonRecycling = "Recycling"
+
+const (
+	ZoneSelectionExplicit       = "explicit"
+	ZoneSelectionFirstAvailable = "first_available"
+	ZoneSelectionCheapest       = "cheapest"
+)
+
+const (
+	MetadataTokenModeOptional = "optional"
+	MetadataTokenModeRequired = "required"
+)
+
+const (
+	InstanceChargeTypePostPaid = "PostPaid"
+	InstanceChargeTypePrePaid  = "PrePaid"
+)
+
+const (
+	NetworkInterfaceStatusAvailable = "Available"
+	NetworkInterfaceStatusInUse     = "InUse"
+)
+
+// The tenancy of instances created on a dedicated host.
+const TenancyHost = "host"
+
+const (
+	PeriodUnitWeek  = "Week"
+	PeriodUnitMonth = "Month"
+)
+
+const (
+	ImageLicenseTypeAuto   = "Auto"
+	ImageLicenseTypeAliyun = "Aliyun"
+	ImageLicenseTypeBYOL   = "BYOL"
+)
+
+const (
+	ImageNameAutoAppendTimestamp = "timestamp"
+	ImageNameAutoAppendUUID      = "uuid"
+)
+
+const (
+	ImageBootModeBIOS = "BIOS"
+	ImageBootModeUEFI = "UEFI"
+)
+
+// The ECS API versions known to be served by ApsaraStack, the first one is
+// the version the SDK speaks by default.
+var KnownEcsApiVersions = []string{"2014-05-26"}
+
 const (
 	IOOptimizedNone      = "none"
 	IOOptimizedOptimized = "optimized"
 )
 
+// The instance families which can be launched without I/O optimization, the
+// instances of all other families are always I/O optimized.
+var ioOptimizedOptionalFamilies = []string{
+	"ecs.t1", "ecs.s1", "ecs.s2", "ecs.s3", "ecs.m1", "ecs.m2", "ecs.c1", "ecs.c2",
+}
+
 const (
 	InstanceNetworkClassic = "classic"
 	InstanceNetworkVpc     = "vpc"
@@ -89,6 +370,16 @@ const (
 	DiskTypeData   = "data"
 )
 
+const (
+	DiskCategoryCloud           = "cloud"
+	DiskCategoryCloudEfficiency = "cloud_efficiency"
+	DiskCategoryCloudSSD        = "cloud_ssd"
+	DiskCategoryEphemeralSSD    = "ephemeral_ssd"
+	DiskCategoryESSD            = "cloud_essd"
+)
+
+var DiskPerformanceLevels = []string{"PL0", "PL1", "PL2", "PL3"}
+
 const (
 	TagResourceImage    = "image"
 	TagResourceInstance = "instance"
@@ -96,6 +387,10 @@ const (
 	TagResourceDisk     = "disk"
 )
 
+// The tag key recording the source image of an image built with
+// fail_if_noop.
+const TagKeySourceImage = "packer_source_image"
+
 const (
 	IpProtocolAll  = "all"
 	IpProtocolTCP  = "tcp"
@@ -115,6 +410,24 @@ const (
 	DefaultCidrBlock = "172.16.0.0/24"
 )
 
+// The error codes of transient failures, a build failing with one of them
+// may succeed when it is run again.
+var transientErrorCodes = []string{
+	"Throttling",
+	"ServiceUnavailable",
+	"InternalError",
+	"OperationConflict",
+	errors.TimeoutErrorCode,
+}
+
+const buildRetryBaseDelay = 30 * time.Second
+
+const (
+	defaultThrottlingRetryBaseDelay = time.Second
+	defaultThrottlingRetryTimes     = 8
+	maxThrottlingRetryDelay         = time.Minute
+)
+
 const (
 	defaultRetryInterval = 5 * time.Second
 	defaultRetryTimes    = 12
@@ -151,6 +464,8 @@ type WaitForExpectArgs struct {
 	RetryInterval time.Duration
 	RetryTimes    int
 	RetryTimeout  time.Duration
+	// Context stops the retries once it's done, it may be nil.
+	Context context.Context
 }
 
 func (c *ClientWrapper) WaitForExpected(args *WaitForExpectArgs) (responses.AcsResponse, error) {
@@ -161,16 +476,32 @@ func (c *ClientWrapper) WaitForExpected(args *WaitForExpectArgs) (responses.AcsR
 		args.RetryTimes = defaultRetryTimes
 	}
 
+	clock := c.clock()
 	var timeoutPoint time.Time
 	if args.RetryTimeout > 0 {
-		timeoutPoint = time.Now().Add(args.RetryTimeout)
+		timeoutPoint = clock.Now().Add(args.RetryTimeout)
 	}
 
 	var lastResponse responses.AcsResponse
 	var lastError error
 
+	throttlingRetryTimes := c.ThrottlingRetryTimes
+	if throttlingRetryTimes <= 0 {
+		throttlingRetryTimes = defaultThrottlingRetryTimes
+	}
+	throttled := 0
+
+	ctx := args.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	for i := 0; ; i++ {
-		if args.RetryTimeout > 0 && time.Now().After(timeoutPoint) {
+		if err := ctx.Err(); err != nil {
+			return lastResponse, err
+		}
+
+		if args.RetryTimeout > 0 && clock.Now().After(timeoutPoint) {
 			break
 		}
 
@@ -182,6 +513,25 @@ func (c *ClientWrapper) WaitForExpected(args *WaitForExpectArgs) (responses.AcsR
 		lastResponse = response
 		lastError = err
 
+		// Throttled requests are retried whatever the caller expects, and
+		// don't count against its retry times.
+		if isThrottlingError(err) && throttled < throttlingRetryTimes {
+			delay := c.throttlingRetryDelay(throttled)
+			throttled++
+			log.Printf("[DEBUG] Request throttled, retrying in %s (%d/%d): %s", delay, throttled, throttlingRetryTimes, err)
+			clock.Sleep(ctx, delay)
+			i--
+			continue
+		}
+
+		// A timed out request is retried like any failed attempt, even when
+		// the caller only retries some error codes.
+		if isTimeoutError(err) {
+			log.Printf("[DEBUG] Request timed out, retrying in %s: %s", args.RetryInterval, err)
+			clock.Sleep(ctx, args.RetryInterval)
+			continue
+		}
+
 		evalResult := args.EvalFunc(response, err)
 		if evalResult.evalPass {
 			return response, nil
@@ -190,7 +540,7 @@ func (c *ClientWrapper) WaitForExpected(args *WaitForExpectArgs) (responses.AcsR
 			return response, err
 		}
 
-		time.Sleep(args.RetryInterval)
+		clock.Sleep(ctx, args.RetryInterval)
 	}
 
 	if lastError == nil {
@@ -198,13 +548,70 @@ func (c *ClientWrapper) WaitForExpected(args *WaitForExpectArgs) (responses.AcsR
 	}
 
 	if args.RetryTimeout > 0 {
-		return lastResponse, fmt.Errorf("evaluate failed after %d seconds timeout with %d seconds retry interval: %s", int(args.RetryTimeout.Seconds()), int(args.RetryInterval.Seconds()), lastError)
+		return lastResponse, fmt.Errorf("evaluate failed after %d seconds timeout with %d seconds retry interval: %w", int(args.RetryTimeout.Seconds()), int(args.RetryInterval.Seconds()), lastError)
 	}
 
-	return lastResponse, fmt.Errorf("evaluate failed after %d times retry with %d seconds retry interval: %s", args.RetryTimes, int(args.RetryInterval.Seconds()), lastError)
+	return lastResponse, fmt.Errorf("evaluate failed after %d times retry with %d seconds retry interval: %w", args.RetryTimes, int(args.RetryInterval.Seconds()), lastError)
+}
+
+// Clock tells the time and waits between the retries of WaitForExpected.
+// Tests replace it to retry without waiting for real.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for the duration, or less if ctx is done before.
+	Sleep(ctx context.Context, duration time.Duration)
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) Sleep(ctx context.Context, duration time.Duration) {
+	sleepContext(ctx, duration)
+}
+
+func (c *ClientWrapper) clock() Clock {
+	if c.Clock == nil {
+		return wallClock{}
+	}
+	return c.Clock
 }
 
-func (c *ClientWrapper) WaitForInstanceStatus(regionId string, instanceId string, expectedStatus string, state multistep.StateBag) (responses.AcsResponse, error) {
+// sleepContext sleeps for the duration, or less if ctx is done before.
+func sleepContext(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// instanceReclaimedError is returned when the instance disappears or is being
+// recycled while it is waited for, which happens to reclaimed spot instances.
+type instanceReclaimedError struct {
+	instanceId string
+}
+
+func (e *instanceReclaimedError) Error() string {
+	return fmt.Sprintf("instance %s was released or is being recycled, a spot instance may have been reclaimed", e.instanceId)
+}
+
+// WaitForInstanceStatus waits until the instance has the expected status,
+// polling it every interval. A timeout of 0 keeps the default wait of about
+// 30 minutes, an interval of 0 the default interval. The wait stops as soon
+// as ctx is done.
+func (c *ClientWrapper) WaitForInstanceStatus(ctx context.Context, regionId string, instanceId string, expectedStatus string, timeout time.Duration, interval time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+	if interval > 0 && timeout <= 0 {
+		// The default number of retries only adds up to the default wait
+		// with the default interval.
+		timeout = mediumRetryTimes * defaultRetryInterval
+	}
+
+	var seen bool
 	return c.WaitForExpected(&WaitForExpectArgs{
 		RequestFunc: func() (responses.AcsResponse, error) {
 			config := state.Get("config").(*Config)
@@ -214,9 +621,29 @@ func (c *ClientWrapper) WaitForInstanceStatus(regionId string, instanceId string
 
 			request.RegionId = regionId
 			request.InstanceIds = fmt.Sprintf("[\"%s\"]", instanceId)
-			return c.DescribeInstances(request)
+			response, err := c.DescribeInstances(request)
+			if err != nil {
+				return response, err
+			}
+
+			instances := response.Instances.Instance
+			if len(instances) == 0 && seen {
+				return response, &instanceReclaimedError{instanceId}
+			}
+			for _, instance := range instances {
+				seen = true
+				for _, lock := range instance.OperationLocks.LockReason {
+					if lock.LockReason == InstanceLockReasonRecycling {
+						return response, &instanceReclaimedError{instanceId}
+					}
+				}
+			}
+			return response, nil
 		},
 		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if _, ok := err.(*instanceReclaimedError); ok {
+				return WaitForExpectFailToStop
+			}
 			if err != nil {
 				return WaitForExpectToRetry
 			}
@@ -230,11 +657,79 @@ func (c *ClientWrapper) WaitForInstanceStatus(regionId string, instanceId string
 			}
 			return WaitForExpectToRetry
 		},
-		RetryTimes: mediumRetryTimes,
+		RetryTimes:    mediumRetryTimes,
+		RetryTimeout:  timeout,
+		RetryInterval: interval,
+		Context:       ctx,
+	})
+}
+
+// WaitForInstanceDeleted waits until the deleted instance is gone from
+// DescribeInstances, the resources it used can't be deleted before. The wait
+// stops as soon as ctx is done.
+func (c *ClientWrapper) WaitForInstanceDeleted(ctx context.Context, regionId string, instanceId string, timeout time.Duration, interval time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+	return c.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			config := state.Get("config").(*Config)
+			request := ecs.CreateDescribeInstancesRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			request.RegionId = regionId
+			request.InstanceIds = fmt.Sprintf("[\"%s\"]", instanceId)
+			return c.DescribeInstances(request)
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if err != nil {
+				return WaitForExpectToRetry
+			}
+
+			for _, instance := range response.(*ecs.DescribeInstancesResponse).Instances.Instance {
+				if instance.InstanceId == instanceId {
+					return WaitForExpectToRetry
+				}
+			}
+			return WaitForExpectSuccess
+		},
+		RetryTimeout:  timeout,
+		RetryInterval: interval,
+		Context:       ctx,
+	})
+}
+
+func (c *ClientWrapper) WaitForNetworkInterfaceStatus(ctx context.Context, regionId string, networkInterfaceId string, expectedStatus string, interval time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+	return c.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			config := state.Get("config").(*Config)
+			request := ecs.CreateDescribeNetworkInterfacesRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+			request.QueryParams["RegionId"] = regionId
+
+			request.NetworkInterfaceId = &[]string{networkInterfaceId}
+			return c.DescribeNetworkInterfaces(request)
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if err != nil {
+				return WaitForExpectToRetry
+			}
+
+			for _, networkInterface := range response.(*ecs.DescribeNetworkInterfacesResponse).NetworkInterfaceSets.NetworkInterfaceSet {
+				if networkInterface.Status == expectedStatus {
+					return WaitForExpectSuccess
+				}
+			}
+			return WaitForExpectToRetry
+		},
+		RetryTimes:    mediumRetryTimes,
+		RetryTimeout:  mediumRetryTimes * defaultRetryInterval,
+		RetryInterval: interval,
+		Context:       ctx,
 	})
 }
 
-func (c *ClientWrapper) WaitForImageStatus(regionId string, imageId string, expectedStatus string, timeout time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+func (c *ClientWrapper) WaitForImageStatus(ctx context.Context, regionId string, imageId string, expectedStatus string, timeout time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+	progress := ""
 	return c.WaitForExpected(&WaitForExpectArgs{
 		RequestFunc: func() (responses.AcsResponse, error) {
 			config := state.Get("config").(*Config)
@@ -246,7 +741,18 @@ func (c *ClientWrapper) WaitForImageStatus(regionId string, imageId string, expe
 			request.ImageId = imageId
 			request.Status = ImageStatusQueried
 
-			return c.DescribeImages(request)
+			response, err := c.DescribeImages(request)
+			if err == nil {
+				for _, image := range response.Images.Image {
+					if image.Progress != "" && image.Progress != progress {
+						progress = image.Progress
+						if ui, ok := state.GetOk("ui"); ok {
+							ui.(packer.Ui).Message(fmt.Sprintf("Image %s progress: %s", imageId, progress))
+						}
+					}
+				}
+			}
+			return response, err
 		},
 		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
 			if err != nil {
@@ -264,10 +770,11 @@ func (c *ClientWrapper) WaitForImageStatus(regionId string, imageId string, expe
 			return WaitForExpectToRetry
 		},
 		RetryTimeout: timeout,
+		Context:      ctx,
 	})
 }
 
-func (c *ClientWrapper) WaitForSnapshotStatus(regionId string, snapshotId string, expectedStatus string, timeout time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+func (c *ClientWrapper) WaitForSnapshotStatus(ctx context.Context, regionId string, snapshotId string, expectedStatus string, timeout time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
 	return c.WaitForExpected(&WaitForExpectArgs{
 
 		RequestFunc: func() (responses.AcsResponse, error) {
@@ -296,6 +803,44 @@ func (c *ClientWrapper) WaitForSnapshotStatus(regionId string, snapshotId string
 			return WaitForExpectToRetry
 		},
 		RetryTimeout: timeout,
+		Context:      ctx,
+	})
+}
+
+func (c *ClientWrapper) WaitForTagsVisible(ctx context.Context, regionId string, resourceType string, resourceId string, tags map[string]string, timeout time.Duration, state multistep.StateBag) (responses.AcsResponse, error) {
+	return c.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
+		RequestFunc: func() (responses.AcsResponse, error) {
+			config := state.Get("config").(*Config)
+			request := ecs.CreateDescribeTagsRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			request.RegionId = regionId
+			request.ResourceType = resourceType
+			request.ResourceId = resourceId
+			request.PageSize = requests.NewInteger(100)
+			return c.DescribeTags(request)
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if err != nil {
+				return WaitForExpectToRetry
+			}
+
+			visible := make(map[string]string)
+			for _, tag := range response.(*ecs.DescribeTagsResponse).Tags.Tag {
+				visible[tag.TagKey] = tag.TagValue
+			}
+			for key, value := range tags {
+				if visibleValue, ok := visible[key]; !ok || visibleValue != value {
+					return WaitForExpectToRetry
+				}
+			}
+
+			return WaitForExpectSuccess
+		},
+		RetryInterval: time.Second,
+		RetryTimeout:  timeout,
 	})
 }
 
@@ -312,8 +857,8 @@ func (c *ClientWrapper) EvalCouldRetryResponse(evalErrors []string, evalErrorTyp
 			return WaitForExpectSuccess
 		}
 
-		e, ok := err.(errors.Error)
-		if !ok {
+		var e errors.Error
+		if !stderrors.As(err, &e) {
 			return WaitForExpectToRetry
 		}
 
@@ -328,3 +873,200 @@ func (c *ClientWrapper) EvalCouldRetryResponse(evalErrors []string, evalErrorTyp
 		return WaitForExpectToRetry
 	}
 }
+
+// QuotaExceededError is the error of an API request which ran out of a quota
+// of the stack, such as the number of instances or the disk capacity. Unlike
+// errors in the template, it goes away once resources are freed or the quota
+// is raised.
+type QuotaExceededError struct {
+	// The quota, such as `PostPaidCpu`, or the whole error code when the
+	// code doesn't name it.
+	Quota    string
+	RegionId string
+	Err      error
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota %s exceeded in region %s, free resources or request a quota increase: %s", e.Quota, e.RegionId, e.Err)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return e.Err
+}
+
+// quotaExceededErrorCodes are the error codes about an exhausted quota which
+// don't contain `QuotaExceed`.
+var quotaExceededErrorCodes = []string{
+	"LastTokenProcessing",
+}
+
+func isQuotaExceededErrorCode(code string) bool {
+	return strings.Contains(code, "QuotaExceed") || ContainsInArray(quotaExceededErrorCodes, code)
+}
+
+// asQuotaExceededError returns err as a QuotaExceededError when it is, or
+// wraps, an API error about an exhausted quota, such as
+// `QuotaExceed.PostPaidDisk`, and err itself otherwise.
+func asQuotaExceededError(err error, regionId string) error {
+	var quotaErr *QuotaExceededError
+	if err == nil || stderrors.As(err, &quotaErr) {
+		return err
+	}
+
+	var sdkErr errors.Error
+	if !stderrors.As(err, &sdkErr) || !isQuotaExceededErrorCode(sdkErr.ErrorCode()) {
+		return err
+	}
+
+	code := sdkErr.ErrorCode()
+	quota := code
+	if parts := strings.SplitN(code, ".", 2); len(parts) == 2 && strings.HasPrefix(parts[0], "QuotaExceed") {
+		quota = parts[1]
+	}
+
+	return &QuotaExceededError{Quota: quota, RegionId: regionId, Err: err}
+}
+
+// requestRegionId returns the region a request goes to, regionId when the
+// request sets it and the RegionId header otherwise.
+func requestRegionId(regionId string, request requests.AcsRequest) string {
+	if regionId != "" {
+		return regionId
+	}
+
+	return request.GetHeaders()["RegionId"]
+}
+
+// The calls which use up a quota of the stack, their errors about an
+// exhausted quota are QuotaExceededErrors.
+
+func (c *ClientWrapper) CreateInstance(request *ecs.CreateInstanceRequest) (*ecs.CreateInstanceResponse, error) {
+	response, err := c.Client.CreateInstance(request)
+	return response, asQuotaExceededError(err, requestRegionId(request.RegionId, request))
+}
+
+func (c *ClientWrapper) CreateImage(request *ecs.CreateImageRequest) (*ecs.CreateImageResponse, error) {
+	response, err := c.Client.CreateImage(request)
+	return response, asQuotaExceededError(err, requestRegionId(request.RegionId, request))
+}
+
+// CopyImage reports the quota of the destination region, the one the copy
+// uses up.
+func (c *ClientWrapper) CopyImage(request *ecs.CopyImageRequest) (*ecs.CopyImageResponse, error) {
+	response, err := c.Client.CopyImage(request)
+	return response, asQuotaExceededError(err, requestRegionId(request.DestinationRegionId, request))
+}
+
+func (c *ClientWrapper) CreateSnapshot(request *ecs.CreateSnapshotRequest) (*ecs.CreateSnapshotResponse, error) {
+	response, err := c.Client.CreateSnapshot(request)
+	return response, asQuotaExceededError(err, requestRegionId("", request))
+}
+
+func (c *ClientWrapper) CreateSecurityGroup(request *ecs.CreateSecurityGroupRequest) (*ecs.CreateSecurityGroupResponse, error) {
+	response, err := c.Client.CreateSecurityGroup(request)
+	return response, asQuotaExceededError(err, requestRegionId(request.RegionId, request))
+}
+
+func (c *ClientWrapper) CreateNetworkInterface(request *ecs.CreateNetworkInterfaceRequest) (*ecs.CreateNetworkInterfaceResponse, error) {
+	response, err := c.Client.CreateNetworkInterface(request)
+	return response, asQuotaExceededError(err, requestRegionId(request.RegionId, request))
+}
+
+func (c *ClientWrapper) AllocateEipAddress(request *ecs.AllocateEipAddressRequest) (*ecs.AllocateEipAddressResponse, error) {
+	response, err := c.Client.AllocateEipAddress(request)
+	return response, asQuotaExceededError(err, requestRegionId(request.RegionId, request))
+}
+
+func (c *ClientWrapper) AllocatePublicIpAddress(request *ecs.AllocatePublicIpAddressRequest) (*ecs.AllocatePublicIpAddressResponse, error) {
+	response, err := c.Client.AllocatePublicIpAddress(request)
+	return response, asQuotaExceededError(err, requestRegionId("", request))
+}
+
+// errorCodeContains reports whether err, or an error it wraps, is an API
+// error whose code contains fragment, such as `DeploymentSet` for
+// `DeploymentSet.NoRoom`.
+func errorCodeContains(err error, fragment string) bool {
+	var sdkErr errors.Error
+	if !stderrors.As(err, &sdkErr) {
+		return false
+	}
+
+	return strings.Contains(sdkErr.ErrorCode(), fragment)
+}
+
+// notFoundErrorCodes are the error codes about an image or a snapshot which
+// doesn't exist.
+var notFoundErrorCodes = []string{
+	"InvalidImageId.NotFound",
+	"InvalidSnapshotId.NotFound",
+}
+
+// isNotFoundError reports whether err, or an error it wraps, is an API error
+// about an image or a snapshot which doesn't exist.
+func isNotFoundError(err error) bool {
+	var sdkErr errors.Error
+	return stderrors.As(err, &sdkErr) && ContainsInArray(notFoundErrorCodes, sdkErr.ErrorCode())
+}
+
+// isThrottlingError reports whether err, or an error it wraps, is an API
+// error about the request rate, such as `Throttling.User`.
+func isThrottlingError(err error) bool {
+	var sdkErr errors.Error
+	if !stderrors.As(err, &sdkErr) {
+		return false
+	}
+
+	code := sdkErr.ErrorCode()
+	return code == "Throttling" || strings.HasPrefix(code, "Throttling.")
+}
+
+// isTimeoutError reports whether err, or an error it wraps, is an API
+// request which timed out connecting or waiting for the response.
+func isTimeoutError(err error) bool {
+	var sdkErr errors.Error
+	if stderrors.As(err, &sdkErr) && sdkErr.ErrorCode() == errors.TimeoutErrorCode {
+		return true
+	}
+
+	var netErr net.Error
+	return stderrors.As(err, &netErr) && netErr.Timeout()
+}
+
+// errorRequestId returns the request id of the API error err is, or wraps,
+// and an empty string for other errors.
+func errorRequestId(err error) string {
+	var serverErr *errors.ServerError
+	if !stderrors.As(err, &serverErr) {
+		return ""
+	}
+
+	return serverErr.RequestId()
+}
+
+// throttlingRetryDelay returns the exponential backoff with jitter before the
+// retry following the given throttled attempt. The jitter only spreads the
+// upper half of the delay, so delays keep increasing.
+func (c *ClientWrapper) throttlingRetryDelay(attempt int) time.Duration {
+	base := c.ThrottlingRetryBaseDelay
+	if base <= 0 {
+		base = defaultThrottlingRetryBaseDelay
+	}
+
+	delay := base << uint(attempt)
+	if delay <= 0 || delay > maxThrottlingRetryDelay {
+		delay = maxThrottlingRetryDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isTransientError reports whether err, or an error it wraps, is an API
+// error which may go away on its own.
+func isTransientError(err error) bool {
+	var sdkErr errors.Error
+	if !stderrors.As(err, &sdkErr) {
+		return false
+	}
+
+	return sdkErr.HttpStatus() >= http.StatusInternalServerError || ContainsInArray(transientErrorCodes, sdkErr.ErrorCode())
+}
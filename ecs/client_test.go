@@ -1,13 +1,75 @@
 package ecs
 
 import (
+	"bytes"
+	"context"
+	stderrors "errors"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
 )
 
+// testApiHandler answers a single API call with a HTTP status code and a JSON
+// body. Server errors are reported by the SDK when the status code isn't 2xx.
+type testApiHandler func(action string, params url.Values) (int, string)
+
+// testClient returns a client that sends all API calls to a local server
+// served by handler.
+func testClient(t *testing.T, handler testApiHandler) *ClientWrapper {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("err: %s", err)
+		}
+		status, body := handler(r.Form.Get("Action"), r.Form)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := ecs.NewClientWithAccessKey("cn-test", "foo", "bar")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	client.Domain = strings.TrimPrefix(server.URL, "http://")
+	client.GetConfig().MaxRetryTime = 0
+
+	return &ClientWrapper{Client: client}
+}
+
+// testErrorBody returns the body of an API error response.
+func testErrorBody(code string) string {
+	return fmt.Sprintf(`{"RequestId":"test-request","Code":"%s","Message":"test error"}`, code)
+}
+
+// testState returns a state bag holding the basics every step relies on.
+func testState(client *ClientWrapper, config *Config) multistep.StateBag {
+	state := new(multistep.BasicStateBag)
+	state.Put("client", client)
+	state.Put("config", config)
+	state.Put("ui", &packer.BasicUi{
+		Reader:      new(bytes.Buffer),
+		Writer:      ioutil.Discard,
+		ErrorWriter: ioutil.Discard,
+	})
+	state.Put("networktype", InstanceNetWork(InstanceNetworkClassic))
+	return state
+}
+
 func TestWaitForExpectedExceedRetryTimes(t *testing.T) {
 	c := ClientWrapper{}
 
@@ -78,3 +140,447 @@ func TestWaitForExpectedExceedRetryTimeout(t *testing.T) {
 		t.Fatalf("WaitForExpected should terminate within %f seconds", (expectTimeout + timeTolerance).Seconds())
 	}
 }
+
+func TestWaitForTagsVisible(t *testing.T) {
+	var describes int
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeTags" || params.Get("ResourceId") != "m-test" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("ResourceId"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		describes++
+		if describes == 1 {
+			return http.StatusOK, `{"RequestId":"test-request","Tags":{"Tag":[]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Tags":{"Tag":[{"TagKey":"build","TagValue":"foo"}]}}`
+	})
+	state := testState(client, &Config{})
+
+	_, err := client.WaitForTagsVisible(context.Background(), "cn-test", TagResourceImage, "m-test", map[string]string{"build": "foo"}, 10*time.Second, state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if describes != 2 {
+		t.Fatalf("tags should be described twice, actual: %d", describes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := client.WaitForTagsVisible(ctx, "cn-test", TagResourceImage, "m-test", map[string]string{"build": "bar"}, 10*time.Second, state); err != context.Canceled {
+		t.Fatalf("the wait should stop with the context, actual: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the wait should stop promptly, took %s", elapsed)
+	}
+}
+
+func TestClientSetApiVersion(t *testing.T) {
+	var version string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		version = params.Get("Version")
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+	})
+
+	request := ecs.CreateDescribeImagesRequest()
+	if _, err := client.DescribeImages(request); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if version != request.GetVersion() {
+		t.Fatalf("the SDK version %s should be sent by default, actual: %s", request.GetVersion(), version)
+	}
+
+	client.SetApiVersion("2016-03-14")
+	client.SetApiVersion("2015-01-01")
+	if _, err := client.DescribeImages(ecs.CreateDescribeImagesRequest()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if version != "2015-01-01" {
+		t.Fatalf("the pinned version should be sent, actual: %s", version)
+	}
+}
+
+func TestClientClone(t *testing.T) {
+	var lock sync.Mutex
+	versions := make(map[string]bool)
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		lock.Lock()
+		versions[params.Get("Version")] = true
+		lock.Unlock()
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+	})
+	client.SetApiVersion("2015-01-01")
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		clone, err := client.Clone()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if clone.Client == client.Client || clone.Clock != client.Clock {
+			t.Fatalf("the clone should have an SDK client of its own and the same clock")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := clone.DescribeImages(ecs.CreateDescribeImagesRequest()); err != nil {
+					t.Errorf("err: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(versions) != 1 || !versions["2015-01-01"] {
+		t.Fatalf("the clones should sign like the client, actual versions: %v", versions)
+	}
+}
+
+func TestEnableAPILogging(t *testing.T) {
+	if value, ok := os.LookupEnv("PACKER_LOG"); ok {
+		defer os.Setenv("PACKER_LOG", value)
+	} else {
+		defer os.Unsetenv("PACKER_LOG")
+	}
+	os.Setenv("PACKER_LOG", "1")
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action == "DeleteImage" {
+			return http.StatusBadRequest, testErrorBody("InvalidImageId.NotFound")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+	})
+	enableAPILogging(&client.Client.Client, "ECS")
+
+	request := ecs.CreateDescribeImagesRequest()
+	request.QueryParams = map[string]string{"AccessKeySecret": "test-secret"}
+	if _, err := client.DescribeImages(request); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(buf.String(), "[DEBUG] ECS DescribeImages: status 200") || !strings.Contains(buf.String(), "RequestId: test-request") {
+		t.Fatalf("the action and the request id should be logged, actual: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "test-secret") {
+		t.Fatalf("the access key secret shouldn't be logged, actual: %s", buf.String())
+	}
+
+	_, err := client.DeleteImage(ecs.CreateDeleteImageRequest())
+	if errorRequestId(fmt.Errorf("wrapped: %w", err)) != "test-request" {
+		t.Fatalf("the request id of the API error should be found, actual: %v", err)
+	}
+	if !strings.Contains(buf.String(), "[DEBUG] ECS DeleteImage: status 400") {
+		t.Fatalf("failed calls should be logged, actual: %s", buf.String())
+	}
+}
+
+func TestIsNotFoundError(t *testing.T) {
+	cases := []struct {
+		err      error
+		notFound bool
+	}{
+		{errors.NewServerError(http.StatusNotFound, testErrorBody("InvalidImageId.NotFound"), ""), true},
+		{errors.NewServerError(http.StatusNotFound, testErrorBody("InvalidSnapshotId.NotFound"), ""), true},
+		{errors.NewServerError(http.StatusNotFound, testErrorBody("InvalidRegionId.NotFound"), ""), false},
+		{fmt.Errorf("InvalidImageId.NotFound"), false},
+	}
+
+	for _, c := range cases {
+		wrapped := fmt.Errorf("Error deleting image: %w", c.err)
+		if isNotFoundError(wrapped) != c.notFound {
+			t.Fatalf("bad result for %s, expected not found: %t", c.err, c.notFound)
+		}
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{errors.NewServerError(http.StatusServiceUnavailable, testErrorBody("ServiceUnavailable"), ""), true},
+		{errors.NewServerError(http.StatusBadRequest, testErrorBody("Throttling"), ""), true},
+		{errors.NewClientError(errors.TimeoutErrorCode, "timeout", nil), true},
+		{errors.NewServerError(http.StatusForbidden, testErrorBody("InvalidAccessKeyId.NotFound"), ""), false},
+		{errors.NewServerError(http.StatusForbidden, testErrorBody("QuotaExceed.ElasticQuota"), ""), false},
+		{fmt.Errorf("image_name is used"), false},
+	}
+
+	for _, c := range cases {
+		wrapped := fmt.Errorf("Error creating instance: %w", fmt.Errorf("evaluate failed: %w", c.err))
+		if isTransientError(wrapped) != c.transient {
+			t.Fatalf("bad result for %s, expected transient: %t", c.err, c.transient)
+		}
+	}
+}
+
+func TestAsQuotaExceededError(t *testing.T) {
+	cases := []struct {
+		err   error
+		quota string
+	}{
+		{errors.NewServerError(http.StatusForbidden, testErrorBody("QuotaExceed.PostPaidDisk"), ""), "PostPaidDisk"},
+		{errors.NewServerError(http.StatusForbidden, testErrorBody("InstanceQuotaExceed"), ""), "InstanceQuotaExceed"},
+		{errors.NewServerError(http.StatusForbidden, testErrorBody("LastTokenProcessing"), ""), "LastTokenProcessing"},
+		{errors.NewServerError(http.StatusForbidden, testErrorBody("InvalidAccessKeyId.NotFound"), ""), ""},
+		{fmt.Errorf("image_name is used"), ""},
+	}
+
+	for _, c := range cases {
+		err := asQuotaExceededError(fmt.Errorf("evaluate failed: %w", c.err), "cn-test")
+
+		var quotaErr *QuotaExceededError
+		if !stderrors.As(err, &quotaErr) {
+			if c.quota != "" {
+				t.Fatalf("%s should be a quota error", c.err)
+			}
+			continue
+		}
+		if quotaErr.Quota != c.quota || quotaErr.RegionId != "cn-test" {
+			t.Fatalf("bad quota error: %#v", quotaErr)
+		}
+		if !strings.Contains(err.Error(), "quota "+c.quota+" exceeded in region cn-test") {
+			t.Fatalf("the error should point at the quota: %s", err)
+		}
+	}
+}
+
+func TestClientWrapper_quotaExceeded(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		return http.StatusForbidden, testErrorBody("QuotaExceed.PostPaidCpu")
+	})
+
+	createInstanceRequest := ecs.CreateCreateInstanceRequest()
+	createInstanceRequest.Headers = map[string]string{"RegionId": "cn-test"}
+	_, err := client.CreateInstance(createInstanceRequest)
+	state := testState(client, &Config{})
+	halt(state, err, "Error creating instance")
+
+	var quotaErr *QuotaExceededError
+	if err := state.Get("error").(error); !stderrors.As(err, &quotaErr) || quotaErr.Quota != "PostPaidCpu" || quotaErr.RegionId != "cn-test" {
+		t.Fatalf("the build should fail with a quota error: %v", err)
+	}
+
+	copyImageRequest := ecs.CreateCopyImageRequest()
+	copyImageRequest.Headers = map[string]string{"RegionId": "cn-test"}
+	copyImageRequest.DestinationRegionId = "cn-copy"
+	if _, err := client.CopyImage(copyImageRequest); !stderrors.As(err, &quotaErr) || quotaErr.RegionId != "cn-copy" {
+		t.Fatalf("the error of a copy should name the destination region: %v", err)
+	}
+}
+
+func TestWaitForInstanceStatus_reclaimed(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Running",` +
+			`"OperationLocks":{"LockReason":[{"LockReason":"Recycling"}]}}]}}`
+	})
+	state := testState(client, &Config{})
+
+	_, err := client.WaitForInstanceStatus(context.Background(), "cn-test", "i-test", InstanceStatusStopped, 0, 0, state)
+	if _, ok := err.(*instanceReclaimedError); !ok {
+		t.Fatalf("a recycled instance should fail fast, actual: %v", err)
+	}
+}
+
+func TestClientThrottlingRetry(t *testing.T) {
+	calls := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		calls++
+		if calls <= 3 {
+			return http.StatusBadRequest, testErrorBody("Throttling.User")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+	})
+	client.ThrottlingRetryBaseDelay = 20 * time.Millisecond
+	clock := &testClock{now: time.Unix(0, 0)}
+	client.Clock = clock
+
+	_, err := client.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			return client.DescribeImages(ecs.CreateDescribeImagesRequest())
+		},
+		// No error is retried by the caller itself.
+		EvalFunc: client.EvalCouldRetryResponse(nil, EvalRetryErrorType),
+	})
+	if err != nil {
+		t.Fatalf("the call should succeed after being throttled: %s", err)
+	}
+	if calls != 4 || len(clock.sleeps) != 3 {
+		t.Fatalf("bad number of calls: %d, sleeps: %v", calls, clock.sleeps)
+	}
+
+	// The jitter spreads retry i over the upper half of base << i.
+	for i, delay := range clock.sleeps {
+		max := client.ThrottlingRetryBaseDelay << uint(i)
+		if delay < max/2 || delay > max {
+			t.Fatalf("retry %d should wait %s to %s, actual: %s", i+1, max/2, max, delay)
+		}
+	}
+}
+
+func TestClientThrottlingRetry_exhausted(t *testing.T) {
+	calls := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		calls++
+		return http.StatusBadRequest, testErrorBody("Throttling")
+	})
+	client.ThrottlingRetryBaseDelay = time.Millisecond
+	client.ThrottlingRetryTimes = 2
+
+	_, err := client.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			return client.DescribeImages(ecs.CreateDescribeImagesRequest())
+		},
+		EvalFunc: client.EvalCouldRetryResponse(nil, EvalRetryErrorType),
+	})
+	if !isThrottlingError(err) {
+		t.Fatalf("the throttling error should be returned: %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("the request should be retried twice, actual calls: %d", calls)
+	}
+}
+
+func TestWaitForInstanceStatus_cancelled(t *testing.T) {
+	calls := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		calls++
+		return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Starting"}]}}`
+	})
+	state := testState(client, &Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.WaitForInstanceStatus(ctx, "cn-test", "i-test", InstanceStatusRunning, 0, 20*time.Millisecond, state)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("the wait should stop with the context, actual: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the wait should stop promptly, took %s", elapsed)
+	}
+	if calls < 2 {
+		t.Fatalf("the instance should be polled at the given interval, polled %d times", calls)
+	}
+}
+
+func TestWaitForImageStatus_cancelled(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-test","Status":"Creating"}]}}`
+	})
+	state := testState(client, &Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.WaitForImageStatus(ctx, "cn-test", "m-test", ImageStatusAvailable, time.Hour, state)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("the wait should stop with the context, actual: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the wait should stop promptly, took %s", elapsed)
+	}
+}
+
+// testClock is a Clock which only moves when it is slept on, so retries
+// happen without waiting.
+type testClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Sleep(ctx context.Context, duration time.Duration) {
+	c.sleeps = append(c.sleeps, duration)
+	c.now = c.now.Add(duration)
+}
+
+func TestWaitForExpected_clock(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	c := ClientWrapper{Clock: clock}
+
+	iter := 0
+	_, err := c.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			iter++
+			return nil, fmt.Errorf("test: let iteration %d failed", iter)
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			return WaitForExpectToRetry
+		},
+		RetryTimeout: 30 * time.Second,
+	})
+	if err == nil || !strings.Contains(err.Error(), "30 seconds timeout") {
+		t.Fatalf("the wait should time out: %v", err)
+	}
+	// The requests are at 0s, 5s, ... and 30s.
+	if iter != 7 {
+		t.Fatalf("bad number of iterations: %d", iter)
+	}
+	for _, sleep := range clock.sleeps {
+		if sleep != defaultRetryInterval {
+			t.Fatalf("retries should wait for the default interval: %v", clock.sleeps)
+		}
+	}
+}
+
+func TestWaitForExpected_timeout(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	c := ClientWrapper{Clock: clock}
+
+	iter := 0
+	response, err := c.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			iter++
+			if iter == 1 {
+				return nil, errors.NewClientError(errors.TimeoutErrorCode, "test timeout", nil)
+			}
+			return responses.NewCommonResponse(), nil
+		},
+		// Only some error codes are retried, a timeout is retried anyway.
+		EvalFunc: c.EvalCouldRetryResponse([]string{"IncorrectInstanceStatus"}, EvalRetryErrorType),
+	})
+	if err != nil || response == nil {
+		t.Fatalf("a timed out request should be retried: %v", err)
+	}
+	if iter != 2 || len(clock.sleeps) != 1 {
+		t.Fatalf("bad retries: %d, %v", iter, clock.sleeps)
+	}
+}
+
+func TestWaitForInstanceStatus_clock(t *testing.T) {
+	calls := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		calls++
+		if calls == 2 {
+			return http.StatusBadRequest, testErrorBody("Throttling")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Starting"}]}}`
+	})
+	clock := &testClock{now: time.Unix(0, 0)}
+	client.Clock = clock
+	state := testState(client, &Config{})
+
+	_, err := client.WaitForInstanceStatus(context.Background(), "cn-test", "i-test", InstanceStatusRunning, time.Minute, 10*time.Second, state)
+	if err == nil {
+		t.Fatalf("the instance never gets running")
+	}
+	// The calls are at 0s, 10s, right after the throttled one, and then
+	// every 10s until the minute is over.
+	if calls != 7 {
+		t.Fatalf("bad number of calls: %d", calls)
+	}
+	if len(clock.sleeps) < 2 || clock.sleeps[1] >= 10*time.Second {
+		t.Fatalf("the throttled call should be retried with its own delay: %v", clock.sleeps)
+	}
+}
@@ -2,9 +2,14 @@ package ecs
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/hashicorp/packer/common/uuid"
 	"github.com/hashicorp/packer/hcl2template"
 	"github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/template/interpolate"
@@ -25,7 +30,11 @@ type ApsaraStackDiskDevice struct {
 	DiskCategory string `mapstructure:"disk_category" required:"false"`
 	// Size of the system disk, measured in GiB. Value
 	// range: [20, 500]. The specified value must be equal to or greater
-	// than max{20, ImageSize}. Default value: max{40, ImageSize}.
+	// than max{20, ImageSize}. Default value: max{40, ImageSize}. Data
+	// disks take 5 to 2000 GiB for `cloud`, 20 to 32768 GiB for
+	// `cloud_efficiency` and `cloud_ssd`, and for `cloud_essd` a minimum
+	// which depends on the performance level. Sizes out of range are
+	// rejected before the build.
 	DiskSize int `mapstructure:"disk_size" required:"false"`
 	// Snapshots are used to create the data
 	// disk After this parameter is specified, Size is ignored. The actual
@@ -48,6 +57,17 @@ type ApsaraStackDiskDevice struct {
 	// it was in the source image. Please refer to Introduction of ECS disk encryption
 	// for more details.
 	Encrypted config.Trilean `mapstructure:"disk_encrypted" required:"false"`
+	// The ID of the KMS key used to encrypt the disk. Only valid when
+	// `disk_encrypted` is true, the default service key is used otherwise.
+	KMSKeyId string `mapstructure:"disk_kms_key_id" required:"false"`
+	// The performance level of an ESSD disk, one of PL0, PL1, PL2 or PL3.
+	// Only valid when `disk_category` is `cloud_essd`.
+	PerformanceLevel string `mapstructure:"disk_performance_level" required:"false"`
+	// The ID of an auto snapshot policy applied to the system disk of the
+	// build instance, so that snapshots are taken during long provisioning.
+	// The policy is cancelled again before the instance is deleted. Only
+	// valid in `system_disk_mapping`.
+	SnapshotPolicyId string `mapstructure:"disk_snapshot_policy_id" required:"false"`
 }
 
 type ApsaraStackDiskDevices struct {
@@ -77,6 +97,20 @@ type ApsaraStackDiskDevices struct {
 	//     range: \[20, 500\]. The specified value must be equal to or greater
 	//     than max{20, ImageSize}. Default value: max{40, ImageSize}.
 	//
+	// -   `disk_encrypted` (boolean) - Whether or not to encrypt the system
+	//     disk. The `cloud` and `ephemeral_ssd` categories can't be encrypted.
+	//
+	// -   `disk_kms_key_id` (string) - The ID of the KMS key used to encrypt
+	//     the system disk. Requires `disk_encrypted` to be true.
+	//
+	// -   `disk_performance_level` (string) - The performance level of an ESSD
+	//     system disk, one of `PL0`, `PL1`, `PL2` or `PL3`. Requires
+	//     `disk_category` to be `cloud_essd`.
+	//
+	// -   `disk_snapshot_policy_id` (string) - The ID of an auto snapshot
+	//     policy applied to the system disk during the build, it's cancelled
+	//     again before the instance is deleted.
+	//
 	ECSSystemDiskMapping ApsaraStackDiskDevice `mapstructure:"system_disk_mapping" required:"false"`
 	// Add one or more data
 	// disks to the image.
@@ -130,41 +164,101 @@ type ApsaraStackDiskDevices struct {
 	//     it was in the source image. Please refer to Introduction of [ECS disk encryption](https://www.alibabacloud.com/help/doc-detail/59643.htm)
 	//     for more details.
 	//
+	// -   `disk_kms_key_id` (string) - The ID of the KMS key used to encrypt
+	//     the data disk. Requires `disk_encrypted` to be true.
+	//
+	// -   `disk_performance_level` (string) - The performance level of an ESSD
+	//     data disk, one of `PL0`, `PL1`, `PL2` or `PL3`. Requires
+	//     `disk_category` to be `cloud_essd`.
+	//
 	ECSImagesDiskMappings []ApsaraStackDiskDevice `mapstructure:"image_disk_mappings" required:"false"`
 }
 
+// The OSS location the target image is exported to.
+type ApsaraStackImageExport struct {
+	// The OSS bucket the image is exported to. The bucket has to be in the
+	// same region as the image.
+	OSSBucket string `mapstructure:"oss_bucket" required:"true"`
+	// The prefix of the exported object keys.
+	OSSPrefix string `mapstructure:"oss_prefix" required:"false"`
+}
+
 type ApsaraStackImageConfig struct {
 	// The name of the user-defined image, [2, 128] English or Chinese
 	// characters. It must begin with an uppercase/lowercase letter or a
 	// Chinese character, and may contain numbers, `_` or `-`. It cannot begin
 	// with `http://` or `https://`.
 	ApsaraStackImageName string `mapstructure:"image_name" required:"true"`
+	// A suffix appended to `image_name` so that repeated builds don't
+	// collide with the images of earlier ones, `timestamp` for the UTC time
+	// of the build such as `20201001120000`, or `uuid`. The name is
+	// truncated to keep within 128 characters. By default the name is used
+	// as is, and the build fails if an image with the name already exists.
+	// It can't be used with `fail_if_noop`.
+	ApsaraStackImageNameAutoAppend string `mapstructure:"image_name_auto_append" required:"false"`
 	// The version number of the image, with a length limit of 1 to 40 English
 	// characters.
 	ApsaraStackImageVersion string `mapstructure:"image_version" required:"false"`
 	// The description of the image, with a length limit of 0 to 256
 	// characters. Leaving it blank means null, which is the default value. It
-	// cannot begin with `http://` or `https://`.
+	// cannot begin with `http://` or `https://` nor contain control
+	// characters other than line breaks. It's rendered with the template
+	// engine, like `image_name`.
 	ApsaraStackImageDescription string `mapstructure:"image_description" required:"false"`
 	// The IDs of to-be-added Aliyun accounts to which the image is shared. The
 	// number of accounts is 1 to 10. If number of accounts is greater than 10,
 	// this parameter is ignored.
 	ApsaraStackImageShareAccounts   []string `mapstructure:"image_share_account" required:"false"`
 	ApsaraStackImageUNShareAccounts []string `mapstructure:"image_unshare_account"`
-	// Copy to the destination regionIds.
+	// Copy to the destination regionIds. Up to `image_copy_concurrency`
+	// regions are copied to at the same time and the build waits until every
+	// copy is available. If one copy fails, the others are cancelled and the
+	// copies are removed.
 	ApsaraStackImageDestinationRegions []string `mapstructure:"image_copy_regions" required:"false"`
+	// How many regions the image is copied to at the same time. The default
+	// value is 3.
+	ImageCopyConcurrency int `mapstructure:"image_copy_concurrency" required:"false"`
 	// The name of the destination image, [2, 128] English or Chinese
 	// characters. It must begin with an uppercase/lowercase letter or a
 	// Chinese character, and may contain numbers, _ or -. It cannot begin with
 	// `http://` or `https://`.
 	ApsaraStackImageDestinationNames []string `mapstructure:"image_copy_names" required:"false"`
+	// The image family the target image is added to, so that consumers can
+	// launch the latest image of the family. It has [2, 128] English or
+	// Chinese characters, must begin with a letter or a Chinese character
+	// and may contain numbers, `.`, `_`, `:` or `-`. It cannot begin with
+	// `aliyun`, `acs:`, `http://` or `https://`. Only the image in the build
+	// region is added to the family.
+	ApsaraStackImageFamily string `mapstructure:"image_family" required:"false"`
+	// The boot mode of the target image, `BIOS` or `UEFI`. By default the
+	// boot mode of the source image is kept. `i386` images can't boot with
+	// `UEFI` and `arm64` images only boot with `UEFI`.
+	ApsaraStackImageBootMode string `mapstructure:"boot_mode" required:"false"`
+	// The license type of the target image, which is used by the instances
+	// launched from it. Optional values are `Auto`, `Aliyun` and `BYOL`
+	// (bring your own license). By default the license handling of the
+	// source image is inherited. Images from the marketplace carry their
+	// own license and can't be captured as `BYOL`.
+	ApsaraStackImageLicenseType string `mapstructure:"image_license_type" required:"false"`
 	// Whether or not to encrypt the target images,            including those
 	// copied if image_copy_regions is specified. If this option is set to
 	// true, a temporary image will be created from the provisioned instance in
 	// the main region and an encrypted copy will be generated in the same
-	// region. By default, Packer will keep the encryption setting to what it
-	// was in the source image.
+	// region, the same goes for an unencrypted copy when it's false. By default, Packer will keep the encryption setting to what it
+	// was in the source image. The snapshots of a source instance with
+	// encrypted disks stay encrypted with their own keys, this option isn't
+	// needed to keep them encrypted.
 	ImageEncrypted config.Trilean `mapstructure:"image_encrypted" required:"false"`
+	// The ID of the KMS key used to encrypt the target image in the build
+	// region. Requires `image_encrypted` to be true, the default service key
+	// is used otherwise and for the copies in other regions.
+	ImageKMSKeyId string `mapstructure:"image_kms_key_id" required:"false"`
+	// If this value is true, the build fails when the system disk or a data
+	// disk with `disk_encrypted` set to true isn't encrypted once the
+	// instance is created, as some stacks silently ignore the flag. Otherwise
+	// a warning is shown. Requested data disks are matched by `disk_device`,
+	// or by `disk_name` when no device is set. The default value is false.
+	StrictDiskEncryption bool `mapstructure:"strict_disk_encryption" required:"false"`
 	// If this value is true, when the target image names including those
 	// copied are duplicated with existing images, it will delete the existing
 	// images and then create the target images, otherwise, the creation will
@@ -173,6 +267,14 @@ type ApsaraStackImageConfig struct {
 	// [-force](/docs/commands/build#force) option is provided in `build`
 	// command, this option can be omitted and taken as true.
 	ApsaraStackImageForceDelete bool `mapstructure:"image_force_delete" required:"false"`
+	// If this value is true, the target image is tagged with the ID of its
+	// source image under the `packer_source_image` key, and the build fails
+	// before anything is created when an image named `image_name` already
+	// carries the ID of the current source image. Packer can't tell whether
+	// any provisioners are configured, so this is useful for pipelines where
+	// the source image is what changes between builds, typically along with
+	// `image_force_delete`. The default value is false.
+	FailIfNoop bool `mapstructure:"fail_if_noop" required:"false"`
 	// If this value is true, when delete the duplicated existing images, the
 	// source snapshots of those images will be delete either. If
 	// [-force](/docs/commands/build#force) option is provided in `build`
@@ -184,18 +286,88 @@ type ApsaraStackImageConfig struct {
 	// default data disks with instance types are not concerned. The default
 	// value is false.
 	ApsaraStackImageIgnoreDataDisks bool `mapstructure:"image_ignore_data_disks" required:"true"`
+	// Devices of the data disks, such as `/dev/xvdb`, that will be
+	// snapshotted separately once the instance is stopped. The snapshots are
+	// named after the image and retained after the build, and their IDs are
+	// exported by the artifact. The image is then built from a snapshot of
+	// the system disk and these snapshots, which are the only data disks
+	// referenced by the image's device mapping.
+	ApsaraStackImageDataDiskSnapshots []string `mapstructure:"image_data_disk_snapshots" required:"false"`
 	// The region validation can be skipped if this value is true, the default
 	// value is false.
 	ApsaraStackImageSkipRegionValidation bool `mapstructure:"skip_region_validation" required:"false"`
 	// Key/value pair tags applied to the destination image and relevant
-	// snapshots.
+	// snapshots. The values may use template functions such as
+	// `{{timestamp}}`. The image is kept when tagging fails, only a warning
+	// is shown.
 	ApsaraStackImageTags map[string]string `mapstructure:"tags" required:"false"`
+	// Whether the tags are applied to the snapshots of the image as well.
+	// The default value is true.
+	ImageTagSnapshots config.Trilean `mapstructure:"image_tag_snapshots" required:"false"`
 	// Same as [`tags`](#tags) but defined as a singular repeatable block
 	// containing a `key` and a `value` field. In HCL2 mode the
 	// [`dynamic_block`](/docs/configuration/from-1.5/expressions#dynamic-blocks)
 	// will allow you to create those programatically.
-	ApsaraStackImageTag    hcl2template.KeyValues `mapstructure:"tag" required:"false"`
+	ApsaraStackImageTag hcl2template.KeyValues `mapstructure:"tag" required:"false"`
+	// If this value is true, Packer waits until the tags it applied to the
+	// instance, the [`run_tags`](#run_tags), and to the image are visible
+	// through `DescribeTags`, so that tag based lookups later in the same
+	// build find them. The default value is false.
+	VerifyTagsVisible bool `mapstructure:"verify_tags_visible" required:"false"`
+	// How long to wait for the tags to become visible when
+	// `verify_tags_visible` is true. The default value is `30s`.
+	TagVisibilityTimeout time.Duration `mapstructure:"tag_visibility_timeout" required:"false"`
+	// How many snapshots are tagged at the same time. Images with many data
+	// disks have many snapshots, which are slow to tag one by one. The
+	// default value is 4.
+	TagConcurrency int `mapstructure:"tag_concurrency" required:"false"`
+	// If this value is true, the tags of the source image are copied onto the
+	// target image and relevant snapshots. Tags defined by `tags` win when a
+	// key is defined in both. The default value is false.
+	InheritSourceImageTags bool `mapstructure:"inherit_source_image_tags" required:"false"`
+	// Only copy the source image tags with these keys when
+	// `inherit_source_image_tags` is true. All tags are copied by default.
+	SourceImageTagKeys []string `mapstructure:"source_image_tag_keys" required:"false"`
+	// URL that the artifact metadata (image IDs, regions, tags, source image
+	// and build duration) is POSTed to as JSON after a successful build. The
+	// notification is best-effort, a failure is logged but doesn't fail the
+	// build.
+	ArtifactWebhookUrl string `mapstructure:"artifact_webhook_url" required:"false"`
+	// Value of the `Authorization` header sent with the webhook request, such
+	// as `Bearer <token>`.
+	ArtifactWebhookAuthHeader string `mapstructure:"artifact_webhook_auth_header" required:"false"`
+	// Timeout of the webhook request. The default value is `10s`.
+	ArtifactWebhookTimeout time.Duration `mapstructure:"artifact_webhook_timeout" required:"false"`
+	// Path of a file the ID of the target image is written to once the
+	// image, and every copy of it, is available. When the image is copied to
+	// other regions, the file holds a JSON object mapping each region to its
+	// image ID instead.
+	ImageIdFile string `mapstructure:"image_id_file" required:"false"`
+	// Path of a file the IDs of the instance, the image and its copies are
+	// recorded in while the build goes, the image once it's tagged. A failed
+	// build keeps the recorded image and the finished copies, and the next
+	// build with the same file and `image_name` picks them up instead of
+	// building the image again, under the name with the suffix of
+	// `image_name_auto_append` of the failed build: it only copies the image to the regions it's
+	// missing in, and shares and exports it. The file is removed once a build succeeds. Resuming is
+	// best-effort, the build starts over when the recorded image can't be
+	// used, and the kept images have to be deleted by hand when the build
+	// isn't run again. It can't be used with `image_encrypted`.
+	ResumeStateFile string `mapstructure:"resume_state_file" required:"false"`
+	// Export the target image in the build region to OSS once it's
+	// available, for offline distribution. The keys of the exported objects
+	// are part of the artifact. The block supports:
+	//
+	// -   `oss_bucket` (string) - The OSS bucket the image is exported to,
+	//     in the same region as the image.
+	//
+	// -   `oss_prefix` (string) - The prefix of the exported object keys.
+	//
+	ImageExport            ApsaraStackImageExport `mapstructure:"image_export" required:"false"`
 	ApsaraStackDiskDevices `mapstructure:",squash"`
+
+	// image_name before the suffix of image_name_auto_append is appended.
+	imageBaseName string
 }
 
 func (c *ApsaraStackImageConfig) Prepare(ctx *interpolate.Context) []error {
@@ -214,6 +386,138 @@ func (c *ApsaraStackImageConfig) Prepare(ctx *interpolate.Context) []error {
 		errs = append(errs, fmt.Errorf("image_name can't include spaces"))
 	}
 
+	if err := validateImageDescription(c.ApsaraStackImageDescription); err != nil {
+		errs = append(errs, err)
+	}
+
+	c.imageBaseName = c.ApsaraStackImageName
+	switch c.ApsaraStackImageNameAutoAppend {
+	case "":
+	case ImageNameAutoAppendTimestamp:
+		c.ApsaraStackImageName = appendImageNameSuffix(c.ApsaraStackImageName, time.Now().UTC().Format("20060102150405"))
+	case ImageNameAutoAppendUUID:
+		c.ApsaraStackImageName = appendImageNameSuffix(c.ApsaraStackImageName, uuid.TimeOrderedUUID())
+	default:
+		errs = append(errs, fmt.Errorf("image_name_auto_append must be %s or %s, got %q",
+			ImageNameAutoAppendTimestamp, ImageNameAutoAppendUUID, c.ApsaraStackImageNameAutoAppend))
+	}
+
+	// fail_if_noop looks the earlier builds up by image_name, which is new
+	// for every build with a suffix.
+	if c.ApsaraStackImageNameAutoAppend != "" && c.FailIfNoop {
+		errs = append(errs, fmt.Errorf("image_name_auto_append can't be used with fail_if_noop"))
+	}
+
+	switch c.ApsaraStackImageBootMode {
+	case "", ImageBootModeBIOS, ImageBootModeUEFI:
+	default:
+		errs = append(errs, fmt.Errorf("boot_mode must be %s or %s, got %q",
+			ImageBootModeBIOS, ImageBootModeUEFI, c.ApsaraStackImageBootMode))
+	}
+
+	switch c.ApsaraStackImageLicenseType {
+	case "", ImageLicenseTypeAuto, ImageLicenseTypeAliyun, ImageLicenseTypeBYOL:
+	default:
+		errs = append(errs, fmt.Errorf("image_license_type must be one of %s, %s or %s, got %q",
+			ImageLicenseTypeAuto, ImageLicenseTypeAliyun, ImageLicenseTypeBYOL, c.ApsaraStackImageLicenseType))
+	}
+
+	if c.TagVisibilityTimeout < 0 {
+		errs = append(errs, fmt.Errorf("tag_visibility_timeout can't be negative"))
+	} else if c.TagVisibilityTimeout == 0 {
+		c.TagVisibilityTimeout = 30 * time.Second
+	}
+
+	if c.TagConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("tag_concurrency can't be negative"))
+	} else if c.TagConcurrency == 0 {
+		c.TagConcurrency = 4
+	}
+
+	if c.ImageCopyConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("image_copy_concurrency can't be negative"))
+	} else if c.ImageCopyConcurrency == 0 {
+		c.ImageCopyConcurrency = defaultImageCopyConcurrency
+	}
+
+	if c.ImageExport.OSSPrefix != "" && c.ImageExport.OSSBucket == "" {
+		errs = append(errs, fmt.Errorf("image_export.oss_prefix requires image_export.oss_bucket to be set"))
+	}
+
+	if c.ApsaraStackImageFamily != "" {
+		if err := validateImageFamily(c.ApsaraStackImageFamily); err != nil {
+			errs = append(errs, err)
+		}
+		// The family would only get the temporary image, not its copy.
+		if c.ImageEncrypted != config.TriUnset {
+			errs = append(errs, fmt.Errorf("image_family can't be used with image_encrypted"))
+		}
+	}
+
+	// The encrypted image is a copy of a temporary one, which isn't kept.
+	if c.ResumeStateFile != "" && c.ImageEncrypted != config.TriUnset {
+		errs = append(errs, fmt.Errorf("resume_state_file can't be used with image_encrypted"))
+	}
+
+	if c.ImageKMSKeyId != "" && !c.ImageEncrypted.True() {
+		errs = append(errs, fmt.Errorf("image_kms_key_id requires image_encrypted to be true"))
+	}
+
+	if len(c.SourceImageTagKeys) > 0 && !c.InheritSourceImageTags {
+		errs = append(errs, fmt.Errorf("source_image_tag_keys requires inherit_source_image_tags to be true"))
+	}
+
+	if c.ArtifactWebhookUrl != "" {
+		if u, err := url.Parse(c.ArtifactWebhookUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("artifact_webhook_url must be a http or https URL, got %q", c.ArtifactWebhookUrl))
+		}
+	}
+	if c.ArtifactWebhookTimeout < 0 {
+		errs = append(errs, fmt.Errorf("artifact_webhook_timeout can't be negative"))
+	} else if c.ArtifactWebhookTimeout == 0 {
+		c.ArtifactWebhookTimeout = 10 * time.Second
+	}
+
+	systemDisk := c.ECSSystemDiskMapping
+	if systemDisk.KMSKeyId != "" && !systemDisk.Encrypted.True() {
+		errs = append(errs, fmt.Errorf("system_disk_mapping.disk_kms_key_id requires system_disk_mapping.disk_encrypted to be true"))
+	}
+	if systemDisk.Encrypted.True() && !diskCategorySupportsEncryption(systemDisk.DiskCategory) {
+		errs = append(errs, fmt.Errorf("system_disk_mapping.disk_category %s doesn't support encryption", systemDisk.DiskCategory))
+	}
+	if err := validateDiskPerformanceLevel("system_disk_mapping", systemDisk); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateDiskSize("system_disk_mapping", systemDisk, true); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, disk := range c.ECSImagesDiskMappings {
+		if disk.KMSKeyId != "" && !disk.Encrypted.True() {
+			errs = append(errs, fmt.Errorf("image_disk_mappings[%d].disk_kms_key_id requires image_disk_mappings[%d].disk_encrypted to be true", i, i))
+		}
+		if err := validateDiskPerformanceLevel(fmt.Sprintf("image_disk_mappings[%d]", i), disk); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateDiskSize(fmt.Sprintf("image_disk_mappings[%d]", i), disk, false); err != nil {
+			errs = append(errs, err)
+		}
+		if disk.SnapshotPolicyId != "" {
+			errs = append(errs, fmt.Errorf("image_disk_mappings[%d].disk_snapshot_policy_id is only supported in system_disk_mapping", i))
+		}
+	}
+
+	devices := make(map[string]struct{})
+	for _, device := range c.ApsaraStackImageDataDiskSnapshots {
+		if !strings.HasPrefix(device, "/dev/") {
+			errs = append(errs, fmt.Errorf("image_data_disk_snapshots must be device names such as /dev/xvdb, got %q", device))
+		}
+		if _, ok := devices[device]; ok {
+			errs = append(errs, fmt.Errorf("image_data_disk_snapshots contains duplicated device %s", device))
+		}
+		devices[device] = struct{}{}
+	}
+
 	if len(c.ApsaraStackImageDestinationRegions) > 0 {
 		regionSet := make(map[string]struct{})
 		regions := make([]string, 0, len(c.ApsaraStackImageDestinationRegions))
@@ -234,3 +538,167 @@ func (c *ApsaraStackImageConfig) Prepare(ctx *interpolate.Context) []error {
 
 	return errs
 }
+
+// diskCategorySupportsEncryption reports whether disks of the category can be
+// encrypted, an empty category leaves the choice to ECS.
+func diskCategorySupportsEncryption(category string) bool {
+	switch category {
+	case DiskCategoryCloud, DiskCategoryEphemeralSSD:
+		return false
+	default:
+		return true
+	}
+}
+
+// validateDiskPerformanceLevel checks the performance level of the disk at
+// the given config path, only ESSD disks have performance levels.
+func validateDiskPerformanceLevel(name string, disk ApsaraStackDiskDevice) error {
+	if disk.PerformanceLevel == "" {
+		return nil
+	}
+	if disk.DiskCategory != DiskCategoryESSD {
+		return fmt.Errorf("%s.disk_performance_level requires %s.disk_category to be %s", name, name, DiskCategoryESSD)
+	}
+	for _, level := range DiskPerformanceLevels {
+		if disk.PerformanceLevel == level {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s.disk_performance_level must be one of %s, got %q", name, strings.Join(DiskPerformanceLevels, ", "), disk.PerformanceLevel)
+}
+
+// diskSizeRange returns the sizes in GiB ECS accepts for a disk of the
+// category, ok is false for categories whose limits aren't known.
+func diskSizeRange(category string, performanceLevel string, system bool) (min int, max int, ok bool) {
+	if system {
+		switch category {
+		case DiskCategoryCloud, DiskCategoryCloudEfficiency, DiskCategoryCloudSSD:
+			return 20, 500, true
+		case DiskCategoryESSD:
+			min, _, _ := diskSizeRange(category, performanceLevel, false)
+			return min, 2048, true
+		}
+		return 0, 0, false
+	}
+
+	switch category {
+	case DiskCategoryCloud:
+		return 5, 2000, true
+	case DiskCategoryCloudEfficiency, DiskCategoryCloudSSD:
+		return 20, 32768, true
+	case DiskCategoryEphemeralSSD:
+		return 5, 800, true
+	case DiskCategoryESSD:
+		switch performanceLevel {
+		case "PL0":
+			return 40, 32768, true
+		case "PL2":
+			return 461, 32768, true
+		case "PL3":
+			return 1261, 32768, true
+		default:
+			// ESSD disks default to PL1.
+			return 20, 32768, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// validateDiskSize checks the size of the disk at the given config path
+// against the range of its category, an unset size leaves it to ECS.
+func validateDiskSize(name string, disk ApsaraStackDiskDevice, system bool) error {
+	if disk.DiskSize == 0 {
+		return nil
+	}
+	if err := validateNumber(name+".disk_size", disk.DiskSize); err != nil {
+		return err
+	}
+
+	min, max, ok := diskSizeRange(disk.DiskCategory, disk.PerformanceLevel, system)
+	if !ok || (disk.DiskSize >= min && disk.DiskSize <= max) {
+		return nil
+	}
+
+	return fmt.Errorf("%s.disk_size of %s disks must be between %d and %d GiB, got %d", name, diskCategoryName(disk), min, max, disk.DiskSize)
+}
+
+// diskCategoryName returns the category of the disk for messages, with the
+// performance level of ESSD disks.
+func diskCategoryName(disk ApsaraStackDiskDevice) string {
+	if disk.DiskCategory == DiskCategoryESSD && disk.PerformanceLevel != "" {
+		return fmt.Sprintf("%s %s", disk.DiskCategory, disk.PerformanceLevel)
+	}
+
+	return disk.DiskCategory
+}
+
+var imageFamilyPattern = regexp.MustCompile(`^[a-zA-Z\p{Han}][a-zA-Z0-9\p{Han}._:-]{1,127}$`)
+
+// validateImageFamily checks the image family name against the naming rules
+// of ECS.
+func validateImageFamily(family string) error {
+	lower := strings.ToLower(family)
+	for _, prefix := range []string{"aliyun", "acs:", "http://", "https://"} {
+		if strings.HasPrefix(lower, prefix) {
+			return fmt.Errorf("image_family can't start with %q, got %q", prefix, family)
+		}
+	}
+	if !imageFamilyPattern.MatchString(family) {
+		return fmt.Errorf("image_family must have 2 to 128 characters, begin with a letter and contain only "+
+			"letters, numbers, '.', '_', ':' or '-', got %q", family)
+	}
+
+	return nil
+}
+
+// validateBootMode checks that an image of the given architecture can boot
+// with the boot mode.
+func validateBootMode(bootMode string, architecture string) error {
+	switch {
+	case bootMode == ImageBootModeUEFI && architecture == "i386":
+		return fmt.Errorf("boot_mode %s isn't supported by %s images, use %s or a x86_64 source image",
+			ImageBootModeUEFI, architecture, ImageBootModeBIOS)
+	case bootMode == ImageBootModeBIOS && architecture == "arm64":
+		return fmt.Errorf("boot_mode %s isn't supported by %s images, which only boot with %s",
+			ImageBootModeBIOS, architecture, ImageBootModeUEFI)
+	}
+
+	return nil
+}
+
+// The limit ECS puts on the length of an image description.
+const maxImageDescriptionLength = 256
+
+// validateImageDescription checks the image description against the rules
+// ECS has for it.
+func validateImageDescription(description string) error {
+	if length := utf8.RuneCountInString(description); length > maxImageDescriptionLength {
+		return fmt.Errorf("image_description must have at most %d characters, got %d", maxImageDescriptionLength, length)
+	}
+	if strings.HasPrefix(description, "http://") || strings.HasPrefix(description, "https://") {
+		return fmt.Errorf("image_description can't start with 'http://' or 'https://'")
+	}
+	for _, r := range description {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' {
+			return fmt.Errorf("image_description can't contain the control character %U", r)
+		}
+	}
+
+	return nil
+}
+
+// The limit ECS puts on the length of an image name.
+const maxImageNameLength = 128
+
+// appendImageNameSuffix appends the suffix to the image name, the name is
+// truncated if the result would be too long.
+func appendImageNameSuffix(name string, suffix string) string {
+	base := []rune(name)
+	if limit := maxImageNameLength - len(suffix) - 1; len(base) > limit {
+		base = base[:limit]
+	}
+
+	return fmt.Sprintf("%s-%s", string(base), suffix)
+}
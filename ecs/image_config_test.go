@@ -1,7 +1,12 @@
 package ecs
 
 import (
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/packer/helper/config"
 )
 
 func testApsaraStackImageConfig() *ApsaraStackImageConfig {
@@ -59,3 +64,287 @@ func TestECSImageConfigPrepare_imageTags(t *testing.T) {
 		}, c.ApsaraStackImageTags)
 	}
 }
+
+func TestECSImageConfigPrepare_dataDiskSnapshots(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ApsaraStackImageDataDiskSnapshots = []string{"/dev/xvdb", "/dev/xvdc"}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ApsaraStackImageDataDiskSnapshots = []string{"xvdb"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ApsaraStackImageDataDiskSnapshots = []string{"/dev/xvdb", "/dev/xvdb"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_licenseType(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	for _, licenseType := range []string{"", "Auto", "Aliyun", "BYOL"} {
+		c.ApsaraStackImageLicenseType = licenseType
+		if err := c.Prepare(nil); len(err) != 0 {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	c.ApsaraStackImageLicenseType = "byol"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_artifactWebhook(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ArtifactWebhookUrl = "https://catalog.example.com/images"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.ArtifactWebhookTimeout != 10*time.Second {
+		t.Fatalf("invalid value, expected: %s, actual: %s", 10*time.Second, c.ArtifactWebhookTimeout)
+	}
+
+	c.ArtifactWebhookUrl = "catalog.example.com/images"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_tagConcurrency(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.TagConcurrency != 4 {
+		t.Fatalf("invalid value, expected: %d, actual: %d", 4, c.TagConcurrency)
+	}
+
+	c.TagConcurrency = -1
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_imageCopyConcurrency(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.ImageCopyConcurrency != 3 {
+		t.Fatalf("invalid value, expected: %d, actual: %d", 3, c.ImageCopyConcurrency)
+	}
+
+	c.ImageCopyConcurrency = -1
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_systemDiskEncryption(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ECSSystemDiskMapping.KMSKeyId = "key-id"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ECSSystemDiskMapping.Encrypted = config.TriTrue
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ECSSystemDiskMapping.DiskCategory = DiskCategoryCloud
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_dataDiskKMSKeyId(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ECSImagesDiskMappings = []ApsaraStackDiskDevice{
+		{Encrypted: config.TriTrue, KMSKeyId: "key-id"},
+		{KMSKeyId: "key-id"},
+	}
+	errs := c.Prepare(nil)
+	if len(errs) != 1 {
+		t.Fatalf("err: %s", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "image_disk_mappings[1]") {
+		t.Fatalf("error should name the disk index: %s", errs[0])
+	}
+}
+
+func TestECSImageConfigPrepare_diskPerformanceLevel(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ECSSystemDiskMapping = ApsaraStackDiskDevice{DiskCategory: DiskCategoryESSD, PerformanceLevel: "PL1"}
+	c.ECSImagesDiskMappings = []ApsaraStackDiskDevice{{DiskCategory: DiskCategoryESSD, PerformanceLevel: "PL3"}}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ECSSystemDiskMapping.PerformanceLevel = "PL4"
+	c.ECSImagesDiskMappings[0].DiskCategory = "cloud_ssd"
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_imageKMSKeyId(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ImageKMSKeyId = "key-id"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error without image_encrypted: %s", err)
+	}
+
+	c.ImageEncrypted = config.TriTrue
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_imageFamily(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	for _, family := range []string{"base-centos", "team:web_1.0"} {
+		c.ApsaraStackImageFamily = family
+		if err := c.Prepare(nil); len(err) != 0 {
+			t.Fatalf("%s: %s", family, err)
+		}
+	}
+
+	for _, family := range []string{"a", "1-base", "aliyun-base", "acs:base", "base family"} {
+		c.ApsaraStackImageFamily = family
+		if err := c.Prepare(nil); len(err) != 1 {
+			t.Fatalf("%s should be invalid: %s", family, err)
+		}
+	}
+
+	c.ApsaraStackImageFamily = "base-centos"
+	c.ImageEncrypted = config.TriTrue
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error with image_encrypted: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_bootMode(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ApsaraStackImageBootMode = "EFI"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error with an unknown boot mode: %s", err)
+	}
+
+	c.ApsaraStackImageBootMode = ImageBootModeUEFI
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := validateBootMode(ImageBootModeUEFI, "i386"); err == nil {
+		t.Fatal("i386 images shouldn't boot with UEFI")
+	}
+	if err := validateBootMode(ImageBootModeBIOS, "arm64"); err == nil {
+		t.Fatal("arm64 images shouldn't boot with BIOS")
+	}
+	if err := validateBootMode(ImageBootModeUEFI, "x86_64"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_imageDescription(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ApsaraStackImageDescription = "Base image\nbuilt nightly"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, description := range []string{strings.Repeat("a", 257), "https://example.com", "tab\tseparated"} {
+		c.ApsaraStackImageDescription = description
+		if err := c.Prepare(nil); len(err) != 1 {
+			t.Fatalf("%q should be invalid: %s", description, err)
+		}
+	}
+}
+
+func TestECSImageConfigPrepare_imageNameAutoAppend(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ApsaraStackImageName = "packer-test"
+	c.ApsaraStackImageNameAutoAppend = ImageNameAutoAppendTimestamp
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if !regexp.MustCompile(`^packer-test-\d{14}$`).MatchString(c.ApsaraStackImageName) {
+		t.Fatalf("bad image name: %s", c.ApsaraStackImageName)
+	}
+
+	c.ApsaraStackImageName = strings.Repeat("a", 128)
+	c.ApsaraStackImageNameAutoAppend = ImageNameAutoAppendUUID
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if len(c.ApsaraStackImageName) != 128 || !strings.HasPrefix(c.ApsaraStackImageName, "aaa") {
+		t.Fatalf("the image name should be truncated: %s", c.ApsaraStackImageName)
+	}
+
+	c.ApsaraStackImageNameAutoAppend = "date"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error with an unknown suffix: %s", err)
+	}
+
+	c.ApsaraStackImageName = "packer-test"
+	c.ApsaraStackImageNameAutoAppend = ImageNameAutoAppendTimestamp
+	c.FailIfNoop = true
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error with fail_if_noop: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_imageExport(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ImageExport.OSSPrefix = "images/"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error without image_export.oss_bucket: %s", err)
+	}
+
+	c.ImageExport.OSSBucket = "bucket"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestECSImageConfigPrepare_diskSize(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ECSSystemDiskMapping = ApsaraStackDiskDevice{DiskCategory: DiskCategoryCloudEfficiency, DiskSize: 40}
+	c.ECSImagesDiskMappings = []ApsaraStackDiskDevice{
+		{DiskCategory: DiskCategoryCloudEfficiency, DiskSize: 32768},
+		{DiskCategory: DiskCategoryESSD, PerformanceLevel: "PL2", DiskSize: 500},
+		{DiskSize: 100000},
+	}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ECSSystemDiskMapping.DiskSize = 501
+	c.ECSImagesDiskMappings[1].PerformanceLevel = "PL3"
+	errs := c.Prepare(nil)
+	if len(errs) != 2 {
+		t.Fatalf("err: %s", errs)
+	}
+	if !strings.Contains(errs[1].Error(), "image_disk_mappings[1]") || !strings.Contains(errs[1].Error(), "cloud_essd PL3") ||
+		!strings.Contains(errs[1].Error(), "1261 and 32768") {
+		t.Fatalf("error should name the disk, its category and the range: %s", errs[1])
+	}
+}
+
+func TestECSImageConfigPrepare_resumeStateFile(t *testing.T) {
+	c := testApsaraStackImageConfig()
+	c.ResumeStateFile = "resume.json"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ImageEncrypted = config.TriTrue
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should error with image_encrypted: %s", err)
+	}
+}
@@ -1,9 +1,17 @@
 package ecs
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/hashicorp/packer/common/uuid"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
@@ -21,11 +29,47 @@ func cleanUpMessage(state multistep.StateBag, module string) {
 	}
 }
 
+// errKeptOnError is recorded in the cleanup summary for the resources kept by
+// keep_instance_on_error, so that they are reported as left behind.
+var errKeptOnError = errors.New("kept by keep_instance_on_error")
+
+// errKeptOnCleanup is recorded in the cleanup summary for the snapshot of the
+// system disk created by snapshot_on_cleanup.
+var errKeptOnCleanup = errors.New("kept by snapshot_on_cleanup")
+
+// keepOnError reports whether the build failed and keeps the instance, along
+// with the resources it relies on, for debugging. Cancelled builds are
+// cleaned up.
+func keepOnError(state multistep.StateBag) bool {
+	config := state.Get("config").(*Config)
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+
+	return config.KeepInstanceOnError && halted && !cancelled
+}
+
+// keepResource skips the cleanup of a resource when keepOnError says so, it
+// returns whether the resource is kept.
+func keepResource(state multistep.StateBag, kind string, id string) bool {
+	if !keepOnError(state) {
+		return false
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	ui.Message(fmt.Sprintf("Keeping %s %s for debugging", kind, id))
+	recordCleanup(state, kind, id, errKeptOnError)
+	return true
+}
+
 func halt(state multistep.StateBag, err error, prefix string) multistep.StepAction {
 	ui := state.Get("ui").(packer.Ui)
 
 	if prefix != "" {
-		err = fmt.Errorf("%s: %s", prefix, err)
+		err = fmt.Errorf("%s: %w", prefix, err)
+	}
+	// Support needs the request id to trace a failed API call.
+	if requestId := errorRequestId(err); requestId != "" && !strings.Contains(err.Error(), requestId) {
+		err = fmt.Errorf("%w (RequestId: %s)", err, requestId)
 	}
 
 	state.Put("error", err)
@@ -33,14 +77,85 @@ func halt(state multistep.StateBag, err error, prefix string) multistep.StepActi
 	return multistep.ActionHalt
 }
 
+// clientTokens are the client tokens of the operations of a build, by
+// operation. Operations of a step may run concurrently.
+type clientTokens struct {
+	// The token of client_token the client tokens are derived from, they
+	// are generated when it's empty.
+	base string
+
+	lock   sync.Mutex
+	tokens map[string]string
+}
+
+func newClientTokens(base string) *clientTokens {
+	return &clientTokens{base: base, tokens: make(map[string]string)}
+}
+
+func (t *clientTokens) token(operation string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	token, ok := t.tokens[operation]
+	if !ok {
+		token = uuid.TimeOrderedUUID()
+		if t.base != "" {
+			// Client tokens are at most 64 characters.
+			token = fmt.Sprintf("%x", sha256.Sum256([]byte(t.base+"/"+operation)))
+		}
+		t.tokens[operation] = token
+		log.Printf("[DEBUG] Client token of %s: %s", operation, token)
+	}
+
+	return token
+}
+
+// clientToken returns the client token of the operation, such as
+// `CreateImage` or `CreateSnapshot:d-xxx`, generating it the first time.
+// Every attempt of the operation during the build sends the same token, so
+// one which went through although its response was lost doesn't create the
+// resource twice.
+func clientToken(state multistep.StateBag, operation string) string {
+	tokens, ok := state.GetOk("clienttokens")
+	if !ok {
+		// Run puts the tokens in the state bag, the ones of steps run on
+		// their own are generated.
+		tokens = newClientTokens("")
+		state.Put("clienttokens", tokens)
+	}
+
+	return tokens.(*clientTokens).token(operation)
+}
+
+// The largest number the integer parameters of the APIs take, they are 32
+// bit.
+const maxAPIInteger = math.MaxInt32
+
+// convertNumber formats a number for a request parameter, zero and negative
+// values leave the parameter out so that ECS applies its default. Numbers
+// too large for the API are left out as well, validateNumber rejects them
+// when the config is prepared.
 func convertNumber(value int) string {
-	if value <= 0 {
+	if value <= 0 || value > maxAPIInteger {
 		return ""
 	}
 
 	return strconv.Itoa(value)
 }
 
+// validateNumber checks that a number set through the option isn't negative
+// and fits in an integer parameter of the APIs.
+func validateNumber(option string, value int) error {
+	if value < 0 {
+		return fmt.Errorf("%s can't be negative, got %d", option, value)
+	}
+	if value > maxAPIInteger {
+		return fmt.Errorf("%s can't be more than %d, got %d", option, maxAPIInteger, value)
+	}
+
+	return nil
+}
+
 func ContainsInArray(arr []string, value string) bool {
 	for _, item := range arr {
 		if item == value {
@@ -50,3 +165,14 @@ func ContainsInArray(arr []string, value string) bool {
 
 	return false
 }
+
+// sortedKeys returns the keys of a map in a stable order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
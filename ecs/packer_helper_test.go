@@ -0,0 +1,68 @@
+package ecs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestConvertNumber(t *testing.T) {
+	for _, c := range []struct {
+		value    int
+		expected string
+	}{
+		{value: 0, expected: ""},
+		{value: -1, expected: ""},
+		{value: 1, expected: "1"},
+		{value: 40, expected: "40"},
+		{value: math.MaxInt32, expected: "2147483647"},
+		{value: math.MaxInt32 + 1, expected: ""},
+	} {
+		if actual := convertNumber(c.value); actual != c.expected {
+			t.Fatalf("convertNumber(%d) = %q, expected %q", c.value, actual, c.expected)
+		}
+	}
+}
+
+func TestValidateNumber(t *testing.T) {
+	for _, c := range []struct {
+		value int
+		valid bool
+	}{
+		{value: 0, valid: true},
+		{value: 1, valid: true},
+		{value: math.MaxInt32, valid: true},
+		{value: -1, valid: false},
+		{value: math.MaxInt32 + 1, valid: false},
+	} {
+		if err := validateNumber("disk_size", c.value); (err == nil) != c.valid {
+			t.Fatalf("bad validation of %d: %v", c.value, err)
+		}
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	state := new(multistep.BasicStateBag)
+
+	token := clientToken(state, "CreateImage:packer-test")
+	if token == "" || clientToken(state, "CreateImage:packer-test") != token {
+		t.Fatalf("the operation should keep its token")
+	}
+	if clientToken(state, "CreateSnapshot:d-test") == token {
+		t.Fatalf("another operation should get another token")
+	}
+
+	state = new(multistep.BasicStateBag)
+	state.Put("clienttokens", newClientTokens("packer-test/0"))
+	token = clientToken(state, "CreateInstance")
+	if len(token) != 64 || token == clientToken(state, "CreateImage:packer-test") {
+		t.Fatalf("the tokens should be derived from client_token for each operation, actual: %s", token)
+	}
+	if newClientTokens("packer-test/0").token("CreateInstance") != token {
+		t.Fatalf("the same client_token should derive the same tokens")
+	}
+	if newClientTokens("packer-test/1").token("CreateInstance") == token {
+		t.Fatalf("another attempt should derive other tokens")
+	}
+}
@@ -0,0 +1,68 @@
+package ecs
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+// The length of the generated instance passwords, ECS accepts 8 to 30
+// characters.
+const generatedPasswordLength = 20
+
+// The character classes of an instance password. ECS requires a password to
+// contain characters of at least three of them, a generated password has all
+// four.
+var passwordCharacterClasses = []string{
+	"ABCDEFGHJKLMNPQRSTUVWXYZ",
+	"abcdefghijkmnpqrstuvwxyz",
+	"23456789",
+	"!@#$%^*-_+=",
+}
+
+// generatePassword returns a random password meeting the complexity rules of
+// ECS. The password is filtered from the logs right away, whatever it is
+// used for.
+func generatePassword() (string, error) {
+	var all string
+	for _, class := range passwordCharacterClasses {
+		all += class
+	}
+
+	password := make([]byte, generatedPasswordLength)
+	for i := range password {
+		// The first characters cover every class, the rest is drawn from all
+		// of them.
+		chars := all
+		if i < len(passwordCharacterClasses) {
+			chars = passwordCharacterClasses[i]
+		}
+		c, err := randomChar(chars)
+		if err != nil {
+			return "", err
+		}
+		password[i] = c
+	}
+
+	// Shuffle, so that the classes aren't in a predictable position.
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	packer.LogSecretFilter.Set(string(password))
+
+	return string(password), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, err
+	}
+
+	return chars[n.Int64()], nil
+}
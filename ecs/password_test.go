@@ -0,0 +1,49 @@
+package ecs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		password, err := generatePassword()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if len(password) != generatedPasswordLength {
+			t.Fatalf("bad length: %s", password)
+		}
+		for _, class := range passwordCharacterClasses {
+			if !strings.ContainsAny(password, class) {
+				t.Fatalf("password %s misses a character of %s", password, class)
+			}
+		}
+		if seen[password] {
+			t.Fatalf("password %s was generated twice", password)
+		}
+		seen[password] = true
+	}
+}
+
+func TestGeneratePassword_filteredFromLogs(t *testing.T) {
+	var out bytes.Buffer
+	packer.LogSecretFilter.SetOutput(&out)
+	defer packer.LogSecretFilter.SetOutput(ioutil.Discard)
+
+	password, err := generatePassword()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := packer.LogSecretFilter.Write([]byte("password " + password)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(out.String(), password) {
+		t.Fatalf("the password should be filtered from the logs: %s", out.String())
+	}
+}
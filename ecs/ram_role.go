@@ -0,0 +1,79 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The service principal ECS uses to assume an instance RAM role.
+const ramRoleEcsPrincipal = "ecs.aliyuncs.com"
+
+// parseRamRoleName returns the role name of ram_role_name, which is either a
+// plain role name or an ARN such as acs:ram::123456789012:role/packer.
+func parseRamRoleName(value string) (string, error) {
+	if !strings.HasPrefix(value, "acs:") {
+		return value, nil
+	}
+
+	parts := strings.SplitN(value, ":", 5)
+	if len(parts) != 5 || parts[1] != "ram" || parts[3] == "" || !strings.HasPrefix(parts[4], "role/") {
+		return "", fmt.Errorf("%q is not a RAM role ARN such as acs:ram::<account-id>:role/<role-name>", value)
+	}
+
+	name := strings.TrimPrefix(parts[4], "role/")
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("%q is not a RAM role ARN such as acs:ram::<account-id>:role/<role-name>", value)
+	}
+
+	return name, nil
+}
+
+// stringOrSlice decodes a policy element which is either a single string or
+// a list of strings.
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*s = []string{value}
+		return nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*s = values
+	return nil
+}
+
+type ramRoleTrustPolicy struct {
+	Statement []struct {
+		Effect    string
+		Action    stringOrSlice
+		Principal struct {
+			Service stringOrSlice
+		}
+	}
+}
+
+// ramRoleAssumableByEcs reports whether the trust policy of a role allows the
+// ECS service to assume it.
+func ramRoleAssumableByEcs(document string) (bool, error) {
+	var policy ramRoleTrustPolicy
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return false, fmt.Errorf("Error parsing the trust policy: %s", err)
+	}
+
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" || !ContainsInArray(statement.Action, "sts:AssumeRole") {
+			continue
+		}
+		if ContainsInArray(statement.Principal.Service, ramRoleEcsPrincipal) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
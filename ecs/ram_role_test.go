@@ -0,0 +1,54 @@
+package ecs
+
+import (
+	"testing"
+)
+
+func TestParseRamRoleName(t *testing.T) {
+	for value, expected := range map[string]string{
+		"packer":                            "packer",
+		"acs:ram::123456789012:role/packer": "packer",
+	} {
+		name, err := parseRamRoleName(value)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if name != expected {
+			t.Fatalf("bad role name for %s, expected: %s, actual: %s", value, expected, name)
+		}
+	}
+
+	for _, value := range []string{
+		"acs:ram::123456789012:user/packer",
+		"acs:ecs::123456789012:role/packer",
+		"acs:ram:::role/packer",
+		"acs:ram::123456789012:role/",
+	} {
+		if _, err := parseRamRoleName(value); err == nil {
+			t.Fatalf("%s should have err", value)
+		}
+	}
+}
+
+func TestRamRoleAssumableByEcs(t *testing.T) {
+	cases := map[string]bool{
+		`{"Statement":[{"Action":"sts:AssumeRole","Effect":"Allow","Principal":{"Service":["ecs.aliyuncs.com"]}}],"Version":"1"}`:       true,
+		`{"Statement":[{"Action":["sts:AssumeRole"],"Effect":"Allow","Principal":{"Service":"ecs.aliyuncs.com"}}],"Version":"1"}`:       true,
+		`{"Statement":[{"Action":"sts:AssumeRole","Effect":"Deny","Principal":{"Service":["ecs.aliyuncs.com"]}}],"Version":"1"}`:        false,
+		`{"Statement":[{"Action":"sts:AssumeRole","Effect":"Allow","Principal":{"Service":["oss.aliyuncs.com"]}}],"Version":"1"}`:       false,
+		`{"Statement":[{"Action":"sts:AssumeRole","Effect":"Allow","Principal":{"RAM":["acs:ram::123456789012:root"]}}],"Version":"1"}`: false,
+	}
+	for document, expected := range cases {
+		assumable, err := ramRoleAssumableByEcs(document)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if assumable != expected {
+			t.Fatalf("bad result for %s, expected: %t, actual: %t", document, expected, assumable)
+		}
+	}
+
+	if _, err := ramRoleAssumableByEcs("not json"); err == nil {
+		t.Fatalf("should have err")
+	}
+}
@@ -0,0 +1,225 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// errKeptForResume is recorded in the cleanup summary for the images kept by
+// resume_state_file, so that they are reported as left behind.
+var errKeptForResume = errors.New("kept by resume_state_file")
+
+// resumeState is the content of resume_state_file, the resources a build
+// created so far.
+type resumeState struct {
+	ImageName string `json:"image_name"`
+	// image_name without the suffix of image_name_auto_append, a run with
+	// another suffix resumes under ImageName.
+	BaseName   string `json:"base_name,omitempty"`
+	InstanceId string `json:"instance_id,omitempty"`
+	// The images by region, the one in the build region and its copies.
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// keepForResume reports whether the build failed and keeps image imageId for
+// the next run to resume from. Only the images resume_state_file records are
+// kept, the next run wouldn't know about the others. Cancelled builds are
+// cleaned up.
+func keepForResume(state multistep.StateBag, imageId string) bool {
+	config := state.Get("config").(*Config)
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if config.ResumeStateFile == "" || !halted || cancelled {
+		return false
+	}
+
+	recorded, ok := state.GetOk("resume_state")
+	if !ok {
+		return false
+	}
+	for _, recordedId := range recorded.(*resumeState).Images {
+		if recordedId == imageId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// saveResumeState writes the resources in the state bag to
+// resume_state_file, and keeps what the file records in the state bag. It's
+// best-effort, a failure is only logged. Callers hold the lock of the images
+// map while it's written to concurrently.
+func saveResumeState(state multistep.StateBag) {
+	config := state.Get("config").(*Config)
+	if config.ResumeStateFile == "" {
+		return
+	}
+
+	resume := resumeState{ImageName: config.ApsaraStackImageName, BaseName: config.imageBaseName}
+	if instance, ok := state.GetOk("instance"); ok {
+		resume.InstanceId = instance.(*ecs.Instance).InstanceId
+	}
+	if images, ok := state.GetOk("ApsaraStackimages"); ok {
+		resume.Images = copyImageIds(images.(map[string]string))
+	}
+
+	if err := writeResumeState(config.ResumeStateFile, &resume); err != nil {
+		log.Printf("[WARN] Unable to write resume_state_file %s: %s", config.ResumeStateFile, err)
+		return
+	}
+	state.Put("resume_state", &resume)
+}
+
+// copyImageIds returns a copy of images, the steps keep adding to the map
+// of the state bag.
+func copyImageIds(images map[string]string) map[string]string {
+	copied := make(map[string]string, len(images))
+	for region, imageId := range images {
+		copied[region] = imageId
+	}
+
+	return copied
+}
+
+// stepSaveResumeState records the image in resume_state_file once it's
+// tagged, a run resuming from the file goes on with distributing it.
+type stepSaveResumeState struct{}
+
+func (s *stepSaveResumeState) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	saveResumeState(state)
+	return multistep.ActionContinue
+}
+
+func (s *stepSaveResumeState) Cleanup(state multistep.StateBag) {}
+
+// writeResumeState replaces the file at once, so that a crash while it's
+// written doesn't leave half of it behind.
+func writeResumeState(path string, resume *resumeState) error {
+	content, err := json.MarshalIndent(resume, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// readResumeState returns the content of the file, nil when it doesn't
+// exist.
+func readResumeState(path string) (*resumeState, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var resume resumeState
+	if err := json.Unmarshal(content, &resume); err != nil {
+		return nil, fmt.Errorf("%s is not a resume state file: %s", path, err)
+	}
+
+	return &resume, nil
+}
+
+// resumeImages returns the images of the previous run recorded in
+// resume_state_file which can be used, by region. It returns nil when the
+// build has to start over: there is no file, the file is of another image,
+// or the image in the build region isn't available. Copies which failed or
+// are gone are left out so that they are copied again.
+func (b *Builder) resumeImages(client *ClientWrapper, ui packer.Ui) map[string]string {
+	path := b.config.ResumeStateFile
+	if path == "" {
+		return nil
+	}
+
+	resume, err := readResumeState(path)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Ignoring resume_state_file: %s", err))
+		return nil
+	}
+	if resume == nil {
+		return nil
+	}
+	// The suffix of image_name_auto_append is new for every run.
+	sameBase := b.config.ApsaraStackImageNameAutoAppend != "" && resume.BaseName == b.config.imageBaseName
+	if resume.ImageName != b.config.ApsaraStackImageName && !sameBase {
+		ui.Say(fmt.Sprintf("Ignoring %s, it was written by a build of image %s", path, resume.ImageName))
+		return nil
+	}
+
+	images := make(map[string]string)
+	for _, region := range sortedKeys(resume.Images) {
+		imageId := resume.Images[region]
+		image, err := b.describeResumedImage(client, region, imageId)
+		if err != nil {
+			log.Printf("[DEBUG] Unable to look image %s in %s up: %s", imageId, region, err)
+			continue
+		}
+		if image == nil {
+			continue
+		}
+
+		// A copy may still be in progress, the build region image has to
+		// be finished.
+		if image.Status == ImageStatusAvailable || (region != b.config.ApsaraStackRegion && image.Status == ImageStatusCreating) {
+			images[region] = imageId
+		}
+	}
+
+	if images[b.config.ApsaraStackRegion] == "" {
+		ui.Say(fmt.Sprintf("The image recorded in %s isn't available, building it again", path))
+		return nil
+	}
+
+	ui.Say(fmt.Sprintf("Resuming from %s with image %s", path, images[b.config.ApsaraStackRegion]))
+	b.config.ApsaraStackImageName = resume.ImageName
+	return images
+}
+
+func (b *Builder) describeResumedImage(client *ClientWrapper, regionId string, imageId string) (*ecs.Image, error) {
+	request := ecs.CreateDescribeImagesRequest()
+	request.Headers = map[string]string{"RegionId": b.config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": b.config.ApsaraStackSecretKey, "Product": "ecs", "Department": b.config.Department, "ResourceGroup": b.config.ResourceGroup}
+
+	request.ImageOwnerAlias = "self"
+	request.RegionId = regionId
+	request.ImageId = imageId
+	request.Status = ImageStatusQueried
+	response, err := client.DescribeImages(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range response.Images.Image {
+		if image.ImageId == imageId {
+			return &image, nil
+		}
+	}
+
+	return nil, nil
+}
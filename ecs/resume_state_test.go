@@ -0,0 +1,194 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestSaveResumeState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resume.json")
+	if resume, err := readResumeState(path); resume != nil || err != nil {
+		t.Fatalf("a missing file should be no state: %v, %v", resume, err)
+	}
+
+	config := &Config{}
+	config.ApsaraStackImageName = "packer-test"
+	config.ResumeStateFile = path
+	state := testState(nil, config)
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+	state.Put("ApsaraStackimages", map[string]string{"cn-test": "m-test", "cn-copy": "m-copy"})
+	saveResumeState(state)
+
+	resume, err := readResumeState(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := &resumeState{
+		ImageName:  "packer-test",
+		InstanceId: "i-test",
+		Images:     map[string]string{"cn-test": "m-test", "cn-copy": "m-copy"},
+	}
+	if !reflect.DeepEqual(resume, expected) {
+		t.Fatalf("bad state: %#v", resume)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("m-test"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := readResumeState(path); err == nil {
+		t.Fatalf("a file which isn't JSON should error")
+	}
+}
+
+func TestBuilderResumeImages(t *testing.T) {
+	statuses := map[string]string{"m-test": ImageStatusAvailable, "m-copy": ImageStatusCreating, "m-failed": ImageStatusCreateFailed}
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeImages" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		status, ok := statuses[params.Get("ImageId")]
+		if !ok {
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"` + params.Get("ImageId") + `","Status":"` + status + `"}]}}`
+	})
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: ioutil.Discard, ErrorWriter: ioutil.Discard}
+
+	var b Builder
+	b.config.ApsaraStackRegion = "cn-test"
+	b.config.ApsaraStackImageName = "packer-test"
+	b.config.ResumeStateFile = filepath.Join(t.TempDir(), "resume.json")
+	if images := b.resumeImages(client, ui); images != nil {
+		t.Fatalf("there is nothing to resume without the file: %v", images)
+	}
+
+	write := func(resume *resumeState) {
+		if err := writeResumeState(b.config.ResumeStateFile, resume); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	write(&resumeState{
+		ImageName: "packer-test",
+		Images:    map[string]string{"cn-test": "m-test", "cn-copy": "m-copy", "cn-failed": "m-failed", "cn-gone": "m-gone"},
+	})
+	images := b.resumeImages(client, ui)
+	if expected := map[string]string{"cn-test": "m-test", "cn-copy": "m-copy"}; !reflect.DeepEqual(images, expected) {
+		t.Fatalf("only the usable images should be resumed: %v", images)
+	}
+
+	write(&resumeState{ImageName: "packer-other", Images: map[string]string{"cn-test": "m-test"}})
+	if images := b.resumeImages(client, ui); images != nil {
+		t.Fatalf("the file of another image should be ignored: %v", images)
+	}
+
+	write(&resumeState{ImageName: "packer-test", Images: map[string]string{"cn-test": "m-copy"}})
+	if images := b.resumeImages(client, ui); images != nil {
+		t.Fatalf("an unfinished image in the build region should be built again: %v", images)
+	}
+
+	b.config.ApsaraStackImageNameAutoAppend = ImageNameAutoAppendTimestamp
+	b.config.imageBaseName = "packer-test"
+	b.config.ApsaraStackImageName = "packer-test-20201001120000"
+	write(&resumeState{ImageName: "packer-test-20201001110000", BaseName: "packer-other", Images: map[string]string{"cn-test": "m-test"}})
+	if images := b.resumeImages(client, ui); images != nil {
+		t.Fatalf("the file of another image should be ignored: %v", images)
+	}
+
+	write(&resumeState{ImageName: "packer-test-20201001110000", BaseName: "packer-test", Images: map[string]string{"cn-test": "m-test"}})
+	if images := b.resumeImages(client, ui); images["cn-test"] != "m-test" {
+		t.Fatalf("the image of a run with another suffix should be resumed: %v", images)
+	}
+	if b.config.ApsaraStackImageName != "packer-test-20201001110000" {
+		t.Fatalf("the resumed run should keep the name of the image: %s", b.config.ApsaraStackImageName)
+	}
+}
+
+func TestStepRegionCopyImage_resumed(t *testing.T) {
+	var lock sync.Mutex
+	var copied []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch action {
+		case "CopyImage":
+			copied = append(copied, params.Get("DestinationRegionId"))
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"m-` + params.Get("DestinationRegionId") + `"}`
+		case "DescribeImages":
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"` + params.Get("ImageId") + `","Status":"Available"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testRegionCopyState(client)
+	state.Put("ApsaraStackimages", map[string]string{"cn-test": "m-source", "cn-test-1": "m-resumed"})
+	config := state.Get("config").(*Config)
+	config.ResumeStateFile = filepath.Join(t.TempDir(), "resume.json")
+
+	step := &stepRegionCopyApsaraStackImage{
+		ApsaraStackImageDestinationRegions: []string{"cn-test-1", "cn-test-2"},
+		RegionId:                           "cn-test",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+
+	if len(copied) != 1 || copied[0] != "cn-test-2" {
+		t.Fatalf("only the missing copy should be made: %v", copied)
+	}
+	resume, err := readResumeState(config.ResumeStateFile)
+	if err != nil || resume == nil {
+		t.Fatalf("the copies should be recorded: %v, %v", resume, err)
+	}
+	if expected := map[string]string{"cn-test": "m-source", "cn-test-1": "m-resumed", "cn-test-2": "m-cn-test-2"}; !reflect.DeepEqual(resume.Images, expected) {
+		t.Fatalf("bad recorded images: %v", resume.Images)
+	}
+}
+
+func TestStepCreateImage_keepForResume(t *testing.T) {
+	var deleted []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DeleteImage":
+			deleted = append(deleted, params.Get("ImageId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	config.ApsaraStackImageName = "packer-test"
+	config.ResumeStateFile = filepath.Join(t.TempDir(), "resume.json")
+	state := testState(client, config)
+	state.Put(multistep.StateHalted, true)
+
+	// An image which failed before it was recorded is unknown to the next
+	// run.
+	step := &stepCreateApsaraStackImage{image: &ecs.Image{ImageId: "m-test"}}
+	step.Cleanup(state)
+	if len(deleted) != 1 || deleted[0] != "m-test" {
+		t.Fatalf("the unrecorded image should be deleted: %v", deleted)
+	}
+
+	deleted = nil
+	state.Put("ApsaraStackimages", map[string]string{"cn-test": "m-test"})
+	if action := (&stepSaveResumeState{}).Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", action)
+	}
+	step.Cleanup(state)
+	if len(deleted) != 0 {
+		t.Fatalf("the recorded image should be kept: %v", deleted)
+	}
+}
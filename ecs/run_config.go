@@ -7,18 +7,87 @@ import (
 	"github.com/hashicorp/packer/helper/communicator"
 	"github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/template/interpolate"
+	"log"
+	"net"
 	"os"
+	"path"
+	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+type ApsaraStackNetworkInterface struct {
+	// The ID of an existing network interface to attach. It's detached again
+	// on cleanup but never deleted.
+	NetworkInterfaceId string `mapstructure:"network_interface_id" required:"false"`
+	// The ID of the VSwitch a new network interface is created on, instead
+	// of attaching an existing one. It's deleted on cleanup.
+	VSwitchId string `mapstructure:"vswitch_id" required:"false"`
+	// The ID of the security group of a new network interface. It defaults
+	// to the security group of the instance.
+	SecurityGroupId string `mapstructure:"security_group_id" required:"false"`
+}
+
+type ApsaraStackSourceImageFilter struct {
+	// The name of the image, `*` matches any characters, such as
+	// `ubuntu_20_04_*`.
+	ImageName string `mapstructure:"image_name" required:"false"`
+	// The owner of the image, `system`, `self`, `others` or `marketplace`.
+	ImageOwnerAlias string `mapstructure:"image_owner_alias" required:"false"`
+	// The operating system type of the image, `linux` or `windows`.
+	OSType string `mapstructure:"os_type" required:"false"`
+	// The architecture of the image, such as `x86_64`.
+	Architecture string `mapstructure:"architecture" required:"false"`
+	// Use the most recently created image when the filter matches several
+	// images, otherwise the build fails. The default value is false.
+	MostRecent bool `mapstructure:"most_recent" required:"false"`
+}
+
+type ApsaraStackUserDataPart struct {
+	// The content of the part.
+	Content string `mapstructure:"content" required:"false"`
+	// Path to a file with the content of the part, instead of `content`.
+	// Files containing `{{` are rendered with the template engine, like
+	// `user_data_file`.
+	File string `mapstructure:"file" required:"false"`
+	// The MIME type cloud-init handles the part as, such as
+	// `text/cloud-config` or `text/x-shellscript`. It's guessed from the
+	// first line of the content when unset.
+	Type string `mapstructure:"type" required:"false"`
+}
+
+// The bandwidth limit of the public IP of an instance, in Mbps.
+const maxInternetBandwidthOut = 100
+
+// The largest TCP port.
+const maxPort = 65535
+
 type RunConfig struct {
+	// Give an instance in a VPC a public IP with the bandwidth of
+	// `internet_max_bandwidth_out` and the billing of `internet_charge_type`,
+	// like classic instances get, instead of allocating an EIP. The bandwidth
+	// defaults to 5 Mbps and has to be between 1 and 100 Mbps.
 	AssociatePublicIpAddress bool `mapstructure:"associate_public_ip_address"`
 	// ID of the zone to which the disk belongs.
 	ZoneId string `mapstructure:"zone_id" required:"false"`
+	// How the zone of the instance is chosen:
+	// -   `explicit` - The zone is `zone_id`, or picked by the API when it's
+	//     not set. This is the default value when `zone_id` or `vswitch_id`
+	//     is set.
+	// -   `first_available` - The first zone where `instance_type` and the
+	//     system disk category are available. This is the default value
+	//     otherwise.
+	// -   `cheapest` - The available zone with the lowest estimated price
+	//     for `instance_type`, the first one wins on equal prices.
+	//
+	// `zone_id` and `vswitch_id` can't be set unless this is `explicit`.
+	ZoneSelection string `mapstructure:"zone_selection" required:"false"`
 	// Whether an ECS instance is I/O optimized or not. If this option is not
 	// provided, the value will be determined by product API according to what
-	// `instance_type` is used.
+	// `instance_type` is used. Only the first generation instance families,
+	// such as `ecs.s2` or `ecs.m1`, can be launched without I/O
+	// optimization, it defaults to true for all others and can't be false.
 	IOOptimized config.Trilean `mapstructure:"io_optimized" required:"false"`
 	// Type of the instance. For values, see [Instance Type
 	// Table](https://www.alibabacloud.com/help/doc-detail/25378.htm?spm=a3c0i.o25499en.a3.9.14a36ac8iYqKRA).
@@ -27,16 +96,64 @@ type RunConfig struct {
 	// Table](https://intl.aliyun.com/help/doc-detail/25620.htm?spm=a3c0i.o25499en.a3.6.Dr1bik)
 	// interface.
 	InstanceType string `mapstructure:"instance_type" required:"true"`
-	Description  string `mapstructure:"description"`
+	// The number of vCPUs of the instance, for instance types whose CPU and
+	// memory can be customized. It has to be set along with `memory`. When
+	// the instance type doesn't offer the size, both are ignored with a
+	// warning and the size of the instance type is used.
+	Cpu int `mapstructure:"cpu" required:"false"`
+	// The memory of the instance in GiB, see `cpu`.
+	Memory int `mapstructure:"memory" required:"false"`
+	// Packer checks that `instance_type` is available in the zone, or in the
+	// region when no zone is set, before creating any resource. Set this to
+	// true to skip the check. The default value is false.
+	SkipInstanceTypeValidation bool `mapstructure:"skip_instance_type_validation" required:"false"`
+	// If this value is true, the build only validates the template and the
+	// instance, by a dry run of `CreateInstance`, and stops afterwards
+	// without creating any resource. The instance is validated in the
+	// existing `security_group_id` and, in a VPC, `vswitch_id`, which are
+	// required. A temporary key pair isn't created for the dry run, and
+	// `source_snapshot_id` can't be used. The default value is false.
+	DryRun      bool   `mapstructure:"dry_run" required:"false"`
+	Description string `mapstructure:"description"`
 	// This is the base image id which you want to
 	// create your customized images.
 	ApsaraStackSourceImage string `mapstructure:"source_image" required:"true"`
+	// Filters to find the source image instead of `source_image`, such as
+	// the latest image of a distribution. The image has to be `Available`.
+	SourceImageFilter ApsaraStackSourceImageFilter `mapstructure:"source_image_filter" required:"false"`
+	// The ID of a system disk snapshot to build from instead of
+	// `source_image`. Packer creates an image from the snapshot first and
+	// launches the instance from it.
+	SourceSnapshotId string `mapstructure:"source_snapshot_id" required:"false"`
+	// Whether to keep the image created from `source_snapshot_id` after a
+	// successful build. It is always deleted when the build fails. The
+	// default value is false.
+	KeepSourceSnapshotImage bool `mapstructure:"keep_source_snapshot_image" required:"false"`
 	// Whether to force shutdown upon device
 	// restart. The default value is `false`.
 	//
 	// If it is set to `false`, the system is shut down normally; if it is set to
 	// `true`, the system is forced to shut down.
 	ForceStopInstance bool `mapstructure:"force_stop_instance" required:"false"`
+	// What happens when the instance is shut down from within the OS, for
+	// example by a provisioner. ApsaraStack ECS has no create parameter for
+	// this, an instance shut down from the OS is always stopped, so Packer
+	// enforces it when it comes to stop the instance:
+	// -   `stop` - The stopped instance is used to create the image as usual.
+	//     This is the default value.
+	// -   `release` - The build fails and the instance is released, no image is
+	//     created from an instance which was shut down unexpectedly.
+	ShutdownBehavior string `mapstructure:"shutdown_behavior" required:"false"`
+	// How a VPC instance is stopped before the image is created:
+	// -   `KeepCharging` - The instance keeps its vCPUs, memory and private
+	//     IP while stopped, and is still charged for them. This is the
+	//     default value.
+	// -   `StopCharging` - The vCPUs and memory of the instance are released
+	//     while stopped, which stops charging for them. The stack may
+	//     release the private IP too, and the instance may fail to start
+	//     again when the zone runs out of resources.
+	// Classic instances ignore this option.
+	StoppedMode string `mapstructure:"stopped_mode" required:"false"`
 	// If this option is set to true, Packer
 	// will not stop the instance for you, and you need to make sure the instance
 	// will be stopped in the final provisioner command. Otherwise, Packer will
@@ -45,18 +162,58 @@ type RunConfig struct {
 	// E.g., Sysprep a windows which may shutdown the instance within its command.
 	// The default value is false.
 	DisableStopInstance bool `mapstructure:"disable_stop_instance" required:"false"`
+	// Commands run through the communicator after the provisioners and
+	// right before the instance is stopped, to clean it up for the image,
+	// for example to clear the machine id or remove the SSH host keys. The
+	// build fails when a command exits with a non-zero status. With
+	// `disable_stop_instance`, the last command may shut the instance down
+	// itself, like Sysprep does.
+	PreImageShutdownCommands []string `mapstructure:"pre_image_shutdown_commands" required:"false"`
 	// ID of the security group to which a newly
 	// created instance belongs. Mutual access is allowed between instances in one
 	// security group. If not specified, the newly created instance will be added
 	// to the default security group. If the default group doesn’t exist, or the
 	// number of instances in it has reached the maximum limit, a new security
-	// group will be created automatically.
+	// group will be created automatically. An existing security group is
+	// checked to belong to the VPC of the build and is never deleted.
 	SecurityGroupId string `mapstructure:"security_group_id" required:"false"`
 	// The security group name. The default value
 	// is blank. [2, 128] English or Chinese characters, must begin with an
 	// uppercase/lowercase letter or Chinese character. Can contain numbers, .,
 	// _ or -. It cannot begin with `http://` or `https://`.
 	SecurityGroupName string `mapstructure:"security_group_name" required:"false"`
+	// Additional security groups the instance joins at creation, such as a
+	// baseline set shared by all builds. The group from `security_group_id`,
+	// or the one Packer creates, is always kept so that provisioners can
+	// still reach the instance.
+	SecurityGroupIds []string `mapstructure:"security_group_ids" required:"false"`
+	// TCP ports the security group Packer creates opens to the build on
+	// top of the port of the communicator, such as the ports of services
+	// the provisioners test. The group only opens the port of the
+	// communicator by default.
+	SecurityGroupIngressPorts []int `mapstructure:"security_group_ingress_ports" required:"false"`
+	// The IPv4 CIDR blocks, such as the one of the build agent, the
+	// security group Packer creates admits to the port of the communicator
+	// and `security_group_ingress_ports`. The group lets in any source,
+	// `0.0.0.0/0`, by default, and Packer warns about it.
+	SecurityGroupSourceCidrs []string `mapstructure:"security_group_source_cidrs" required:"false"`
+	// Whether the primary network interface of the instance checks the
+	// source and destination of its traffic. Set it to `false` to build
+	// router or NAT appliance images which forward traffic. It's left to
+	// the default of ECS if unset, and only works in a VPC.
+	SourceDestCheck config.Trilean `mapstructure:"source_dest_check" required:"false"`
+	// A secondary network interface attached to the instance after it's
+	// created, to build images for instances with several network
+	// interfaces. Either `network_interface_id` or `vswitch_id` must be set.
+	SecondaryNetworkInterface ApsaraStackNetworkInterface `mapstructure:"secondary_network_interface" required:"false"`
+	// The ID of the dedicated host the instance is created on. Packer checks
+	// before the build that the host supports `instance_type` and is in
+	// `zone_id`, the instance is created in the zone of the host otherwise.
+	DedicatedHostId string `mapstructure:"dedicated_host_id" required:"false"`
+	// The ID of the deployment set the instance is created in. The
+	// deployment set must be in the zone of the instance and have room for
+	// another instance.
+	DeploymentSetId string `mapstructure:"deployment_set_id" required:"false"`
 	// User data to apply when launching the instance. Note
 	// that you need to be careful about escaping characters due to the templates
 	// being JSON. It is often more convenient to use user_data_file, instead.
@@ -64,9 +221,51 @@ type RunConfig struct {
 	// shutting down the instance this must be handled in a provisioner.
 	UserData string `mapstructure:"user_data" required:"false"`
 	// Path to a file that will be used for the user
-	// data when launching the instance.
+	// data when launching the instance. Files containing `{{` are rendered
+	// with the template engine, like `user_data`.
 	UserDataFile string `mapstructure:"user_data_file" required:"false"`
-	// VPC ID allocated by the system.
+	// Several user data documents which are assembled into a MIME
+	// multi-part archive, so that cloud-init gets for instance both a
+	// cloud-config and a shell script. It can't be combined with `user_data`
+	// or `user_data_file`. Each part has a `content` or a `file`, and a
+	// `type`.
+	UserDataParts []ApsaraStackUserDataPart `mapstructure:"user_data_parts" required:"false"`
+	// Whether to gzip the user data before it is base64 encoded, which
+	// cloud-init decompresses on its own. This helps scripts fit the limit
+	// of 16KB of base64 encoded user data. The default value is false.
+	UserDataCompress bool `mapstructure:"user_data_compress" required:"false"`
+	// Shell commands to run once at the first boot of the instance, before
+	// Packer tries to connect to it. They are assembled into a cloud-init
+	// `runcmd` section, which is merged with `user_data` or `user_data_file`
+	// when those are set. This is handy for small tweaks, such as opening a
+	// firewall port, which are needed before the communicator can connect.
+	BootstrapCommands []string `mapstructure:"bootstrap_commands" required:"false"`
+	// Whether to enable WinRM over HTTPS through the user data of a Windows
+	// instance. A PowerShell script creates a self-signed certificate, sets
+	// up an HTTPS listener on `winrm_port` and opens the port in the
+	// Windows firewall, Packer then connects with `winrm_use_ssl` and
+	// `winrm_insecure`. Requires the `winrm` communicator, `user_data` holding
+	// a `[powershell]` script runs after the bootstrap script. The default
+	// value is false.
+	WinRMHttpsBootstrap bool `mapstructure:"winrm_https_bootstrap" required:"false"`
+	// The DNS name of the self-signed certificate of the WinRM HTTPS
+	// listener. The default value is `packer`.
+	WinRMHttpsCertDnsName string `mapstructure:"winrm_https_cert_dns_name" required:"false"`
+	// How many days the self-signed certificate of the WinRM HTTPS listener
+	// is valid. The default value is 365.
+	WinRMHttpsCertValidityDays int `mapstructure:"winrm_https_cert_validity_days" required:"false"`
+	// Name of the RAM role attached to the instance, so that provisioners
+	// can call cloud APIs without credentials. A role ARN such as
+	// `acs:ram::123456789012:role/packer` is accepted as well and is resolved
+	// to the role name. The role is detached again before the instance is
+	// deleted.
+	RamRoleName string `mapstructure:"ram_role_name" required:"false"`
+	// If this value is true, Packer checks before launching the instance
+	// that the trust policy of `ram_role_name` allows the ECS service to
+	// assume it. The default value is false.
+	VerifyRamRole bool `mapstructure:"verify_ram_role" required:"false"`
+	// The ID of an existing VPC to build in. Packer checks that it exists
+	// and never deletes it, otherwise a temporary VPC is created.
 	VpcId string `mapstructure:"vpc_id" required:"false"`
 	// The VPC name. The default value is blank. [2, 128]
 	// English or Chinese characters, must begin with an uppercase/lowercase
@@ -77,10 +276,44 @@ type RunConfig struct {
 	// Value options: 192.168.0.0/16 and
 	// 172.16.0.0/16. When not specified, the default value is 172.16.0.0/16.
 	CidrBlock string `mapstructure:"vpc_cidr_block" required:"false"`
-	// The ID of the VSwitch to be used.
+	// Fail the build instead of falling back to the classic network, which
+	// newer ApsaraStack releases no longer support. Without `vpc_id`,
+	// `vswitch_id`, `user_data` or a key pair the instance is created in the
+	// classic network. The default value is false.
+	ForbidClassicNetwork bool `mapstructure:"forbid_classic_network" required:"false"`
+	// The ID of an existing VSwitch to be used, it requires `vpc_id`. Packer
+	// checks that it belongs to `vpc_id` and to `zone_id`, if set, and never
+	// deletes it. The instance is created in the zone of the VSwitch.
 	VSwitchId string `mapstructure:"vswitch_id" required:"false"`
 	// The ID of the VSwitch to be used.
 	VSwitchName string `mapstructure:"vswitch_name" required:"false"`
+	// The private IP address of the instance in a VPC, instead of one
+	// assigned by DHCP. It must be within the CIDR block of the vswitch.
+	PrivateIp string `mapstructure:"private_ip" required:"false"`
+	// The number of IPv6 addresses assigned to the instance in a VPC. The
+	// vswitch must have IPv6 enabled. The first address is available to
+	// later steps as `instance_ipv6` in the state. The default value is 0.
+	Ipv6AddressCount int `mapstructure:"ipv6_address_count" required:"false"`
+	// Whether the metadata service of the instance requires a session token,
+	// `optional` or `required`. With `required` scripts running on the
+	// instance, such as `user_data`, have to fetch a token first to read the
+	// metadata. By default the setting of the stack is used.
+	MetadataTokenMode string `mapstructure:"metadata_token_mode" required:"false"`
+	// How many network hops the metadata token response may travel, from 1
+	// to 64. By default the setting of the stack is used.
+	MetadataHopLimit int `mapstructure:"metadata_http_put_response_hop_limit" required:"false"`
+	// Key/value pair tags applied to the temporary instance when it is created,
+	// for example for cost tracking. They are not applied to the resulting
+	// image, see `tags` for that. Template variables such as
+	// `{{build_name}}` are interpolated, keys with an empty value are
+	// skipped.
+	RunTags map[string]string `mapstructure:"run_tags" required:"false"`
+	// Key/value pair tags applied to the system disk and the data disks of
+	// the temporary instance once it is created. The disks carry the
+	// `run_tags` of the instance as well, a key of both gets the value of
+	// `disk_tags` on the disks. Template variables are interpolated. Failing
+	// to tag the disks doesn't fail the build.
+	DiskTags map[string]string `mapstructure:"disk_tags" required:"false"`
 	// Display name of the instance, which is a string of 2 to 128 Chinese or
 	// English characters. It must begin with an uppercase/lowercase letter or
 	// a Chinese character and can contain numerals, `.`, `_`, or `-`. The
@@ -88,6 +321,15 @@ type RunConfig struct {
 	// parameter is not specified, the default value is InstanceId of the
 	// instance. It cannot begin with `http://` or `https://`.
 	InstanceName string `mapstructure:"instance_name" required:"false"`
+	// Description of the instance, [2, 256] characters. It cannot begin with
+	// `http://` or `https://`.
+	InstanceDescription string `mapstructure:"instance_description" required:"false"`
+	// Host name of the instance. For Windows instances, which use the WinRM
+	// communicator, it is 2 to 15 letters, numbers or `-`. For Linux
+	// instances it is 2 to 64 characters and may contain `.` to separate
+	// segments of letters, numbers or `-`. In both cases it can't begin or end
+	// with `.` or `-`, or contain them consecutively.
+	HostName string `mapstructure:"host_name" required:"false"`
 	// Internet charge type, which can be
 	// `PayByTraffic` or `PayByBandwidth`. Optional values:
 	// -   `PayByBandwidth`
@@ -105,33 +347,305 @@ type RunConfig struct {
 	//     automatically sets it to 0 Mbps.
 	// -   `PayByTraffic`: \[1, 100\]. If this parameter is not specified, an
 	//     error is returned.
-	InternetMaxBandwidthOut int `mapstructure:"internet_max_bandwidth_out" required:"false"`
+	//
+	// Instances in the classic network, or in a VPC with
+	// `associate_public_ip_address`, get 5 Mbps when this option is not set at
+	// all, an explicit `0` is sent as is.
+	InternetMaxBandwidthOut *int `mapstructure:"internet_max_bandwidth_out" required:"false"`
+	// Bandwidth of the EIP allocated for an instance in a VPC, in Mbps. It
+	// defaults to `internet_max_bandwidth_out`.
+	EipBandwidth int `mapstructure:"eip_bandwidth" required:"false"`
+	// Billing method of the EIP allocated for an instance in a VPC, which
+	// can be `PayByBandwidth` or `PayByTraffic`. It defaults to
+	// `internet_charge_type`.
+	EipInternetChargeType string `mapstructure:"eip_internet_charge_type" required:"false"`
+	// Billing method of the instance, which can be `PostPaid` (pay as you
+	// go) or `PrePaid` (subscription). A `PrePaid` instance is switched to
+	// `PostPaid` on cleanup so that it can be released, a minimum charge for
+	// its subscription may still apply. The default value is `PostPaid`.
+	InstanceChargeType string `mapstructure:"instance_charge_type" required:"false"`
+	// The spot strategy of the instance, which can be `NoSpot`,
+	// `SpotWithPriceLimit` (a spot instance with `spot_price_limit` as the
+	// highest price per hour) or `SpotAsPriceGo` (a spot instance bidding
+	// the market price). Spot instances may be reclaimed during the build,
+	// which then fails. By default no spot instance is used.
+	SpotStrategy string `mapstructure:"spot_strategy" required:"false"`
+	// The highest price per hour of the spot instance, only valid when
+	// `spot_strategy` is `SpotWithPriceLimit`.
+	SpotPriceLimit float64 `mapstructure:"spot_price_limit" required:"false"`
+	// Whether a `PrePaid` instance is renewed automatically when it expires.
+	// The default value is false, so that instances kept after a failed
+	// build don't renew silently. Only valid when `instance_charge_type` is
+	// `PrePaid`.
+	AutoRenew bool `mapstructure:"auto_renew" required:"false"`
+	// When the instance is released automatically, in the ISO 8601 format
+	// such as `2020-10-01T12:00:00Z`, in case the build is killed before it
+	// cleans up. It must be at least 30 minutes and at most 3 years ahead.
+	// Only valid when `instance_charge_type` is `PostPaid`.
+	InstanceAutoReleaseTime string `mapstructure:"instance_auto_release_time" required:"false"`
+	// The subscription period of a `PrePaid` instance, in `period_unit`.
+	// The default value is 1.
+	Period int `mapstructure:"period" required:"false"`
+	// The unit of `period`, `Week` (1 to 4 weeks) or `Month` (1 to 60
+	// months). The default value is `Month`.
+	PeriodUnit string `mapstructure:"period_unit" required:"false"`
 	// Timeout of creating snapshot(s).
 	// The default timeout is 3600 seconds if this option is not set or is set
 	// to 0. For those disks containing lots of data, it may require a higher
 	// timeout value.
 	WaitSnapshotReadyTimeout int `mapstructure:"wait_snapshot_ready_timeout" required:"false"`
-	// Communicator settings
+	// How many times the whole build is retried when it fails because of a
+	// transient error, such as a throttled or unavailable API. The created
+	// resources are cleaned up before each retry, which waits 30 seconds
+	// and doubles on every later retry. Errors like invalid credentials or an
+	// exceeded quota are never retried, neither are builds run with an
+	// `-on-error` other than `cleanup`. The default value is 0.
+	BuildRetries int `mapstructure:"build_retries" required:"false"`
+	// A token the client tokens of the calls creating resources are derived
+	// from, instead of random ones for each build. Running a build again
+	// with the same token picks up the resources the earlier run created
+	// instead of creating them again, which helps when the Packer process
+	// of the earlier run was killed. Use a new token for every other build,
+	// ECS returns the resources of the earlier run even when they were
+	// deleted since. Each retry of `build_retries` derives other tokens.
+	ClientToken string `mapstructure:"client_token" required:"false"`
+	// Additional error codes which retry the creation of the instance, such
+	// as `OperationDenied.NoStock`. They are added to the built-in list,
+	// which always retries `IdempotentProcessing`.
+	CreateInstanceRetryCodes []string `mapstructure:"create_instance_retry_codes" required:"false"`
+	// Additional error codes which retry the creation of the image. They are
+	// added to the built-in list, which always retries `IdempotentProcessing`
+	// and `IncorrectImageStatus`, as concurrent builds in a region run into
+	// those. Throttled requests are always retried.
+	CreateImageRetryCodes []string `mapstructure:"create_image_retry_codes" required:"false"`
+	// How long to wait for a created instance to be ready, such as `20m`.
+	// By default Packer waits for about 30 minutes.
+	InstanceCreateTimeout time.Duration `mapstructure:"instance_create_timeout" required:"false"`
+	// How often the status of the instance is polled while waiting for it to
+	// start or stop, such as `2s`. Shorter intervals speed up builds with
+	// fast instance types but make throttling more likely. The default value
+	// is `5s`.
+	StatusPollInterval time.Duration `mapstructure:"status_poll_interval" required:"false"`
+	// Wait for cloud-init to finish configuring the instance once the
+	// communicator is connected, before the provisioners run. On Windows
+	// the `cloudbase-init` service is waited for instead. Images without
+	// either aren't waited for. The default value is false.
+	WaitForCloudInit bool `mapstructure:"wait_for_cloud_init" required:"false"`
+	// How long to wait for cloud-init, such as `20m`. The default value is
+	// `10m`.
+	CloudInitTimeout time.Duration `mapstructure:"cloud_init_timeout" required:"false"`
+	// The ID of an existing server load balancer the instance is registered
+	// with as a backend once the provisioners have run. The build waits for
+	// the health check of the load balancer to report the instance healthy
+	// before the image is created, and removes the instance from the load
+	// balancer afterwards.
+	LoadBalancerId string `mapstructure:"load_balancer_id" required:"false"`
+	// How long to wait for the instance to pass the health check of
+	// `load_balancer_id`, such as `10m`. The default value is `5m`.
+	LoadBalancerHealthCheckTimeout time.Duration `mapstructure:"load_balancer_health_check_timeout" required:"false"`
+	// How many times deleting the instance is tried during cleanup. The
+	// default value is 36.
+	CleanupRetryTimes int `mapstructure:"cleanup_retry_times" required:"false"`
+	// How long to wait between the tries to delete the instance during
+	// cleanup, such as `10s`. The default value is `5s`.
+	CleanupRetryInterval time.Duration `mapstructure:"cleanup_retry_interval" required:"false"`
+	// Additional error codes which retry deleting the instance during
+	// cleanup. They are added to the built-in list, which always retries
+	// `IncorrectInstanceStatus.Initializing`.
+	CleanupRetryCodes []string `mapstructure:"cleanup_retry_codes" required:"false"`
+	// Whether to force stop the instance before deleting it during cleanup,
+	// so that a guest OS which hangs while shutting down doesn't hold up the
+	// teardown of a failed build. Unlike `force_stop_instance` this only
+	// applies to cleanup. The default value is true, set it to false to keep
+	// the graceful path.
+	CleanupForceStop config.Trilean `mapstructure:"cleanup_force_stop" required:"false"`
+	// If this value is true, the data disks of the instance which aren't
+	// deleted with it, see `disk_delete_with_instance`, are deleted after
+	// the instance when the build fails. The default value is false.
+	CleanupOrphanedDisks bool `mapstructure:"cleanup_orphaned_disks" required:"false"`
+	// If this value is true, a snapshot of the system disk of the instance is
+	// created before the instance is deleted when the build fails, so that
+	// the disk can still be inspected afterwards. Its id is printed and it
+	// has to be deleted by hand, unless
+	// `snapshot_on_cleanup_retention_days` is set. Failing to create the
+	// snapshot doesn't keep the instance from being deleted. The default
+	// value is false.
+	SnapshotOnCleanup bool `mapstructure:"snapshot_on_cleanup" required:"false"`
+	// How many days the snapshot of `snapshot_on_cleanup` is retained
+	// before it's deleted automatically, from 1 to 65536. By default the
+	// snapshot is retained until it's deleted.
+	SnapshotOnCleanupRetentionDays int `mapstructure:"snapshot_on_cleanup_retention_days" required:"false"`
+	// Whether to keep the instance running when the build fails, for
+	// debugging a failed provisioner. The resources it relies on, such as
+	// its EIP, security group and temporary key pair, are kept as well, and
+	// Packer prints how to connect to it and where the credentials come
+	// from, without showing passwords. With `-debug` a temporary private key
+	// is saved to `ecs_<instance id>.pem` in the current directory, its path
+	// is printed. Cancelled builds are still cleaned up and failed builds
+	// aren't retried. The kept resources have to be deleted by hand. The
+	// default value is false.
+	KeepInstanceOnError bool `mapstructure:"keep_instance_on_error" required:"false"`
+	// Whether the snapshots of the instance which were started by a failed
+	// image creation are deleted when the build fails, they aren't removed
+	// along with the image otherwise. The default value is true.
+	CleanupSnapshotsOnFailure config.Trilean `mapstructure:"cleanup_snapshots_on_failure" required:"false"`
+	// Whether to generate a random password for the instance and the
+	// communicator when the communicator has no credentials, such as WinRM
+	// without `winrm_password`. SSH without credentials uses a temporary key
+	// pair instead. The default value is true.
+	SSHPasswordAutoGenerate config.Trilean `mapstructure:"ssh_password_auto_generate" required:"false"`
+	// Communicator settings. Without `communicator` it is picked from the OS
+	// type of the source image, `winrm` for Windows and `ssh` otherwise. A
+	// `source_image` is looked up when the template is validated, with
+	// `source_image_filter`, `source_snapshot_id` or
+	// `skip_region_validation` the communicator is picked at build time.
 	Comm communicator.Config `mapstructure:",squash"`
 	// If this value is true, packer will connect to
 	// the ECS created through private ip instead of allocating a public ip or an
-	// EIP. The default value is false.
+	// EIP. The private ip is preferred even if the instance has a public
+	// address, together with `ssh_bastion_host` this allows builds which stay
+	// inside the VPC. The default value is false.
 	SSHPrivateIp bool `mapstructure:"ssh_private_ip" required:"false"`
+
+	// Set when no communicator was configured and the source image couldn't
+	// be looked up in Prepare, so that the communicator is picked from the
+	// OS type of the source image once it is resolved.
+	detectCommunicator bool
+	// Set when the password of the communicator was generated.
+	passwordGenerated bool
 }
 
-func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
-	if c.Comm.SSHPrivateKeyFile == "" && c.Comm.SSHPassword == "" && c.Comm.WinRMPassword == "" {
+// hasCredentials reports whether any credentials were given for the
+// communicator.
+func (c *RunConfig) hasCredentials() bool {
+	return c.Comm.SSHPrivateKeyFile != "" || c.Comm.SSHPassword != "" || c.Comm.WinRMPassword != "" || c.Comm.SSHKeyPairName != ""
+}
+
+// prepareTemporaryKeyPair names a temporary key pair for the build when SSH
+// has no credentials, which SSH connects with. WinRM has no key pair.
+func (c *RunConfig) prepareTemporaryKeyPair() {
+	if c.Comm.Type == "winrm" {
+		c.Comm.SSHTemporaryKeyPairName = ""
+		c.Comm.SSHProxyHost = ""
+		c.Comm.SSHProxyPort = 0
+		return
+	}
+	if c.hasCredentials() {
+		return
+	}
 
-		c.Comm.SSHTimeout = 10 * time.Minute
+	c.Comm.SSHTimeout = 10 * time.Minute
+	if c.Comm.SSHTemporaryKeyPairName == "" {
 		c.Comm.SSHTemporaryKeyPairName = fmt.Sprintf("packer_%s", uuid.TimeOrderedUUID())
-		c.Comm.SSHProxyHost = "http://100.67.154.166"
-		c.Comm.SSHProxyPort = 56601
+	}
+	c.Comm.SSHProxyHost = "http://100.67.154.166"
+	c.Comm.SSHProxyPort = 56601
+}
+
+// generatePassword sets a random password for the communicator when it has
+// no credentials and ssh_password_auto_generate isn't false.
+func (c *RunConfig) generatePassword() error {
+	if c.SSHPasswordAutoGenerate.False() {
+		return nil
+	}
+
+	var password *string
+	switch c.Comm.Type {
+	case "winrm":
+		if c.Comm.WinRMPassword == "" {
+			password = &c.Comm.WinRMPassword
+		}
+	case "ssh", "":
+		if c.Comm.SSHPassword == "" && c.Comm.SSHPrivateKeyFile == "" && c.Comm.SSHKeyPairName == "" &&
+			c.Comm.SSHTemporaryKeyPairName == "" && !c.Comm.SSHAgentAuth {
+			password = &c.Comm.SSHPassword
+		}
+	}
+	if password == nil {
+		return nil
+	}
+
+	generated, err := generatePassword()
+	if err != nil {
+		return fmt.Errorf("Error generating a password for the instance: %s", err)
+	}
+	*password = generated
+	c.passwordGenerated = true
+	log.Printf("[INFO] No credentials were given for the %s communicator, a random password was generated", c.Comm.Type)
+
+	return nil
+}
+
+func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
+	if c.Comm.Type == "" {
+		c.detectCommunicator = true
+		// Only WinRM credentials were given, so the source image is most
+		// likely Windows. It is confirmed against the image at build time.
+		if c.Comm.SSHUsername == "" && c.Comm.WinRMUser != "" {
+			c.Comm.Type = "winrm"
+		}
+	}
+
+	// A communicator picked at build time gets its temporary key pair or
+	// password once it's known.
+	if !c.detectCommunicator {
+		c.prepareTemporaryKeyPair()
+	}
+
+	if c.WinRMHttpsBootstrap && c.Comm.Type == "winrm" {
+		// The listener has a self-signed certificate, the default port then
+		// is 5986.
+		c.Comm.WinRMUseSSL = true
+		c.Comm.WinRMInsecure = true
 	}
 
 	// Validation
 	errs := c.Comm.Prepare(ctx)
-	if c.ApsaraStackSourceImage == "" {
-		errs = append(errs, errors.New("A source_image must be specified"))
+	if c.WinRMHttpsBootstrap {
+		if c.Comm.Type != "winrm" {
+			errs = append(errs, errors.New("winrm_https_bootstrap requires the winrm communicator"))
+		}
+		if len(c.UserDataParts) > 0 || len(c.BootstrapCommands) > 0 {
+			errs = append(errs, errors.New("winrm_https_bootstrap can't be combined with user_data_parts or bootstrap_commands, which are for cloud-init"))
+		}
+		if c.WinRMHttpsCertDnsName == "" {
+			c.WinRMHttpsCertDnsName = "packer"
+		}
+		if c.WinRMHttpsCertValidityDays < 0 {
+			errs = append(errs, errors.New("winrm_https_cert_validity_days can't be negative"))
+		} else if c.WinRMHttpsCertValidityDays == 0 {
+			c.WinRMHttpsCertValidityDays = 365
+		}
+	}
+	if !c.detectCommunicator {
+		if err := c.generatePassword(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	filter := c.SourceImageFilter
+	filterSet := filter.ImageName != "" || filter.ImageOwnerAlias != "" || filter.OSType != "" || filter.Architecture != ""
+	sources := 0
+	for _, set := range []bool{c.ApsaraStackSourceImage != "", filterSet, c.SourceSnapshotId != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources == 0 {
+		errs = append(errs, errors.New("A source_image, source_image_filter or source_snapshot_id must be specified"))
+	}
+	if sources > 1 {
+		errs = append(errs, errors.New("Only one of source_image, source_image_filter or source_snapshot_id can be specified"))
+	}
+	if c.KeepSourceSnapshotImage && c.SourceSnapshotId == "" {
+		errs = append(errs, errors.New("keep_source_snapshot_image requires source_snapshot_id to be set"))
+	}
+	if filter.MostRecent && !filterSet {
+		errs = append(errs, errors.New("source_image_filter.most_recent requires some source_image_filter to be set"))
+	}
+	if filter.ImageName != "" {
+		if _, err := path.Match(filter.ImageName, ""); err != nil {
+			errs = append(errs, fmt.Errorf("source_image_filter.image_name %q is not a valid pattern: %s", filter.ImageName, err))
+		}
 	}
 
 	if strings.TrimSpace(c.ApsaraStackSourceImage) != c.ApsaraStackSourceImage {
@@ -140,6 +654,26 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 
 	if c.InstanceType == "" {
 		errs = append(errs, errors.New("An ApsaraStack_instance_type must be specified"))
+	} else if instanceTypeRequiresIOOptimized(c.InstanceType) {
+		if c.IOOptimized.False() {
+			errs = append(errs, fmt.Errorf("io_optimized can't be false, instance type %s is always I/O optimized", c.InstanceType))
+		} else {
+			c.IOOptimized = config.TriTrue
+		}
+	}
+
+	if c.InstanceDescription != "" {
+		if len(c.InstanceDescription) < 2 || len(c.InstanceDescription) > 256 {
+			errs = append(errs, fmt.Errorf("instance_description must be 2 to 256 characters"))
+		} else if strings.HasPrefix(c.InstanceDescription, "http://") || strings.HasPrefix(c.InstanceDescription, "https://") {
+			errs = append(errs, fmt.Errorf("instance_description can't start with 'http://' or 'https://'"))
+		}
+	}
+
+	if c.HostName != "" {
+		if err := validateHostName(c.HostName, c.Comm.Type == "winrm"); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	if c.UserData != "" && c.UserDataFile != "" {
@@ -150,5 +684,402 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 		}
 	}
 
+	if len(c.UserDataParts) > 0 && (c.UserData != "" || c.UserDataFile != "") {
+		errs = append(errs, fmt.Errorf("user_data_parts can't be combined with user_data or user_data_file"))
+	}
+	for i, part := range c.UserDataParts {
+		if (part.Content == "") == (part.File == "") {
+			errs = append(errs, fmt.Errorf("user_data_parts[%d] requires either content or file", i))
+		} else if part.File != "" {
+			if _, err := os.Stat(part.File); err != nil {
+				errs = append(errs, fmt.Errorf("user_data_parts[%d].file not found: %s", i, part.File))
+			}
+		}
+		if part.Type != "" && !isUserDataContentType(part.Type) {
+			errs = append(errs, fmt.Errorf("user_data_parts[%d].type %q isn't handled by cloud-init, expected one of %s",
+				i, part.Type, strings.Join(userDataContentTypes, ", ")))
+		}
+	}
+
+	if c.PrivateIp != "" && net.ParseIP(c.PrivateIp).To4() == nil {
+		errs = append(errs, fmt.Errorf("private_ip must be an IPv4 address, got %q", c.PrivateIp))
+	}
+
+	if (c.Cpu == 0) != (c.Memory == 0) {
+		errs = append(errs, fmt.Errorf("cpu and memory have to be set together"))
+	} else if err := validateNumber("cpu", c.Cpu); err != nil {
+		errs = append(errs, err)
+	} else if err := validateNumber("memory", c.Memory); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateNumber("ipv6_address_count", c.Ipv6AddressCount); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.MetadataTokenMode {
+	case "", MetadataTokenModeOptional, MetadataTokenModeRequired:
+	default:
+		errs = append(errs, fmt.Errorf("metadata_token_mode must be %s or %s, got %q",
+			MetadataTokenModeOptional, MetadataTokenModeRequired, c.MetadataTokenMode))
+	}
+	if c.MetadataHopLimit != 0 && (c.MetadataHopLimit < 1 || c.MetadataHopLimit > 64) {
+		errs = append(errs, fmt.Errorf("metadata_http_put_response_hop_limit must be between 1 and 64, got %d", c.MetadataHopLimit))
+	}
+
+	errs = append(errs, validateRunTags(c.RunTags)...)
+	errs = append(errs, validateDiskTags(c.DiskTags, c.RunTags)...)
+
+	if eni := c.SecondaryNetworkInterface; eni != (ApsaraStackNetworkInterface{}) {
+		if (eni.NetworkInterfaceId == "") == (eni.VSwitchId == "") {
+			errs = append(errs, fmt.Errorf("secondary_network_interface requires either network_interface_id or vswitch_id"))
+		}
+		if eni.SecurityGroupId != "" && eni.VSwitchId == "" {
+			errs = append(errs, fmt.Errorf("secondary_network_interface.security_group_id requires secondary_network_interface.vswitch_id"))
+		}
+		if c.VpcId == "" {
+			errs = append(errs, fmt.Errorf("secondary_network_interface requires vpc_id, the network interface has to be in the VPC of the instance"))
+		}
+	}
+
+	if c.VSwitchId != "" && c.VpcId == "" {
+		errs = append(errs, fmt.Errorf("vswitch_id requires vpc_id, the vswitch must belong to an existing VPC"))
+	}
+
+	for _, securityGroupId := range c.SecurityGroupIds {
+		if securityGroupId == "" {
+			errs = append(errs, fmt.Errorf("security_group_ids can't contain empty ids"))
+			break
+		}
+	}
+
+	// Only the ssh and winrm communicators have a port.
+	if port := c.Comm.Port(); (c.Comm.Type == "ssh" || c.Comm.Type == "winrm") && (port < 1 || port > maxPort) {
+		errs = append(errs, fmt.Errorf("%s_port must be between 1 and %d, got %d", c.Comm.Type, maxPort, port))
+	}
+	for _, port := range c.SecurityGroupIngressPorts {
+		if port < 1 || port > maxPort {
+			errs = append(errs, fmt.Errorf("security_group_ingress_ports must be between 1 and %d, got %d", maxPort, port))
+			break
+		}
+	}
+	if len(c.SecurityGroupIngressPorts) > 0 && c.SecurityGroupId != "" {
+		errs = append(errs, fmt.Errorf("security_group_ingress_ports only applies to the security group Packer creates, it can't be used with security_group_id"))
+	}
+	for _, sourceCidr := range c.SecurityGroupSourceCidrs {
+		if ip, _, err := net.ParseCIDR(sourceCidr); err != nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("security_group_source_cidrs must be IPv4 CIDR blocks such as 10.0.0.0/8, got %q", sourceCidr))
+		}
+	}
+	if len(c.SecurityGroupSourceCidrs) > 0 && c.SecurityGroupId != "" {
+		errs = append(errs, fmt.Errorf("security_group_source_cidrs only applies to the security group Packer creates, it can't be used with security_group_id"))
+	}
+
+	switch c.ShutdownBehavior {
+	case "":
+		c.ShutdownBehavior = ShutdownBehaviorStop
+	case ShutdownBehaviorStop, ShutdownBehaviorRelease:
+	default:
+		errs = append(errs, fmt.Errorf("shutdown_behavior must be %s or %s, got %q",
+			ShutdownBehaviorStop, ShutdownBehaviorRelease, c.ShutdownBehavior))
+	}
+
+	switch c.StoppedMode {
+	case "":
+		c.StoppedMode = StoppedModeKeepCharging
+	case StoppedModeKeepCharging, StoppedModeStopCharging:
+	default:
+		errs = append(errs, fmt.Errorf("stopped_mode must be %s or %s, got %q",
+			StoppedModeKeepCharging, StoppedModeStopCharging, c.StoppedMode))
+	}
+
+	switch c.ZoneSelection {
+	case "":
+		// Leaving the zone to the API may pick one without stock for the
+		// instance type.
+		if c.ZoneId == "" && c.VSwitchId == "" && c.DedicatedHostId == "" {
+			c.ZoneSelection = ZoneSelectionFirstAvailable
+		} else {
+			c.ZoneSelection = ZoneSelectionExplicit
+		}
+	case ZoneSelectionExplicit:
+	case ZoneSelectionFirstAvailable, ZoneSelectionCheapest:
+		if c.ZoneId != "" || c.VSwitchId != "" {
+			errs = append(errs, fmt.Errorf("zone_id and vswitch_id can't be set when zone_selection is %s", c.ZoneSelection))
+		}
+		if c.DedicatedHostId != "" {
+			errs = append(errs, fmt.Errorf("dedicated_host_id can't be set when zone_selection is %s, the instance is created in the zone of the host", c.ZoneSelection))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("zone_selection must be one of %s, %s or %s, got %q",
+			ZoneSelectionExplicit, ZoneSelectionFirstAvailable, ZoneSelectionCheapest, c.ZoneSelection))
+	}
+
+	switch c.InstanceChargeType {
+	case "", InstanceChargeTypePostPaid, InstanceChargeTypePrePaid:
+	default:
+		errs = append(errs, fmt.Errorf("instance_charge_type must be %s or %s, got %q",
+			InstanceChargeTypePostPaid, InstanceChargeTypePrePaid, c.InstanceChargeType))
+	}
+
+	switch c.SpotStrategy {
+	case "", SpotStrategyNoSpot, SpotStrategySpotWithPriceLimit, SpotStrategySpotAsPriceGo:
+	default:
+		errs = append(errs, fmt.Errorf("spot_strategy must be one of %s, %s or %s, got %q",
+			SpotStrategyNoSpot, SpotStrategySpotWithPriceLimit, SpotStrategySpotAsPriceGo, c.SpotStrategy))
+	}
+
+	if c.SpotPriceLimit < 0 {
+		errs = append(errs, fmt.Errorf("spot_price_limit can't be negative"))
+	} else if c.SpotPriceLimit > 0 && c.SpotStrategy != SpotStrategySpotWithPriceLimit {
+		errs = append(errs, fmt.Errorf("spot_price_limit can only be set when spot_strategy is %s", SpotStrategySpotWithPriceLimit))
+	}
+
+	if c.AutoRenew && c.InstanceChargeType != InstanceChargeTypePrePaid {
+		errs = append(errs, fmt.Errorf("auto_renew can only be set when instance_charge_type is %s", InstanceChargeTypePrePaid))
+	}
+	if c.InstanceAutoReleaseTime != "" {
+		if c.InstanceChargeType == InstanceChargeTypePrePaid {
+			errs = append(errs, fmt.Errorf("instance_auto_release_time can only be set when instance_charge_type is %s", InstanceChargeTypePostPaid))
+		}
+		if releaseTime, err := validateAutoReleaseTime(c.InstanceAutoReleaseTime, time.Now()); err != nil {
+			errs = append(errs, err)
+		} else {
+			c.InstanceAutoReleaseTime = releaseTime
+		}
+	}
+
+	if c.InstanceChargeType == InstanceChargeTypePrePaid {
+		maxPeriod := 0
+		switch c.PeriodUnit {
+		case PeriodUnitWeek:
+			maxPeriod = 4
+		case "", PeriodUnitMonth:
+			maxPeriod = 60
+		default:
+			errs = append(errs, fmt.Errorf("period_unit must be %s or %s, got %q", PeriodUnitWeek, PeriodUnitMonth, c.PeriodUnit))
+		}
+		if maxPeriod > 0 && (c.Period < 0 || c.Period > maxPeriod) {
+			errs = append(errs, fmt.Errorf("period must be between 1 and %d, got %d", maxPeriod, c.Period))
+		}
+	} else if c.Period != 0 || c.PeriodUnit != "" {
+		errs = append(errs, fmt.Errorf("period and period_unit can only be set when instance_charge_type is %s", InstanceChargeTypePrePaid))
+	}
+
+	if c.InternetMaxBandwidthOut != nil && *c.InternetMaxBandwidthOut < 0 {
+		errs = append(errs, fmt.Errorf("internet_max_bandwidth_out can't be negative"))
+	} else if c.InternetMaxBandwidthOut != nil && *c.InternetMaxBandwidthOut > maxInternetBandwidthOut {
+		errs = append(errs, fmt.Errorf("internet_max_bandwidth_out can't be more than %d Mbps, got %d", maxInternetBandwidthOut, *c.InternetMaxBandwidthOut))
+	} else if c.InternetMaxBandwidthOut != nil && *c.InternetMaxBandwidthOut == 0 && c.AssociatePublicIpAddress {
+		errs = append(errs, fmt.Errorf("associate_public_ip_address requires internet_max_bandwidth_out to be at least 1 Mbps"))
+	}
+
+	if c.EipBandwidth < 0 {
+		errs = append(errs, fmt.Errorf("eip_bandwidth can't be negative"))
+	}
+
+	switch c.EipInternetChargeType {
+	case "", "PayByBandwidth", "PayByTraffic":
+	default:
+		errs = append(errs, fmt.Errorf("eip_internet_charge_type must be PayByBandwidth or PayByTraffic, got %q", c.EipInternetChargeType))
+	}
+
+	if c.RamRoleName != "" {
+		name, err := parseRamRoleName(c.RamRoleName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ram_role_name: %s", err))
+		} else {
+			c.RamRoleName = name
+		}
+	} else if c.VerifyRamRole {
+		errs = append(errs, fmt.Errorf("verify_ram_role requires ram_role_name to be set"))
+	}
+
+	if c.BuildRetries < 0 {
+		errs = append(errs, fmt.Errorf("build_retries can't be negative"))
+	}
+
+	if c.Comm.SSHKeyPairName != "" {
+		if c.Comm.SSHPassword != "" {
+			errs = append(errs, fmt.Errorf("ssh_password and ssh_keypair_name can't both be set"))
+		}
+		if c.Comm.SSHPrivateKeyFile == "" && !c.Comm.SSHAgentAuth {
+			errs = append(errs, fmt.Errorf("ssh_private_key_file must be provided or ssh_agent_auth enabled when ssh_keypair_name is specified"))
+		}
+	}
+
+	if c.InstanceCreateTimeout < 0 {
+		errs = append(errs, fmt.Errorf("instance_create_timeout can't be negative"))
+	}
+
+	if c.CleanupRetryTimes < 0 {
+		errs = append(errs, fmt.Errorf("cleanup_retry_times can't be negative"))
+	}
+	if c.CleanupRetryInterval < 0 {
+		errs = append(errs, fmt.Errorf("cleanup_retry_interval can't be negative"))
+	}
+	if c.StatusPollInterval < 0 {
+		errs = append(errs, fmt.Errorf("status_poll_interval can't be negative"))
+	}
+	if c.CloudInitTimeout < 0 {
+		errs = append(errs, fmt.Errorf("cloud_init_timeout can't be negative"))
+	} else if c.CloudInitTimeout == 0 {
+		c.CloudInitTimeout = 10 * time.Minute
+	}
+
+	if c.SnapshotOnCleanupRetentionDays != 0 {
+		if !c.SnapshotOnCleanup {
+			errs = append(errs, fmt.Errorf("snapshot_on_cleanup_retention_days requires snapshot_on_cleanup"))
+		} else if c.SnapshotOnCleanupRetentionDays < 1 || c.SnapshotOnCleanupRetentionDays > maxSnapshotRetentionDays {
+			errs = append(errs, fmt.Errorf("snapshot_on_cleanup_retention_days must be from 1 to %d, got %d",
+				maxSnapshotRetentionDays, c.SnapshotOnCleanupRetentionDays))
+		}
+	}
+
+	if c.LoadBalancerHealthCheckTimeout < 0 {
+		errs = append(errs, fmt.Errorf("load_balancer_health_check_timeout can't be negative"))
+	} else if c.LoadBalancerHealthCheckTimeout == 0 {
+		c.LoadBalancerHealthCheckTimeout = 5 * time.Minute
+	}
+
+	for i, command := range c.BootstrapCommands {
+		if strings.TrimSpace(command) == "" {
+			errs = append(errs, fmt.Errorf("bootstrap_commands[%d] can't be empty", i))
+		}
+	}
+
+	return errs
+}
+
+var (
+	windowsHostNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+(-[a-zA-Z0-9]+)*$`)
+	linuxHostNameRegexp   = regexp.MustCompile(`^[a-zA-Z0-9]+([.-][a-zA-Z0-9]+)*$`)
+)
+
+// instanceTypeRequiresIOOptimized reports whether instances of the type have
+// to be I/O optimized, which is all but the first generation families.
+func instanceTypeRequiresIOOptimized(instanceType string) bool {
+	family := instanceType
+	if parts := strings.Split(instanceType, "."); len(parts) >= 2 {
+		family = parts[0] + "." + parts[1]
+	}
+
+	for _, optional := range ioOptimizedOptionalFamilies {
+		if family == optional {
+			return false
+		}
+	}
+	return true
+}
+
+// The window in which the release time of an instance can be set.
+const (
+	minAutoReleaseDelay = 30 * time.Minute
+	maxAutoReleaseDelay = 3 * 365 * 24 * time.Hour
+)
+
+// validateAutoReleaseTime checks that the release time is an ISO 8601 time
+// within the window ECS accepts after now, and returns it in UTC as the API
+// expects.
+func validateAutoReleaseTime(releaseTime string, now time.Time) (string, error) {
+	t, err := time.Parse(time.RFC3339, releaseTime)
+	if err != nil {
+		return "", fmt.Errorf("instance_auto_release_time must be an ISO 8601 time such as 2020-10-01T12:00:00Z, got %q", releaseTime)
+	}
+	if t.Before(now.Add(minAutoReleaseDelay)) {
+		return "", fmt.Errorf("instance_auto_release_time must be at least %s ahead, got %s", minAutoReleaseDelay, releaseTime)
+	}
+	if t.After(now.Add(maxAutoReleaseDelay)) {
+		return "", fmt.Errorf("instance_auto_release_time can't be more than 3 years ahead, got %s", releaseTime)
+	}
+
+	return t.UTC().Format("2006-01-02T15:04:05Z"), nil
+}
+
+// validateHostName checks the host name against the rules ECS has for the
+// platform of the instance.
+func validateHostName(hostName string, windows bool) error {
+	if windows {
+		if len(hostName) < 2 || len(hostName) > 15 || !windowsHostNameRegexp.MatchString(hostName) {
+			return fmt.Errorf("host_name of a Windows instance must be 2 to 15 letters, numbers or '-', "+
+				"and can't begin or end with '-' or contain consecutive '-', got %q", hostName)
+		}
+		return nil
+	}
+
+	if len(hostName) < 2 || len(hostName) > 64 || !linuxHostNameRegexp.MatchString(hostName) {
+		return fmt.Errorf("host_name of a Linux instance must be 2 to 64 letters, numbers, '.' or '-', "+
+			"and can't begin or end with '.' or '-' or contain them consecutively, got %q", hostName)
+	}
+	return nil
+}
+
+// The limits ECS puts on the tags of a resource.
+const (
+	maxInstanceTags      = 20
+	maxInstanceTagLength = 128
+)
+
+// validateTags checks the keys and values of the tags set through the option
+// against the rules ECS has for tags.
+func validateTags(option string, tags map[string]string) []error {
+	var errs []error
+	for _, key := range sortedKeys(tags) {
+		value := tags[key]
+		switch {
+		case key == "":
+			errs = append(errs, fmt.Errorf("%s keys can't be empty", option))
+		case utf8.RuneCountInString(key) > maxInstanceTagLength:
+			errs = append(errs, fmt.Errorf("%s key %q is longer than %d characters", option, key, maxInstanceTagLength))
+		case strings.HasPrefix(key, "aliyun") || strings.HasPrefix(key, "acs:") ||
+			strings.HasPrefix(key, "http://") || strings.HasPrefix(key, "https://"):
+			errs = append(errs, fmt.Errorf("%s key %q can't start with aliyun, acs:, http:// or https://", option, key))
+		}
+		if utf8.RuneCountInString(value) > maxInstanceTagLength {
+			errs = append(errs, fmt.Errorf("%s value of %q is longer than %d characters", option, key, maxInstanceTagLength))
+		}
+	}
+
+	return errs
+}
+
+// validateDiskTags checks disk_tags. The disks get the run_tags of the
+// instance when it is created, so keys shared with run_tags only count once
+// against the limit.
+func validateDiskTags(diskTags map[string]string, runTags map[string]string) []error {
+	if len(diskTags) == 0 {
+		return nil
+	}
+
+	errs := validateTags("disk_tags", diskTags)
+	keys := make(map[string]bool)
+	for _, tags := range []map[string]string{runTags, diskTags} {
+		for key := range tags {
+			keys[key] = true
+		}
+	}
+	if len(keys) > maxInstanceTags {
+		errs = append(errs, fmt.Errorf("disk_tags and run_tags have %d distinct keys, a disk can't have more than %d tags",
+			len(keys), maxInstanceTags))
+	}
+
+	return errs
+}
+
+// validateRunTags checks run_tags against the limits of the tags the instance
+// is created with, tags with an empty value aren't sent.
+func validateRunTags(runTags map[string]string) []error {
+	errs := validateTags("run_tags", runTags)
+
+	sent := 0
+	for _, value := range runTags {
+		if value != "" {
+			sent++
+		}
+	}
+	if sent > maxInstanceTags {
+		errs = append(errs, fmt.Errorf("run_tags have %d tags with a value, the instance can't have more than %d tags", sent, maxInstanceTags))
+	}
+
 	return errs
 }
@@ -1,11 +1,15 @@
 package ecs
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/packer/helper/communicator"
+	"github.com/hashicorp/packer/helper/config"
 )
 
 func testConfig() *RunConfig {
@@ -36,6 +40,84 @@ func TestRunConfigPrepare_InstanceType(t *testing.T) {
 	}
 }
 
+func TestRunConfigPrepare_WinRMHttpsBootstrap(t *testing.T) {
+	c := testConfig()
+	c.WinRMHttpsBootstrap = true
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("winrm_https_bootstrap should require the winrm communicator: %s", err)
+	}
+
+	c = testConfig()
+	c.Comm = communicator.Config{
+		Type:  "winrm",
+		WinRM: communicator.WinRM{WinRMUser: "Administrator", WinRMPassword: "Passw0rd!"},
+	}
+	c.WinRMHttpsBootstrap = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if !c.Comm.WinRMUseSSL || !c.Comm.WinRMInsecure || c.Comm.WinRMPort != 5986 {
+		t.Fatalf("the communicator should connect over HTTPS: %#v", c.Comm.WinRM)
+	}
+	if c.WinRMHttpsCertDnsName != "packer" || c.WinRMHttpsCertValidityDays != 365 {
+		t.Fatalf("bad defaults: %s, %d", c.WinRMHttpsCertDnsName, c.WinRMHttpsCertValidityDays)
+	}
+
+	c.BootstrapCommands = []string{"echo hello"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("bootstrap_commands should be rejected: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_DiskTags(t *testing.T) {
+	c := testConfig()
+	c.RunTags = map[string]string{"team": "infra"}
+	c.DiskTags = map[string]string{"team": "storage", "aliyun-key": "value"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("reserved disk_tags keys should error: %s", err)
+	}
+
+	delete(c.DiskTags, "aliyun-key")
+	for i := 0; i < maxInstanceTags-1; i++ {
+		c.DiskTags[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("keys shared with run_tags should count once: %s", err)
+	}
+
+	c.DiskTags["one-too-many"] = "value"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("too many tags should error: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_IOOptimized(t *testing.T) {
+	for instanceType, optional := range map[string]bool{
+		"ecs.s2.large":  true,
+		"ecs.m1.medium": true,
+		"ecs.n1.tiny":   false,
+		"ecs.g6.large":  false,
+		"ecs.ebmg5.24x": false,
+	} {
+		c := testConfig()
+		c.InstanceType = instanceType
+		if err := c.Prepare(nil); len(err) != 0 {
+			t.Fatalf("err: %s", err)
+		}
+		if optional && c.IOOptimized != config.TriUnset {
+			t.Fatalf("io_optimized should be left to ECS for %s", instanceType)
+		}
+		if !optional && c.IOOptimized != config.TriTrue {
+			t.Fatalf("io_optimized should default to true for %s", instanceType)
+		}
+
+		c.IOOptimized = config.TriFalse
+		if err := c.Prepare(nil); (len(err) == 0) != optional {
+			t.Fatalf("bad errors for io_optimized false with %s: %s", instanceType, err)
+		}
+	}
+}
+
 func TestRunConfigPrepare_SourceECSImage(t *testing.T) {
 	c := testConfig()
 	c.ApsaraStackSourceImage = ""
@@ -107,6 +189,15 @@ func TestRunConfigPrepare_UserDataFile(t *testing.T) {
 
 func TestRunConfigPrepare_TemporaryKeyPairName(t *testing.T) {
 	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Comm.SSHTemporaryKeyPairName != "" || c.Comm.SSHPassword != "" {
+		t.Fatalf("the credentials of a communicator picked at build time should wait for it: %q", c.Comm.SSHTemporaryKeyPairName)
+	}
+
+	c = testConfig()
+	c.Comm.Type = "ssh"
 	c.Comm.SSHTemporaryKeyPairName = ""
 	if err := c.Prepare(nil); len(err) != 0 {
 		t.Fatalf("err: %s", err)
@@ -176,3 +267,654 @@ func TestRunConfigPrepare_DisableStopInstance(t *testing.T) {
 		t.Fatalf("invalid value, expected: %t, actul: %t", false, c.DisableStopInstance)
 	}
 }
+
+func TestRunConfigPrepare_CommunicatorDetection(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if !c.detectCommunicator || c.Comm.Type != "ssh" {
+		t.Fatalf("invalid value, expected detected ssh, actual: %t %s", c.detectCommunicator, c.Comm.Type)
+	}
+
+	c = testConfig()
+	c.Comm.SSHUsername = ""
+	c.Comm.WinRMUser = "Administrator"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if !c.detectCommunicator || c.Comm.Type != "winrm" {
+		t.Fatalf("invalid value, expected detected winrm, actual: %t %s", c.detectCommunicator, c.Comm.Type)
+	}
+
+	c = testConfig()
+	c.Comm.Type = "none"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.detectCommunicator || c.Comm.Type != "none" {
+		t.Fatalf("explicit communicator should win, actual: %t %s", c.detectCommunicator, c.Comm.Type)
+	}
+}
+
+func TestRunConfigPrepare_AutoRenew(t *testing.T) {
+	c := testConfig()
+	c.AutoRenew = true
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.InstanceChargeType = "PrePaid"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.InstanceChargeType = "Monthly"
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_ShutdownBehavior(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.ShutdownBehavior != "stop" {
+		t.Fatalf("invalid value, expected: %s, actual: %s", "stop", c.ShutdownBehavior)
+	}
+
+	c.ShutdownBehavior = "release"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ShutdownBehavior = "terminate"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SnapshotOnCleanupRetentionDays(t *testing.T) {
+	c := testConfig()
+	c.SnapshotOnCleanupRetentionDays = 7
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("retention days without snapshot_on_cleanup should error: %s", err)
+	}
+
+	c.SnapshotOnCleanup = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SnapshotOnCleanupRetentionDays = maxSnapshotRetentionDays + 1
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_StoppedMode(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.StoppedMode != StoppedModeKeepCharging {
+		t.Fatalf("invalid value, expected: %s, actual: %s", StoppedModeKeepCharging, c.StoppedMode)
+	}
+
+	c.StoppedMode = StoppedModeStopCharging
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.StoppedMode = "stopcharging"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_InternetMaxBandwidthOut(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.InternetMaxBandwidthOut != nil {
+		t.Fatalf("internet_max_bandwidth_out should stay unset")
+	}
+
+	bandwidth := -1
+	c.InternetMaxBandwidthOut = &bandwidth
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_RamRoleName(t *testing.T) {
+	c := testConfig()
+	c.RamRoleName = "acs:ram::123456789012:role/packer"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.RamRoleName != "packer" {
+		t.Fatalf("the ARN should be resolved to the role name, actual: %s", c.RamRoleName)
+	}
+
+	c.RamRoleName = "acs:ram::123456789012:user/packer"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.RamRoleName = ""
+	c.VerifyRamRole = true
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_ZoneSelection(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.ZoneSelection != ZoneSelectionFirstAvailable {
+		t.Fatalf("invalid value, expected: %s, actual: %s", ZoneSelectionFirstAvailable, c.ZoneSelection)
+	}
+
+	c = testConfig()
+	c.ZoneId = "cn-beijing-a"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.ZoneSelection != ZoneSelectionExplicit {
+		t.Fatalf("invalid value, expected: %s, actual: %s", ZoneSelectionExplicit, c.ZoneSelection)
+	}
+
+	c.ZoneId = ""
+	c.ZoneSelection = ZoneSelectionCheapest
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ZoneId = "cn-beijing-a"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.ZoneId = ""
+	c.ZoneSelection = "random"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SpotStrategy(t *testing.T) {
+	c := testConfig()
+	c.SpotStrategy = SpotStrategySpotWithPriceLimit
+	c.SpotPriceLimit = 0.5
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SpotStrategy = SpotStrategySpotAsPriceGo
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SpotPriceLimit = 0
+	c.SpotStrategy = "Spot"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SecurityGroupIds(t *testing.T) {
+	c := testConfig()
+	c.SecurityGroupIds = []string{"sg-base", "sg-extra"}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SecurityGroupIds = []string{"sg-base", ""}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_InstanceCreateTimeout(t *testing.T) {
+	c := testConfig()
+	c.InstanceCreateTimeout = 20 * time.Minute
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.InstanceCreateTimeout = -time.Minute
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_PrivateIp(t *testing.T) {
+	c := testConfig()
+	c.PrivateIp = "172.16.0.10"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.PrivateIp = "172.16.0"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_Eip(t *testing.T) {
+	c := testConfig()
+	c.EipBandwidth = 10
+	c.EipInternetChargeType = "PayByTraffic"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.EipBandwidth = -1
+	c.EipInternetChargeType = "PayByHour"
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_HostName(t *testing.T) {
+	c := testConfig()
+	c.HostName = "packer.build-01"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.HostName = "packer..build"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := validateHostName("packer-win", true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, hostName := range []string{"packer.win", "packer-windows-build", "-packer"} {
+		if err := validateHostName(hostName, true); err == nil {
+			t.Fatalf("%s should be rejected for Windows", hostName)
+		}
+	}
+}
+
+func TestRunConfigPrepare_InstanceDescription(t *testing.T) {
+	c := testConfig()
+	c.InstanceDescription = "packer build instance"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.InstanceDescription = "https://example.com"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_KeyPairName(t *testing.T) {
+	c := testConfig()
+	c.Comm.SSHKeyPairName = "packer"
+	c.Comm.SSHPrivateKeyFile = ""
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("should require a private key: %s", err)
+	}
+	if c.Comm.SSHTemporaryKeyPairName != "" {
+		t.Fatalf("no temporary key pair should be used with ssh_keypair_name: %s", c.Comm.SSHTemporaryKeyPairName)
+	}
+
+	c.Comm.SSHAgentAuth = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.Comm.SSHPassword = "Password123"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("ssh_password and ssh_keypair_name shouldn't be accepted together: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SSHPasswordAutoGenerate(t *testing.T) {
+	c := testConfig()
+	c.Comm.Type = "winrm"
+	c.Comm.WinRMUser = "Administrator"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Comm.WinRMPassword == "" {
+		t.Fatalf("a password should be generated for WinRM")
+	}
+
+	c = testConfig()
+	c.Comm.Type = "winrm"
+	c.Comm.WinRMUser = "Administrator"
+	c.SSHPasswordAutoGenerate = config.TriFalse
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Comm.WinRMPassword != "" {
+		t.Fatalf("no password should be generated when disabled")
+	}
+
+	c = testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Comm.SSHPassword != "" {
+		t.Fatalf("SSH should use a temporary key pair instead of a password")
+	}
+}
+
+func TestRunConfigPrepare_Metadata(t *testing.T) {
+	c := testConfig()
+	c.MetadataTokenMode = MetadataTokenModeRequired
+	c.MetadataHopLimit = 64
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.MetadataTokenMode = "enforced"
+	c.MetadataHopLimit = 65
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_RunTags(t *testing.T) {
+	c := testConfig()
+	c.RunTags = map[string]string{"build": "nightly", "empty": ""}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.RunTags = map[string]string{"acs:build": "nightly", "version": strings.Repeat("x", 129)}
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Tags with an empty value aren't sent, they don't count.
+	c.RunTags = map[string]string{"empty": ""}
+	for i := 0; i < maxInstanceTags; i++ {
+		c.RunTags[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	c.RunTags["key-extra"] = "value"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("too many tags should be rejected: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_AssociatePublicIpAddress(t *testing.T) {
+	c := testConfig()
+	bandwidth := 100
+	c.InternetMaxBandwidthOut = &bandwidth
+	c.AssociatePublicIpAddress = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	bandwidth = 101
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	bandwidth = 0
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("associate_public_ip_address should require bandwidth: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_CustomSize(t *testing.T) {
+	c := testConfig()
+	c.Cpu = 2
+	c.Memory = 6
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.Memory = 0
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("cpu without memory should be rejected: %s", err)
+	}
+
+	c.Cpu = -2
+	c.Memory = -6
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_StatusPollInterval(t *testing.T) {
+	c := testConfig()
+	c.StatusPollInterval = -time.Second
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_LoadBalancerHealthCheckTimeout(t *testing.T) {
+	c := testConfig()
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if c.LoadBalancerHealthCheckTimeout != 5*time.Minute {
+		t.Fatalf("bad default: %s", c.LoadBalancerHealthCheckTimeout)
+	}
+
+	c.LoadBalancerHealthCheckTimeout = -time.Second
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_VSwitchIdWithoutVpcId(t *testing.T) {
+	c := testConfig()
+	c.VSwitchId = "vsw-test"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.VpcId = "vpc-test"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SourceImageFilter(t *testing.T) {
+	c := testConfig()
+	c.SourceImageFilter = ApsaraStackSourceImageFilter{ImageName: "ubuntu_*", MostRecent: true}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("source_image and source_image_filter should be exclusive: %s", err)
+	}
+
+	c.ApsaraStackSourceImage = ""
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SourceImageFilter = ApsaraStackSourceImageFilter{ImageName: "ubuntu_[", MostRecent: true}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("a bad pattern should error: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SourceSnapshotId(t *testing.T) {
+	c := testConfig()
+	c.SourceSnapshotId = "s-system"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("source_image and source_snapshot_id should be exclusive: %s", err)
+	}
+
+	c.ApsaraStackSourceImage = ""
+	c.KeepSourceSnapshotImage = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SourceSnapshotId = ""
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("keep_source_snapshot_image requires source_snapshot_id: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_UserDataParts(t *testing.T) {
+	c := testConfig()
+	c.UserDataParts = []ApsaraStackUserDataPart{
+		{Content: "#cloud-config\n", Type: "text/cloud-config"},
+		{Content: "echo hello"},
+	}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.UserData = "echo hello"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.UserData = ""
+	c.UserDataParts = []ApsaraStackUserDataPart{
+		{Content: "echo hello", File: "script.sh"},
+		{Content: "echo hello", Type: "text/html"},
+		{File: "/this/file/does/not/exist"},
+	}
+	if err := c.Prepare(nil); len(err) != 3 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SecondaryNetworkInterface(t *testing.T) {
+	c := testConfig()
+	c.VpcId = "vpc-test"
+	c.SecondaryNetworkInterface = ApsaraStackNetworkInterface{NetworkInterfaceId: "eni-test", VSwitchId: "vsw-test"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SecondaryNetworkInterface = ApsaraStackNetworkInterface{NetworkInterfaceId: "eni-test", SecurityGroupId: "sg-test"}
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SecondaryNetworkInterface = ApsaraStackNetworkInterface{VSwitchId: "vsw-test", SecurityGroupId: "sg-test"}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.VpcId = ""
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_Period(t *testing.T) {
+	c := testConfig()
+	c.Period = 2
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.InstanceChargeType = InstanceChargeTypePrePaid
+	c.PeriodUnit = PeriodUnitWeek
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.Period = 5
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.PeriodUnit = "Year"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_InstanceAutoReleaseTime(t *testing.T) {
+	c := testConfig()
+	c.InstanceAutoReleaseTime = time.Now().Add(2 * time.Hour).In(time.FixedZone("UTC+8", 8*3600)).Format(time.RFC3339)
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasSuffix(c.InstanceAutoReleaseTime, "Z") {
+		t.Fatalf("the release time should be converted to UTC: %s", c.InstanceAutoReleaseTime)
+	}
+
+	c.InstanceChargeType = InstanceChargeTypePrePaid
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestValidateAutoReleaseTime(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		releaseTime string
+		valid       bool
+	}{
+		{"2020-10-01T14:00:00Z", true},
+		{"2020-10-01T12:10:00Z", false},
+		{"2024-10-01T12:00:00Z", false},
+		{"2020-10-01 14:00:00", false},
+		{"tomorrow", false},
+	}
+	for _, c := range cases {
+		if _, err := validateAutoReleaseTime(c.releaseTime, now); (err == nil) != c.valid {
+			t.Fatalf("bad validation of %s: %v", c.releaseTime, err)
+		}
+	}
+}
+
+func TestRunConfigPrepare_SecurityGroupIngressPorts(t *testing.T) {
+	c := testConfig()
+	c.Comm.SSHPort = 2222
+	c.SecurityGroupIngressPorts = []int{8080}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.Comm.SSHPort = 70000
+	c.SecurityGroupIngressPorts = []int{0}
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("should have 2 errors: %s", err)
+	}
+
+	c.Comm.SSHPort = -1
+	c.SecurityGroupIngressPorts = nil
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("a negative ssh_port should error: %s", err)
+	}
+
+	c.Comm.Type = "none"
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("the none communicator has no port: %s", err)
+	}
+
+	c.Comm.Type = "ssh"
+	c.Comm.SSHPort = 22
+	c.SecurityGroupIngressPorts = []int{8080}
+	c.SecurityGroupId = "sg-test"
+	if err := c.Prepare(nil); len(err) != 1 {
+		t.Fatalf("security_group_ingress_ports shouldn't work with security_group_id: %s", err)
+	}
+}
+
+func TestRunConfigPrepare_SecurityGroupSourceCidrs(t *testing.T) {
+	c := testConfig()
+	c.SecurityGroupSourceCidrs = []string{"10.0.0.0/8", "192.168.1.10/32"}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.SecurityGroupSourceCidrs = []string{"10.0.0.1", "fd00::/8"}
+	if err := c.Prepare(nil); len(err) != 2 {
+		t.Fatalf("should have 2 errors: %s", err)
+	}
+}
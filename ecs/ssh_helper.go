@@ -0,0 +1,47 @@
+package ecs
+
+import (
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/communicator"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+// SSHHost returns the host the communicator connects to. A host set in the
+// communicator config wins, then the private IP of the instance when
+// privateIp is set, then the EIP of the instance, then the address the
+// network steps put in the state.
+func SSHHost(comm *communicator.Config, privateIp bool) func(multistep.StateBag) (string, error) {
+	return func(state multistep.StateBag) (string, error) {
+		if host := comm.Host(); host != "" {
+			return host, nil
+		}
+		if privateIp {
+			if ipAddress, ok := state.GetOk("private_ip"); ok {
+				return ipAddress.(string), nil
+			}
+		}
+		if eip, ok := state.GetOk("eip"); ok {
+			return eip.(string), nil
+		}
+		if ipAddress, ok := state.GetOk("ipaddress"); ok {
+			return ipAddress.(string), nil
+		}
+
+		return "", fmt.Errorf("Failed to retrieve IP address of the instance")
+	}
+}
+
+// instancePrivateIp returns the first private IP of the instance, from its
+// VPC attributes or, for classic network instances, its inner addresses.
+func instancePrivateIp(instance *ecs.Instance) string {
+	if ipAddress := instance.VpcAttributes.PrivateIpAddress.IpAddress; len(ipAddress) > 0 {
+		return ipAddress[0]
+	}
+	if ipAddress := instance.InnerIpAddress.IpAddress; len(ipAddress) > 0 {
+		return ipAddress[0]
+	}
+
+	return ""
+}
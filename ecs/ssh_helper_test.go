@@ -0,0 +1,66 @@
+package ecs
+
+import (
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/communicator"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestSSHHost(t *testing.T) {
+	comm := &communicator.Config{Type: "ssh"}
+	state := new(multistep.BasicStateBag)
+	host := SSHHost(comm, false)
+
+	if _, err := host(state); err == nil {
+		t.Fatal("should have error without an address")
+	}
+
+	state.Put("ipaddress", "172.16.0.10")
+	if actual, _ := host(state); actual != "172.16.0.10" {
+		t.Fatalf("bad host: %s", actual)
+	}
+
+	state.Put("eip", "47.0.0.1")
+	if actual, _ := host(state); actual != "47.0.0.1" {
+		t.Fatalf("the EIP should be preferred: %s", actual)
+	}
+
+	comm.SSH.SSHHost = "bastion.example.com"
+	if actual, _ := host(state); actual != "bastion.example.com" {
+		t.Fatalf("the configured host should be preferred: %s", actual)
+	}
+}
+
+func TestSSHHost_privateIp(t *testing.T) {
+	comm := &communicator.Config{Type: "ssh"}
+	state := new(multistep.BasicStateBag)
+	state.Put("private_ip", "192.168.0.10")
+	state.Put("eip", "47.0.0.1")
+	state.Put("ipaddress", "47.0.0.1")
+
+	if actual, _ := SSHHost(comm, true)(state); actual != "192.168.0.10" {
+		t.Fatalf("the private IP should be preferred: %s", actual)
+	}
+	if actual, _ := SSHHost(comm, false)(state); actual != "47.0.0.1" {
+		t.Fatalf("bad host: %s", actual)
+	}
+}
+
+func TestInstancePrivateIp(t *testing.T) {
+	instance := &ecs.Instance{}
+	if actual := instancePrivateIp(instance); actual != "" {
+		t.Fatalf("bad private IP: %s", actual)
+	}
+
+	instance.InnerIpAddress.IpAddress = []string{"10.0.0.10"}
+	if actual := instancePrivateIp(instance); actual != "10.0.0.10" {
+		t.Fatalf("bad private IP: %s", actual)
+	}
+
+	instance.VpcAttributes.PrivateIpAddress.IpAddress = []string{"192.168.0.10"}
+	if actual := instancePrivateIp(instance); actual != "192.168.0.10" {
+		t.Fatalf("the VPC address should be preferred: %s", actual)
+	}
+}
@@ -0,0 +1,94 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepApplySnapshotPolicy struct {
+	SnapshotPolicyId string
+	RegionId         string
+	diskId           string
+}
+
+func (s *stepApplySnapshotPolicy) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	describePolicyRequest := ecs.CreateDescribeAutoSnapshotPolicyExRequest()
+	describePolicyRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describePolicyRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describePolicyRequest.RegionId = s.RegionId
+	describePolicyRequest.AutoSnapshotPolicyId = s.SnapshotPolicyId
+	policiesResponse, err := client.DescribeAutoSnapshotPolicyEx(describePolicyRequest)
+	if err != nil {
+		return halt(state, err, "Error querying auto snapshot policy")
+	}
+	if len(policiesResponse.AutoSnapshotPolicies.AutoSnapshotPolicy) == 0 {
+		return halt(state, fmt.Errorf("the auto snapshot policy %s of system_disk_mapping doesn't exist in the region %s",
+			s.SnapshotPolicyId, s.RegionId), "")
+	}
+
+	describeDisksRequest := ecs.CreateDescribeDisksRequest()
+	describeDisksRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeDisksRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describeDisksRequest.RegionId = s.RegionId
+	describeDisksRequest.InstanceId = instance.InstanceId
+	describeDisksRequest.DiskType = DiskTypeSystem
+	disksResponse, err := client.DescribeDisks(describeDisksRequest)
+	if err != nil {
+		return halt(state, err, "Error describe system disk")
+	}
+	disks := disksResponse.Disks.Disk
+	if len(disks) == 0 {
+		return halt(state, fmt.Errorf("the system disk of instance %s isn't found", instance.InstanceId), "")
+	}
+	diskId := disks[0].DiskId
+
+	ui.Say(fmt.Sprintf("Applying auto snapshot policy %s to system disk %s...", s.SnapshotPolicyId, diskId))
+
+	applyRequest := ecs.CreateApplyAutoSnapshotPolicyRequest()
+	applyRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	applyRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	// The SDK request lacks the region of the policy.
+	applyRequest.QueryParams["regionId"] = s.RegionId
+
+	applyRequest.AutoSnapshotPolicyId = s.SnapshotPolicyId
+	applyRequest.DiskIds = fmt.Sprintf("[\"%s\"]", diskId)
+	if _, err := client.ApplyAutoSnapshotPolicy(applyRequest); err != nil {
+		return halt(state, err, fmt.Sprintf("Error applying auto snapshot policy %s", s.SnapshotPolicyId))
+	}
+	s.diskId = diskId
+
+	return multistep.ActionContinue
+}
+
+func (s *stepApplySnapshotPolicy) Cleanup(state multistep.StateBag) {
+	if s.diskId == "" {
+		return
+	}
+
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Cancelling auto snapshot policy %s of system disk %s...", s.SnapshotPolicyId, s.diskId))
+
+	request := ecs.CreateCancelAutoSnapshotPolicyRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	request.QueryParams["regionId"] = s.RegionId
+
+	request.DiskIds = fmt.Sprintf("[\"%s\"]", s.diskId)
+	if _, err := client.CancelAutoSnapshotPolicy(request); err != nil {
+		ui.Error(fmt.Sprintf("Error cancelling auto snapshot policy of system disk %s: %s", s.diskId, err))
+	}
+}
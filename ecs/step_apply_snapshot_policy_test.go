@@ -0,0 +1,69 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepApplySnapshotPolicy(t *testing.T) {
+	var applied, cancelled url.Values
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeAutoSnapshotPolicyEx":
+			return http.StatusOK, `{"RequestId":"test-request","AutoSnapshotPolicies":{"AutoSnapshotPolicy":[{"AutoSnapshotPolicyId":"sp-test"}]}}`
+		case "DescribeDisks":
+			if params.Get("DiskType") != DiskTypeSystem {
+				t.Errorf("bad disk type: %s", params.Get("DiskType"))
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[{"DiskId":"d-system"}]}}`
+		case "ApplyAutoSnapshotPolicy":
+			applied = params
+		case "CancelAutoSnapshotPolicy":
+			cancelled = params
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepApplySnapshotPolicy{SnapshotPolicyId: "sp-test", RegionId: "cn-test"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if applied.Get("autoSnapshotPolicyId") != "sp-test" || applied.Get("diskIds") != `["d-system"]` {
+		t.Fatalf("bad apply request: %v", applied)
+	}
+
+	step.Cleanup(state)
+	if cancelled.Get("diskIds") != `["d-system"]` {
+		t.Fatalf("the policy should be cancelled on cleanup: %v", cancelled)
+	}
+}
+
+func TestStepApplySnapshotPolicy_notFound(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeAutoSnapshotPolicyEx" {
+			t.Errorf("unexpected action %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","AutoSnapshotPolicies":{"AutoSnapshotPolicy":[]}}`
+	})
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepApplySnapshotPolicy{SnapshotPolicyId: "sp-missing", RegionId: "cn-test"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %s", action)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "sp-missing") {
+		t.Fatalf("the error should name the policy: %s", err)
+	}
+	step.Cleanup(state)
+}
@@ -0,0 +1,196 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// The weight the instance is registered with, it takes its share of the
+// traffic like any other backend.
+const loadBalancerBackendWeight = "100"
+
+// loadBalancerBackend is an entry of the BackendServers parameter of the SLB
+// API, which takes the servers as a JSON array.
+type loadBalancerBackend struct {
+	ServerId string `json:"ServerId"`
+	Weight   string `json:"Weight"`
+}
+
+// stepAttachLoadBalancer registers the instance as a backend of an existing
+// load balancer, and waits for its health check to pass, so the image is
+// only created from an instance which serves traffic.
+type stepAttachLoadBalancer struct {
+	LoadBalancerId string
+	Timeout        time.Duration
+	// How often the health status is polled, the default retry interval
+	// when zero.
+	pollInterval time.Duration
+	instanceId   string
+}
+
+func (s *stepAttachLoadBalancer) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	instance := state.Get("instance").(*ecs.Instance)
+	ui := state.Get("ui").(packer.Ui)
+
+	slbClient, err := config.SlbClient(client)
+	if err != nil {
+		return halt(state, err, "")
+	}
+
+	ui.Say(fmt.Sprintf("Adding instance %s to load balancer %s...", instance.InstanceId, s.LoadBalancerId))
+
+	request := slb.CreateAddBackendServersRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "slb", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.LoadBalancerId = s.LoadBalancerId
+	request.BackendServers = loadBalancerBackends(instance.InstanceId)
+	if _, err := slbClient.AddBackendServers(request); err != nil {
+		return halt(state, err, "Error adding instance to load balancer")
+	}
+	s.instanceId = instance.InstanceId
+
+	ui.Say(fmt.Sprintf("Waiting for instance %s to pass the health check of load balancer %s...", instance.InstanceId, s.LoadBalancerId))
+	if err := s.waitForHealthy(ctx, state, slbClient); err != nil {
+		return halt(state, err, "Error waiting for load balancer health check")
+	}
+
+	return multistep.ActionContinue
+}
+
+// loadBalancerBackends encodes the instance as the BackendServers parameter.
+func loadBalancerBackends(instanceId string) string {
+	// A slice of plain strings always marshals.
+	backends, _ := json.Marshal([]loadBalancerBackend{{ServerId: instanceId, Weight: loadBalancerBackendWeight}})
+	return string(backends)
+}
+
+// waitForHealthy waits until every listener of the load balancer reports the
+// instance as healthy, the error of a timeout carries the health status last
+// seen.
+func (s *stepAttachLoadBalancer) waitForHealthy(ctx context.Context, state multistep.StateBag, slbClient *SlbClientWrapper) error {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	lastStatus := ""
+	_, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
+		RequestFunc: func() (responses.AcsResponse, error) {
+			request := slb.CreateDescribeHealthStatusRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "slb", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			request.RegionId = config.ApsaraStackRegion
+			request.LoadBalancerId = s.LoadBalancerId
+			return slbClient.DescribeHealthStatus(request)
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if err != nil {
+				return WaitForExpectToRetry
+			}
+
+			status := backendHealthStatus(response.(*slb.DescribeHealthStatusResponse), s.instanceId)
+			if status != lastStatus {
+				lastStatus = status
+				ui.Message(fmt.Sprintf("Health status of instance %s: %s", s.instanceId, status))
+			}
+			if status == BackendServerHealthNormal {
+				return WaitForExpectSuccess
+			}
+
+			return WaitForExpectToRetry
+		},
+		RetryInterval: s.pollInterval,
+		RetryTimeout:  s.Timeout,
+	})
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if lastStatus == "" {
+		return fmt.Errorf("timeout waiting %s for instance %s to become healthy: %w", s.Timeout, s.instanceId, err)
+	}
+
+	return fmt.Errorf("timeout waiting %s for instance %s to become healthy, last health status: %s", s.Timeout, s.instanceId, lastStatus)
+}
+
+// backendHealthStatus sums up the health status the listeners report for the
+// instance, it's normal only when all of them agree, and empty while none
+// reports the instance yet.
+func backendHealthStatus(response *slb.DescribeHealthStatusResponse, instanceId string) string {
+	var statuses []string
+	healthy := true
+	for _, backend := range response.BackendServers.BackendServer {
+		if backend.ServerId != instanceId {
+			continue
+		}
+
+		if backend.ListenerPort != 0 {
+			statuses = append(statuses, fmt.Sprintf("%s on port %d", backend.ServerHealthStatus, backend.ListenerPort))
+		} else {
+			statuses = append(statuses, backend.ServerHealthStatus)
+		}
+		if backend.ServerHealthStatus != BackendServerHealthNormal {
+			healthy = false
+		}
+	}
+
+	if len(statuses) == 0 {
+		return ""
+	}
+	if healthy {
+		return BackendServerHealthNormal
+	}
+
+	return strings.Join(statuses, ", ")
+}
+
+func (s *stepAttachLoadBalancer) Cleanup(state multistep.StateBag) {
+	if s.instanceId == "" || keepResource(state, "load balancer backend", s.instanceId) {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	ui.Say(fmt.Sprintf("Removing instance %s from load balancer %s...", s.instanceId, s.LoadBalancerId))
+
+	err := s.removeBackend(state)
+	recordCleanup(state, "load balancer backend", s.instanceId, err)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error removing instance %s from load balancer %s: %s", s.instanceId, s.LoadBalancerId, err))
+	}
+}
+
+func (s *stepAttachLoadBalancer) removeBackend(state multistep.StateBag) error {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	slbClient, err := config.SlbClient(client)
+	if err != nil {
+		return err
+	}
+
+	request := slb.CreateRemoveBackendServersRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "slb", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.LoadBalancerId = s.LoadBalancerId
+	request.BackendServers = loadBalancerBackends(s.instanceId)
+	_, err = slbClient.RemoveBackendServers(request)
+	return err
+}
@@ -0,0 +1,103 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func testLoadBalancerHealth(status string) string {
+	return `{"RequestId":"test-request","BackendServers":{"BackendServer":[` +
+		`{"ServerId":"i-other","ServerHealthStatus":"abnormal","ListenerPort":80},` +
+		`{"ServerId":"i-test","ServerHealthStatus":"` + status + `","ListenerPort":80}]}}`
+}
+
+func TestStepAttachLoadBalancer(t *testing.T) {
+	var actions []string
+	checks := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		if params.Get("LoadBalancerId") != "lb-test" {
+			t.Errorf("bad request: %v", params)
+			return http.StatusBadRequest, testErrorBody("InvalidParameter")
+		}
+		switch action {
+		case "AddBackendServers", "RemoveBackendServers":
+			if params.Get("BackendServers") != `[{"ServerId":"i-test","Weight":"100"}]` {
+				t.Errorf("bad backend servers: %s", params.Get("BackendServers"))
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DescribeHealthStatus":
+			checks++
+			if checks < 3 {
+				return http.StatusOK, testLoadBalancerHealth(BackendServerHealthAbnormal)
+			}
+			return http.StatusOK, testLoadBalancerHealth(BackendServerHealthNormal)
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepAttachLoadBalancer{LoadBalancerId: "lb-test", Timeout: time.Minute}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %v", action, state.Get("error"))
+	}
+	if checks != 3 {
+		t.Fatalf("the health status should be polled until the instance is healthy, polled %d times", checks)
+	}
+
+	step.Cleanup(state)
+	if actions[len(actions)-1] != "RemoveBackendServers" {
+		t.Fatalf("the instance should be removed from the load balancer: %v", actions)
+	}
+}
+
+func TestStepAttachLoadBalancer_timeout(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action == "DescribeHealthStatus" {
+			return http.StatusOK, testLoadBalancerHealth(BackendServerHealthAbnormal)
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepAttachLoadBalancer{LoadBalancerId: "lb-test", Timeout: time.Minute}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("an instance which never becomes healthy should halt the build")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "last health status: abnormal on port 80") {
+		t.Fatalf("the error should carry the last health status: %s", err)
+	}
+}
+
+func TestStepAttachLoadBalancer_addError(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		return http.StatusBadRequest, testErrorBody("InvalidLoadBalancerId.NotFound")
+	})
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepAttachLoadBalancer{LoadBalancerId: "lb-test", Timeout: time.Minute}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("failing to add the instance should halt the build")
+	}
+
+	step.Cleanup(state)
+	if len(actions) != 1 {
+		t.Fatalf("an instance which wasn't added shouldn't be removed: %v", actions)
+	}
+}
@@ -0,0 +1,137 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepAttachNetworkInterface struct {
+	NetworkInterface ApsaraStackNetworkInterface
+	RegionId         string
+	networkInterface *ecs.NetworkInterfaceSet
+	instanceId       string
+	created          bool
+}
+
+func (s *stepAttachNetworkInterface) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	networkInterfaceId := s.NetworkInterface.NetworkInterfaceId
+	if networkInterfaceId == "" {
+		securityGroupId := s.NetworkInterface.SecurityGroupId
+		if securityGroupId == "" {
+			securityGroupId = state.Get("securitygroupid").(string)
+		}
+
+		ui.Say(fmt.Sprintf("Creating secondary network interface on vswitch %s...", s.NetworkInterface.VSwitchId))
+
+		request := ecs.CreateCreateNetworkInterfaceRequest()
+		request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+		// The SDK request lacks the region of the network interface.
+		request.QueryParams["RegionId"] = s.RegionId
+
+		request.ClientToken = clientToken(state, "CreateNetworkInterface")
+		request.VSwitchId = s.NetworkInterface.VSwitchId
+		request.SecurityGroupId = securityGroupId
+		request.Description = fmt.Sprintf("Secondary network interface of %s", instance.InstanceId)
+		response, err := client.CreateNetworkInterface(request)
+		if err != nil {
+			return halt(state, err, "Error creating secondary network interface")
+		}
+		networkInterfaceId = response.NetworkInterfaceId
+		s.networkInterface = &ecs.NetworkInterfaceSet{NetworkInterfaceId: networkInterfaceId}
+		s.created = true
+
+		if _, err := client.WaitForNetworkInterfaceStatus(ctx, s.RegionId, networkInterfaceId, NetworkInterfaceStatusAvailable, config.StatusPollInterval, state); err != nil {
+			return halt(state, err, fmt.Sprintf("Error waiting for network interface %s to be available", networkInterfaceId))
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Attaching network interface %s to instance %s...", networkInterfaceId, instance.InstanceId))
+
+	request := ecs.CreateAttachNetworkInterfaceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	request.QueryParams["RegionId"] = s.RegionId
+
+	request.InstanceId = instance.InstanceId
+	request.NetworkInterfaceId = networkInterfaceId
+	if _, err := client.AttachNetworkInterface(request); err != nil {
+		return halt(state, err, fmt.Sprintf("Error attaching network interface %s", networkInterfaceId))
+	}
+	s.instanceId = instance.InstanceId
+
+	response, err := client.WaitForNetworkInterfaceStatus(ctx, s.RegionId, networkInterfaceId, NetworkInterfaceStatusInUse, config.StatusPollInterval, state)
+	if err != nil {
+		return halt(state, err, fmt.Sprintf("Error waiting for network interface %s to be attached", networkInterfaceId))
+	}
+
+	networkInterfaces := response.(*ecs.DescribeNetworkInterfacesResponse).NetworkInterfaceSets.NetworkInterfaceSet
+	s.networkInterface = &networkInterfaces[0]
+	ui.Message(fmt.Sprintf("Attached network interface %s with private IP %s", networkInterfaceId, s.networkInterface.PrivateIpAddress))
+	state.Put("secondary_network_interface", s.networkInterface)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepAttachNetworkInterface) Cleanup(state multistep.StateBag) {
+	if s.networkInterface == nil || keepResource(state, "network interface", s.networkInterface.NetworkInterfaceId) {
+		return
+	}
+
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	networkInterfaceId := s.networkInterface.NetworkInterfaceId
+
+	if s.instanceId != "" {
+		ui.Say(fmt.Sprintf("Detaching network interface %s...", networkInterfaceId))
+
+		request := ecs.CreateDetachNetworkInterfaceRequest()
+		request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+		request.QueryParams["RegionId"] = s.RegionId
+
+		request.InstanceId = s.instanceId
+		request.NetworkInterfaceId = networkInterfaceId
+		if _, err := client.DetachNetworkInterface(request); err != nil {
+			ui.Error(fmt.Sprintf("Error detaching network interface %s: %s", networkInterfaceId, err))
+			if s.created {
+				recordCleanup(state, "network interface", networkInterfaceId, err)
+			}
+			return
+		}
+	}
+
+	if !s.created {
+		return
+	}
+
+	if _, err := client.WaitForNetworkInterfaceStatus(context.Background(), s.RegionId, networkInterfaceId, NetworkInterfaceStatusAvailable, config.StatusPollInterval, state); err != nil {
+		ui.Error(fmt.Sprintf("Error waiting for network interface %s to be detached, it may still be around: %s", networkInterfaceId, err))
+		recordCleanup(state, "network interface", networkInterfaceId, err)
+		return
+	}
+
+	ui.Say(fmt.Sprintf("Deleting network interface %s...", networkInterfaceId))
+
+	request := ecs.CreateDeleteNetworkInterfaceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	request.QueryParams["RegionId"] = s.RegionId
+
+	request.NetworkInterfaceId = networkInterfaceId
+	_, err := client.DeleteNetworkInterface(request)
+	recordCleanup(state, "network interface", networkInterfaceId, err)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error deleting network interface %s, it may still be around: %s", networkInterfaceId, err))
+	}
+}
@@ -0,0 +1,90 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+// testNetworkInterfaceClient fakes the network interface API, the interface
+// changes its status with every attach and detach.
+func testNetworkInterfaceClient(t *testing.T, actions *[]string) *ClientWrapper {
+	status := NetworkInterfaceStatusAvailable
+	return testClient(t, func(action string, params url.Values) (int, string) {
+		*actions = append(*actions, action)
+		switch action {
+		case "CreateNetworkInterface":
+			if params.Get("VSwitchId") != "vsw-secondary" || params.Get("SecurityGroupId") != "sg-test" {
+				t.Errorf("bad create request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","NetworkInterfaceId":"eni-test"}`
+		case "AttachNetworkInterface":
+			if params.Get("InstanceId") != "i-test" || params.Get("NetworkInterfaceId") != "eni-test" {
+				t.Errorf("bad attach request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			status = NetworkInterfaceStatusInUse
+		case "DetachNetworkInterface":
+			status = NetworkInterfaceStatusAvailable
+		case "DescribeNetworkInterfaces":
+			return http.StatusOK, fmt.Sprintf(`{"RequestId":"test-request","NetworkInterfaceSets":{"NetworkInterfaceSet":[{"NetworkInterfaceId":"eni-test","Status":"%s","PrivateIpAddress":"192.168.1.10"}]}}`, status)
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+}
+
+func TestStepAttachNetworkInterface_create(t *testing.T) {
+	var actions []string
+	client := testNetworkInterfaceClient(t, &actions)
+	state := testCreateInstanceState(client, &Config{RunConfig: RunConfig{StatusPollInterval: time.Millisecond}})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepAttachNetworkInterface{
+		NetworkInterface: ApsaraStackNetworkInterface{VSwitchId: "vsw-secondary"},
+		RegionId:         "cn-test",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	networkInterface := state.Get("secondary_network_interface").(*ecs.NetworkInterfaceSet)
+	if networkInterface.NetworkInterfaceId != "eni-test" || networkInterface.PrivateIpAddress != "192.168.1.10" {
+		t.Fatalf("bad network interface: %#v", networkInterface)
+	}
+
+	actions = nil
+	step.Cleanup(state)
+	if actions[0] != "DetachNetworkInterface" || actions[len(actions)-1] != "DeleteNetworkInterface" {
+		t.Fatalf("the created network interface should be detached and deleted: %v", actions)
+	}
+}
+
+func TestStepAttachNetworkInterface_existing(t *testing.T) {
+	var actions []string
+	client := testNetworkInterfaceClient(t, &actions)
+	state := testCreateInstanceState(client, &Config{RunConfig: RunConfig{StatusPollInterval: time.Millisecond}})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepAttachNetworkInterface{
+		NetworkInterface: ApsaraStackNetworkInterface{NetworkInterfaceId: "eni-test"},
+		RegionId:         "cn-test",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if actions[0] != "AttachNetworkInterface" {
+		t.Fatalf("an existing network interface shouldn't be created: %v", actions)
+	}
+
+	actions = nil
+	step.Cleanup(state)
+	if len(actions) != 1 || actions[0] != "DetachNetworkInterface" {
+		t.Fatalf("an existing network interface should only be detached: %v", actions)
+	}
+}
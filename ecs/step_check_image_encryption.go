@@ -0,0 +1,63 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	confighelper "github.com/hashicorp/packer/helper/config"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// stepCheckImageEncryption compares the encryption of the instance disks the
+// image is made of with image_encrypted. ApsaraStack creates encrypted images
+// from encrypted disks, whatever the image is asked to be.
+type stepCheckImageEncryption struct {
+	ImageEncrypted  confighelper.Trilean
+	IgnoreDataDisks bool
+}
+
+func (s *stepCheckImageEncryption) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	describeDisksRequest := ecs.CreateDescribeDisksRequest()
+	describeDisksRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeDisksRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describeDisksRequest.RegionId = config.ApsaraStackRegion
+	describeDisksRequest.InstanceId = instance.InstanceId
+	if s.IgnoreDataDisks {
+		describeDisksRequest.DiskType = DiskTypeSystem
+	}
+	disksResponse, err := client.DescribeDisks(describeDisksRequest)
+	if err != nil {
+		return halt(state, err, "Error describing the disks of the instance")
+	}
+
+	var encrypted []string
+	for _, disk := range disksResponse.Disks.Disk {
+		if disk.Encrypted {
+			encrypted = append(encrypted, disk.DiskId)
+		}
+	}
+	if len(encrypted) == 0 {
+		return multistep.ActionContinue
+	}
+
+	switch s.ImageEncrypted {
+	case confighelper.TriFalse:
+		err := fmt.Errorf("the disks %v of instance %s are encrypted and ApsaraStack can't create an unencrypted image from encrypted disks, "+
+			"unset image_encrypted or set it to true", encrypted, instance.InstanceId)
+		return halt(state, err, "Error checking image encryption")
+	case confighelper.TriUnset:
+		ui.Error(fmt.Sprintf("Warning: the disks %v of instance %s are encrypted, the image will be encrypted as well", encrypted, instance.InstanceId))
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepCheckImageEncryption) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,59 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	confighelper "github.com/hashicorp/packer/helper/config"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepCheckImageEncryption(t *testing.T) {
+	var diskType string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeDisks" || params.Get("InstanceId") != "i-test" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("InstanceId"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		diskType = params.Get("DiskType")
+		if diskType == DiskTypeSystem {
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[{"DiskId":"d-system","Encrypted":false}]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[` +
+			`{"DiskId":"d-system","Encrypted":false},{"DiskId":"d-encrypted","Encrypted":true}]}}`
+	})
+
+	newState := func() multistep.StateBag {
+		state := testState(client, &Config{})
+		state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+		return state
+	}
+
+	step := &stepCheckImageEncryption{ImageEncrypted: confighelper.TriFalse}
+	state := newState()
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("an encrypted disk should halt when image_encrypted is false")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "d-encrypted") {
+		t.Fatalf("the encrypted disk should be reported: %s", err)
+	}
+
+	for _, encrypted := range []confighelper.Trilean{confighelper.TriUnset, confighelper.TriTrue} {
+		step.ImageEncrypted = encrypted
+		if action := step.Run(context.Background(), newState()); action != multistep.ActionContinue {
+			t.Fatalf("an encrypted disk shouldn't halt when image_encrypted is %v", encrypted)
+		}
+	}
+
+	step = &stepCheckImageEncryption{ImageEncrypted: confighelper.TriFalse, IgnoreDataDisks: true}
+	if action := step.Run(context.Background(), newState()); action != multistep.ActionContinue {
+		t.Fatalf("data disks left out of the image shouldn't be checked")
+	}
+	if diskType != DiskTypeSystem {
+		t.Fatalf("only the system disk should be described, actual: %q", diskType)
+	}
+}
@@ -3,6 +3,11 @@ package ecs
 import (
 	"context"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
@@ -17,6 +22,62 @@ func (s *stepCheckApsaraStackSourceImage) Run(ctx context.Context, state multist
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
+	client.Domain = config.serviceEndpoint("ecs", config.Endpoint)
+	client.SetHTTPSInsecure(true)
+
+	var images []ecs.Image
+	if config.ApsaraStackSourceImage != "" {
+		found, err := describeSourceImage(client, config)
+		if err != nil {
+			return halt(state, err, "")
+		}
+		images = found
+	} else {
+		image, err := findSourceImage(state, config.SourceImageFilter)
+		if err != nil {
+			return halt(state, err, "Error finding source image")
+		}
+		ui.Message(fmt.Sprintf("Found image %s (%s) matching source_image_filter", image.ImageId, image.ImageName))
+
+		// Later steps and the image tags refer to the image by its ID.
+		config.ApsaraStackSourceImage = image.ImageId
+		if config.FailIfNoop {
+			config.ApsaraStackImageTags[TagKeySourceImage] = image.ImageId
+		}
+		images = []ecs.Image{*image}
+	}
+
+	if !config.ApsaraStackSkipImageValidation && images[0].Status != "" && images[0].Status != ImageStatusAvailable {
+		err := fmt.Errorf("source image %s is %s, it has to be %s", images[0].ImageId, images[0].Status, ImageStatusAvailable)
+		return halt(state, err, "")
+	}
+
+	ui.Message(fmt.Sprintf("Found image ID: %s", images[0].ImageId))
+
+	if config.ApsaraStackImageLicenseType == ImageLicenseTypeBYOL && images[0].ProductCode != "" {
+		err := fmt.Errorf("source image %s is a marketplace image (%s) which carries its own license", images[0].ImageId, images[0].ProductCode)
+		return halt(state, err, "Error validating image_license_type")
+	}
+
+	if config.ApsaraStackImageBootMode != "" {
+		if err := validateBootMode(config.ApsaraStackImageBootMode, images[0].Architecture); err != nil {
+			return halt(state, fmt.Errorf("source image %s: %s", images[0].ImageId, err), "Error validating boot_mode")
+		}
+	}
+
+	if config.detectCommunicator {
+		if err := s.detectCommunicator(config, &images[0], ui); err != nil {
+			return halt(state, err, "Error choosing communicator for source image")
+		}
+	}
+
+	state.Put("source_image", &images[0])
+	return multistep.ActionContinue
+}
+
+// describeSourceImage looks up source_image among the images of the user and
+// the system images.
+func describeSourceImage(client *ClientWrapper, config *Config) ([]ecs.Image, error) {
 	describeImagesRequest := ecs.CreateDescribeImagesRequest()
 	describeImagesRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	describeImagesRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs"}
@@ -25,15 +86,16 @@ func (s *stepCheckApsaraStackSourceImage) Run(ctx context.Context, state multist
 
 	describeImagesRequest.RegionId = config.ApsaraStackRegion
 	describeImagesRequest.ImageId = config.ApsaraStackSourceImage
+	// Images which aren't available are looked up too, to tell why they
+	// can't be used.
+	describeImagesRequest.Status = ImageStatusQueried
 	if config.ApsaraStackSkipImageValidation {
 		describeImagesRequest.ShowExpired = "true"
 	}
-	client.Domain = config.Endpoint
-	client.SetHTTPSInsecure(true)
 
 	imagesResponse, err := client.DescribeImages(describeImagesRequest)
 	if err != nil {
-		return halt(state, err, "Error querying ApsaraStack image")
+		return nil, fmt.Errorf("Error querying ApsaraStack image: %w", err)
 	}
 
 	images := imagesResponse.Images.Image
@@ -42,7 +104,7 @@ func (s *stepCheckApsaraStackSourceImage) Run(ctx context.Context, state multist
 	describeImagesRequest.ImageOwnerAlias = "system"
 	marketImagesResponse, err := client.DescribeImages(describeImagesRequest)
 	if err != nil {
-		return halt(state, err, "Error querying ApsaraStack system image")
+		return nil, fmt.Errorf("Error querying ApsaraStack system image: %w", err)
 	}
 
 	marketImages := marketImagesResponse.Images.Image
@@ -51,14 +113,104 @@ func (s *stepCheckApsaraStackSourceImage) Run(ctx context.Context, state multist
 	}
 
 	if len(images) == 0 {
-		err := fmt.Errorf("No ApsaraStack image was found matching filters: %v", config.ApsaraStackSourceImage)
-		return halt(state, err, "")
+		return nil, fmt.Errorf("No ApsaraStack image was found matching filters: %v", config.ApsaraStackSourceImage)
 	}
 
-	ui.Message(fmt.Sprintf("Found image ID: %s", images[0].ImageId))
+	return images, nil
+}
 
-	state.Put("source_image", &images[0])
-	return multistep.ActionContinue
+// findSourceImage looks up the images matching the filter, there has to be
+// exactly one unless the most recent one is picked.
+func findSourceImage(state multistep.StateBag, filter ApsaraStackSourceImageFilter) (*ecs.Image, error) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	// Patterns are matched here, the API only matches exact names.
+	namePattern := strings.Contains(filter.ImageName, "*")
+
+	var images []ecs.Image
+	for page := 1; ; page++ {
+		request := ecs.CreateDescribeImagesRequest()
+		request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs"}
+
+		request.RegionId = config.ApsaraStackRegion
+		request.ImageOwnerAlias = filter.ImageOwnerAlias
+		request.OSType = filter.OSType
+		request.Architecture = filter.Architecture
+		if !namePattern {
+			request.ImageName = filter.ImageName
+		}
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(100)
+
+		response, err := client.DescribeImages(request)
+		if err != nil {
+			return nil, fmt.Errorf("Error querying ApsaraStack images: %w", err)
+		}
+		for _, image := range response.Images.Image {
+			if namePattern {
+				if matched, _ := path.Match(filter.ImageName, image.ImageName); !matched {
+					continue
+				}
+			}
+			images = append(images, image)
+		}
+
+		if len(response.Images.Image) == 0 || page*100 >= response.TotalCount {
+			break
+		}
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image matches source_image_filter")
+	}
+	if len(images) > 1 && !filter.MostRecent {
+		ids := make([]string, 0, len(images))
+		for _, image := range images {
+			ids = append(ids, image.ImageId)
+		}
+		return nil, fmt.Errorf("%d images match source_image_filter, set most_recent to use the latest one: %s",
+			len(images), strings.Join(ids, ", "))
+	}
+
+	// The creation times are in UTC, so they sort as strings.
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].CreationTime > images[j].CreationTime
+	})
+
+	return &images[0], nil
+}
+
+// communicatorForImage returns the communicator for the OS type of image.
+func communicatorForImage(image *ecs.Image) string {
+	if strings.EqualFold(image.OSType, "windows") {
+		return "winrm"
+	}
+
+	return "ssh"
+}
+
+// detectCommunicator picks the communicator of a source image which
+// couldn't be looked up in Prepare, such as one found by
+// source_image_filter.
+func (s *stepCheckApsaraStackSourceImage) detectCommunicator(config *Config, image *ecs.Image, ui packer.Ui) error {
+	commType := communicatorForImage(image)
+	ui.Message(fmt.Sprintf("No communicator was specified, using %s for %s image %s", commType, image.OSType, image.ImageId))
+	if config.Comm.Type != commType {
+		config.Comm.Type = commType
+		if errs := config.Comm.Prepare(&config.ctx); len(errs) > 0 {
+			return &packer.MultiError{Errors: errs}
+		}
+	}
+
+	// Prepare left the credentials to the communicator picked here.
+	config.RunConfig.prepareTemporaryKeyPair()
+	if err := config.RunConfig.generatePassword(); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (s *stepCheckApsaraStackSourceImage) Cleanup(multistep.StateBag) {
@@ -0,0 +1,113 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+const testSourceImages = `{"RequestId":"test-request","TotalCount":3,"Images":{"Image":[
+	{"ImageId":"m-old","ImageName":"ubuntu_20_04_20200901","Status":"Available","CreationTime":"2020-09-01T00:00:00Z"},
+	{"ImageId":"m-new","ImageName":"ubuntu_20_04_20201001","Status":"Available","CreationTime":"2020-10-01T00:00:00Z"},
+	{"ImageId":"m-other","ImageName":"centos_8_20201001","Status":"Available","CreationTime":"2020-10-02T00:00:00Z"}]}}`
+
+func TestStepCheckSourceImage_filter(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeImages" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		if params.Get("ImageName") != "" || params.Get("OSType") != "linux" {
+			t.Errorf("bad describe request: %v", params)
+			return http.StatusBadRequest, testErrorBody("InvalidParameter")
+		}
+		return http.StatusOK, testSourceImages
+	})
+
+	config := &Config{}
+	config.Endpoint = client.Domain
+	config.SourceImageFilter = ApsaraStackSourceImageFilter{ImageName: "ubuntu_20_04_*", OSType: "linux"}
+	state := testState(client, config)
+
+	step := &stepCheckApsaraStackSourceImage{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("several matching images should need most_recent: %s", action)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "most_recent") {
+		t.Fatalf("bad error: %s", err)
+	}
+
+	state = testState(client, config)
+	config.SourceImageFilter.MostRecent = true
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if config.ApsaraStackSourceImage != "m-new" {
+		t.Fatalf("the most recent matching image should be used: %s", config.ApsaraStackSourceImage)
+	}
+}
+
+func TestStepCheckSourceImage_unavailable(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if params.Get("ImageOwnerAlias") == "system" {
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-source","Status":"CreateFailed"}]}}`
+	})
+
+	config := &Config{}
+	config.Endpoint = client.Domain
+	config.ApsaraStackSourceImage = "m-source"
+	state := testState(client, config)
+
+	step := &stepCheckApsaraStackSourceImage{SourceECSImageId: "m-source"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %s", action)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "CreateFailed") {
+		t.Fatalf("the error should tell the status: %s", err)
+	}
+}
+
+func TestStepCheckSourceImage_detectCommunicator(t *testing.T) {
+	osType := "windows"
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		return http.StatusOK, `{"RequestId":"test-request","TotalCount":1,"Images":{"Image":[
+			{"ImageId":"m-source","ImageName":"source","OSType":"` + osType + `","Status":"Available"}]}}`
+	})
+	newConfig := func() *Config {
+		config := &Config{RunConfig: *testConfig()}
+		config.Endpoint = client.Domain
+		config.ApsaraStackSourceImage = ""
+		config.SourceImageFilter = ApsaraStackSourceImageFilter{ImageName: "source"}
+		config.Comm.WinRMUser = "Administrator"
+		if errs := config.RunConfig.Prepare(&config.ctx); len(errs) != 0 {
+			t.Fatalf("err: %s", errs)
+		}
+		return config
+	}
+
+	config := newConfig()
+	state := testState(client, config)
+	step := &stepCheckApsaraStackSourceImage{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if config.Comm.Type != "winrm" || config.Comm.SSHTemporaryKeyPairName != "" || config.Comm.SSHProxyHost != "" || config.Comm.WinRMPassword == "" {
+		t.Fatalf("a windows image should use winrm with a password and no key pair: %s %q %q", config.Comm.Type, config.Comm.SSHTemporaryKeyPairName, config.Comm.SSHProxyHost)
+	}
+
+	osType = "linux"
+	config = newConfig()
+	state = testState(client, config)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if config.Comm.Type != "ssh" || config.Comm.SSHTemporaryKeyPairName == "" || config.Comm.SSHPassword != "" {
+		t.Fatalf("a linux image should use ssh with a temporary key pair: %s %q", config.Comm.Type, config.Comm.SSHTemporaryKeyPairName)
+	}
+}
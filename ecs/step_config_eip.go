@@ -3,9 +3,9 @@ package ecs
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
-	"github.com/hashicorp/packer/common/uuid"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
@@ -14,12 +14,13 @@ import (
 )
 
 type stepConfigApsaraStackEIP struct {
-	AssociatePublicIpAddress bool
-	RegionId                 string
-	InternetChargeType       string
-	InternetMaxBandwidthOut  int
-	allocatedId              string
-	SSHPrivateIp             bool
+	RegionId                string
+	InternetChargeType      string
+	InternetMaxBandwidthOut *int
+	EipBandwidth            int
+	EipInternetChargeType   string
+	allocatedId             string
+	SSHPrivateIp            bool
 }
 
 var allocateEipAddressRetryErrors = []string{
@@ -46,6 +47,7 @@ func (s *stepConfigApsaraStackEIP) Run(ctx context.Context, state multistep.Stat
 
 	allocateEipAddressRequest := s.buildAllocateEipAddressRequest(state)
 	allocateEipAddressResponse, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return client.AllocateEipAddress(allocateEipAddressRequest)
 		},
@@ -87,12 +89,13 @@ func (s *stepConfigApsaraStackEIP) Run(ctx context.Context, state multistep.Stat
 		return halt(state, err, "Error wait eip associated timeout")
 	}
 
+	state.Put("eip", ipaddress)
 	state.Put("ipaddress", ipaddress)
 	return multistep.ActionContinue
 }
 
 func (s *stepConfigApsaraStackEIP) Cleanup(state multistep.StateBag) {
-	if len(s.allocatedId) == 0 {
+	if len(s.allocatedId) == 0 || keepResource(state, "EIP", s.allocatedId) {
 		return
 	}
 
@@ -121,7 +124,9 @@ func (s *stepConfigApsaraStackEIP) Cleanup(state multistep.StateBag) {
 	releaseEipAddressRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	releaseEipAddressRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 	releaseEipAddressRequest.AllocationId = s.allocatedId
-	if _, err := client.ReleaseEipAddress(releaseEipAddressRequest); err != nil {
+	_, err := client.ReleaseEipAddress(releaseEipAddressRequest)
+	recordCleanup(state, "EIP", s.allocatedId, err)
+	if err != nil {
 		ui.Say(fmt.Sprintf("Failed to release eip: %s", err))
 	}
 }
@@ -173,10 +178,17 @@ func (s *stepConfigApsaraStackEIP) buildAllocateEipAddressRequest(state multiste
 	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-	request.ClientToken = uuid.TimeOrderedUUID()
+	request.ClientToken = clientToken(state, "AllocateEipAddress")
 	request.RegionId = instance.RegionId
 	request.InternetChargeType = s.InternetChargeType
-	request.Bandwidth = string(convertNumber(s.InternetMaxBandwidthOut))
+	if s.EipInternetChargeType != "" {
+		request.InternetChargeType = s.EipInternetChargeType
+	}
+	if s.EipBandwidth > 0 {
+		request.Bandwidth = strconv.Itoa(s.EipBandwidth)
+	} else if s.InternetMaxBandwidthOut != nil {
+		request.Bandwidth = strconv.Itoa(*s.InternetMaxBandwidthOut)
+	}
 
 	return request
 }
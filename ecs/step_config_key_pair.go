@@ -0,0 +1,132 @@
+package ecs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/communicator"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// The size of the RSA key generated for a temporary key pair.
+const temporaryKeyPairBits = 2048
+
+type stepConfigApsaraStackKeyPair struct {
+	Comm     *communicator.Config
+	RegionId string
+	keyName  string
+}
+
+func (s *stepConfigApsaraStackKeyPair) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.Comm.SSHKeyPairName != "" {
+		state.Put("keyPair", s.Comm.SSHKeyPairName)
+		return multistep.ActionContinue
+	}
+
+	if s.Comm.SSHTemporaryKeyPairName == "" {
+		return multistep.ActionContinue
+	}
+
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Creating temporary key pair: %s", s.Comm.SSHTemporaryKeyPairName))
+
+	privateKey, publicKey, err := generateKeyPair()
+	if err != nil {
+		return halt(state, err, "Error generating temporary key pair")
+	}
+
+	request := ecs.CreateImportKeyPairRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = s.RegionId
+	request.KeyPairName = s.Comm.SSHTemporaryKeyPairName
+	request.PublicKeyBody = publicKey
+	if _, err := client.ImportKeyPair(request); err != nil {
+		return halt(state, err, "Error importing temporary key pair")
+	}
+
+	s.keyName = s.Comm.SSHTemporaryKeyPairName
+	// The private key only lives in memory, for the communicator.
+	s.Comm.SSHPrivateKey = privateKey
+	state.Put("keyPair", s.keyName)
+
+	return multistep.ActionContinue
+}
+
+// generateKeyPair returns a new RSA private key in PEM encoding along with
+// its public key in the authorized_keys format.
+func generateKeyPair() ([]byte, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, temporaryKeyPairBits)
+	if err != nil {
+		return nil, "", err
+	}
+
+	privateKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	return privateKey, authorizedKey(&key.PublicKey), nil
+}
+
+// authorizedKey encodes an RSA public key in the authorized_keys format,
+// that is its SSH wire format (RFC 4253, section 6.6) in base64.
+func authorizedKey(key *rsa.PublicKey) string {
+	var wire []byte
+	writeString := func(b []byte) {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(b)))
+		wire = append(append(wire, length...), b...)
+	}
+	writeMpint := func(n *big.Int) {
+		b := n.Bytes()
+		// A leading 1 bit would make the number negative.
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		writeString(b)
+	}
+
+	writeString([]byte("ssh-rsa"))
+	writeMpint(big.NewInt(int64(key.E)))
+	writeMpint(key.N)
+
+	return fmt.Sprintf("ssh-rsa %s\n", base64.StdEncoding.EncodeToString(wire))
+}
+
+func (s *stepConfigApsaraStackKeyPair) Cleanup(state multistep.StateBag) {
+	if s.keyName == "" || keepResource(state, "key pair", s.keyName) {
+		return
+	}
+
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Deleting temporary key pair: %s", s.keyName))
+
+	request := ecs.CreateDeleteKeyPairsRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = s.RegionId
+	request.KeyPairNames = fmt.Sprintf("[\"%s\"]", s.keyName)
+	_, err := client.DeleteKeyPairs(request)
+	recordCleanup(state, "key pair", s.keyName, err)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error deleting temporary key pair %s, it may still be around: %s", s.keyName, err))
+	}
+}
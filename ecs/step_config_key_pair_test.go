@@ -0,0 +1,77 @@
+package ecs
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/communicator"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepConfigKeyPair_temporary(t *testing.T) {
+	var publicKey, deleted string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "ImportKeyPair":
+			if params.Get("KeyPairName") != "packer_test" {
+				t.Errorf("bad key pair name: %v", params)
+			}
+			publicKey = params.Get("PublicKeyBody")
+			return http.StatusOK, `{"RequestId":"test-request","KeyPairName":"packer_test"}`
+		case "DeleteKeyPairs":
+			deleted = params.Get("KeyPairNames")
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testState(client, testCreateInstanceConfig())
+
+	comm := &communicator.Config{}
+	comm.SSHTemporaryKeyPairName = "packer_test"
+	step := &stepConfigApsaraStackKeyPair{Comm: comm, RegionId: "cn-test"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+
+	if state.Get("keyPair").(string) != "packer_test" {
+		t.Fatalf("the key pair should be in state")
+	}
+	block, _ := pem.Decode(comm.SSHPrivateKey)
+	if block == nil {
+		t.Fatalf("the private key should be kept for the communicator")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if publicKey != authorizedKey(&key.PublicKey) || !strings.HasPrefix(publicKey, "ssh-rsa AAAAB3NzaC1yc2E") {
+		t.Fatalf("bad public key: %s", publicKey)
+	}
+
+	step.Cleanup(state)
+	if deleted != `["packer_test"]` {
+		t.Fatalf("the temporary key pair should be deleted, actual: %s", deleted)
+	}
+}
+
+func TestStepConfigKeyPair_existing(t *testing.T) {
+	state := testState(nil, testCreateInstanceConfig())
+
+	comm := &communicator.Config{}
+	comm.SSHKeyPairName = "packer"
+	step := &stepConfigApsaraStackKeyPair{Comm: comm}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+	step.Cleanup(state)
+
+	if state.Get("keyPair").(string) != "packer" || len(comm.SSHPrivateKey) != 0 {
+		t.Fatalf("the existing key pair should be used as is")
+	}
+}
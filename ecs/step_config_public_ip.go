@@ -23,6 +23,9 @@ func (s *stepConfigApsaraStackPublicIP) Run(ctx context.Context, state multistep
 
 	if s.SSHPrivateIp {
 		ipaddress := instance.InnerIpAddress.IpAddress
+		if len(instance.VpcAttributes.PrivateIpAddress.IpAddress) > 0 {
+			ipaddress = instance.VpcAttributes.PrivateIpAddress.IpAddress
+		}
 		if len(ipaddress) == 0 {
 			ui.Say("Failed to get private ip of instance")
 			return multistep.ActionHalt
@@ -6,7 +6,7 @@ import (
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
-	"github.com/hashicorp/packer/common/uuid"
+	"github.com/hashicorp/packer/helper/communicator"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
@@ -17,7 +17,11 @@ type stepConfigApsaraStackSecurityGroup struct {
 	Description       string
 	VpcId             string
 	RegionId          string
-	isCreate          bool
+	Comm              *communicator.Config
+	IngressPorts      []int
+	// The sources the ingress rules admit, anywhere when empty.
+	SourceCidrs []string
+	isCreate    bool
 }
 
 var createSecurityGroupRetryErrors = []string{
@@ -41,10 +45,6 @@ func (s *stepConfigApsaraStackSecurityGroup) Run(ctx context.Context, state mult
 
 		describeSecurityGroupsRequest.RegionId = s.RegionId
 		describeSecurityGroupsRequest.SecurityGroupId = s.SecurityGroupId
-		if networkType == InstanceNetworkVpc {
-			vpcId := state.Get("vpcid").(string)
-			describeSecurityGroupsRequest.VpcId = vpcId
-		}
 
 		securityGroupsResponse, err := client.DescribeSecurityGroups(describeSecurityGroupsRequest)
 		if err != nil {
@@ -54,8 +54,12 @@ func (s *stepConfigApsaraStackSecurityGroup) Run(ctx context.Context, state mult
 		securityGroupItems := securityGroupsResponse.SecurityGroups.SecurityGroup
 		for _, securityGroupItem := range securityGroupItems {
 			if securityGroupItem.SecurityGroupId == s.SecurityGroupId {
-				state.Put("securitygroupid", s.SecurityGroupId)
+				// The existing security group is never deleted on cleanup.
 				s.isCreate = false
+				if vpcId, ok := state.GetOk("vpcid"); ok && networkType == InstanceNetworkVpc && securityGroupItem.VpcId != vpcId.(string) {
+					return halt(state, fmt.Errorf("the security group %s belongs to the VPC %s, not to %s", s.SecurityGroupId, securityGroupItem.VpcId, vpcId), "")
+				}
+				state.Put("securitygroupid", s.SecurityGroupId)
 				return multistep.ActionContinue
 			}
 		}
@@ -69,6 +73,7 @@ func (s *stepConfigApsaraStackSecurityGroup) Run(ctx context.Context, state mult
 
 	createSecurityGroupRequest := s.buildCreateSecurityGroupRequest(state)
 	securityGroupResponse, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return client.CreateSecurityGroup(createSecurityGroupRequest)
 		},
@@ -90,6 +95,7 @@ func (s *stepConfigApsaraStackSecurityGroup) Run(ctx context.Context, state mult
 	authorizeSecurityGroupEgressRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	authorizeSecurityGroupEgressRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
+	authorizeSecurityGroupEgressRequest.ClientToken = clientToken(state, "AuthorizeSecurityGroupEgress:"+securityGroupId)
 	authorizeSecurityGroupEgressRequest.SecurityGroupId = securityGroupId
 	authorizeSecurityGroupEgressRequest.RegionId = s.RegionId
 	authorizeSecurityGroupEgressRequest.IpProtocol = IpProtocolAll
@@ -101,26 +107,55 @@ func (s *stepConfigApsaraStackSecurityGroup) Run(ctx context.Context, state mult
 		return halt(state, err, "Failed authorizing security group")
 	}
 
-	authorizeSecurityGroupRequest := ecs.CreateAuthorizeSecurityGroupRequest()
-	authorizeSecurityGroupRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
-	authorizeSecurityGroupRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	sourceCidrs := s.SourceCidrs
+	if len(sourceCidrs) == 0 {
+		sourceCidrs = []string{DefaultCidrIp}
+	}
+	for _, port := range s.ingressPorts() {
+		for _, sourceCidr := range sourceCidrs {
+			ui.Message(fmt.Sprintf("Opening port %d to %s in security group %s", port, sourceCidr, securityGroupId))
+
+			authorizeSecurityGroupRequest := ecs.CreateAuthorizeSecurityGroupRequest()
+			authorizeSecurityGroupRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			authorizeSecurityGroupRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			authorizeSecurityGroupRequest.ClientToken = clientToken(state, fmt.Sprintf("AuthorizeSecurityGroup:%s:%d:%s", securityGroupId, port, sourceCidr))
+			authorizeSecurityGroupRequest.SecurityGroupId = securityGroupId
+			authorizeSecurityGroupRequest.RegionId = s.RegionId
+			authorizeSecurityGroupRequest.IpProtocol = IpProtocolTCP
+			authorizeSecurityGroupRequest.PortRange = fmt.Sprintf("%d/%d", port, port)
+			authorizeSecurityGroupRequest.NicType = NicTypeInternet
+			authorizeSecurityGroupRequest.SourceCidrIp = sourceCidr
+
+			if _, err := client.AuthorizeSecurityGroup(authorizeSecurityGroupRequest); err != nil {
+				return halt(state, err, "Failed authorizing security group")
+			}
+		}
+	}
 
-	authorizeSecurityGroupRequest.SecurityGroupId = securityGroupId
-	authorizeSecurityGroupRequest.RegionId = s.RegionId
-	authorizeSecurityGroupRequest.IpProtocol = IpProtocolAll
-	authorizeSecurityGroupRequest.PortRange = DefaultPortRange
-	authorizeSecurityGroupRequest.NicType = NicTypeInternet
-	authorizeSecurityGroupRequest.SourceCidrIp = DefaultCidrIp
+	return multistep.ActionContinue
+}
 
-	if _, err := client.AuthorizeSecurityGroup(authorizeSecurityGroupRequest); err != nil {
-		return halt(state, err, "Failed authorizing security group")
+// ingressPorts returns the TCP ports the created security group opens, the
+// port of the communicator followed by security_group_ingress_ports. The
+// communicator is read when the step runs since it may only be picked once
+// the source image is known.
+func (s *stepConfigApsaraStackSecurityGroup) ingressPorts() []int {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, port := range append([]int{s.Comm.Port()}, s.IngressPorts...) {
+		if port <= 0 || seen[port] {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, port)
 	}
 
-	return multistep.ActionContinue
+	return ports
 }
 
 func (s *stepConfigApsaraStackSecurityGroup) Cleanup(state multistep.StateBag) {
-	if !s.isCreate {
+	if !s.isCreate || keepResource(state, "security group", s.SecurityGroupId) {
 		return
 	}
 
@@ -143,6 +178,7 @@ func (s *stepConfigApsaraStackSecurityGroup) Cleanup(state multistep.StateBag) {
 		EvalFunc:   client.EvalCouldRetryResponse(deleteSecurityGroupRetryErrors, EvalRetryErrorType),
 		RetryTimes: shortRetryTimes,
 	})
+	recordCleanup(state, "security group", s.SecurityGroupId, err)
 
 	if err != nil {
 		ui.Error(fmt.Sprintf("Failed to delete security group, it may still be around: %s", err))
@@ -157,7 +193,7 @@ func (s *stepConfigApsaraStackSecurityGroup) buildCreateSecurityGroupRequest(sta
 	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-	request.ClientToken = uuid.TimeOrderedUUID()
+	request.ClientToken = clientToken(state, "CreateSecurityGroup")
 	request.RegionId = s.RegionId
 	request.SecurityGroupName = s.SecurityGroupName
 
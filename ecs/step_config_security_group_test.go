@@ -0,0 +1,83 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/communicator"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepConfigSecurityGroup_ingressPorts(t *testing.T) {
+	var ingress []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateSecurityGroup":
+			if params.Get("SecurityGroupName") != "packer-test" {
+				t.Errorf("bad security group name: %s", params.Get("SecurityGroupName"))
+			}
+			return http.StatusOK, `{"RequestId":"test-request","SecurityGroupId":"sg-test"}`
+		case "AuthorizeSecurityGroup":
+			ingress = append(ingress, fmt.Sprintf("%s %s %s", params.Get("IpProtocol"), params.Get("PortRange"), params.Get("SourceCidrIp")))
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	state := testState(client, config)
+
+	step := &stepConfigApsaraStackSecurityGroup{
+		SecurityGroupName: "packer-test",
+		RegionId:          "cn-test",
+		Comm:              &communicator.Config{Type: "ssh", SSH: communicator.SSH{SSHPort: 2222}},
+		IngressPorts:      []int{8080, 2222},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+
+	expected := []string{"tcp 2222/2222 0.0.0.0/0", "tcp 8080/8080 0.0.0.0/0"}
+	if fmt.Sprint(ingress) != fmt.Sprint(expected) {
+		t.Fatalf("only the communicator port and the extra ports should be opened, actual: %v", ingress)
+	}
+}
+
+func TestStepConfigSecurityGroup_sourceCidrs(t *testing.T) {
+	var ingress []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateSecurityGroup":
+			return http.StatusOK, `{"RequestId":"test-request","SecurityGroupId":"sg-test"}`
+		case "AuthorizeSecurityGroup":
+			ingress = append(ingress, fmt.Sprintf("%s %s", params.Get("PortRange"), params.Get("SourceCidrIp")))
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	state := testState(client, config)
+
+	step := &stepConfigApsaraStackSecurityGroup{
+		RegionId:    "cn-test",
+		Comm:        &communicator.Config{Type: "winrm", WinRM: communicator.WinRM{WinRMPort: 5986}},
+		SourceCidrs: []string{"10.0.0.0/8", "192.168.1.0/24"},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+
+	expected := []string{"5986/5986 10.0.0.0/8", "5986/5986 192.168.1.0/24"}
+	if fmt.Sprint(ingress) != fmt.Sprint(expected) {
+		t.Fatalf("the port should only be opened to the source CIDR blocks, actual: %v", ingress)
+	}
+}
+
+func TestStepConfigSecurityGroup_noCommunicator(t *testing.T) {
+	step := &stepConfigApsaraStackSecurityGroup{Comm: &communicator.Config{Type: "none"}}
+	if ports := step.ingressPorts(); len(ports) != 0 {
+		t.Fatalf("no port should be opened without a communicator: %v", ports)
+	}
+}
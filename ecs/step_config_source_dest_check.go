@@ -0,0 +1,64 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// The type of the network interface an instance is created with.
+const NetworkInterfaceTypePrimary = "Primary"
+
+type stepConfigSourceDestCheck struct {
+	SourceDestCheck bool
+	RegionId        string
+}
+
+func (s *stepConfigSourceDestCheck) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	describeRequest := ecs.CreateDescribeNetworkInterfacesRequest()
+	describeRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	// The SDK request lacks the region of the network interface.
+	describeRequest.QueryParams["RegionId"] = s.RegionId
+
+	describeRequest.InstanceId = instance.InstanceId
+	describeRequest.Type = NetworkInterfaceTypePrimary
+	describeResponse, err := client.DescribeNetworkInterfaces(describeRequest)
+	if err != nil {
+		return halt(state, err, "Error querying the primary network interface")
+	}
+	networkInterfaces := describeResponse.NetworkInterfaceSets.NetworkInterfaceSet
+	if len(networkInterfaces) == 0 {
+		return halt(state, fmt.Errorf("the primary network interface of instance %s isn't found", instance.InstanceId), "")
+	}
+	networkInterfaceId := networkInterfaces[0].NetworkInterfaceId
+
+	ui.Say(fmt.Sprintf("Setting source/destination check of network interface %s to %t...", networkInterfaceId, s.SourceDestCheck))
+
+	request := ecs.CreateModifyNetworkInterfaceAttributeRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	// Neither is the source/destination check part of the SDK request.
+	request.QueryParams["RegionId"] = s.RegionId
+	request.QueryParams["SourceDestCheck"] = strconv.FormatBool(s.SourceDestCheck)
+
+	request.NetworkInterfaceId = networkInterfaceId
+	if _, err := client.ModifyNetworkInterfaceAttribute(request); err != nil {
+		return halt(state, err, fmt.Sprintf("Error modifying network interface %s", networkInterfaceId))
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepConfigSourceDestCheck) Cleanup(state multistep.StateBag) {
+	// The primary network interface is released along with the instance.
+}
@@ -0,0 +1,40 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepConfigSourceDestCheck(t *testing.T) {
+	var modified url.Values
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeNetworkInterfaces":
+			if params.Get("InstanceId") != "i-test" || params.Get("Type") != NetworkInterfaceTypePrimary {
+				t.Errorf("bad describe request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","NetworkInterfaceSets":{"NetworkInterfaceSet":[{"NetworkInterfaceId":"eni-primary","Type":"Primary"}]}}`
+		case "ModifyNetworkInterfaceAttribute":
+			modified = params
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepConfigSourceDestCheck{SourceDestCheck: false, RegionId: "cn-test"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if modified.Get("NetworkInterfaceId") != "eni-primary" || modified.Get("SourceDestCheck") != "false" {
+		t.Fatalf("bad modify request: %v", modified)
+	}
+}
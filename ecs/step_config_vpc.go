@@ -8,7 +8,6 @@ import (
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
 
 	//"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
-	"github.com/hashicorp/packer/common/uuid"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
@@ -37,13 +36,9 @@ func (s *stepConfigApsaraStackVPC) Run(ctx context.Context, state multistep.Stat
 	config := state.Get("config").(*Config)
 	client := state.Get("client").(*ClientWrapper)
 	ui := state.Get("ui").(packer.Ui)
-	vpcclient, err := vpc.NewClientWithAccessKey(config.ApsaraStackRegion, config.ApsaraStackAccessKey, config.ApsaraStackSecretKey)
+	vpcclient, err := config.VpcClient(client)
 	if err != nil {
-		panic(err) // TODO eRROR STATEMENT
-	}
-	vpcclient.Domain = client.Domain
-	if client.GetHttpProxy() != "" {
-		vpcclient.SetHttpProxy(client.GetHttpProxy())
+		return halt(state, err, "Error creating VPC client")
 	}
 	if len(s.VpcId) != 0 {
 		describeVpcsRequest := vpc.CreateDescribeVpcsRequest()
@@ -76,6 +71,7 @@ func (s *stepConfigApsaraStackVPC) Run(ctx context.Context, state multistep.Stat
 	createVpcRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "vpc", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
 	createVpcResponse, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return vpcclient.CreateVpc(createVpcRequest)
 		},
@@ -87,6 +83,7 @@ func (s *stepConfigApsaraStackVPC) Run(ctx context.Context, state multistep.Stat
 
 	vpcId := createVpcResponse.(*vpc.CreateVpcResponse).VpcId
 	_, err = client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			request := vpc.CreateDescribeVpcsRequest()
 			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
@@ -128,7 +125,7 @@ func (s *stepConfigApsaraStackVPC) Run(ctx context.Context, state multistep.Stat
 }
 
 func (s *stepConfigApsaraStackVPC) Cleanup(state multistep.StateBag) {
-	if !s.isCreate {
+	if !s.isCreate || keepResource(state, "VPC", s.VpcId) {
 		return
 	}
 	config := state.Get("config").(*Config)
@@ -136,13 +133,10 @@ func (s *stepConfigApsaraStackVPC) Cleanup(state multistep.StateBag) {
 
 	client := state.Get("client").(*ClientWrapper)
 	ui := state.Get("ui").(packer.Ui)
-	vpcclient, err := vpc.NewClientWithAccessKey(config.ApsaraStackRegion, config.ApsaraStackAccessKey, config.ApsaraStackSecretKey)
+	vpcclient, err := config.VpcClient(client)
 	if err != nil {
-		panic(err) // TODO eRROR STATEMENT
-	}
-	vpcclient.Domain = client.Domain
-	if client.GetHttpProxy() != "" {
-		vpcclient.SetHttpProxy(client.GetHttpProxy())
+		ui.Error(fmt.Sprintf("Error creating VPC client: %s", err))
+		return
 	}
 	_, err = client.WaitForExpected(&WaitForExpectArgs{
 		RequestFunc: func() (responses.AcsResponse, error) {
@@ -156,6 +150,7 @@ func (s *stepConfigApsaraStackVPC) Cleanup(state multistep.StateBag) {
 		EvalFunc:   client.EvalCouldRetryResponse(deleteVpcRetryErrors, EvalRetryErrorType),
 		RetryTimes: shortRetryTimes,
 	})
+	recordCleanup(state, "VPC", s.VpcId, err)
 
 	if err != nil {
 		ui.Error(fmt.Sprintf("Error deleting vpc, it may still be around: %s", err))
@@ -169,7 +164,7 @@ func (s *stepConfigApsaraStackVPC) buildCreateVpcRequest(state multistep.StateBa
 	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "vpc", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-	request.ClientToken = uuid.TimeOrderedUUID()
+	request.ClientToken = clientToken(state, "CreateVpc")
 	request.RegionId = config.ApsaraStackRegion
 	request.CidrBlock = s.CidrBlock
 	request.VpcName = s.VpcName
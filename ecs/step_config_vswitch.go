@@ -7,7 +7,6 @@ import (
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
-	"github.com/hashicorp/packer/common/uuid"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
@@ -37,39 +36,50 @@ func (s *stepConfigApsaraStackVSwitch) Run(ctx context.Context, state multistep.
 	ui := state.Get("ui").(packer.Ui)
 	vpcId := state.Get("vpcid").(string)
 	config := state.Get("config").(*Config)
-	vpcclient, err := vpc.NewClientWithAccessKey(config.ApsaraStackRegion, config.ApsaraStackAccessKey, config.ApsaraStackSecretKey)
+	vpcclient, err := config.VpcClient(client)
 	if err != nil {
-		panic(err) // TODO eRROR STATEMENT
-	}
-	vpcclient.Domain = client.Domain
-	if client.GetHttpProxy() != "" {
-		vpcclient.SetHttpProxy(client.GetHttpProxy())
+		return halt(state, err, "Error creating VPC client")
 	}
 	if len(s.VSwitchId) != 0 {
 		describeVSwitchesRequest := vpc.CreateDescribeVSwitchesRequest()
 		describeVSwitchesRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 		describeVSwitchesRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "vpc", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-		describeVSwitchesRequest.VpcId = vpcId
+		// The VPC and zone are checked on the result, so that a mismatch
+		// isn't reported as a missing vswitch.
 		describeVSwitchesRequest.VSwitchId = s.VSwitchId
-		describeVSwitchesRequest.ZoneId = s.ZoneId
 
 		vswitchesResponse, err := vpcclient.DescribeVSwitches(describeVSwitchesRequest)
 		if err != nil {
 			return halt(state, err, "Failed querying vswitch")
 		}
 
+		// The existing vswitch is never deleted on cleanup.
+		s.isCreate = false
 		vswitch := vswitchesResponse.VSwitches.VSwitch
 		if len(vswitch) > 0 {
+			if vswitch[0].VpcId != vpcId {
+				return halt(state, fmt.Errorf("the vswitch %s belongs to the VPC %s, not to vpc_id %s", s.VSwitchId, vswitch[0].VpcId, vpcId), "")
+			}
+			if s.ZoneId != "" && vswitch[0].ZoneId != s.ZoneId {
+				return halt(state, fmt.Errorf("the vswitch %s is in the zone %s, not in zone_id %s", s.VSwitchId, vswitch[0].ZoneId, s.ZoneId), "")
+			}
+
 			state.Put("vswitchid", vswitch[0].VSwitchId)
-			s.isCreate = false
+			state.Put("vswitchcidr", vswitch[0].CidrBlock)
+			state.Put("vswitchipv6cidr", vswitch[0].Ipv6CidrBlock)
+			// The instance has to be created in the zone of the vswitch.
+			state.Put("zoneid", vswitch[0].ZoneId)
 			return multistep.ActionContinue
 		}
 
-		s.isCreate = false
 		return halt(state, fmt.Errorf("The specified vswitch {%s} doesn't exist.", s.VSwitchId), "")
 	}
 
+	if zoneId, ok := state.GetOk("zoneid"); ok && s.ZoneId == "" {
+		s.ZoneId = zoneId.(string)
+	}
+
 	if s.ZoneId == "" {
 		describeZonesRequest := vpc.CreateDescribeZonesRequest()
 		describeZonesRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
@@ -135,6 +145,7 @@ func (s *stepConfigApsaraStackVSwitch) Run(ctx context.Context, state multistep.
 
 	createVSwitchRequest := s.buildCreateVSwitchRequest(state)
 	createVSwitchResponse, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return vpcclient.CreateVSwitch(createVSwitchRequest)
 		},
@@ -154,6 +165,7 @@ func (s *stepConfigApsaraStackVSwitch) Run(ctx context.Context, state multistep.
 	describeVSwitchesRequest.VSwitchId = vSwitchId
 
 	_, err = client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return vpcclient.DescribeVSwitches(describeVSwitchesRequest)
 		},
@@ -183,13 +195,15 @@ func (s *stepConfigApsaraStackVSwitch) Run(ctx context.Context, state multistep.
 
 	ui.Message(fmt.Sprintf("Created vswitch: %s", vSwitchId))
 	state.Put("vswitchid", vSwitchId)
+	state.Put("vswitchcidr", s.CidrBlock)
+	state.Put("vswitchipv6cidr", "")
 	s.isCreate = true
 	s.VSwitchId = vSwitchId
 	return multistep.ActionContinue
 }
 
 func (s *stepConfigApsaraStackVSwitch) Cleanup(state multistep.StateBag) {
-	if !s.isCreate {
+	if !s.isCreate || keepResource(state, "vSwitch", s.VSwitchId) {
 		return
 	}
 
@@ -198,13 +212,10 @@ func (s *stepConfigApsaraStackVSwitch) Cleanup(state multistep.StateBag) {
 	client := state.Get("client").(*ClientWrapper)
 	ui := state.Get("ui").(packer.Ui)
 	config := state.Get("config").(*Config)
-	vpcclient, err := vpc.NewClientWithAccessKey(config.ApsaraStackRegion, config.ApsaraStackAccessKey, config.ApsaraStackSecretKey)
+	vpcclient, err := config.VpcClient(client)
 	if err != nil {
-		panic(err) // TODO eRROR STATEMENT
-	}
-	vpcclient.Domain = client.Domain
-	if client.GetHttpProxy() != "" {
-		vpcclient.SetHttpProxy(client.GetHttpProxy())
+		ui.Error(fmt.Sprintf("Error creating VPC client: %s", err))
+		return
 	}
 	_, err = client.WaitForExpected(&WaitForExpectArgs{
 		RequestFunc: func() (responses.AcsResponse, error) {
@@ -218,6 +229,7 @@ func (s *stepConfigApsaraStackVSwitch) Cleanup(state multistep.StateBag) {
 		EvalFunc:   client.EvalCouldRetryResponse(deleteVSwitchRetryErrors, EvalRetryErrorType),
 		RetryTimes: shortRetryTimes,
 	})
+	recordCleanup(state, "vSwitch", s.VSwitchId, err)
 
 	if err != nil {
 		ui.Error(fmt.Sprintf("Error deleting vswitch, it may still be around: %s", err))
@@ -231,7 +243,7 @@ func (s *stepConfigApsaraStackVSwitch) buildCreateVSwitchRequest(state multistep
 	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "vpc", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-	request.ClientToken = uuid.TimeOrderedUUID()
+	request.ClientToken = clientToken(state, "CreateVSwitch")
 	request.CidrBlock = s.CidrBlock
 	request.ZoneId = s.ZoneId
 	request.VpcId = vpcId
@@ -0,0 +1,67 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepConfigVSwitch_existing(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		return http.StatusOK, `{"RequestId":"test-request","VSwitches":{"VSwitch":[{"VSwitchId":"vsw-test","VpcId":"vpc-test",` +
+			`"ZoneId":"cn-test-a","CidrBlock":"172.16.0.0/24"}]}}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	state := testState(client, config)
+	state.Put("vpcid", "vpc-test")
+
+	step := &stepConfigApsaraStackVSwitch{VSwitchId: "vsw-test"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if state.Get("vswitchid") != "vsw-test" || state.Get("zoneid") != "cn-test-a" {
+		t.Fatalf("the vswitch and its zone should be in the state: %v, %v", state.Get("vswitchid"), state.Get("zoneid"))
+	}
+
+	step.Cleanup(state)
+	if len(actions) != 1 {
+		t.Fatalf("an existing vswitch shouldn't be deleted: %v", actions)
+	}
+}
+
+func TestStepConfigVSwitch_mismatch(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		return http.StatusOK, `{"RequestId":"test-request","VSwitches":{"VSwitch":[{"VSwitchId":"vsw-test","VpcId":"vpc-test",` +
+			`"ZoneId":"cn-test-a"}]}}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+
+	cases := []struct {
+		vpcId  string
+		zoneId string
+		reason string
+	}{
+		{"vpc-other", "", "belongs to the VPC vpc-test"},
+		{"vpc-test", "cn-test-b", "is in the zone cn-test-a"},
+	}
+	for _, c := range cases {
+		state := testState(client, config)
+		state.Put("vpcid", c.vpcId)
+
+		step := &stepConfigApsaraStackVSwitch{VSwitchId: "vsw-test", ZoneId: c.zoneId}
+		if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+			t.Fatalf("bad action: %s", action)
+		}
+		if err := state.Get("error").(error); !strings.Contains(err.Error(), c.reason) {
+			t.Fatalf("bad error: %s", err)
+		}
+	}
+}
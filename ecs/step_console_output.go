@@ -0,0 +1,92 @@
+package ecs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// The number of lines of the console output shown when the communicator
+// doesn't connect.
+const consoleOutputTailLines = 50
+
+// stepConsoleOutputOnFailure shows the tail of the serial console of the
+// instance when the communicator fails to connect, which usually tells why
+// it didn't boot or cloud-init didn't set it up. It runs right before the
+// communicator connects, so that its cleanup follows a failed connection.
+type stepConsoleOutputOnFailure struct{}
+
+func (s *stepConsoleOutputOnFailure) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	return multistep.ActionContinue
+}
+
+func (s *stepConsoleOutputOnFailure) Cleanup(state multistep.StateBag) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	// The communicator is in the state once it connected, a later failure
+	// isn't about the boot.
+	_, connected := state.GetOk("communicator")
+	if cancelled || !halted || connected {
+		return
+	}
+	// Without a communicator nothing connects, so it isn't what failed.
+	config := state.Get("config").(*Config)
+	if config.Comm.Type == "none" {
+		return
+	}
+
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	request := ecs.CreateGetInstanceConsoleOutputRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.InstanceId = instance.InstanceId
+	request.RemoveSymbols = requests.NewBoolean(true)
+	response, err := client.GetInstanceConsoleOutput(request)
+	if err != nil {
+		log.Printf("[DEBUG] Unable to get the console output of instance %s: %s", instance.InstanceId, err)
+		return
+	}
+
+	lines := consoleOutputTail(response.ConsoleOutput, consoleOutputTailLines)
+	if len(lines) == 0 {
+		ui.Say(fmt.Sprintf("Instance %s has no console output yet", instance.InstanceId))
+		return
+	}
+
+	ui.Say(fmt.Sprintf("Console output of instance %s, last %d lines:", instance.InstanceId, len(lines)))
+	for _, line := range lines {
+		ui.Message(line)
+	}
+}
+
+// consoleOutputTail decodes the base64 console output and returns its last
+// lines, the output is taken as it is when it isn't base64.
+func consoleOutputTail(output string, count int) []string {
+	if decoded, err := base64.StdEncoding.DecodeString(output); err == nil {
+		output = string(decoded)
+	}
+
+	output = strings.TrimRight(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	if output == "" {
+		return nil
+	}
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+
+	return lines
+}
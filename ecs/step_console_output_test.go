@@ -0,0 +1,73 @@
+package ecs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestStepConsoleOutputOnFailure(t *testing.T) {
+	var lines []string
+	for i := 0; i < 60; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	output := base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+
+	requested := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "GetInstanceConsoleOutput" || params.Get("InstanceId") != "i-test" {
+			t.Errorf("unexpected request: %s %v", action, params)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		requested++
+		return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test","ConsoleOutput":"` + output + `"}`
+	})
+
+	for _, c := range []struct {
+		halted    bool
+		connected bool
+		commType  string
+		shown     bool
+	}{
+		{halted: false, shown: false},
+		{halted: true, connected: true, shown: false},
+		{halted: true, commType: "none", shown: false},
+		{halted: true, shown: true},
+	} {
+		var out bytes.Buffer
+		config := &Config{}
+		config.Comm.Type = c.commType
+		state := testState(client, config)
+		state.Put("ui", &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &out, ErrorWriter: &out})
+		state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+		if c.halted {
+			state.Put(multistep.StateHalted, true)
+		}
+		if c.connected {
+			state.Put("communicator", "test")
+		}
+		requested = 0
+
+		(&stepConsoleOutputOnFailure{}).Cleanup(state)
+		if shown := requested == 1; shown != c.shown {
+			t.Fatalf("halted %t, connected %t, communicator %q: console output requested %d times", c.halted, c.connected, c.commType, requested)
+		}
+		if !c.shown {
+			continue
+		}
+		if !strings.Contains(out.String(), "Console output of instance i-test, last 50 lines:") {
+			t.Fatalf("the header should name the instance: %s", out.String())
+		}
+		if strings.Contains(out.String(), "line 9\n") || !strings.Contains(out.String(), "line 10\n") || !strings.Contains(out.String(), "line 59\n") {
+			t.Fatalf("only the tail of the output should be shown: %s", out.String())
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepCreateApsaraStackDataDiskSnapshots struct {
+	Devices                  []string
+	WaitSnapshotReadyTimeout int
+	snapshots                map[string]string
+}
+
+func (s *stepCreateApsaraStackDataDiskSnapshots) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	describeDisksRequest := ecs.CreateDescribeDisksRequest()
+	describeDisksRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeDisksRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describeDisksRequest.RegionId = config.ApsaraStackRegion
+	describeDisksRequest.InstanceId = instance.InstanceId
+	describeDisksRequest.DiskType = DiskTypeData
+	disksResponse, err := client.DescribeDisks(describeDisksRequest)
+	if err != nil {
+		return halt(state, err, "Error describe data disks")
+	}
+
+	disks := make(map[string]ecs.Disk)
+	for _, disk := range disksResponse.Disks.Disk {
+		disks[disk.Device] = disk
+	}
+
+	for _, device := range s.Devices {
+		if _, ok := disks[device]; !ok {
+			err := fmt.Errorf("data disk %s is not attached to instance %s", device, instance.InstanceId)
+			return halt(state, err, "Error validating image_data_disk_snapshots")
+		}
+	}
+
+	s.snapshots = make(map[string]string)
+	for _, device := range s.Devices {
+		disk := disks[device]
+
+		createSnapshotRequest := ecs.CreateCreateSnapshotRequest()
+		createSnapshotRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		createSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+		createSnapshotRequest.ClientToken = clientToken(state, "CreateSnapshot:"+disk.DiskId)
+		createSnapshotRequest.DiskId = disk.DiskId
+		createSnapshotRequest.SnapshotName = fmt.Sprintf("%s-%s", config.ApsaraStackImageName, path.Base(device))
+		snapshot, err := client.CreateSnapshot(createSnapshotRequest)
+		if err != nil {
+			return halt(state, err, "Error creating data disk snapshot")
+		}
+
+		ui.Say(fmt.Sprintf("Creating snapshot from data disk %s(%s): %s", disk.DiskId, device, snapshot.SnapshotId))
+		s.snapshots[device] = snapshot.SnapshotId
+
+		_, err = client.WaitForSnapshotStatus(ctx, config.ApsaraStackRegion, snapshot.SnapshotId, SnapshotStatusAccomplished, time.Duration(s.WaitSnapshotReadyTimeout)*time.Second, state)
+		if err != nil {
+			_, ok := err.(errors.Error)
+			if ok {
+				return halt(state, err, "Error querying created data disk snapshot")
+			}
+
+			return halt(state, err, "Timeout waiting for data disk snapshot to be created")
+		}
+	}
+
+	state.Put("ApsaraStackdatadisksnapshots", s.snapshots)
+	return multistep.ActionContinue
+}
+
+func (s *stepCreateApsaraStackDataDiskSnapshots) Cleanup(state multistep.StateBag) {
+	if len(s.snapshots) == 0 {
+		return
+	}
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say("Deleting the data disk snapshots because of cancellation or error...")
+
+	for device, snapshotId := range s.snapshots {
+		deleteSnapshotRequest := ecs.CreateDeleteSnapshotRequest()
+		deleteSnapshotRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		deleteSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+		deleteSnapshotRequest.SnapshotId = snapshotId
+		_, err := client.DeleteSnapshot(deleteSnapshotRequest)
+		recordCleanup(state, "snapshot", snapshotId, err)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error deleting snapshot %s of %s, it may still be around: %s", snapshotId, device, err))
+		}
+	}
+}
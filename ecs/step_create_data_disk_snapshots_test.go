@@ -0,0 +1,119 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+// testDataDiskSnapshotsClient fakes the disk and snapshot API for an
+// instance with the data disks /dev/xvdb and /dev/xvdc, creating a snapshot
+// of failDiskId fails.
+func testDataDiskSnapshotsClient(t *testing.T, failDiskId string, created *[]string, deleted *[]string) *ClientWrapper {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeDisks":
+			if params.Get("InstanceId") != "i-test" || params.Get("DiskType") != DiskTypeData {
+				t.Errorf("bad describe request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[` +
+				`{"DiskId":"d-xvdb","Device":"/dev/xvdb"},{"DiskId":"d-xvdc","Device":"/dev/xvdc"}]}}`
+		case "CreateSnapshot":
+			if params.Get("DiskId") == failDiskId {
+				return http.StatusBadRequest, testErrorBody("IncorrectDiskStatus")
+			}
+			*created = append(*created, params.Get("SnapshotName"))
+			return http.StatusOK, `{"RequestId":"test-request","SnapshotId":"s-` + params.Get("DiskId") + `"}`
+		case "DescribeSnapshots":
+			return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[{"SnapshotId":"s-test","Status":"accomplished"}]}}`
+		case "DeleteSnapshot":
+			*deleted = append(*deleted, params.Get("SnapshotId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+	return client
+}
+
+func testDataDiskSnapshotsState(client *ClientWrapper) multistep.StateBag {
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	config.ApsaraStackImageName = "packer-test"
+	state := testState(client, config)
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+	return state
+}
+
+func TestStepCreateDataDiskSnapshots(t *testing.T) {
+	var created, deleted []string
+	state := testDataDiskSnapshotsState(testDataDiskSnapshotsClient(t, "", &created, &deleted))
+
+	step := &stepCreateApsaraStackDataDiskSnapshots{
+		Devices:                  []string{"/dev/xvdb", "/dev/xvdc"},
+		WaitSnapshotReadyTimeout: 60,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+
+	if expected := []string{"packer-test-xvdb", "packer-test-xvdc"}; !reflect.DeepEqual(created, expected) {
+		t.Fatalf("bad snapshots created: %v", created)
+	}
+	snapshots := state.Get("ApsaraStackdatadisksnapshots").(map[string]string)
+	if expected := map[string]string{"/dev/xvdb": "s-d-xvdb", "/dev/xvdc": "s-d-xvdc"}; !reflect.DeepEqual(snapshots, expected) {
+		t.Fatalf("bad snapshots: %v", snapshots)
+	}
+
+	step.Cleanup(state)
+	if len(deleted) != 0 {
+		t.Fatalf("the snapshots of a successful build should be kept: %v", deleted)
+	}
+}
+
+func TestStepCreateDataDiskSnapshots_failed(t *testing.T) {
+	var created, deleted []string
+	state := testDataDiskSnapshotsState(testDataDiskSnapshotsClient(t, "d-xvdc", &created, &deleted))
+
+	step := &stepCreateApsaraStackDataDiskSnapshots{
+		Devices:                  []string{"/dev/xvdb", "/dev/xvdc"},
+		WaitSnapshotReadyTimeout: 60,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("a failed snapshot should halt the build")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "Error creating data disk snapshot") {
+		t.Fatalf("bad error: %s", err)
+	}
+
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if len(deleted) != 1 || deleted[0] != "s-d-xvdb" {
+		t.Fatalf("the snapshots created before the failure should be deleted: %v", deleted)
+	}
+}
+
+func TestStepCreateDataDiskSnapshots_missingDevice(t *testing.T) {
+	var created, deleted []string
+	state := testDataDiskSnapshotsState(testDataDiskSnapshotsClient(t, "", &created, &deleted))
+
+	step := &stepCreateApsaraStackDataDiskSnapshots{Devices: []string{"/dev/xvdb", "/dev/xvdz"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("a device which isn't attached should halt the build")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "/dev/xvdz") {
+		t.Fatalf("the missing device should be reported: %s", err)
+	}
+	if len(created) != 0 {
+		t.Fatalf("no snapshot should be created: %v", created)
+	}
+}
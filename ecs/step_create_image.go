@@ -2,14 +2,17 @@ package ecs
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/hashicorp/packer/common/random"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
-	"github.com/hashicorp/packer/common/uuid"
+	confighelper "github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
@@ -17,11 +20,16 @@ import (
 type stepCreateApsaraStackImage struct {
 	ApsaraStackImageIgnoreDataDisks bool
 	WaitSnapshotReadyTimeout        int
+	RetryErrorCodes                 []string
+	CleanupSnapshotsOnFailure       bool
+	instanceId                      string
+	startTime                       time.Time
 	image                           *ecs.Image
 }
 
 var createImageRetryErrors = []string{
 	"IdempotentProcessing",
+	"IncorrectImageStatus",
 }
 
 func (s *stepCreateApsaraStackImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -29,8 +37,10 @@ func (s *stepCreateApsaraStackImage) Run(ctx context.Context, state multistep.St
 	client := state.Get("client").(*ClientWrapper)
 	ui := state.Get("ui").(packer.Ui)
 
+	// With image_encrypted set, the image is copied onto itself and only the
+	// copy is kept.
 	tempImageName := config.ApsaraStackImageName
-	if config.ImageEncrypted.True() {
+	if config.ImageEncrypted != confighelper.TriUnset {
 		tempImageName = fmt.Sprintf("packer_%s", random.AlphaNum(7))
 		ui.Say(fmt.Sprintf("Creating temporary image for encryption: %s", tempImageName))
 	} else {
@@ -38,20 +48,35 @@ func (s *stepCreateApsaraStackImage) Run(ctx context.Context, state multistep.St
 	}
 
 	createImageRequest := s.buildCreateImageRequest(state, tempImageName)
+	s.instanceId = createImageRequest.InstanceId
+	s.startTime = time.Now()
+	retryErrors := append(append([]string{}, createImageRetryErrors...), s.RetryErrorCodes...)
+	evalRetry := client.EvalCouldRetryResponse(retryErrors, EvalRetryErrorType)
 	createImageResponse, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return client.CreateImage(createImageRequest)
 		},
-		EvalFunc: client.EvalCouldRetryResponse(createImageRetryErrors, EvalRetryErrorType),
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			result := evalRetry(response, err)
+			var e errors.Error
+			if stderrors.As(err, &e) && result == WaitForExpectToRetry {
+				log.Printf("[DEBUG] Retrying to create image after error code %s", e.ErrorCode())
+			}
+			return result
+		},
 	})
 
 	if err != nil {
+		if config.ApsaraStackImageFamily != "" && errorCodeContains(err, "ImageFamily") {
+			err = fmt.Errorf("%w, the image can't be added to the image family %s", err, config.ApsaraStackImageFamily)
+		}
 		return halt(state, err, "Error creating image")
 	}
 
 	imageId := createImageResponse.(*ecs.CreateImageResponse).ImageId
 
-	imagesResponse, err := client.WaitForImageStatus(config.ApsaraStackRegion, imageId, ImageStatusAvailable, time.Duration(s.WaitSnapshotReadyTimeout)*time.Second, state)
+	imagesResponse, err := client.WaitForImageStatus(ctx, config.ApsaraStackRegion, imageId, ImageStatusAvailable, time.Duration(s.WaitSnapshotReadyTimeout)*time.Second, state)
 
 	// save image first for cleaning up if timeout
 	images := imagesResponse.(*ecs.DescribeImagesResponse).Images.Image
@@ -64,6 +89,21 @@ func (s *stepCreateApsaraStackImage) Run(ctx context.Context, state multistep.St
 		return halt(state, err, "Timeout waiting for image to be created")
 	}
 
+	if config.ApsaraStackImageBootMode != "" {
+		ui.Say(fmt.Sprintf("Setting boot mode of image %s to %s...", imageId, config.ApsaraStackImageBootMode))
+
+		request := ecs.CreateModifyImageAttributeRequest()
+		request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+		request.RegionId = config.ApsaraStackRegion
+		request.ImageId = imageId
+		request.BootMode = config.ApsaraStackImageBootMode
+		if _, err := client.ModifyImageAttribute(request); err != nil {
+			return halt(state, err, "Error setting boot mode of image")
+		}
+	}
+
 	var snapshotIds []string
 	for _, device := range images[0].DiskDeviceMappings.DiskDeviceMapping {
 		snapshotIds = append(snapshotIds, device.SnapshotId)
@@ -80,15 +120,21 @@ func (s *stepCreateApsaraStackImage) Run(ctx context.Context, state multistep.St
 }
 
 func (s *stepCreateApsaraStackImage) Cleanup(state multistep.StateBag) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	// The snapshots of an image which can't be deleted are kept.
+	keep := make(map[string]bool)
+	if s.CleanupSnapshotsOnFailure && (cancelled || halted) {
+		// The image may not even exist, so this runs after it's deleted.
+		defer func() { s.deleteIntermediateSnapshots(state, keep) }()
+	}
+
 	if s.image == nil {
 		return
 	}
 
 	config := state.Get("config").(*Config)
-	encryptedSet := config.ImageEncrypted.True()
-
-	_, cancelled := state.GetOk(multistep.StateCancelled)
-	_, halted := state.GetOk(multistep.StateHalted)
+	encryptedSet := config.ImageEncrypted != confighelper.TriUnset
 
 	if !cancelled && !halted && !encryptedSet {
 		return
@@ -97,6 +143,12 @@ func (s *stepCreateApsaraStackImage) Cleanup(state multistep.StateBag) {
 	client := state.Get("client").(*ClientWrapper)
 	ui := state.Get("ui").(packer.Ui)
 
+	if keepForResume(state, s.image.ImageId) {
+		ui.Message(fmt.Sprintf("Keeping image %s for resume_state_file", s.image.ImageId))
+		recordCleanup(state, "image", s.image.ImageId, errKeptForResume)
+		return
+	}
+
 	if !cancelled && !halted && encryptedSet {
 		ui.Say(fmt.Sprintf("Deleting temporary image %s(%s) and related snapshots after finishing encryption...", s.image.ImageId, s.image.ImageName))
 	} else {
@@ -109,8 +161,13 @@ func (s *stepCreateApsaraStackImage) Cleanup(state multistep.StateBag) {
 
 	deleteImageRequest.RegionId = config.ApsaraStackRegion
 	deleteImageRequest.ImageId = s.image.ImageId
-	if _, err := client.DeleteImage(deleteImageRequest); err != nil {
+	_, err := client.DeleteImage(deleteImageRequest)
+	recordCleanup(state, "image", s.image.ImageId, err)
+	if err != nil {
 		ui.Error(fmt.Sprintf("Error deleting image, it may still be around: %s", err))
+		for _, diskDevices := range s.image.DiskDeviceMappings.DiskDeviceMapping {
+			keep[diskDevices.SnapshotId] = true
+		}
 		return
 	}
 
@@ -121,13 +178,71 @@ func (s *stepCreateApsaraStackImage) Cleanup(state multistep.StateBag) {
 		deleteSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
 		deleteSnapshotRequest.SnapshotId = diskDevices.SnapshotId
-		if _, err := client.DeleteSnapshot(deleteSnapshotRequest); err != nil {
+		_, err := client.DeleteSnapshot(deleteSnapshotRequest)
+		recordCleanup(state, "snapshot", diskDevices.SnapshotId, err)
+		if err != nil {
 			ui.Error(fmt.Sprintf("Error deleting snapshot, it may still be around: %s", err))
-			return
 		}
 	}
 }
 
+// deleteIntermediateSnapshots deletes the snapshots of the instance which
+// were created since the image creation started, except for those to keep.
+func (s *stepCreateApsaraStackImage) deleteIntermediateSnapshots(state multistep.StateBag, keep map[string]bool) {
+	if s.instanceId == "" || s.startTime.IsZero() {
+		return
+	}
+
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+
+	request := ecs.CreateDescribeSnapshotsRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.InstanceId = s.instanceId
+	response, err := client.DescribeSnapshots(request)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error querying the snapshots of instance %s, they may be left behind: %s", s.instanceId, err))
+		return
+	}
+
+	for _, snapshot := range response.Snapshots.Snapshot {
+		if keep[snapshot.SnapshotId] || !snapshotCreatedSince(snapshot, s.startTime) {
+			continue
+		}
+
+		log.Printf("[INFO] Deleting snapshot %s left behind by the image creation", snapshot.SnapshotId)
+		deleteRequest := ecs.CreateDeleteSnapshotRequest()
+		deleteRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+		deleteRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+		deleteRequest.SnapshotId = snapshot.SnapshotId
+		_, err := client.DeleteSnapshot(deleteRequest)
+		recordCleanup(state, "snapshot", snapshot.SnapshotId, err)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error deleting snapshot %s, it may still be around: %s", snapshot.SnapshotId, err))
+			continue
+		}
+		ui.Message(fmt.Sprintf("Deleted snapshot %s", snapshot.SnapshotId))
+	}
+}
+
+// snapshotCreatedSince reports whether the snapshot was created after the
+// time, allowing for a minute of clock skew. Snapshots with an unknown
+// creation time are never reported.
+func snapshotCreatedSince(snapshot ecs.Snapshot, since time.Time) bool {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00"} {
+		if created, err := time.Parse(layout, snapshot.CreationTime); err == nil {
+			return !created.Before(since.Add(-time.Minute))
+		}
+	}
+
+	return false
+}
+
 func (s *stepCreateApsaraStackImage) buildCreateImageRequest(state multistep.StateBag, imageName string) *ecs.CreateImageRequest {
 	config := state.Get("config").(*Config)
 
@@ -135,14 +250,31 @@ func (s *stepCreateApsaraStackImage) buildCreateImageRequest(state multistep.Sta
 	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-	request.ClientToken = uuid.TimeOrderedUUID()
+	request.ClientToken = clientToken(state, "CreateImage:"+imageName)
 	request.RegionId = config.ApsaraStackRegion
 	request.ImageName = imageName
 	request.ImageVersion = config.ApsaraStackImageVersion
 	request.Description = config.ApsaraStackImageDescription
-	if s.ApsaraStackImageIgnoreDataDisks {
-		snapshotId := state.Get("ApsaraStacksnapshot").(string)
-		request.SnapshotId = snapshotId
+	request.ImageFamily = config.ApsaraStackImageFamily
+	if config.ApsaraStackImageLicenseType != "" {
+		request.QueryParams["LicenseType"] = config.ApsaraStackImageLicenseType
+	}
+	// The retained data disk snapshots are the data disks of the image, the
+	// other data disks of the instance aren't included.
+	if dataDiskSnapshots, ok := state.GetOk("ApsaraStackdatadisksnapshots"); ok {
+		diskDeviceMappings := []ecs.CreateImageDiskDeviceMapping{
+			{SnapshotId: state.Get("ApsaraStacksnapshot").(string), DiskType: DiskTypeSystem},
+		}
+		for _, device := range config.ApsaraStackImageDataDiskSnapshots {
+			diskDeviceMappings = append(diskDeviceMappings, ecs.CreateImageDiskDeviceMapping{
+				SnapshotId: dataDiskSnapshots.(map[string]string)[device],
+				DiskType:   DiskTypeData,
+				Device:     device,
+			})
+		}
+		request.DiskDeviceMapping = &diskDeviceMappings
+	} else if s.ApsaraStackImageIgnoreDataDisks {
+		request.SnapshotId = state.Get("ApsaraStacksnapshot").(string)
 	} else {
 		instance := state.Get("instance").(*ecs.Instance)
 		request.InstanceId = instance.InstanceId
@@ -0,0 +1,223 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	confighelper "github.com/hashicorp/packer/helper/config"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepCreateImage_retryIncorrectImageStatus(t *testing.T) {
+	var tokens []string
+	images := make(map[string]string)
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateImage":
+			token := params.Get("ClientToken")
+			tokens = append(tokens, token)
+			if len(tokens) == 1 {
+				// Another build in the region is creating an image.
+				return http.StatusForbidden, testErrorBody("IncorrectImageStatus")
+			}
+			if _, ok := images[token]; !ok {
+				images[token] = fmt.Sprintf("m-test%d", len(images))
+			}
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"` + images[token] + `"}`
+		case "DescribeImages":
+			return http.StatusOK, fmt.Sprintf(`{"RequestId":"test-request","Images":{"Image":[{"ImageId":"%s","Status":"Available"}]}}`, params.Get("ImageId"))
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	config.ApsaraStackImageName = "packer-test"
+	state := testState(client, config)
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepCreateApsaraStackImage{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v, err: %s", action, state.Get("error"))
+	}
+	if len(tokens) != 2 || tokens[0] != tokens[1] {
+		t.Fatalf("the retry should reuse the client token: %v", tokens)
+	}
+	if len(images) != 1 || state.Get("ApsaraStackimage").(string) != "m-test0" {
+		t.Fatalf("only one image should be created: %v, actual: %s", images, state.Get("ApsaraStackimage"))
+	}
+}
+
+func TestStepCreateImage_lostResponse(t *testing.T) {
+	var lock sync.Mutex
+	var tokens []string
+	images := make(map[string]string)
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateImage":
+			lock.Lock()
+			token := params.Get("ClientToken")
+			tokens = append(tokens, token)
+			if _, ok := images[token]; !ok {
+				images[token] = fmt.Sprintf("m-test%d", len(images))
+			}
+			attempt, imageId := len(tokens), images[token]
+			lock.Unlock()
+			if attempt == 1 {
+				// The image is created but the response doesn't make it
+				// back in time.
+				time.Sleep(200 * time.Millisecond)
+			}
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"` + imageId + `"}`
+		case "DescribeImages":
+			return http.StatusOK, fmt.Sprintf(`{"RequestId":"test-request","Images":{"Image":[{"ImageId":"%s","Status":"Available"}]}}`, params.Get("ImageId"))
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.SetReadTimeout(50 * time.Millisecond)
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	config.ApsaraStackImageName = "packer-test"
+	state := testState(client, config)
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepCreateApsaraStackImage{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v, err: %s", action, state.Get("error"))
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(tokens) != 2 || tokens[0] != tokens[1] {
+		t.Fatalf("the retry should reuse the client token: %v", tokens)
+	}
+	if len(images) != 1 || state.Get("ApsaraStackimage").(string) != "m-test0" {
+		t.Fatalf("exactly one image should be created: %v, actual: %s", images, state.Get("ApsaraStackimage"))
+	}
+}
+
+func TestStepCreateImage_cleanupIntermediateSnapshots(t *testing.T) {
+	var deleted []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeSnapshots":
+			if params.Get("InstanceId") != "i-test" {
+				t.Errorf("bad describe request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[
+				{"SnapshotId":"s-old","CreationTime":"2020-01-01T00:00:00Z"},
+				{"SnapshotId":"s-failed","CreationTime":"` + time.Now().UTC().Format(time.RFC3339) + `"}]}}`
+		case "DeleteSnapshot":
+			deleted = append(deleted, params.Get("SnapshotId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testState(client, &Config{})
+	state.Put(multistep.StateHalted, true)
+
+	step := &stepCreateApsaraStackImage{
+		CleanupSnapshotsOnFailure: true,
+		instanceId:                "i-test",
+		startTime:                 time.Now(),
+	}
+	step.Cleanup(state)
+	if len(deleted) != 1 || deleted[0] != "s-failed" {
+		t.Fatalf("only the snapshot of the failed image should be deleted: %v", deleted)
+	}
+
+	deleted = nil
+	state.Remove(multistep.StateHalted)
+	step.Cleanup(state)
+	if len(deleted) != 0 {
+		t.Fatalf("snapshots shouldn't be deleted after a successful build: %v", deleted)
+	}
+}
+
+func TestStepCreateImage_cleanupSummary(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DeleteImage":
+			return http.StatusForbidden, testErrorBody("Forbidden")
+		case "DescribeSnapshots":
+			return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	summary := &cleanupSummary{}
+	state := testState(client, &Config{})
+	state.Put("cleanup_summary", summary)
+	state.Put(multistep.StateHalted, true)
+
+	step := &stepCreateApsaraStackImage{image: &ecs.Image{ImageId: "m-test"}}
+	step.Cleanup(state)
+	if leaked := summary.leaked(); len(leaked) != 1 || leaked[0].kind != "image" || leaked[0].id != "m-test" {
+		t.Fatalf("the image which failed to be deleted should be left behind: %v", leaked)
+	}
+}
+
+func TestStepCreateImage_cleanupUnencrypted(t *testing.T) {
+	var deleted []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DeleteImage":
+			deleted = append(deleted, params.Get("ImageId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DeleteSnapshot":
+			deleted = append(deleted, params.Get("SnapshotId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	// The unencrypted copy replaces the image, as an encrypted one would.
+	config.ImageEncrypted = confighelper.TriFalse
+	state := testState(client, config)
+
+	step := &stepCreateApsaraStackImage{image: &ecs.Image{ImageId: "m-test"}}
+	step.image.DiskDeviceMappings.DiskDeviceMapping = []ecs.DiskDeviceMapping{{SnapshotId: "s-test"}}
+	step.Cleanup(state)
+	if !reflect.DeepEqual(deleted, []string{"m-test", "s-test"}) {
+		t.Fatalf("the image and its snapshots should be deleted once copied: %v", deleted)
+	}
+}
+
+func TestStepCreateImage_dataDiskSnapshots(t *testing.T) {
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	config.ApsaraStackImageDataDiskSnapshots = []string{"/dev/xvdc"}
+	state := testState(nil, config)
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+	state.Put("ApsaraStacksnapshot", "s-system")
+	state.Put("ApsaraStackdatadisksnapshots", map[string]string{"/dev/xvdc": "s-xvdc"})
+
+	step := &stepCreateApsaraStackImage{}
+	request := step.buildCreateImageRequest(state, "packer-test")
+	if request.InstanceId != "" || request.SnapshotId != "" || request.DiskDeviceMapping == nil {
+		t.Fatalf("the image should be built from the snapshots: %#v", request)
+	}
+	expected := []ecs.CreateImageDiskDeviceMapping{
+		{SnapshotId: "s-system", DiskType: DiskTypeSystem},
+		{SnapshotId: "s-xvdc", DiskType: DiskTypeData, Device: "/dev/xvdc"},
+	}
+	if !reflect.DeepEqual(*request.DiskDeviceMapping, expected) {
+		t.Fatalf("bad disk device mapping: %#v", *request.DiskDeviceMapping)
+	}
+}
@@ -3,18 +3,24 @@ package ecs
 import (
 	"context"
 	"encoding/base64"
+	stderrors "errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/hashicorp/packer/common/uuid"
-
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	confighelper "github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
+	"github.com/hashicorp/packer/template/interpolate"
 )
 
 type stepCreateApsaraStackInstance struct {
@@ -22,49 +28,120 @@ type stepCreateApsaraStackInstance struct {
 	InstanceType            string
 	UserData                string
 	UserDataFile            string
+	UserDataParts           []ApsaraStackUserDataPart
+	BootstrapCommands       []string
+	UserDataCompress        bool
 	instanceId              string
 	RegionId                string
 	InternetChargeType      string
-	InternetMaxBandwidthOut int
+	InternetMaxBandwidthOut *int
 	InstanceName            string
 	ZoneId                  string
+	RetryErrorCodes         []string
+	CreateTimeout           time.Duration
+	CleanupRetryTimes       int
+	CleanupRetryInterval    time.Duration
+	CleanupRetryErrorCodes  []string
+	CleanupForceStop        bool
+	CleanupOrphanedDisks    bool
+	SnapshotOnCleanup       bool
+	SnapshotRetentionDays   int
+	WaitSnapshotTimeout     int
 	instance                *ecs.Instance
 }
 
 var createInstanceRetryErrors = []string{
 	"IdempotentProcessing",
+	errors.TimeoutErrorCode,
 }
 
+// How long cleanup waits for a force stopped instance to be stopped before
+// deleting it anyway.
+const cleanupForceStopTimeout = 2 * time.Minute
+
 var deleteInstanceRetryErrors = []string{
 	"IncorrectInstanceStatus.Initializing",
 }
 
+var deleteDiskRetryErrors = []string{
+	"IncorrectDiskStatus",
+}
+
+var snapshotOnCleanupRetryErrors = []string{
+	"IncorrectDiskStatus",
+	"IncorrectInstanceStatus",
+}
+
 func (s *stepCreateApsaraStackInstance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	client := state.Get("client").(*ClientWrapper)
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
+	if state.Get("networktype").(InstanceNetWork) != InstanceNetworkVpc {
+		ui.Say("Warning: the instance is created in the classic network, which is deprecated and no longer " +
+			"supported by newer ApsaraStack releases. Set vpc_id or vswitch_id to build in a VPC, and " +
+			"forbid_classic_network to enforce it.")
+	}
+
 	ui.Say("Creating instance...")
 	createInstanceRequest, err := s.buildCreateInstanceRequest(state)
 	if err != nil {
 		return halt(state, err, "")
 	}
 
+	retryErrors := append(append([]string{}, createInstanceRetryErrors...), s.RetryErrorCodes...)
+	evalRetry := client.EvalCouldRetryResponse(retryErrors, EvalRetryErrorType)
 	createInstanceResponse, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
 		RequestFunc: func() (responses.AcsResponse, error) {
 			return client.CreateInstance(createInstanceRequest)
 		},
-		EvalFunc: client.EvalCouldRetryResponse(createInstanceRetryErrors, EvalRetryErrorType),
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			result := evalRetry(response, err)
+			var e errors.Error
+			if stderrors.As(err, &e) && result == WaitForExpectToRetry {
+				log.Printf("[DEBUG] Retrying to create instance after error code %s", e.ErrorCode())
+			}
+			return result
+		},
 	})
 
 	if err != nil {
+		if createInstanceRequest.DeploymentSetId != "" && errorCodeContains(err, "DeploymentSet") {
+			err = fmt.Errorf("the instance can't be placed in deployment set %s, check that the deployment set "+
+				"isn't full and is in the same zone as zone_id %s: %w", createInstanceRequest.DeploymentSetId, createInstanceRequest.ZoneId, err)
+		}
+		if createInstanceRequest.DedicatedHostId != "" && errorCodeContains(err, "DedicatedHost") {
+			err = fmt.Errorf("the instance can't be placed on dedicated host %s, check that the host has enough "+
+				"free capacity for %s and is in the zone %s of the instance: %w", createInstanceRequest.DedicatedHostId,
+				createInstanceRequest.InstanceType, createInstanceRequest.ZoneId, err)
+		}
+		if createInstanceRequest.RamRoleName != "" && errorCodeContains(err, "RamRole") {
+			err = fmt.Errorf("the RAM role %s can't be attached to the instance, check that it exists and can be "+
+				"assumed by ECS, verify_ram_role checks this before the build: %w", createInstanceRequest.RamRoleName, err)
+		}
 		return halt(state, err, "Error creating instance")
 	}
 
 	instanceId := createInstanceResponse.(*ecs.CreateInstanceResponse).InstanceId
+	// Keep track of the instance as soon as it exists, so that it's still
+	// deleted on cleanup if any of the following calls fail.
+	s.instanceId = instanceId
+
+	// CreateInstance doesn't take a release time, it's set right after.
+	if config.InstanceAutoReleaseTime != "" {
+		if err := setInstanceAutoReleaseTime(client, config, instanceId); err != nil {
+			return halt(state, err, "Error setting auto release time of instance")
+		}
+		ui.Message(fmt.Sprintf("Instance %s is released automatically at %s", instanceId, config.InstanceAutoReleaseTime))
+	}
 
-	_, err = client.WaitForInstanceStatus(s.RegionId, instanceId, InstanceStatusStopped, state)
+	waitStart := time.Now()
+	_, err = client.WaitForInstanceStatus(ctx, s.RegionId, instanceId, InstanceStatusStopped, s.CreateTimeout, config.StatusPollInterval, state)
 	if err != nil {
+		if _, ok := err.(*instanceReclaimedError); !ok {
+			err = fmt.Errorf("instance %s isn't %s after %s: %w", instanceId, InstanceStatusStopped, time.Since(waitStart).Round(time.Second), err)
+		}
 		return halt(state, err, "Error waiting create instance")
 	}
 
@@ -79,17 +156,35 @@ func (s *stepCreateApsaraStackInstance) Run(ctx context.Context, state multistep
 	}
 
 	ui.Message(fmt.Sprintf("Created instance: %s", instanceId))
+	if config.VerifyTagsVisible && createInstanceRequest.Tag != nil {
+		tags := make(map[string]string)
+		for _, tag := range *createInstanceRequest.Tag {
+			tags[tag.Key] = tag.Value
+		}
+		ui.Message(fmt.Sprintf("Waiting for tags to be visible on instance: %s", instanceId))
+		if _, err := client.WaitForTagsVisible(ctx, s.RegionId, TagResourceInstance, instanceId, tags, config.TagVisibilityTimeout, state); err != nil {
+			ui.Error(fmt.Sprintf("Timeout waiting for tags to be visible on instance %s: %s", instanceId, err))
+		}
+	}
 	s.instance = &instances.Instances.Instance[0]
 	state.Put("instance", s.instance)
+	saveResumeState(state)
 	// instance_id is the generic term used so that users can have access to the
 	// instance id inside of the provisioners, used in step_provision.
 	state.Put("instance_id", instanceId)
+	if privateIp := instancePrivateIp(s.instance); privateIp != "" {
+		state.Put("private_ip", privateIp)
+	}
 
 	return multistep.ActionContinue
 }
 
 func (s *stepCreateApsaraStackInstance) Cleanup(state multistep.StateBag) {
-	if s.instance == nil {
+	if s.instanceId == "" {
+		return
+	}
+	if keepResource(state, "instance", s.instanceId) {
+		s.reportKeptInstance(state)
 		return
 	}
 	cleanUpMessage(state, "instance")
@@ -98,22 +193,328 @@ func (s *stepCreateApsaraStackInstance) Cleanup(state multistep.StateBag) {
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
+	// Detach the RAM role first, so that the attachment doesn't outlive an
+	// instance which fails to be deleted.
+	if config.RamRoleName != "" {
+		if err := detachInstanceRamRole(client, config, s.instanceId); err != nil {
+			ui.Say(fmt.Sprintf("Failed to detach RAM role %s from instance %s: %s", config.RamRoleName, s.instanceId, err))
+		}
+	}
+
+	// The disks have to be looked up while they are still attached.
+	var orphanedDisks []string
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if s.CleanupOrphanedDisks && (cancelled || halted) {
+		disks, err := s.describeOrphanedDisks(state)
+		if err != nil {
+			ui.Say(fmt.Sprintf("Failed to describe the data disks of instance %s, they may be left behind: %s", s.instanceId, err))
+		}
+		orphanedDisks = disks
+	}
+
+	// The snapshot is taken before the instance is stopped, so that it's
+	// there even if stopping hangs.
+	if s.SnapshotOnCleanup && halted {
+		s.snapshotSystemDisk(state)
+	}
+
+	if s.CleanupForceStop {
+		s.forceStop(state)
+	}
+
+	// Subscription instances can't be deleted before they expire, they are
+	// switched to pay as you go first.
+	if config.InstanceChargeType == InstanceChargeTypePrePaid {
+		if err := s.convertToPostPaid(state); err != nil {
+			ui.Say(fmt.Sprintf("Failed to switch instance %s to %s before deleting it: %s", s.instanceId, InstanceChargeTypePostPaid, err))
+		}
+	}
+
+	retryTimes := s.CleanupRetryTimes
+	if retryTimes == 0 {
+		retryTimes = shortRetryTimes
+	}
+	retryErrors := append(append([]string{}, deleteInstanceRetryErrors...), s.CleanupRetryErrorCodes...)
+	evalRetry := client.EvalCouldRetryResponse(retryErrors, EvalRetryErrorType)
+	attempt := 0
 	_, err := client.WaitForExpected(&WaitForExpectArgs{
 		RequestFunc: func() (responses.AcsResponse, error) {
+			attempt++
 			request := ecs.CreateDeleteInstanceRequest()
 			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-			request.InstanceId = s.instance.InstanceId
+			request.InstanceId = s.instanceId
 			request.Force = requests.NewBoolean(true)
 			return client.DeleteInstance(request)
 		},
-		EvalFunc:   client.EvalCouldRetryResponse(deleteInstanceRetryErrors, EvalRetryErrorType),
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			result := evalRetry(response, err)
+			if e, ok := err.(errors.Error); ok && result == WaitForExpectToRetry {
+				log.Printf("[DEBUG] Retrying to delete instance %s after error code %s, attempt %d of %d", s.instanceId, e.ErrorCode(), attempt, retryTimes)
+			}
+			return result
+		},
+		RetryTimes:    retryTimes,
+		RetryInterval: s.CleanupRetryInterval,
+	})
+
+	recordCleanup(state, "instance", s.instanceId, err)
+	if err != nil {
+		ui.Say(fmt.Sprintf("Failed to clean up instance %s: %s", s.instanceId, err))
+		return
+	}
+
+	// The security group, the vswitch and the VPC are cleaned up next, they
+	// can't be deleted while the instance is still around.
+	deleteTimeout := time.Duration(APSARASTACK_DEFAULT_SHORT_TIMEOUT) * time.Second
+	if _, err := client.WaitForInstanceDeleted(context.Background(), s.RegionId, s.instanceId, deleteTimeout, s.CleanupRetryInterval, state); err != nil {
+		ui.Say(fmt.Sprintf("Timeout waiting for instance %s to be deleted, the resources it uses may fail to be cleaned up: %s", s.instanceId, err))
+	}
+
+	for _, diskId := range orphanedDisks {
+		s.deleteOrphanedDisk(state, diskId)
+	}
+}
+
+// snapshotSystemDisk keeps a snapshot of the system disk of a failed build,
+// errors are only reported so that the instance is deleted anyway.
+func (s *stepCreateApsaraStackInstance) snapshotSystemDisk(state multistep.StateBag) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	describeDisksRequest := ecs.CreateDescribeDisksRequest()
+	describeDisksRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeDisksRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describeDisksRequest.RegionId = s.RegionId
+	describeDisksRequest.InstanceId = s.instanceId
+	describeDisksRequest.DiskType = DiskTypeSystem
+	disks, err := client.DescribeDisks(describeDisksRequest)
+	if err == nil && len(disks.Disks.Disk) == 0 {
+		err = fmt.Errorf("no system disk found")
+	}
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to find the system disk of instance %s, deleting it without a snapshot: %s", s.instanceId, err))
+		return
+	}
+	diskId := disks.Disks.Disk[0].DiskId
+
+	ui.Say(fmt.Sprintf("Creating a snapshot of system disk %s of the failed build...", diskId))
+	response, err := client.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			request := ecs.CreateCreateSnapshotRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			// Unlike the snapshot of the image, this one outlives the build.
+			request.ClientToken = clientToken(state, "CreateSnapshot:"+diskId+":cleanup")
+			request.RegionId = s.RegionId
+			request.DiskId = diskId
+			request.SnapshotName = fmt.Sprintf("packer-cleanup-%s", s.instanceId)
+			request.Description = fmt.Sprintf("System disk of instance %s of a failed Packer build", s.instanceId)
+			if s.SnapshotRetentionDays > 0 {
+				request.RetentionDays = requests.NewInteger(s.SnapshotRetentionDays)
+			}
+			return client.CreateSnapshot(request)
+		},
+		EvalFunc:   client.EvalCouldRetryResponse(snapshotOnCleanupRetryErrors, EvalRetryErrorType),
 		RetryTimes: shortRetryTimes,
 	})
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to create a snapshot of system disk %s, deleting instance %s without it: %s", diskId, s.instanceId, err))
+		return
+	}
+
+	snapshotId := response.(*ecs.CreateSnapshotResponse).SnapshotId
+	retained := errKeptOnCleanup
+	if s.SnapshotRetentionDays > 0 {
+		retained = fmt.Errorf("%w for %d days", errKeptOnCleanup, s.SnapshotRetentionDays)
+	}
+	recordCleanup(state, "snapshot", snapshotId, retained)
+
+	// The disk goes away with the instance, it has to stay until the
+	// snapshot is done.
+	timeout := time.Duration(s.WaitSnapshotTimeout) * time.Second
+	if _, err := client.WaitForSnapshotStatus(context.Background(), s.RegionId, snapshotId, SnapshotStatusAccomplished, timeout, state); err != nil {
+		ui.Error(fmt.Sprintf("Snapshot %s of system disk %s may be incomplete, deleting instance %s anyway: %s", snapshotId, diskId, s.instanceId, err))
+		return
+	}
+	ui.Message(fmt.Sprintf("Created snapshot %s of system disk %s, it's kept after instance %s is deleted", snapshotId, diskId, s.instanceId))
+}
+
+// convertToPostPaid switches the subscription instance and its data disks
+// to pay as you go, so that the instance can be deleted.
+func (s *stepCreateApsaraStackInstance) convertToPostPaid(state multistep.StateBag) error {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	request := ecs.CreateModifyInstanceChargeTypeRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
+	request.ClientToken = clientToken(state, "ModifyInstanceChargeType:"+s.instanceId)
+	request.RegionId = s.RegionId
+	request.InstanceIds = fmt.Sprintf("[\"%s\"]", s.instanceId)
+	request.InstanceChargeType = InstanceChargeTypePostPaid
+	request.IncludeDataDisks = requests.NewBoolean(true)
+	request.AutoPay = requests.NewBoolean(true)
+	_, err := client.ModifyInstanceChargeType(request)
+	return err
+}
+
+// reportKeptInstance tells how to connect to the instance kept by
+// keep_instance_on_error. A temporary private key is written to a file,
+// it only lives in memory otherwise.
+func (s *stepCreateApsaraStackInstance) reportKeptInstance(state multistep.StateBag) {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Error(fmt.Sprintf("The build failed and keep_instance_on_error is set, the instance %s is kept running. "+
+		"It and the resources it uses have to be deleted by hand!", s.instanceId))
+
+	host := ""
+	if ipAddress, ok := state.GetOk("ipaddress"); ok {
+		host = ipAddress.(string)
+		ui.Message(fmt.Sprintf("Public IP: %s", host))
+	}
+	if privateIp, ok := state.GetOk("private_ip"); ok {
+		if host == "" || config.SSHPrivateIp {
+			host = privateIp.(string)
+		}
+		ui.Message(fmt.Sprintf("Private IP: %s", privateIp))
+	}
+	if host == "" {
+		return
+	}
+
+	// The hint says where the credentials come from, the secrets aren't
+	// shown.
+	comm := config.Comm
+	switch comm.Type {
+	case "winrm":
+		ui.Message(fmt.Sprintf("Connect with WinRM to %s:%d as %s, with %s", host, comm.Port(), comm.WinRMUser, passwordHint(config)))
+	case "ssh":
+		keyFile := comm.SSHPrivateKeyFile
+		if keyFile == "" && len(comm.SSHPrivateKey) > 0 {
+			// Like the debug mode of Packer's own key pair steps, the
+			// temporary private key is only saved with -debug.
+			if !config.PackerDebug {
+				ui.Message(fmt.Sprintf("Connect with: ssh -p %d %s@%s, with the private key of the temporary key pair %s. "+
+					"It's only kept in memory, run the build with -debug to save it.", comm.Port(), comm.SSHUsername, host, comm.SSHTemporaryKeyPairName))
+				return
+			}
+			path, err := filepath.Abs(fmt.Sprintf("ecs_%s.pem", s.instanceId))
+			if err == nil {
+				err = ioutil.WriteFile(path, comm.SSHPrivateKey, 0600)
+			}
+			if err != nil {
+				ui.Error(fmt.Sprintf("Error saving the private key of the instance: %s", err))
+				return
+			}
+			ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", path))
+			keyFile = path
+		}
+		switch {
+		case keyFile != "":
+			ui.Message(fmt.Sprintf("Connect with: ssh -i %s -p %d %s@%s", keyFile, comm.Port(), comm.SSHUsername, host))
+		case comm.SSHAgentAuth:
+			ui.Message(fmt.Sprintf("Connect with: ssh -p %d %s@%s, with the keys of your SSH agent", comm.Port(), comm.SSHUsername, host))
+		default:
+			ui.Message(fmt.Sprintf("Connect with: ssh -p %d %s@%s, with %s", comm.Port(), comm.SSHUsername, host, passwordHint(config)))
+		}
+	}
+}
+
+// passwordHint tells where the password of the communicator comes from,
+// without the password itself.
+func passwordHint(config *Config) string {
+	if config.passwordGenerated {
+		return "the password generated for the build"
+	}
+
+	return "the password from the template"
+}
+
+// describeOrphanedDisks lists the data disks of the instance which aren't
+// deleted along with it.
+func (s *stepCreateApsaraStackInstance) describeOrphanedDisks(state multistep.StateBag) ([]string, error) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	request := ecs.CreateDescribeDisksRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = s.RegionId
+	request.InstanceId = s.instanceId
+	request.DiskType = DiskTypeData
+	response, err := client.DescribeDisks(request)
 	if err != nil {
-		ui.Say(fmt.Sprintf("Failed to clean up instance %s: %s", s.instance.InstanceId, err))
+		return nil, err
+	}
+
+	var diskIds []string
+	for _, disk := range response.Disks.Disk {
+		if !disk.DeleteWithInstance {
+			diskIds = append(diskIds, disk.DiskId)
+		}
+	}
+
+	return diskIds, nil
+}
+
+// deleteOrphanedDisk deletes a data disk left behind by the deleted
+// instance, retrying while the disk is still being detached.
+func (s *stepCreateApsaraStackInstance) deleteOrphanedDisk(state multistep.StateBag, diskId string) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	_, err := client.WaitForExpected(&WaitForExpectArgs{
+		RequestFunc: func() (responses.AcsResponse, error) {
+			request := ecs.CreateDeleteDiskRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			request.DiskId = diskId
+			return client.DeleteDisk(request)
+		},
+		EvalFunc:      client.EvalCouldRetryResponse(deleteDiskRetryErrors, EvalRetryErrorType),
+		RetryTimes:    shortRetryTimes,
+		RetryInterval: s.CleanupRetryInterval,
+	})
+	recordCleanup(state, "data disk", diskId, err)
+	if err != nil {
+		ui.Say(fmt.Sprintf("Failed to delete data disk %s of instance %s, it may still be around: %s", diskId, s.instanceId, err))
+		return
+	}
+
+	ui.Message(fmt.Sprintf("Deleted data disk %s of instance %s", diskId, s.instanceId))
+}
+
+// forceStop stops the instance without waiting for the guest OS to shut
+// down, which makes deleting it quicker. Errors are only logged as the
+// instance is deleted with force anyway.
+func (s *stepCreateApsaraStackInstance) forceStop(state multistep.StateBag) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	request := ecs.CreateStopInstanceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.InstanceId = s.instanceId
+	request.ForceStop = requests.NewBoolean(true)
+	if _, err := client.StopInstance(request); err != nil {
+		log.Printf("[DEBUG] Failed to force stop instance %s before deleting it: %s", s.instanceId, err)
+		return
+	}
+
+	if _, err := client.WaitForInstanceStatus(context.Background(), s.RegionId, s.instanceId, InstanceStatusStopped, cleanupForceStopTimeout, config.StatusPollInterval, state); err != nil {
+		log.Printf("[DEBUG] Instance %s isn't stopped before deleting it: %s", s.instanceId, err)
 	}
 }
 
@@ -123,40 +524,125 @@ func (s *stepCreateApsaraStackInstance) buildCreateInstanceRequest(state multist
 	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
-	request.ClientToken = uuid.TimeOrderedUUID()
+	request.ClientToken = clientToken(state, "CreateInstance")
 	request.RegionId = s.RegionId
 	request.InstanceType = s.InstanceType
+	if config.Cpu > 0 {
+		// The SDK has no fields for Cpu and Memory yet.
+		request.QueryParams["Cpu"] = strconv.Itoa(config.Cpu)
+		request.QueryParams["Memory"] = strconv.Itoa(config.Memory)
+	}
 	request.InstanceName = s.InstanceName
+	request.Description = config.InstanceDescription
+	request.HostName = config.HostName
 	request.ZoneId = s.ZoneId
+	if zoneId, ok := state.GetOk("zoneid"); ok && s.ZoneId == "" {
+		request.ZoneId = zoneId.(string)
+	}
+	request.RamRoleName = config.RamRoleName
+
+	var runTags []ecs.CreateInstanceTag
+	for _, key := range sortedKeys(config.RunTags) {
+		if config.RunTags[key] == "" {
+			continue
+		}
+		runTags = append(runTags, ecs.CreateInstanceTag{Key: key, Value: config.RunTags[key]})
+	}
+	if len(runTags) > 0 {
+		request.Tag = &runTags
+	}
+
+	request.DeploymentSetId = config.DeploymentSetId
+	if config.DedicatedHostId != "" {
+		request.DedicatedHostId = config.DedicatedHostId
+		request.Tenancy = TenancyHost
+	}
+	request.HttpTokens = config.MetadataTokenMode
+	if config.MetadataHopLimit > 0 {
+		request.HttpPutResponseHopLimit = requests.NewInteger(config.MetadataHopLimit)
+	}
 
 	sourceImage := state.Get("source_image").(*ecs.Image)
 	request.ImageId = sourceImage.ImageId
 
 	securityGroupId := state.Get("securitygroupid").(string)
-	request.SecurityGroupId = securityGroupId
+	if len(config.SecurityGroupIds) == 0 {
+		request.SecurityGroupId = securityGroupId
+	} else {
+		// SecurityGroupIds can't be combined with SecurityGroupId and the
+		// SDK has no field for it yet.
+		for i, id := range securityGroupIds(securityGroupId, config.SecurityGroupIds) {
+			request.QueryParams[fmt.Sprintf("SecurityGroupIds.%d", i+1)] = id
+		}
+	}
 
 	networkType := state.Get("networktype").(InstanceNetWork)
 	if networkType == InstanceNetworkVpc {
 		vswitchId := state.Get("vswitchid").(string)
 		request.VSwitchId = vswitchId
 
+		if config.PrivateIp != "" {
+			if cidr, ok := state.GetOk("vswitchcidr"); ok {
+				if err := validatePrivateIp(config.PrivateIp, cidr.(string)); err != nil {
+					return nil, fmt.Errorf("private_ip can't be used in vswitch %s: %s", vswitchId, err)
+				}
+			}
+			request.PrivateIpAddress = config.PrivateIp
+		}
+
+		if config.Ipv6AddressCount > 0 {
+			if cidr, ok := state.GetOk("vswitchipv6cidr"); ok && cidr.(string) == "" {
+				return nil, fmt.Errorf("vswitch %s has no IPv6 CIDR block, enable IPv6 on the vswitch to use ipv6_address_count", vswitchId)
+			}
+			// The SDK has no field for Ipv6AddressCount yet.
+			request.QueryParams["Ipv6AddressCount"] = strconv.Itoa(config.Ipv6AddressCount)
+		}
+
 		userData, err := s.getUserData(state)
 		if err != nil {
 			return nil, err
 		}
 
 		request.UserData = userData
-	} else {
+	}
+	// Classic instances always get a public IP, VPC instances only when asked
+	// to, otherwise they go through an EIP.
+	if networkType != InstanceNetworkVpc || config.AssociatePublicIpAddress {
 		if s.InternetChargeType == "" {
 			s.InternetChargeType = "PayByTraffic"
 		}
 
-		if s.InternetMaxBandwidthOut == 0 {
-			s.InternetMaxBandwidthOut = 5
+		if s.InternetMaxBandwidthOut == nil {
+			defaultBandwidthOut := 5
+			s.InternetMaxBandwidthOut = &defaultBandwidthOut
 		}
 	}
 	request.InternetChargeType = s.InternetChargeType
-	request.InternetMaxBandwidthOut = requests.Integer(convertNumber(s.InternetMaxBandwidthOut))
+	if s.InternetMaxBandwidthOut != nil {
+		if err := validateNumber("internet_max_bandwidth_out", *s.InternetMaxBandwidthOut); err != nil {
+			return nil, err
+		}
+		request.InternetMaxBandwidthOut = requests.NewInteger(*s.InternetMaxBandwidthOut)
+	}
+
+	if config.InstanceChargeType != "" {
+		request.InstanceChargeType = config.InstanceChargeType
+	}
+	if config.InstanceChargeType == InstanceChargeTypePrePaid {
+		request.AutoRenew = requests.NewBoolean(config.AutoRenew)
+		request.Period = requests.NewInteger(1)
+		if config.Period > 0 {
+			request.Period = requests.NewInteger(config.Period)
+		}
+		request.PeriodUnit = PeriodUnitMonth
+		if config.PeriodUnit != "" {
+			request.PeriodUnit = config.PeriodUnit
+		}
+	}
+	request.SpotStrategy = config.SpotStrategy
+	if config.SpotStrategy == SpotStrategySpotWithPriceLimit && config.SpotPriceLimit > 0 {
+		request.SpotPriceLimit = requests.Float(strconv.FormatFloat(config.SpotPriceLimit, 'f', -1, 64))
+	}
 
 	if s.IOOptimized.True() {
 		request.IoOptimized = IOOptimizedOptimized
@@ -169,27 +655,54 @@ func (s *stepCreateApsaraStackInstance) buildCreateInstanceRequest(state multist
 		password = config.Comm.WinRMPassword
 	}
 	request.Password = password
+	// Instances launched with a key pair are reached with the private key of
+	// the pair instead of a password.
+	request.KeyPairName = config.Comm.SSHKeyPairName
+	if keyPair, ok := state.GetOk("keyPair"); ok {
+		request.KeyPairName = keyPair.(string)
+	}
 
 	systemDisk := config.ApsaraStackImageConfig.ECSSystemDiskMapping
 	request.SystemDiskDiskName = systemDisk.DiskName
 	request.SystemDiskCategory = systemDisk.DiskCategory
+	if err := validateNumber("system_disk_mapping.disk_size", systemDisk.DiskSize); err != nil {
+		return nil, err
+	}
 	request.SystemDiskSize = requests.Integer(convertNumber(systemDisk.DiskSize))
 	request.SystemDiskDescription = systemDisk.Description
+	request.SystemDiskPerformanceLevel = systemDisk.PerformanceLevel
+	// The SDK has no fields for the system disk encryption yet.
+	if systemDisk.Encrypted != confighelper.TriUnset {
+		request.QueryParams["SystemDisk.Encrypted"] = strconv.FormatBool(systemDisk.Encrypted.True())
+	}
+	if systemDisk.KMSKeyId != "" {
+		request.QueryParams["SystemDisk.KMSKeyId"] = systemDisk.KMSKeyId
+	}
 
 	imageDisks := config.ApsaraStackImageConfig.ECSImagesDiskMappings
 	var dataDisks []ecs.CreateInstanceDataDisk
-	for _, imageDisk := range imageDisks {
+	for i, imageDisk := range imageDisks {
+		if err := validateNumber(fmt.Sprintf("image_disk_mappings[%d].disk_size", i), imageDisk.DiskSize); err != nil {
+			return nil, err
+		}
+
 		var dataDisk ecs.CreateInstanceDataDisk
 		dataDisk.DiskName = imageDisk.DiskName
 		dataDisk.Category = imageDisk.DiskCategory
-		dataDisk.Size = string(convertNumber(imageDisk.DiskSize))
+		// An unset size is left out, disks created from a snapshot take its
+		// size.
+		dataDisk.Size = convertNumber(imageDisk.DiskSize)
 		dataDisk.SnapshotId = imageDisk.SnapshotId
 		dataDisk.Description = imageDisk.Description
+		dataDisk.PerformanceLevel = imageDisk.PerformanceLevel
 		dataDisk.DeleteWithInstance = strconv.FormatBool(imageDisk.DeleteWithInstance)
 		dataDisk.Device = imageDisk.Device
 		if imageDisk.Encrypted != confighelper.TriUnset {
 			dataDisk.Encrypted = strconv.FormatBool(imageDisk.Encrypted.True())
 		}
+		if imageDisk.Encrypted.True() {
+			dataDisk.KMSKeyId = imageDisk.KMSKeyId
+		}
 
 		dataDisks = append(dataDisks, dataDisk)
 	}
@@ -198,22 +711,184 @@ func (s *stepCreateApsaraStackInstance) buildCreateInstanceRequest(state multist
 	return request, nil
 }
 
+// readUserDataFile reads a user data file, only files using the template
+// engine are rendered, so that files which happen to look like templates
+// keep working as before.
+func readUserDataFile(state multistep.StateBag, path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	userData := string(data)
+	if strings.Contains(userData, "{{") {
+		config := state.Get("config").(*Config)
+		userData, err = interpolate.Render(userData, &config.ctx)
+		if err != nil {
+			return "", fmt.Errorf("Error interpolating user data file %s: %s", path, err)
+		}
+	}
+
+	return userData, nil
+}
+
 func (s *stepCreateApsaraStackInstance) getUserData(state multistep.StateBag) (string, error) {
 	userData := s.UserData
 
+	// Prepare already rejects this, but never drop the inline user data
+	// silently if the step is configured with both.
+	if s.UserData != "" && s.UserDataFile != "" {
+		return "", fmt.Errorf("Only one of user_data or user_data_file can be specified.")
+	}
+
 	if s.UserDataFile != "" {
-		data, err := ioutil.ReadFile(s.UserDataFile)
+		data, err := readUserDataFile(state, s.UserDataFile)
 		if err != nil {
 			return "", err
 		}
+		userData = data
+	}
+
+	if len(s.UserDataParts) > 0 {
+		var parts []userDataPart
+		if len(s.BootstrapCommands) > 0 {
+			parts = append(parts, userDataPart{contentType: "text/cloud-config", content: buildBootstrapCloudConfig(s.BootstrapCommands)})
+		}
+		for _, part := range s.UserDataParts {
+			content := part.Content
+			if part.File != "" {
+				data, err := readUserDataFile(state, part.File)
+				if err != nil {
+					return "", err
+				}
+				content = data
+			}
+
+			contentType := part.Type
+			if contentType == "" {
+				contentType = userDataContentType(content)
+			}
+			parts = append(parts, userDataPart{contentType: contentType, content: content})
+		}
+
+		merged, err := mergeUserDataParts(parts)
+		if err != nil {
+			return "", fmt.Errorf("Error assembling user_data_parts: %s", err)
+		}
+		userData = merged
+	} else if len(s.BootstrapCommands) > 0 {
+		bootstrap := buildBootstrapCloudConfig(s.BootstrapCommands)
+		if userData == "" {
+			userData = bootstrap
+		} else {
+			merged, err := mergeUserData(bootstrap, userData)
+			if err != nil {
+				return "", fmt.Errorf("Error merging bootstrap_commands into user data: %s", err)
+			}
+			userData = merged
+		}
+	}
 
-		userData = string(data)
+	if config := state.Get("config").(*Config); config.WinRMHttpsBootstrap {
+		bootstrap := buildWinRMHttpsBootstrap(config.Comm.WinRMPort, config.WinRMHttpsCertDnsName, config.WinRMHttpsCertValidityDays)
+		// Windows instances run a single script, so PowerShell user data
+		// is appended to the bootstrap script.
+		switch {
+		case userData == "":
+			userData = bootstrap
+		case strings.HasPrefix(userData, powerShellUserDataHeader):
+			userData = bootstrap + strings.TrimPrefix(userData, powerShellUserDataHeader)
+		default:
+			return "", fmt.Errorf("winrm_https_bootstrap only works with user data which is a %s script", powerShellUserDataHeader)
+		}
 	}
 
 	if userData != "" {
-		userData = base64.StdEncoding.EncodeToString([]byte(userData))
+		data := []byte(userData)
+		if s.UserDataCompress {
+			compressed, err := gzipUserData(data)
+			if err != nil {
+				return "", fmt.Errorf("Error compressing user data: %s", err)
+			}
+			data = compressed
+		}
+
+		userData = base64.StdEncoding.EncodeToString(data)
+		if len(userData) > maxUserDataSize {
+			err := fmt.Errorf("The user data is %d bytes after base64 encoding, which exceeds the limit of %d bytes", len(userData), maxUserDataSize)
+			if !s.UserDataCompress {
+				err = fmt.Errorf("%s, try setting user_data_compress", err)
+			}
+			return "", err
+		}
 	}
 
 	return userData, nil
 
 }
+
+// setInstanceAutoReleaseTime schedules the automatic release of the
+// instance at instance_auto_release_time.
+func setInstanceAutoReleaseTime(client *ClientWrapper, config *Config, instanceId string) error {
+	request := ecs.CreateModifyInstanceAutoReleaseTimeRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.InstanceId = instanceId
+	request.AutoReleaseTime = config.InstanceAutoReleaseTime
+	_, err := client.ModifyInstanceAutoReleaseTime(request)
+	return err
+}
+
+// detachInstanceRamRole detaches the RAM role of the build from the instance.
+// The SDK has no request for DetachInstanceRamRole, so it's sent as a common
+// request.
+func detachInstanceRamRole(client *ClientWrapper, config *Config, instanceId string) error {
+	request := requests.NewCommonRequest()
+	request.Method = requests.POST
+	request.Product = "Ecs"
+	request.Version = "2014-05-26"
+	request.ApiName = "DetachInstanceRamRole"
+	request.Headers["RegionId"] = config.ApsaraStackRegion
+	request.QueryParams["AccessKeySecret"] = config.ApsaraStackSecretKey
+	request.QueryParams["Product"] = "ecs"
+	request.QueryParams["Department"] = config.Department
+	request.QueryParams["ResourceGroup"] = config.ResourceGroup
+	request.QueryParams["RegionId"] = config.ApsaraStackRegion
+	request.QueryParams["InstanceIds"] = fmt.Sprintf("[\"%s\"]", instanceId)
+	request.QueryParams["RamRoleName"] = config.RamRoleName
+
+	_, err := client.ProcessCommonRequest(request)
+	return err
+}
+
+// validatePrivateIp makes sure the private IP is within the CIDR block of the
+// vswitch, so that the instance isn't rejected by the API.
+func validatePrivateIp(ip string, cidrBlock string) error {
+	_, network, err := net.ParseCIDR(cidrBlock)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR block %q: %s", cidrBlock, err)
+	}
+	if !network.Contains(net.ParseIP(ip)) {
+		return fmt.Errorf("%s isn't within the CIDR block %s", ip, cidrBlock)
+	}
+
+	return nil
+}
+
+// securityGroupIds puts the primary security group first and drops
+// duplicates from the additional ones.
+func securityGroupIds(primary string, additional []string) []string {
+	ids := []string{primary}
+	seen := map[string]struct{}{primary: {}}
+	for _, id := range additional {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
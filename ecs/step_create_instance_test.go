@@ -0,0 +1,1208 @@
+package ecs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	confighelper "github.com/hashicorp/packer/helper/config"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+func testCreateInstanceConfig() *Config {
+	return &Config{
+		ApsaraStackAccessConfig: ApsaraStackAccessConfig{
+			ApsaraStackRegion: "cn-test",
+		},
+		RunConfig: RunConfig{
+			InstanceType: "ecs.n1.tiny",
+		},
+	}
+}
+
+func testCreateInstanceState(client *ClientWrapper, config *Config) multistep.StateBag {
+	state := testState(client, config)
+	state.Put("source_image", &ecs.Image{ImageId: "m-source"})
+	state.Put("securitygroupid", "sg-test")
+	return state
+}
+
+func TestStepCreateInstance_cleanupWhenDescribeFails(t *testing.T) {
+	var describes int
+	var deleted string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test"}`
+		case "DescribeInstances":
+			if deleted != "" {
+				return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[]}}`
+			}
+			describes++
+			if describes == 1 {
+				return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Stopped"}]}}`
+			}
+			return http.StatusInternalServerError, testErrorBody("InternalError")
+		case "DeleteInstance":
+			deleted = params.Get("InstanceId")
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		InstanceType: config.InstanceType,
+		RegionId:     config.ApsaraStackRegion,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	if _, ok := state.GetOk("instance"); ok {
+		t.Fatal("instance shouldn't be in state")
+	}
+
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if deleted != "i-test" {
+		t.Fatalf("instance should be deleted, actual: %q", deleted)
+	}
+}
+
+func TestStepCreateInstance_winrmHttpsBootstrap(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.WinRMHttpsBootstrap = true
+	config.WinRMHttpsCertDnsName = "packer"
+	config.WinRMHttpsCertValidityDays = 365
+	config.Comm.WinRMPort = 5986
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	userData, err := step.getUserData(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(userData)
+	if !strings.HasPrefix(string(decoded), "[powershell]\n") || !strings.Contains(string(decoded), "Port='5986'") ||
+		!strings.Contains(string(decoded), "-LocalPort 5986") {
+		t.Fatalf("bad user data: %s", decoded)
+	}
+
+	step.UserData = "[powershell]\nWrite-Output hello"
+	userData, err = step.getUserData(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decoded, _ = base64.StdEncoding.DecodeString(userData)
+	if strings.Count(string(decoded), "[powershell]") != 1 || !strings.HasSuffix(string(decoded), "Restart-Service -Name WinRM\n\nWrite-Output hello") {
+		t.Fatalf("the PowerShell user data should run after the bootstrap script: %s", decoded)
+	}
+
+	step.UserData = "[bat]\necho hello"
+	if _, err := step.getUserData(state); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStepCreateInstance_bootstrapCommands(t *testing.T) {
+	state := testCreateInstanceState(nil, testCreateInstanceConfig())
+
+	step := &stepCreateApsaraStackInstance{
+		BootstrapCommands: []string{"systemctl restart sshd"},
+	}
+	userData, err := step.getUserData(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(userData)
+	if string(decoded) != "#cloud-config\nruncmd:\n  - \"systemctl restart sshd\"\n" {
+		t.Fatalf("bad user data: %s", decoded)
+	}
+
+	step.UserData = "#!/bin/sh\necho hello"
+	userData, err = step.getUserData(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decoded, _ = base64.StdEncoding.DecodeString(userData)
+	if !strings.Contains(string(decoded), "Content-Type: text/cloud-config") ||
+		!strings.Contains(string(decoded), "Content-Type: text/x-shellscript") {
+		t.Fatalf("user data should be merged: %s", decoded)
+	}
+
+	step.UserData = strings.Repeat("x", maxUserDataSize)
+	if _, err := step.getUserData(state); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStepCreateInstance_userDataAndFile(t *testing.T) {
+	state := testCreateInstanceState(nil, testCreateInstanceConfig())
+
+	step := &stepCreateApsaraStackInstance{
+		UserData:     "foo",
+		UserDataFile: "bar",
+	}
+	if _, err := step.getUserData(state); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStepCreateInstance_internetMaxBandwidthOut(t *testing.T) {
+	zero, ten := 0, 10
+	cases := []struct {
+		name     string
+		value    *int
+		expected string
+	}{
+		{"unset", nil, "5"},
+		{"explicit zero", &zero, "0"},
+		{"explicit value", &ten, "10"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := testCreateInstanceState(nil, testCreateInstanceConfig())
+			step := &stepCreateApsaraStackInstance{
+				InternetMaxBandwidthOut: c.value,
+			}
+			request, err := step.buildCreateInstanceRequest(state)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(request.InternetMaxBandwidthOut) != c.expected {
+				t.Fatalf("bad bandwidth, expected: %s, actual: %s", c.expected, request.InternetMaxBandwidthOut)
+			}
+		})
+	}
+}
+
+func TestStepCreateInstance_associatePublicIpAddress(t *testing.T) {
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(nil, config)
+	state.Put("networktype", InstanceNetWork(InstanceNetworkVpc))
+	state.Put("vswitchid", "vsw-test")
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.InternetMaxBandwidthOut != "" || request.InternetChargeType != "" {
+		t.Fatalf("VPC instances shouldn't get public bandwidth by default: %s %s", request.InternetMaxBandwidthOut, request.InternetChargeType)
+	}
+
+	config.AssociatePublicIpAddress = true
+	request, err = step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.InternetMaxBandwidthOut != "5" || request.InternetChargeType != "PayByTraffic" {
+		t.Fatalf("bad public bandwidth: %s %s", request.InternetMaxBandwidthOut, request.InternetChargeType)
+	}
+}
+
+func TestStepCreateInstance_customSize(t *testing.T) {
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, ok := request.QueryParams["Cpu"]; ok {
+		t.Fatalf("the size of the instance type should be used by default")
+	}
+
+	config.Cpu = 2
+	config.Memory = 6
+	request, err = step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.QueryParams["Cpu"] != "2" || request.QueryParams["Memory"] != "6" {
+		t.Fatalf("bad size: %v", request.QueryParams)
+	}
+}
+
+func TestStepCreateInstance_runTags(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.RunTags = map[string]string{"team": "infra", "cost-center": "42", "empty": ""}
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []ecs.CreateInstanceTag{{Key: "cost-center", Value: "42"}, {Key: "team", Value: "infra"}}
+	if request.Tag == nil || !reflect.DeepEqual(*request.Tag, expected) {
+		t.Fatalf("bad tags: %#v", request.Tag)
+	}
+}
+
+func TestStepCreateInstance_userDataCompress(t *testing.T) {
+	state := testCreateInstanceState(nil, testCreateInstanceConfig())
+
+	// Repetitive scripts compress well below the limit.
+	script := "#!/bin/sh\n" + strings.Repeat("echo hello\n", 2000)
+	step := &stepCreateApsaraStackInstance{UserData: script}
+	_, err := step.getUserData(state)
+	if err == nil || !strings.Contains(err.Error(), "user_data_compress") {
+		t.Fatalf("uncompressed user data should exceed the limit: %v", err)
+	}
+
+	step.UserDataCompress = true
+	userData, err := step.getUserData(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(userData)
+	reader, err := gzip.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	raw, _ := ioutil.ReadAll(reader)
+	if string(raw) != script {
+		t.Fatalf("bad user data after decompressing")
+	}
+}
+
+func TestStepCreateInstance_userDataFileInterpolation(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.ctx.BuildName = "nightly"
+	state := testCreateInstanceState(nil, config)
+
+	path := filepath.Join(t.TempDir(), "user_data")
+	step := &stepCreateApsaraStackInstance{UserDataFile: path}
+
+	for content, expected := range map[string]string{
+		"#!/bin/sh\necho {{ build_name }}\n": "#!/bin/sh\necho nightly\n",
+		"#!/bin/sh\necho ${HOME}\n":          "#!/bin/sh\necho ${HOME}\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		userData, err := step.getUserData(state)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		decoded, _ := base64.StdEncoding.DecodeString(userData)
+		if string(decoded) != expected {
+			t.Fatalf("bad user data, expected: %q, actual: %q", expected, decoded)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("echo {{ nope }}"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := step.getUserData(state); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("the template error should name the file: %v", err)
+	}
+}
+
+func TestStepCreateInstance_userDataParts(t *testing.T) {
+	state := testCreateInstanceState(nil, testCreateInstanceConfig())
+
+	path := filepath.Join(t.TempDir(), "script")
+	if err := ioutil.WriteFile(path, []byte("echo hello\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	step := &stepCreateApsaraStackInstance{
+		UserDataParts: []ApsaraStackUserDataPart{
+			{Content: "#cloud-config\npackages: [nginx]\n"},
+			{File: path, Type: "text/x-shellscript-per-boot"},
+		},
+	}
+	userData, err := step.getUserData(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	decoded, _ := base64.StdEncoding.DecodeString(userData)
+	for _, expected := range []string{
+		"Content-Type: multipart/mixed",
+		"Content-Type: text/cloud-config; charset=\"utf-8\"",
+		"Content-Type: text/x-shellscript-per-boot; charset=\"utf-8\"",
+		"echo hello",
+	} {
+		if !strings.Contains(string(decoded), expected) {
+			t.Fatalf("the user data should contain %q: %s", expected, decoded)
+		}
+	}
+}
+
+func TestStepCreateInstance_spotStrategy(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.SpotStrategy = SpotStrategySpotWithPriceLimit
+	config.SpotPriceLimit = 0.25
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.SpotStrategy != SpotStrategySpotWithPriceLimit || request.SpotPriceLimit != "0.25" {
+		t.Fatalf("bad spot settings: %s %s", request.SpotStrategy, request.SpotPriceLimit)
+	}
+}
+
+func TestStepCreateInstance_systemDiskEncryption(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.ECSSystemDiskMapping.Encrypted = confighelper.TriTrue
+	config.ECSSystemDiskMapping.KMSKeyId = "key-id"
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.QueryParams["SystemDisk.Encrypted"] != "true" || request.QueryParams["SystemDisk.KMSKeyId"] != "key-id" {
+		t.Fatalf("bad system disk encryption: %v", request.QueryParams)
+	}
+}
+
+func TestStepCreateInstance_dataDiskKMSKeyId(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.ECSImagesDiskMappings = []ApsaraStackDiskDevice{
+		{DiskSize: 20, Encrypted: confighelper.TriTrue, KMSKeyId: "key-id"},
+	}
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dataDisks := *request.DataDisk
+	if dataDisks[0].Encrypted != "true" || dataDisks[0].KMSKeyId != "key-id" {
+		t.Fatalf("bad data disk encryption: %#v", dataDisks[0])
+	}
+}
+
+func TestStepCreateInstance_diskPerformanceLevel(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.ECSSystemDiskMapping = ApsaraStackDiskDevice{DiskCategory: DiskCategoryESSD, PerformanceLevel: "PL1"}
+	config.ECSImagesDiskMappings = []ApsaraStackDiskDevice{
+		{DiskCategory: DiskCategoryESSD, DiskSize: 20, PerformanceLevel: "PL2"},
+	}
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.SystemDiskPerformanceLevel != "PL1" || (*request.DataDisk)[0].PerformanceLevel != "PL2" {
+		t.Fatalf("bad performance levels: %s %s", request.SystemDiskPerformanceLevel, (*request.DataDisk)[0].PerformanceLevel)
+	}
+}
+
+func TestStepCreateInstance_securityGroupIds(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.SecurityGroupIds = []string{"sg-base", "sg-test", "sg-extra"}
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.SecurityGroupId != "" {
+		t.Fatalf("SecurityGroupId can't be combined with SecurityGroupIds: %s", request.SecurityGroupId)
+	}
+	expected := map[string]string{
+		"SecurityGroupIds.1": "sg-test",
+		"SecurityGroupIds.2": "sg-base",
+		"SecurityGroupIds.3": "sg-extra",
+	}
+	for key, value := range expected {
+		if request.QueryParams[key] != value {
+			t.Fatalf("bad %s, expected: %s, actual: %s", key, value, request.QueryParams[key])
+		}
+	}
+	if _, ok := request.QueryParams["SecurityGroupIds.4"]; ok {
+		t.Fatalf("security groups should be deduplicated: %v", request.QueryParams)
+	}
+}
+
+func TestStepCreateInstance_deploymentSetError(t *testing.T) {
+	var deploymentSetId string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "CreateInstance" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		deploymentSetId = params.Get("DeploymentSetId")
+		return http.StatusForbidden, testErrorBody("DeploymentSet.NoRoom")
+	})
+
+	config := testCreateInstanceConfig()
+	config.DeploymentSetId = "ds-test"
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		InstanceType: config.InstanceType,
+		RegionId:     config.ApsaraStackRegion,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if deploymentSetId != "ds-test" {
+		t.Fatalf("bad deployment set id: %q", deploymentSetId)
+	}
+	err := state.Get("error").(error)
+	if !strings.Contains(err.Error(), "same zone") {
+		t.Fatalf("error should suggest checking the zone: %s", err)
+	}
+}
+
+func TestStepCreateInstance_retryErrorCodes(t *testing.T) {
+	var creates int
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			creates++
+			if creates == 1 {
+				return http.StatusForbidden, testErrorBody("OperationDenied.NoStock")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test"}`
+		case "DescribeInstances":
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Stopped"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		InstanceType:    config.InstanceType,
+		RegionId:        config.ApsaraStackRegion,
+		RetryErrorCodes: []string{"OperationDenied.NoStock"},
+	}
+	builtIn := len(createInstanceRetryErrors)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v, err: %s", action, state.Get("error"))
+	}
+	if creates != 2 {
+		t.Fatalf("create should be retried once, actual creates: %d", creates)
+	}
+	if len(createInstanceRetryErrors) != builtIn {
+		t.Fatalf("the built-in retry errors shouldn't be changed: %v", createInstanceRetryErrors)
+	}
+}
+
+func TestStepCreateInstance_createTimeout(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test"}`
+		case "DescribeInstances":
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Pending"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		InstanceType:  config.InstanceType,
+		RegionId:      config.ApsaraStackRegion,
+		CreateTimeout: time.Nanosecond,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	err := state.Get("error").(error)
+	if !strings.Contains(err.Error(), "isn't Stopped after") {
+		t.Fatalf("error should include the elapsed time: %s", err)
+	}
+}
+
+func TestStepCreateInstance_cleanupDetachesRamRole(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		if action == "DetachInstanceRamRole" && params.Get("RamRoleName") != "packer" {
+			t.Errorf("bad role name: %s", params.Get("RamRoleName"))
+			return http.StatusBadRequest, testErrorBody("InvalidParameter")
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	config := testCreateInstanceConfig()
+	config.RamRoleName = "packer"
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{instanceId: "i-test"}
+
+	step.Cleanup(state)
+	if !reflect.DeepEqual(actions, []string{"DetachInstanceRamRole", "DeleteInstance", "DescribeInstances"}) {
+		t.Fatalf("role should be detached before the instance is deleted: %v", actions)
+	}
+}
+
+func TestStepCreateInstance_keepInstanceOnError(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	config := testCreateInstanceConfig()
+	config.KeepInstanceOnError = true
+	config.Comm.Type = "ssh"
+	config.Comm.SSHUsername = "root"
+	config.Comm.SSHPrivateKeyFile = "packer.pem"
+	state := testCreateInstanceState(client, config)
+	state.Put("ipaddress", "203.0.113.10")
+	summary := &cleanupSummary{}
+	state.Put("cleanup_summary", summary)
+	step := &stepCreateApsaraStackInstance{instanceId: "i-test"}
+
+	step.Cleanup(state)
+	if !reflect.DeepEqual(actions, []string{"DeleteInstance", "DescribeInstances"}) {
+		t.Fatalf("the instance of a successful build should be deleted: %v", actions)
+	}
+
+	actions = nil
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if len(actions) != 0 {
+		t.Fatalf("the instance of a failed build should be kept: %v", actions)
+	}
+	if leaked := summary.leaked(); len(leaked) != 1 || leaked[0].id != "i-test" || leaked[0].err != errKeptOnError {
+		t.Fatalf("the kept instance should be reported as left behind: %v", leaked)
+	}
+
+	state.Put(multistep.StateCancelled, true)
+	step.Cleanup(state)
+	if !reflect.DeepEqual(actions, []string{"DeleteInstance", "DescribeInstances"}) {
+		t.Fatalf("the instance of a cancelled build should be deleted: %v", actions)
+	}
+}
+
+func TestStepCreateInstance_reportKeptInstance(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Chdir(wd)
+
+	report := func(config *Config) string {
+		var out bytes.Buffer
+		state := testCreateInstanceState(nil, config)
+		state.Put("ui", &packer.BasicUi{Reader: new(bytes.Buffer), Writer: &out, ErrorWriter: &out})
+		state.Put("ipaddress", "203.0.113.10")
+		(&stepCreateApsaraStackInstance{instanceId: "i-test"}).reportKeptInstance(state)
+		return out.String()
+	}
+
+	config := testCreateInstanceConfig()
+	config.Comm.Type = "winrm"
+	config.Comm.WinRMUser = "Administrator"
+	config.Comm.WinRMPassword = "Secret123!"
+	if out := report(config); strings.Contains(out, "Secret123!") || !strings.Contains(out, "as Administrator, with the password from the template") {
+		t.Fatalf("the hint shouldn't show the password: %s", out)
+	}
+
+	config = testCreateInstanceConfig()
+	config.Comm.Type = "ssh"
+	config.Comm.SSHUsername = "root"
+	config.Comm.SSHTemporaryKeyPairName = "packer_test"
+	config.Comm.SSHPrivateKey = []byte("private key")
+	keyFile := filepath.Join(dir, "ecs_i-test.pem")
+	if out := report(config); !strings.Contains(out, "run the build with -debug") {
+		t.Fatalf("the hint should tell how to save the key: %s", out)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Fatalf("the key should only be saved with -debug: %v", err)
+	}
+
+	config.PackerDebug = true
+	if out := report(config); !strings.Contains(out, "ssh -i "+keyFile+" ") {
+		t.Fatalf("the hint should use the absolute path of the saved key: %s", out)
+	}
+	if key, err := ioutil.ReadFile(keyFile); err != nil || string(key) != "private key" {
+		t.Fatalf("the key should be saved with -debug: %q, %v", key, err)
+	}
+}
+
+func TestStepCreateInstance_snapshotOnCleanup(t *testing.T) {
+	var actions []string
+	var snapshot url.Values
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		switch action {
+		case "DescribeDisks":
+			if params.Get("DiskType") != DiskTypeSystem {
+				t.Errorf("bad describe request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[{"DiskId":"d-system"}]}}`
+		case "CreateSnapshot":
+			snapshot = params
+			return http.StatusOK, `{"RequestId":"test-request","SnapshotId":"s-cleanup"}`
+		case "DescribeSnapshots":
+			return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[{"SnapshotId":"s-cleanup","Status":"accomplished"}]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	state := testCreateInstanceState(client, testCreateInstanceConfig())
+	summary := &cleanupSummary{}
+	state.Put("cleanup_summary", summary)
+	step := &stepCreateApsaraStackInstance{instanceId: "i-test", SnapshotOnCleanup: true, SnapshotRetentionDays: 7}
+
+	step.Cleanup(state)
+	if !reflect.DeepEqual(actions, []string{"DeleteInstance", "DescribeInstances"}) {
+		t.Fatalf("no snapshot should be taken of a successful build: %v", actions)
+	}
+
+	actions = nil
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	expected := []string{"DescribeDisks", "CreateSnapshot", "DescribeSnapshots", "DeleteInstance", "DescribeInstances"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("the system disk should be snapshotted before the instance is deleted: %v", actions)
+	}
+	if snapshot.Get("DiskId") != "d-system" || snapshot.Get("RetentionDays") != "7" {
+		t.Fatalf("bad snapshot request: %v", snapshot)
+	}
+	if leaked := summary.leaked(); len(leaked) != 1 || leaked[0].id != "s-cleanup" || !errors.Is(leaked[0].err, errKeptOnCleanup) {
+		t.Fatalf("the snapshot should be reported: %v", leaked)
+	}
+}
+
+func TestStepCreateInstance_snapshotOnCleanupError(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		switch action {
+		case "DescribeDisks":
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[{"DiskId":"d-system"}]}}`
+		case "CreateSnapshot":
+			return http.StatusForbidden, testErrorBody("QuotaExceed.Snapshot")
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	state := testCreateInstanceState(client, testCreateInstanceConfig())
+	state.Put(multistep.StateHalted, true)
+	step := &stepCreateApsaraStackInstance{instanceId: "i-test", SnapshotOnCleanup: true}
+
+	step.Cleanup(state)
+	if !reflect.DeepEqual(actions, []string{"DescribeDisks", "CreateSnapshot", "DeleteInstance", "DescribeInstances"}) {
+		t.Fatalf("failing to snapshot the system disk shouldn't keep the instance: %v", actions)
+	}
+}
+
+func TestStepCreateInstance_numbers(t *testing.T) {
+	for _, c := range []struct {
+		name         string
+		systemSize   int
+		dataSize     int
+		bandwidthOut int
+		valid        bool
+	}{
+		{name: "defaults", valid: true},
+		{name: "sizes", systemSize: 40, dataSize: 100, bandwidthOut: 100, valid: true},
+		{name: "negative system disk", systemSize: -40},
+		{name: "negative data disk", dataSize: -1},
+		{name: "data disk overflow", dataSize: math.MaxInt32 + 1},
+		{name: "negative bandwidth", bandwidthOut: -5},
+	} {
+		config := testCreateInstanceConfig()
+		config.ECSSystemDiskMapping.DiskSize = c.systemSize
+		config.ECSImagesDiskMappings = []ApsaraStackDiskDevice{{DiskSize: c.dataSize}}
+		state := testCreateInstanceState(nil, config)
+		bandwidthOut := c.bandwidthOut
+		step := &stepCreateApsaraStackInstance{InternetMaxBandwidthOut: &bandwidthOut}
+
+		request, err := step.buildCreateInstanceRequest(state)
+		if !c.valid {
+			if err == nil {
+				t.Fatalf("%s: should have error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: err: %s", c.name, err)
+		}
+		if string(request.SystemDiskSize) != convertNumber(c.systemSize) || (*request.DataDisk)[0].Size != convertNumber(c.dataSize) ||
+			string(request.InternetMaxBandwidthOut) != strconv.Itoa(c.bandwidthOut) {
+			t.Fatalf("%s: bad request: %s, %s, %s", c.name, request.SystemDiskSize, (*request.DataDisk)[0].Size, request.InternetMaxBandwidthOut)
+		}
+	}
+}
+
+func TestStepCreateInstance_privateIp(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.PrivateIp = "172.16.0.10"
+	state := testCreateInstanceState(nil, config)
+	state.Put("networktype", InstanceNetWork(InstanceNetworkVpc))
+	state.Put("vswitchid", "vsw-test")
+	state.Put("vswitchcidr", "172.16.0.0/24")
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.PrivateIpAddress != "172.16.0.10" {
+		t.Fatalf("bad private ip: %s", request.PrivateIpAddress)
+	}
+
+	config.PrivateIp = "172.16.1.10"
+	if _, err := step.buildCreateInstanceRequest(state); err == nil || !strings.Contains(err.Error(), "172.16.0.0/24") {
+		t.Fatalf("private ip outside of the vswitch should be rejected: %v", err)
+	}
+}
+
+func TestStepCreateInstance_ipv6AddressCount(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.Ipv6AddressCount = 1
+	state := testCreateInstanceState(nil, config)
+	state.Put("networktype", InstanceNetWork(InstanceNetworkVpc))
+	state.Put("vswitchid", "vsw-test")
+	state.Put("vswitchipv6cidr", "2408:4321:180:1701::/64")
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.QueryParams["Ipv6AddressCount"] != "1" {
+		t.Fatalf("bad ipv6 address count: %s", request.QueryParams["Ipv6AddressCount"])
+	}
+
+	state.Put("vswitchipv6cidr", "")
+	if _, err := step.buildCreateInstanceRequest(state); err == nil || !strings.Contains(err.Error(), "enable IPv6") {
+		t.Fatalf("vswitch without IPv6 should be rejected: %v", err)
+	}
+}
+
+func TestStepCreateInstance_stableClientToken(t *testing.T) {
+	var tokens []string
+	instances := make(map[string]string)
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			token := params.Get("ClientToken")
+			tokens = append(tokens, token)
+			if _, ok := instances[token]; !ok {
+				// The instance is created, but the response gets lost.
+				instances[token] = fmt.Sprintf("i-test%d", len(instances))
+				return http.StatusForbidden, testErrorBody("IdempotentProcessing")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"` + instances[token] + `"}`
+		case "DescribeInstances":
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test0","Status":"Stopped"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		InstanceType: config.InstanceType,
+		RegionId:     config.ApsaraStackRegion,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v, err: %s", action, state.Get("error"))
+	}
+	if len(tokens) != 2 || tokens[0] != tokens[1] {
+		t.Fatalf("the retry should reuse the client token: %v", tokens)
+	}
+	if len(instances) != 1 || step.instanceId != "i-test0" {
+		t.Fatalf("only one instance should be created: %v, actual: %s", instances, step.instanceId)
+	}
+}
+
+func TestStepCreateInstance_cleanupRetries(t *testing.T) {
+	attempts := 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DeleteInstance" {
+			t.Errorf("unexpected action: %s", action)
+		}
+		attempts++
+		return http.StatusForbidden, testErrorBody("IncorrectInstanceStatus")
+	})
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		CleanupRetryTimes:      3,
+		CleanupRetryInterval:   time.Millisecond,
+		CleanupRetryErrorCodes: []string{"IncorrectInstanceStatus"},
+		instanceId:             "i-test",
+	}
+	step.Cleanup(state)
+
+	if attempts != 3 {
+		t.Fatalf("deleting the instance should be tried 3 times, actual: %d", attempts)
+	}
+}
+
+func TestStepCreateInstance_cleanupWaitsForDeletion(t *testing.T) {
+	var describes int
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DeleteInstance":
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DescribeInstances":
+			describes++
+			if describes < 3 {
+				return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Stopping"}]}}`
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+
+	state := testCreateInstanceState(client, testCreateInstanceConfig())
+	step := &stepCreateApsaraStackInstance{instanceId: "i-test"}
+	step.Cleanup(state)
+
+	if describes != 3 {
+		t.Fatalf("the cleanup should wait until the instance is gone, DescribeInstances calls: %d", describes)
+	}
+}
+
+func TestStepCreateInstance_cleanupForceStop(t *testing.T) {
+	var actions []string
+	deleted := false
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		switch action {
+		case "StopInstance":
+			if params.Get("ForceStop") != "true" {
+				t.Errorf("the instance should be force stopped: %v", params)
+			}
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DescribeInstances":
+			if deleted {
+				return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[]}}`
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Stopped"}]}}`
+		case "DeleteInstance":
+			deleted = true
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		RegionId:         config.ApsaraStackRegion,
+		CleanupForceStop: true,
+		instanceId:       "i-test",
+	}
+	step.Cleanup(state)
+
+	expected := []string{"StopInstance", "DescribeInstances", "DeleteInstance", "DescribeInstances"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("bad actions, expected: %v, actual: %v", expected, actions)
+	}
+}
+
+func TestStepCreateInstance_cleanupOrphanedDisks(t *testing.T) {
+	var deleted []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeDisks":
+			if params.Get("InstanceId") != "i-test" {
+				t.Errorf("bad instance id: %v", params)
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[{"DiskId":"d-kept","DeleteWithInstance":false},{"DiskId":"d-deleted","DeleteWithInstance":true}]}}`
+		case "DeleteInstance":
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DescribeInstances":
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[]}}`
+		case "DeleteDisk":
+			deleted = append(deleted, params.Get("DiskId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+
+	config := testCreateInstanceConfig()
+	state := testCreateInstanceState(client, config)
+	step := &stepCreateApsaraStackInstance{
+		RegionId:             config.ApsaraStackRegion,
+		CleanupOrphanedDisks: true,
+		instanceId:           "i-test",
+	}
+
+	step.Cleanup(state)
+	if len(deleted) != 0 {
+		t.Fatalf("disks of a successful build shouldn't be deleted: %v", deleted)
+	}
+
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if !reflect.DeepEqual(deleted, []string{"d-kept"}) {
+		t.Fatalf("only the disk kept by the instance should be deleted, actual: %v", deleted)
+	}
+}
+
+func TestStepCreateInstance_keyPairName(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.Comm.SSHKeyPairName = "packer"
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if request.KeyPairName != "packer" || request.Password != "" {
+		t.Fatalf("the instance should be launched with the key pair only: %q, %q", request.KeyPairName, request.Password)
+	}
+}
+
+func TestStepCreateInstance_metadataOptions(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.MetadataTokenMode = MetadataTokenModeRequired
+	config.MetadataHopLimit = 2
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if request.HttpTokens != MetadataTokenModeRequired || request.HttpPutResponseHopLimit != "2" {
+		t.Fatalf("bad metadata options: %q, %q", request.HttpTokens, request.HttpPutResponseHopLimit)
+	}
+}
+
+func TestStepCreateInstance_dataDiskSize(t *testing.T) {
+	config := testCreateInstanceConfig()
+	sizes := []int{0, 5, 20, 100, 1024, 32768}
+	for _, size := range sizes {
+		config.ECSImagesDiskMappings = append(config.ECSImagesDiskMappings, ApsaraStackDiskDevice{DiskSize: size})
+	}
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dataDisks := *request.DataDisk
+	if len(dataDisks) != len(sizes) {
+		t.Fatalf("bad data disks: %#v", dataDisks)
+	}
+	if dataDisks[0].Size != "" {
+		t.Fatalf("an unset size should be left out: %q", dataDisks[0].Size)
+	}
+	for i, size := range sizes[1:] {
+		if expected := strconv.Itoa(size); dataDisks[i+1].Size != expected {
+			t.Fatalf("bad size of data disk %d: expected %q, got %q", i+1, expected, dataDisks[i+1].Size)
+		}
+	}
+}
+
+func TestStepCreateInstance_cancelled(t *testing.T) {
+	var creates int
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		creates++
+		return http.StatusBadRequest, testErrorBody("IdempotentProcessing")
+	})
+	state := testCreateInstanceState(client, testCreateInstanceConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	step := &stepCreateApsaraStackInstance{}
+	if action := step.Run(ctx, state); action != multistep.ActionHalt {
+		t.Fatalf("the step should halt once cancelled, actual: %s", action)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the retries should stop promptly, took %s", elapsed)
+	}
+	if creates != 1 {
+		t.Fatalf("the creation shouldn't be retried after the cancellation, tried %d times", creates)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("bad error: %s", err)
+	}
+}
+
+func TestStepCreateInstance_prePaid(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.InstanceChargeType = InstanceChargeTypePrePaid
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.Period != "1" || request.PeriodUnit != PeriodUnitMonth {
+		t.Fatalf("bad default period: %s %s", request.Period, request.PeriodUnit)
+	}
+
+	config.Period = 3
+	config.PeriodUnit = PeriodUnitWeek
+	request, err = step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.InstanceChargeType != InstanceChargeTypePrePaid || request.Period != "3" || request.PeriodUnit != PeriodUnitWeek {
+		t.Fatalf("bad period: %s %s %s", request.InstanceChargeType, request.Period, request.PeriodUnit)
+	}
+}
+
+func TestStepCreateInstance_cleanupPrePaid(t *testing.T) {
+	var actions []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		actions = append(actions, action)
+		if action == "ModifyInstanceChargeType" && params.Get("InstanceChargeType") != InstanceChargeTypePostPaid {
+			t.Errorf("bad charge type: %s", params.Get("InstanceChargeType"))
+			return http.StatusBadRequest, testErrorBody("InvalidParameter")
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+	config := testCreateInstanceConfig()
+	config.InstanceChargeType = InstanceChargeTypePrePaid
+	state := testCreateInstanceState(client, config)
+
+	step := &stepCreateApsaraStackInstance{instanceId: "i-test"}
+	step.Cleanup(state)
+
+	if !reflect.DeepEqual(actions, []string{"ModifyInstanceChargeType", "DeleteInstance", "DescribeInstances"}) {
+		t.Fatalf("the instance should be switched to %s before being deleted: %v", InstanceChargeTypePostPaid, actions)
+	}
+}
+
+func TestStepCreateInstance_dedicatedHost(t *testing.T) {
+	config := testCreateInstanceConfig()
+	config.DedicatedHostId = "dh-test"
+	state := testCreateInstanceState(nil, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	request, err := step.buildCreateInstanceRequest(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if request.DedicatedHostId != "dh-test" || request.Tenancy != TenancyHost {
+		t.Fatalf("bad dedicated host: %s %s", request.DedicatedHostId, request.Tenancy)
+	}
+}
+
+func TestStepCreateInstance_autoReleaseTime(t *testing.T) {
+	var releaseTime, deleted string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test"}`
+		case "ModifyInstanceAutoReleaseTime":
+			releaseTime = params.Get("AutoReleaseTime")
+			return http.StatusBadRequest, testErrorBody("InvalidAutoReleaseTime.Malformed")
+		case "DeleteInstance":
+			deleted = params.Get("InstanceId")
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+	config := testCreateInstanceConfig()
+	config.InstanceAutoReleaseTime = "2020-10-01T12:00:00Z"
+	state := testCreateInstanceState(client, config)
+
+	step := &stepCreateApsaraStackInstance{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %s", action)
+	}
+	if releaseTime != "2020-10-01T12:00:00Z" {
+		t.Fatalf("bad release time: %s", releaseTime)
+	}
+
+	step.Cleanup(state)
+	if deleted != "i-test" {
+		t.Fatalf("the instance should still be deleted: %q", deleted)
+	}
+}
+
+func TestStepCreateInstance_verifyTagsVisible(t *testing.T) {
+	var describeTags int
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test"}`
+		case "DescribeInstances":
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"Stopped"}]}}`
+		case "DescribeTags":
+			describeTags++
+			if params.Get("ResourceType") != TagResourceInstance || params.Get("ResourceId") != "i-test" {
+				t.Errorf("bad tagged resource: %s %s", params.Get("ResourceType"), params.Get("ResourceId"))
+			}
+			if describeTags == 1 {
+				return http.StatusOK, `{"RequestId":"test-request","Tags":{"Tag":[]}}`
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Tags":{"Tag":[{"TagKey":"team","TagValue":"packer"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	client.Clock = &testClock{now: time.Unix(0, 0)}
+	config := testCreateInstanceConfig()
+	config.RunTags = map[string]string{"team": "packer"}
+	config.VerifyTagsVisible = true
+	config.TagVisibilityTimeout = time.Minute
+	state := testCreateInstanceState(client, config)
+
+	step := &stepCreateApsaraStackInstance{RegionId: config.ApsaraStackRegion}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+	if describeTags != 2 {
+		t.Fatalf("the run tags should be waited for, DescribeTags calls: %d", describeTags)
+	}
+}
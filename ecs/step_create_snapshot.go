@@ -43,6 +43,7 @@ func (s *stepCreateApsaraStackSnapshot) Run(ctx context.Context, state multistep
 	createSnapshotRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 	createSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
+	createSnapshotRequest.ClientToken = clientToken(state, "CreateSnapshot:"+disks[0].DiskId)
 	createSnapshotRequest.DiskId = disks[0].DiskId
 	snapshot, err := client.CreateSnapshot(createSnapshotRequest)
 	if err != nil {
@@ -52,7 +53,7 @@ func (s *stepCreateApsaraStackSnapshot) Run(ctx context.Context, state multistep
 	// Create the ApsaraStack snapshot
 	ui.Say(fmt.Sprintf("Creating snapshot from system disk %s: %s", disks[0].DiskId, snapshot.SnapshotId))
 
-	snapshotsResponse, err := client.WaitForSnapshotStatus(config.ApsaraStackRegion, snapshot.SnapshotId, SnapshotStatusAccomplished, time.Duration(s.WaitSnapshotReadyTimeout)*time.Second, state)
+	snapshotsResponse, err := client.WaitForSnapshotStatus(ctx, config.ApsaraStackRegion, snapshot.SnapshotId, SnapshotStatusAccomplished, time.Duration(s.WaitSnapshotReadyTimeout)*time.Second, state)
 	if err != nil {
 		_, ok := err.(errors.Error)
 		if ok {
@@ -93,8 +94,9 @@ func (s *stepCreateApsaraStackSnapshot) Cleanup(state multistep.StateBag) {
 	deleteSnapshotRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
 	deleteSnapshotRequest.SnapshotId = s.snapshot.SnapshotId
-	if _, err := client.DeleteSnapshot(deleteSnapshotRequest); err != nil {
+	_, err := client.DeleteSnapshot(deleteSnapshotRequest)
+	recordCleanup(state, "snapshot", s.snapshot.SnapshotId, err)
+	if err != nil {
 		ui.Error(fmt.Sprintf("Error deleting snapshot, it may still be around: %s", err))
-		return
 	}
 }
@@ -0,0 +1,131 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/common/random"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// stepCreateSourceImage creates the source image from source_snapshot_id,
+// the image is deleted again once the build is done unless it is kept.
+type stepCreateSourceImage struct {
+	SnapshotId               string
+	Keep                     bool
+	WaitSnapshotReadyTimeout int
+	imageId                  string
+}
+
+func (s *stepCreateSourceImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.SnapshotId == "" {
+		return multistep.ActionContinue
+	}
+
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	client.Domain = config.serviceEndpoint("ecs", config.Endpoint)
+	client.SetHTTPSInsecure(true)
+
+	if err := s.validateSnapshot(state); err != nil {
+		return halt(state, err, "Error validating source_snapshot_id")
+	}
+
+	imageName := fmt.Sprintf("packer_source_%s", random.AlphaNum(7))
+	ui.Say(fmt.Sprintf("Creating source image %s from snapshot %s...", imageName, s.SnapshotId))
+
+	request := ecs.CreateCreateImageRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.ClientToken = clientToken(state, "CreateImage:"+imageName)
+	request.RegionId = config.ApsaraStackRegion
+	request.SnapshotId = s.SnapshotId
+	request.ImageName = imageName
+	request.Description = fmt.Sprintf("Source image of a Packer build, created from snapshot %s", s.SnapshotId)
+	response, err := client.CreateImage(request)
+	if err != nil {
+		return halt(state, err, "Error creating source image from snapshot")
+	}
+	s.imageId = response.ImageId
+	state.Put("source_snapshot_image", s.imageId)
+
+	if _, err := client.WaitForImageStatus(ctx, config.ApsaraStackRegion, s.imageId, ImageStatusAvailable, time.Duration(s.WaitSnapshotReadyTimeout)*time.Second, state); err != nil {
+		return halt(state, err, "Timeout waiting for source image to be created")
+	}
+	ui.Message(fmt.Sprintf("Created source image %s", s.imageId))
+
+	// The image is the source image of the build from now on.
+	config.ApsaraStackSourceImage = s.imageId
+
+	return multistep.ActionContinue
+}
+
+// validateSnapshot makes sure the snapshot exists and was taken from a system
+// disk, an image can't be created from a data disk snapshot.
+func (s *stepCreateSourceImage) validateSnapshot(state multistep.StateBag) error {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	request := ecs.CreateDescribeSnapshotsRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.SnapshotIds = fmt.Sprintf("[\"%s\"]", s.SnapshotId)
+	response, err := client.DescribeSnapshots(request)
+	if err != nil {
+		return fmt.Errorf("Error querying snapshot %s: %w", s.SnapshotId, err)
+	}
+
+	for _, snapshot := range response.Snapshots.Snapshot {
+		if snapshot.SnapshotId != s.SnapshotId {
+			continue
+		}
+		if !strings.EqualFold(snapshot.SourceDiskType, DiskTypeSystem) {
+			return fmt.Errorf("the snapshot %s was taken from a %s disk, it has to be a system disk snapshot", s.SnapshotId, snapshot.SourceDiskType)
+		}
+		if snapshot.Status != SnapshotStatusAccomplished {
+			return fmt.Errorf("the snapshot %s is %s, not %s", s.SnapshotId, snapshot.Status, SnapshotStatusAccomplished)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("the snapshot %s doesn't exist in region %s", s.SnapshotId, config.ApsaraStackRegion)
+}
+
+func (s *stepCreateSourceImage) Cleanup(state multistep.StateBag) {
+	if s.imageId == "" {
+		return
+	}
+
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if s.Keep && !cancelled && !halted {
+		return
+	}
+
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Deleting source image %s created from snapshot %s...", s.imageId, s.SnapshotId))
+
+	request := ecs.CreateDeleteImageRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.ImageId = s.imageId
+	_, err := client.DeleteImage(request)
+	recordCleanup(state, "source image", s.imageId, err)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error deleting source image %s, it may still be around: %s", s.imageId, err))
+	}
+}
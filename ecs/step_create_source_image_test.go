@@ -0,0 +1,86 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepCreateSourceImage(t *testing.T) {
+	deleted := false
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeSnapshots":
+			return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[` +
+				`{"SnapshotId":"s-system","Status":"accomplished","SourceDiskType":"System"}]}}`
+		case "CreateImage":
+			if params.Get("SnapshotId") != "s-system" {
+				t.Errorf("the image should be created from the snapshot: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"m-source"}`
+		case "DescribeImages":
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-source","Status":"Available"}]}}`
+		case "DeleteImage":
+			deleted = params.Get("ImageId") == "m-source"
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+
+	config := &Config{}
+	config.Endpoint = client.Domain
+	state := testState(client, config)
+
+	step := &stepCreateSourceImage{SnapshotId: "s-system", Keep: true, WaitSnapshotReadyTimeout: 60}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+	if config.ApsaraStackSourceImage != "m-source" || state.Get("source_snapshot_image") != "m-source" {
+		t.Fatalf("the created image should be the source image: %s", config.ApsaraStackSourceImage)
+	}
+
+	step.Cleanup(state)
+	if deleted {
+		t.Fatalf("the image should be kept after a successful build")
+	}
+
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if !deleted {
+		t.Fatalf("the image should be deleted when the build fails")
+	}
+}
+
+func TestStepCreateSourceImage_dataDiskSnapshot(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeSnapshots" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[` +
+			`{"SnapshotId":"s-data","Status":"accomplished","SourceDiskType":"Data"}]}}`
+	})
+
+	config := &Config{}
+	config.Endpoint = client.Domain
+
+	for snapshotId, message := range map[string]string{
+		"s-data":    "has to be a system disk snapshot",
+		"s-missing": "doesn't exist",
+	} {
+		state := testState(client, config)
+		step := &stepCreateSourceImage{SnapshotId: snapshotId}
+		if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+			t.Fatalf("the snapshot %s should be rejected", snapshotId)
+		}
+		if err := state.Get("error").(error); !strings.Contains(err.Error(), message) {
+			t.Fatalf("bad error: %s", err)
+		}
+	}
+}
@@ -3,14 +3,25 @@ package ecs
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
 
 type stepCreateTags struct {
-	Tags map[string]string
+	Tags                   map[string]string
+	InheritSourceImageTags bool
+	SourceImageTagKeys     []string
+	Concurrency            int
+	TagSnapshots           bool
+}
+
+var addTagsRetryErrors = []string{
+	"Throttling",
+	"ServiceUnavailable",
 }
 
 func (s *stepCreateTags) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -20,14 +31,20 @@ func (s *stepCreateTags) Run(ctx context.Context, state multistep.StateBag) mult
 	imageId := state.Get("ApsaraStackimage").(string)
 	snapshotIds := state.Get("ApsaraStacksnapshots").([]string)
 
-	if len(s.Tags) == 0 {
+	imageTags := s.Tags
+	if s.InheritSourceImageTags {
+		sourceImage := state.Get("source_image").(*ecs.Image)
+		imageTags = mergeSourceImageTags(sourceImage, s.SourceImageTagKeys, s.Tags)
+	}
+
+	if len(imageTags) == 0 {
 		return multistep.ActionContinue
 	}
 
-	ui.Say(fmt.Sprintf("Adding tags(%s) to image: %s", s.Tags, imageId))
+	ui.Say(fmt.Sprintf("Adding tags(%s) to image: %s", imageTags, imageId))
 
 	var tags []ecs.AddTagsTag
-	for key, value := range s.Tags {
+	for key, value := range imageTags {
 		var tag ecs.AddTagsTag
 		tag.Key = key
 		tag.Value = value
@@ -36,35 +53,129 @@ func (s *stepCreateTags) Run(ctx context.Context, state multistep.StateBag) mult
 
 	addTagsRequest := ecs.CreateAddTagsRequest()
 	addTagsRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
-	addTagsRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.Department}
+	addTagsRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
 	addTagsRequest.RegionId = config.ApsaraStackRegion
 	addTagsRequest.ResourceId = imageId
 	addTagsRequest.ResourceType = TagResourceImage
 	addTagsRequest.Tag = &tags
 
+	// The image exists at this point and is worth more than its tags, so
+	// tagging errors are only reported instead of halting, which would
+	// delete the image.
 	if _, err := client.AddTags(addTagsRequest); err != nil {
-		return halt(state, err, "Error Adding tags to image")
+		ui.Error(fmt.Sprintf("Error Adding tags to image %s, continuing without them: %s", imageId, err))
+		return multistep.ActionContinue
 	}
 
-	for _, snapshotId := range snapshotIds {
-		ui.Say(fmt.Sprintf("Adding tags(%s) to snapshot: %s", s.Tags, snapshotId))
-		addTagsRequest := ecs.CreateAddTagsRequest()
-		addTagsRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
-		addTagsRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.Department}
-
-		addTagsRequest.RegionId = config.ApsaraStackRegion
-		addTagsRequest.ResourceId = snapshotId
-		addTagsRequest.ResourceType = TagResourceSnapshot
-		addTagsRequest.Tag = &tags
-
-		if _, err := client.AddTags(addTagsRequest); err != nil {
-			return halt(state, err, "Error Adding tags to snapshot")
+	if config.VerifyTagsVisible {
+		ui.Message(fmt.Sprintf("Waiting for tags to be visible on image: %s", imageId))
+		if _, err := client.WaitForTagsVisible(ctx, config.ApsaraStackRegion, TagResourceImage, imageId, imageTags, config.TagVisibilityTimeout, state); err != nil {
+			ui.Error(fmt.Sprintf("Timeout waiting for tags to be visible on image %s: %s", imageId, err))
 		}
 	}
 
+	if !s.TagSnapshots {
+		return multistep.ActionContinue
+	}
+
+	if err := s.tagSnapshots(ctx, state, snapshotIds, tags); err != nil {
+		ui.Error(fmt.Sprintf("Error Adding tags to snapshots, continuing without them: %s", err))
+	}
+
 	return multistep.ActionContinue
 }
+
 func (s *stepCreateTags) Cleanup(state multistep.StateBag) {
 	// Nothing need to do, tags will be cleaned when the resource is cleaned
 }
+
+// tagSnapshots adds the tags to the snapshots with at most Concurrency
+// requests in flight. Every snapshot is tried, the errors of the failed ones
+// are returned together.
+func (s *stepCreateTags) tagSnapshots(ctx context.Context, state multistep.StateBag, snapshotIds []string, tags []ecs.AddTagsTag) error {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs *packer.MultiError
+	)
+	snapshotIdsChan := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The SDK changes its client on every request, every goroutine
+			// uses a client of its own.
+			client, err := state.Get("client").(*ClientWrapper).Clone()
+			for snapshotId := range snapshotIdsChan {
+				if err != nil {
+					lock.Lock()
+					errs = packer.MultiErrorAppend(errs, fmt.Errorf("snapshot %s: %s", snapshotId, err))
+					lock.Unlock()
+					continue
+				}
+
+				ui.Say(fmt.Sprintf("Adding tags to snapshot: %s", snapshotId))
+				addTagsRequest := ecs.CreateAddTagsRequest()
+				addTagsRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+				addTagsRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+				addTagsRequest.RegionId = config.ApsaraStackRegion
+				addTagsRequest.ResourceId = snapshotId
+				addTagsRequest.ResourceType = TagResourceSnapshot
+				addTagsRequest.Tag = &tags
+
+				_, tagErr := client.WaitForExpected(&WaitForExpectArgs{
+					Context: ctx,
+					RequestFunc: func() (responses.AcsResponse, error) {
+						return client.AddTags(addTagsRequest)
+					},
+					EvalFunc: client.EvalCouldRetryResponse(addTagsRetryErrors, EvalRetryErrorType),
+				})
+				if tagErr != nil {
+					lock.Lock()
+					errs = packer.MultiErrorAppend(errs, fmt.Errorf("snapshot %s: %s", snapshotId, tagErr))
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, snapshotId := range snapshotIds {
+		snapshotIdsChan <- snapshotId
+	}
+	close(snapshotIdsChan)
+	wg.Wait()
+
+	if errs != nil {
+		return errs
+	}
+
+	return nil
+}
+
+// mergeSourceImageTags returns the tags of the source image allowed by keys,
+// or all of them when keys is empty, overridden by the explicit tags.
+func mergeSourceImageTags(sourceImage *ecs.Image, keys []string, tags map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, tag := range sourceImage.Tags.Tag {
+		if len(keys) > 0 && !ContainsInArray(keys, tag.TagKey) {
+			continue
+		}
+		merged[tag.TagKey] = tag.TagValue
+	}
+
+	for key, value := range tags {
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,152 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestMergeSourceImageTags(t *testing.T) {
+	sourceImage := &ecs.Image{}
+	sourceImage.Tags.Tag = []ecs.Tag{
+		{TagKey: "os", TagValue: "centos"},
+		{TagKey: "lineage", TagValue: "base"},
+		{TagKey: "owner", TagValue: "platform"},
+	}
+	tags := map[string]string{"owner": "infra"}
+
+	merged := mergeSourceImageTags(sourceImage, nil, tags)
+	expected := map[string]string{"os": "centos", "lineage": "base", "owner": "infra"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("invalid value, expected: %v, actual: %v", expected, merged)
+	}
+
+	merged = mergeSourceImageTags(sourceImage, []string{"lineage", "owner"}, tags)
+	expected = map[string]string{"lineage": "base", "owner": "infra"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("invalid value, expected: %v, actual: %v", expected, merged)
+	}
+}
+
+func TestStepCreateTags_tagSnapshotsConcurrently(t *testing.T) {
+	var (
+		lock              sync.Mutex
+		inFlight, maxSeen int
+		tagged            []string
+	)
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "AddTags" {
+			t.Errorf("unexpected action: %s", action)
+		}
+
+		lock.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		tagged = append(tagged, params.Get("ResourceId"))
+		lock.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+
+		if params.Get("ResourceId") == "s-broken" {
+			return http.StatusNotFound, testErrorBody("InvalidSnapshotId.NotFound")
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	snapshotIds := []string{"s-1", "s-2", "s-broken", "s-3", "s-4", "s-5"}
+	var errorOutput bytes.Buffer
+	state := testState(client, &Config{})
+	state.Put("ui", &packer.BasicUi{
+		Reader:      new(bytes.Buffer),
+		Writer:      ioutil.Discard,
+		ErrorWriter: &errorOutput,
+	})
+	state.Put("ApsaraStackimage", "m-test")
+	state.Put("ApsaraStacksnapshots", snapshotIds)
+
+	step := &stepCreateTags{
+		Tags:         map[string]string{"build": "foo"},
+		Concurrency:  2,
+		TagSnapshots: true,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("shouldn't halt when a snapshot can't be tagged: %s", state.Get("error"))
+	}
+
+	if !strings.Contains(errorOutput.String(), "s-broken") {
+		t.Fatalf("the failed snapshot should be reported: %s", errorOutput.String())
+	}
+	if len(tagged) != len(snapshotIds)+1 {
+		t.Fatalf("the image and every snapshot should be tagged, actual: %v", tagged)
+	}
+	if maxSeen > 2 {
+		t.Fatalf("at most 2 requests should be in flight, actual: %d", maxSeen)
+	}
+}
+
+func TestStepCreateTags_skipSnapshots(t *testing.T) {
+	var tagged []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		tagged = append(tagged, params.Get("ResourceId"))
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	state := testState(client, &Config{})
+	state.Put("ApsaraStackimage", "m-test")
+	state.Put("ApsaraStacksnapshots", []string{"s-1", "s-2"})
+
+	step := &stepCreateTags{
+		Tags: map[string]string{"build": "foo"},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should not halt: %s", state.Get("error"))
+	}
+
+	if !reflect.DeepEqual(tagged, []string{"m-test"}) {
+		t.Fatalf("only the image should be tagged, actual: %v", tagged)
+	}
+}
+
+func TestStepCreateTags_resourceGroup(t *testing.T) {
+	var groups []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		groups = append(groups, params.Get("ResourceGroup"))
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	config := &Config{}
+	config.Department = "dept-test"
+	config.ResourceGroup = "rg-test"
+	state := testState(client, config)
+	state.Put("ApsaraStackimage", "m-test")
+	state.Put("ApsaraStacksnapshots", []string{"s-1"})
+
+	step := &stepCreateTags{
+		Tags:         map[string]string{"build": "foo"},
+		TagSnapshots: true,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should not halt: %s", state.Get("error"))
+	}
+
+	if !reflect.DeepEqual(groups, []string{"rg-test", "rg-test"}) {
+		t.Fatalf("the image and the snapshot should be tagged in the resource group, actual: %v", groups)
+	}
+}
@@ -0,0 +1,67 @@
+package ecs
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// stepDryRunInstance validates the instance of the build by a dry run of
+// CreateInstance. It takes the place of the steps creating resources when
+// dry_run is set, the instance is validated in the security group and the
+// vSwitch given in the template.
+type stepDryRunInstance struct {
+	Instance *stepCreateApsaraStackInstance
+}
+
+func (s *stepDryRunInstance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	state.Put("securitygroupid", config.SecurityGroupId)
+	if state.Get("networktype").(InstanceNetWork) == InstanceNetworkVpc {
+		state.Put("vswitchid", config.VSwitchId)
+	}
+
+	ui.Say("Dry run of creating the instance...")
+	request, err := s.Instance.buildCreateInstanceRequest(state)
+	if err != nil {
+		return halt(state, err, "Dry run failed")
+	}
+
+	request.DryRun = requests.NewBoolean(true)
+	response, err := client.CreateInstance(request)
+	if err == nil {
+		// The stack ignored DryRun, Cleanup deletes the instance again.
+		s.Instance.instanceId = response.InstanceId
+		err = fmt.Errorf("the stack doesn't support dry runs and created instance %s", response.InstanceId)
+		return halt(state, err, "Dry run failed")
+	}
+
+	var e errors.Error
+	if !stderrors.As(err, &e) {
+		return halt(state, err, "Dry run failed, the instance can't be created")
+	}
+	switch {
+	case e.ErrorCode() == "DryRunOperation":
+		ui.Say(fmt.Sprintf("Dry run succeeded, an instance of type %s can be created from image %s in security group %s. "+
+			"No resource was created, the build stops here.", request.InstanceType, request.ImageId, request.SecurityGroupId))
+		return multistep.ActionContinue
+	case strings.HasPrefix(e.ErrorCode(), "InvalidParameter"):
+		err = fmt.Errorf("a parameter of the instance is invalid, %s: %s", e.ErrorCode(), e.Message())
+		return halt(state, err, "Dry run failed")
+	}
+
+	return halt(state, err, "Dry run failed, the instance can't be created")
+}
+
+func (s *stepDryRunInstance) Cleanup(state multistep.StateBag) {
+	s.Instance.Cleanup(state)
+}
@@ -0,0 +1,105 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepDryRunInstance(t *testing.T) {
+	code := "DryRunOperation"
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "CreateInstance" || params.Get("DryRun") != "true" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("DryRun"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		if params.Get("SecurityGroupId") != "sg-test" || params.Get("VSwitchId") != "vsw-test" {
+			t.Errorf("the dry run should use the resources of the template: %v", params)
+		}
+		return http.StatusBadRequest, testErrorBody(code)
+	})
+
+	config := testCreateInstanceConfig()
+	config.SecurityGroupId = "sg-test"
+	config.VpcId = "vpc-test"
+	config.VSwitchId = "vsw-test"
+	newState := func() multistep.StateBag {
+		state := testState(client, config)
+		state.Put("networktype", InstanceNetWork(InstanceNetworkVpc))
+		state.Put("source_image", &ecs.Image{ImageId: "m-source"})
+		return state
+	}
+	step := &stepDryRunInstance{
+		Instance: &stepCreateApsaraStackInstance{
+			InstanceType: config.InstanceType,
+			RegionId:     config.ApsaraStackRegion,
+		},
+	}
+
+	state := newState()
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	step.Cleanup(state)
+
+	code = "InvalidParameter.Mismatch"
+	state = newState()
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %s", action)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "a parameter of the instance is invalid, InvalidParameter.Mismatch") {
+		t.Fatalf("the invalid parameter should be reported: %s", err)
+	}
+
+	code = "InvalidInstanceType.NotSupported"
+	state = newState()
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %s", action)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "the instance can't be created") {
+		t.Fatalf("bad error: %s", err)
+	}
+	if step.Instance.instanceId != "" {
+		t.Fatalf("no instance should be created: %s", step.Instance.instanceId)
+	}
+}
+
+func TestStepDryRunInstance_notSupported(t *testing.T) {
+	var deleted string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CreateInstance":
+			return http.StatusOK, `{"RequestId":"test-request","InstanceId":"i-test"}`
+		case "DeleteInstance":
+			deleted = params.Get("InstanceId")
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		case "DescribeInstances":
+			return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request"}`
+	})
+
+	config := testCreateInstanceConfig()
+	config.SecurityGroupId = "sg-test"
+	state := testCreateInstanceState(client, config)
+	step := &stepDryRunInstance{
+		Instance: &stepCreateApsaraStackInstance{
+			InstanceType: config.InstanceType,
+			RegionId:     config.ApsaraStackRegion,
+		},
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("an ignored dry run should fail the build")
+	}
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if deleted != "i-test" {
+		t.Fatalf("the instance created by the ignored dry run should be deleted, actual: %q", deleted)
+	}
+}
@@ -0,0 +1,167 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepExportApsaraStackImage struct {
+	OSSBucket   string
+	OSSPrefix   string
+	WaitTimeout int
+	taskId      string
+	finished    bool
+}
+
+func (s *stepExportApsaraStackImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+
+	imageId := state.Get("ApsaraStackimages").(map[string]string)[config.ApsaraStackRegion]
+	ui.Say(fmt.Sprintf("Exporting image %s to OSS bucket %s...", imageId, s.OSSBucket))
+
+	request := ecs.CreateExportImageRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.ImageId = imageId
+	request.OSSBucket = s.OSSBucket
+	request.OSSPrefix = s.OSSPrefix
+
+	response, err := client.ExportImage(request)
+	if err != nil {
+		if errorCodeContains(err, "OSS") {
+			err = fmt.Errorf("%w, the OSS bucket %s has to exist in the region %s of the image", err, s.OSSBucket, config.ApsaraStackRegion)
+		}
+		return halt(state, err, "Error exporting image")
+	}
+	s.taskId = response.TaskId
+
+	task, err := s.waitForExport(ctx, state)
+	if err != nil {
+		return halt(state, err, "Error waiting for image export")
+	}
+	s.finished = true
+
+	objects := exportedObjects(task)
+	if len(objects) == 0 {
+		log.Printf("[DEBUG] Export task %s doesn't report the object keys", s.taskId)
+	}
+	for _, object := range objects {
+		ui.Message(fmt.Sprintf("Exported image %s to oss://%s/%s", imageId, s.OSSBucket, object))
+	}
+	state.Put("ApsaraStackexportedobjects", objects)
+
+	return multistep.ActionContinue
+}
+
+// waitForExport waits until the export task finishes, a failed task is
+// reported with the reason given by ECS.
+func (s *stepExportApsaraStackImage) waitForExport(ctx context.Context, state multistep.StateBag) (*ecs.DescribeTaskAttributeResponse, error) {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+
+	timeout := s.WaitTimeout
+	if timeout <= 0 {
+		timeout = APSARASTACK_DEFAULT_LONG_TIMEOUT
+	}
+
+	progress := ""
+	response, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
+		RequestFunc: func() (responses.AcsResponse, error) {
+			request := ecs.CreateDescribeTaskAttributeRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			request.RegionId = config.ApsaraStackRegion
+			request.TaskId = s.taskId
+
+			response, err := client.DescribeTaskAttribute(request)
+			if err == nil && response.TaskProcess != "" && response.TaskProcess != progress {
+				progress = response.TaskProcess
+				ui.Message(fmt.Sprintf("Export task %s progress: %s", s.taskId, progress))
+			}
+			return response, err
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if err != nil {
+				return WaitForExpectToRetry
+			}
+
+			switch response.(*ecs.DescribeTaskAttributeResponse).TaskStatus {
+			case TaskStatusFinished:
+				return WaitForExpectSuccess
+			case TaskStatusFailed, TaskStatusDeleted:
+				return WaitForExpectFailToStop
+			}
+
+			return WaitForExpectToRetry
+		},
+		RetryTimeout: time.Duration(timeout) * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Timeout waiting for export task %s to finish: %w", s.taskId, err)
+	}
+
+	task := response.(*ecs.DescribeTaskAttributeResponse)
+	if task.TaskStatus != TaskStatusFinished {
+		var reasons []string
+		for _, operation := range task.OperationProgressSet.OperationProgress {
+			if operation.ErrorMsg != "" {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", operation.ErrorCode, operation.ErrorMsg))
+			}
+		}
+		return nil, fmt.Errorf("export task %s is %s: %s", s.taskId, task.TaskStatus, strings.Join(reasons, ", "))
+	}
+
+	return task, nil
+}
+
+// exportedObjects collects the OSS object keys reported by a finished export
+// task.
+func exportedObjects(task *ecs.DescribeTaskAttributeResponse) []string {
+	var objects []string
+	for _, operation := range task.OperationProgressSet.OperationProgress {
+		for _, item := range operation.RelatedItemSet.RelatedItem {
+			if strings.Contains(strings.ToLower(item.Name), "object") && item.Value != "" {
+				objects = append(objects, item.Value)
+			}
+		}
+	}
+
+	return objects
+}
+
+func (s *stepExportApsaraStackImage) Cleanup(state multistep.StateBag) {
+	if s.taskId == "" || s.finished {
+		return
+	}
+
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Cancelling export task %s...", s.taskId))
+
+	request := ecs.CreateCancelTaskRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.TaskId = s.taskId
+	if _, err := client.CancelTask(request); err != nil {
+		ui.Error(fmt.Sprintf("Error cancelling export task %s, the partial export may still be in the bucket: %s", s.taskId, err))
+	}
+}
@@ -0,0 +1,135 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func testExportImageState(client *ClientWrapper) multistep.StateBag {
+	state := testState(client, testCreateInstanceConfig())
+	state.Get("config").(*Config).ApsaraStackRegion = "cn-test"
+	state.Put("ApsaraStackimages", map[string]string{"cn-test": "m-test"})
+	return state
+}
+
+func TestStepExportImage(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "ExportImage":
+			if params.Get("ImageId") != "m-test" || params.Get("OSSBucket") != "bucket" || params.Get("OSSPrefix") != "images/" {
+				t.Errorf("bad export request: %v", params)
+			}
+			return http.StatusOK, `{"RequestId":"test-request","TaskId":"t-test"}`
+		case "DescribeTaskAttribute":
+			return http.StatusOK, `{"RequestId":"test-request","TaskId":"t-test","TaskStatus":"Finished","OperationProgressSet":{"OperationProgress":[{"OperationStatus":"Success","RelatedItemSet":{"RelatedItem":[{"Name":"OSSObject","Value":"images/m-test_system.raw"}]}}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testExportImageState(client)
+
+	step := &stepExportApsaraStackImage{OSSBucket: "bucket", OSSPrefix: "images/"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+	step.Cleanup(state)
+
+	objects := state.Get("ApsaraStackexportedobjects").([]string)
+	if !reflect.DeepEqual(objects, []string{"images/m-test_system.raw"}) {
+		t.Fatalf("bad exported objects: %v", objects)
+	}
+}
+
+func TestStepExportImage_bucketInOtherRegion(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "ExportImage" {
+			t.Errorf("unexpected action: %s", action)
+		}
+		return http.StatusBadRequest, testErrorBody("InvalidOSSBucket.NotFound")
+	})
+	state := testExportImageState(client)
+
+	step := &stepExportApsaraStackImage{OSSBucket: "bucket"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("should halt")
+	}
+
+	err := state.Get("error").(error)
+	if !strings.Contains(err.Error(), "region cn-test") {
+		t.Fatalf("error should name the region of the image: %s", err)
+	}
+}
+
+func TestStepExportImage_cancelFailedTask(t *testing.T) {
+	var cancelled string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "ExportImage":
+			return http.StatusOK, `{"RequestId":"test-request","TaskId":"t-test"}`
+		case "DescribeTaskAttribute":
+			return http.StatusOK, `{"RequestId":"test-request","TaskId":"t-test","TaskStatus":"Failed","OperationProgressSet":{"OperationProgress":[{"OperationStatus":"Failed","ErrorCode":"NoPermission","ErrorMsg":"the role can't write to the bucket"}]}}`
+		case "CancelTask":
+			cancelled = params.Get("TaskId")
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testExportImageState(client)
+
+	step := &stepExportApsaraStackImage{OSSBucket: "bucket"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("should halt")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "can't write to the bucket") {
+		t.Fatalf("error should carry the reason of the task: %s", err)
+	}
+
+	step.Cleanup(state)
+	if cancelled != "t-test" {
+		t.Fatalf("the failed export task should be cancelled, actual: %q", cancelled)
+	}
+}
+
+func TestStepExportImage_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var cancelled string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "ExportImage":
+			return http.StatusOK, `{"RequestId":"test-request","TaskId":"t-test"}`
+		case "DescribeTaskAttribute":
+			cancel()
+			return http.StatusOK, `{"RequestId":"test-request","TaskId":"t-test","TaskStatus":"Processing"}`
+		case "CancelTask":
+			cancelled = params.Get("TaskId")
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testExportImageState(client)
+
+	start := time.Now()
+	step := &stepExportApsaraStackImage{OSSBucket: "bucket"}
+	if action := step.Run(ctx, state); action != multistep.ActionHalt {
+		t.Fatalf("a cancelled build should halt")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the wait should stop with the build, took %s", elapsed)
+	}
+
+	step.Cleanup(state)
+	if cancelled != "t-test" {
+		t.Fatalf("the unfinished export task should be cancelled, actual: %q", cancelled)
+	}
+}
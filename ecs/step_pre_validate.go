@@ -3,26 +3,72 @@ package ecs
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ram"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
 
 type stepPreValidate struct {
-	ApsaraStackDestImageName string
-	ForceDelete              bool
+	ApsaraStackDestImageName   string
+	ForceDelete                bool
+	FailIfNoop                 bool
+	SourceImageId              string
+	RamRoleName                string
+	VerifyRamRole              bool
+	InstanceType               string
+	ZoneId                     string
+	SkipInstanceTypeValidation bool
+	KeyPairName                string
+	DedicatedHostId            string
+	DataDisks                  []ApsaraStackDiskDevice
 }
 
+// The number of alternative instance types suggested when the instance type
+// isn't available.
+const maxSuggestedInstanceTypes = 5
+
 func (s *stepPreValidate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	if err := s.validateRegions(state); err != nil {
 		return halt(state, err, "")
 	}
 
+	if err := s.validateInstanceType(state); err != nil {
+		return halt(state, err, "")
+	}
+
+	if err := s.validateCustomSize(state); err != nil {
+		return halt(state, err, "")
+	}
+
+	if err := s.validateNoop(state); err != nil {
+		return halt(state, err, "")
+	}
+
 	if err := s.validateDestImageName(state); err != nil {
 		return halt(state, err, "")
 	}
 
+	if err := s.validateRamRole(state); err != nil {
+		return halt(state, err, "")
+	}
+
+	if err := s.validateKeyPair(state); err != nil {
+		return halt(state, err, "")
+	}
+
+	if err := s.validateDedicatedHost(state); err != nil {
+		return halt(state, err, "")
+	}
+
+	if err := s.validateDataDiskSnapshots(state); err != nil {
+		return halt(state, err, "")
+	}
+
 	if err := s.validateinsecure(state); err != nil {
 		return halt(state, err, "")
 	}
@@ -91,6 +137,398 @@ func (s *stepPreValidate) validateDestImageName(state multistep.StateBag) error
 
 	return nil
 }
+
+// validateNoop fails when an image with the target name was already built
+// from the current source image, so rebuilding it wouldn't change anything.
+func (s *stepPreValidate) validateNoop(state multistep.StateBag) error {
+	if !s.FailIfNoop {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	// The source image may have been found by source_image_filter.
+	sourceImageId := s.SourceImageId
+	if image, ok := state.GetOk("source_image"); ok {
+		sourceImageId = image.(*ecs.Image).ImageId
+	}
+	if config.SourceSnapshotId != "" {
+		sourceImageId = config.SourceSnapshotId
+	}
+
+	ui.Say("Prevalidating image is not up-to-date...")
+
+	describeImagesRequest := ecs.CreateDescribeImagesRequest()
+	describeImagesRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeImagesRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describeImagesRequest.RegionId = config.ApsaraStackRegion
+	describeImagesRequest.ImageName = s.ApsaraStackDestImageName
+	describeImagesRequest.Status = ImageStatusQueried
+
+	imagesResponse, err := client.DescribeImages(describeImagesRequest)
+	if err != nil {
+		return fmt.Errorf("Error querying ApsaraStack image: %s", err)
+	}
+
+	for _, image := range imagesResponse.Images.Image {
+		if image.ImageName != s.ApsaraStackDestImageName {
+			continue
+		}
+		for _, tag := range image.Tags.Tag {
+			if tag.TagKey == TagKeySourceImage && tag.TagValue == sourceImageId {
+				return fmt.Errorf("Error: Image %s is already built from source image %s, "+
+					"fail_if_noop is set so it isn't built again", image.ImageId, sourceImageId)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateKeyPair checks that the key pair the instance is launched with
+// exists in the region.
+func (s *stepPreValidate) validateKeyPair(state multistep.StateBag) error {
+	if s.KeyPairName == "" {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	ui.Say(fmt.Sprintf("Prevalidating key pair %s...", s.KeyPairName))
+
+	request := ecs.CreateDescribeKeyPairsRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.KeyPairName = s.KeyPairName
+	response, err := client.DescribeKeyPairs(request)
+	if err != nil {
+		return fmt.Errorf("Error querying key pair %s: %s", s.KeyPairName, err)
+	}
+
+	for _, keyPair := range response.KeyPairs.KeyPair {
+		if keyPair.KeyPairName == s.KeyPairName {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("The key pair %s doesn't exist in region %s", s.KeyPairName, config.ApsaraStackRegion)
+}
+
+// validateDataDiskSnapshots checks the data disks restored from snapshots
+// before the instance is created, which would fail late otherwise.
+func (s *stepPreValidate) validateDataDiskSnapshots(state multistep.StateBag) error {
+	var snapshotIds []string
+	for _, disk := range s.DataDisks {
+		if disk.SnapshotId != "" {
+			snapshotIds = append(snapshotIds, disk.SnapshotId)
+		}
+	}
+	if len(snapshotIds) == 0 {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	ui.Say("Prevalidating data disk snapshots...")
+
+	request := ecs.CreateDescribeSnapshotsRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.SnapshotIds = fmt.Sprintf("[\"%s\"]", strings.Join(snapshotIds, "\",\""))
+	request.PageSize = requests.NewInteger(100)
+	response, err := client.DescribeSnapshots(request)
+	if err != nil {
+		return fmt.Errorf("Error querying data disk snapshots: %s", err)
+	}
+
+	snapshots := make(map[string]ecs.Snapshot)
+	for _, snapshot := range response.Snapshots.Snapshot {
+		snapshots[snapshot.SnapshotId] = snapshot
+	}
+
+	var errs *packer.MultiError
+	for i, disk := range s.DataDisks {
+		if disk.SnapshotId == "" {
+			continue
+		}
+
+		snapshot, ok := snapshots[disk.SnapshotId]
+		if !ok {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("image_disk_mappings[%d]: the snapshot %s doesn't exist in region %s",
+				i, disk.SnapshotId, config.ApsaraStackRegion))
+			continue
+		}
+		if snapshot.Status != SnapshotStatusAccomplished {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("image_disk_mappings[%d]: the snapshot %s is %s, not %s",
+				i, disk.SnapshotId, snapshot.Status, SnapshotStatusAccomplished))
+			continue
+		}
+		size, err := strconv.Atoi(snapshot.SourceDiskSize)
+		if err != nil {
+			continue
+		}
+		// A disk can't be smaller than the snapshot it is restored from.
+		if disk.DiskSize != 0 && disk.DiskSize < size {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("image_disk_mappings[%d]: disk_size %d is smaller than the %d GB of the snapshot %s",
+				i, disk.DiskSize, size, disk.SnapshotId))
+			continue
+		}
+		// Without disk_size the disk gets the size of the snapshot, which has
+		// to be in the range of the disk category.
+		if min, max, ok := diskSizeRange(disk.DiskCategory, disk.PerformanceLevel, false); ok && disk.DiskSize == 0 && (size < min || size > max) {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("image_disk_mappings[%d]: the %d GB of the snapshot %s are out of the %d to %d GiB of %s disks, set disk_size",
+				i, size, disk.SnapshotId, min, max, diskCategoryName(disk)))
+		}
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (s *stepPreValidate) validateDedicatedHost(state multistep.StateBag) error {
+	if s.DedicatedHostId == "" {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	ui.Say(fmt.Sprintf("Prevalidating dedicated host %s...", s.DedicatedHostId))
+
+	request := ecs.CreateDescribeDedicatedHostsRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.DedicatedHostIds = fmt.Sprintf("[\"%s\"]", s.DedicatedHostId)
+	response, err := client.DescribeDedicatedHosts(request)
+	if err != nil {
+		return fmt.Errorf("Error querying dedicated host %s: %s", s.DedicatedHostId, err)
+	}
+
+	hosts := response.DedicatedHosts.DedicatedHost
+	if len(hosts) == 0 {
+		return fmt.Errorf("The dedicated host %s doesn't exist in region %s", s.DedicatedHostId, config.ApsaraStackRegion)
+	}
+	host := hosts[0]
+
+	if s.ZoneId != "" && host.ZoneId != s.ZoneId {
+		return fmt.Errorf("The dedicated host %s is in zone %s, not in zone_id %s", s.DedicatedHostId, host.ZoneId, s.ZoneId)
+	}
+	if s.ZoneId == "" {
+		// The network and the instance have to be in the zone of the host.
+		state.Put("zoneid", host.ZoneId)
+	}
+
+	if !dedicatedHostSupports(host, s.InstanceType) {
+		return fmt.Errorf("The dedicated host %s of type %s doesn't support the instance type %s, supported types are %s",
+			s.DedicatedHostId, host.DedicatedHostType, s.InstanceType,
+			strings.Join(append(host.SupportedInstanceTypesList.SupportedInstanceTypesList,
+				host.SupportedInstanceTypeFamilies.SupportedInstanceTypeFamily...), ", "))
+	}
+
+	return nil
+}
+
+// dedicatedHostSupports reports whether instances of the type can be created
+// on the host, a host which doesn't list its types is assumed to support it.
+func dedicatedHostSupports(host ecs.DedicatedHost, instanceType string) bool {
+	types := host.SupportedInstanceTypesList.SupportedInstanceTypesList
+	families := host.SupportedInstanceTypeFamilies.SupportedInstanceTypeFamily
+	if len(types) == 0 && len(families) == 0 {
+		return true
+	}
+
+	for _, supported := range types {
+		if supported == instanceType {
+			return true
+		}
+	}
+	// Instance types are named after their family, such as ecs.g6.large in
+	// the ecs.g6 family.
+	for _, family := range families {
+		if strings.HasPrefix(instanceType, family+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateRamRole makes sure ECS is allowed to assume the RAM role of the
+// instance, an instance launched with a role it can't assume has no
+// credentials.
+func (s *stepPreValidate) validateRamRole(state multistep.StateBag) error {
+	if !s.VerifyRamRole {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	ui.Say(fmt.Sprintf("Prevalidating RAM role %s...", s.RamRoleName))
+
+	ramClient, err := ram.NewClientWithStsToken(config.ApsaraStackRegion, config.ApsaraStackAccessKey, config.ApsaraStackSecretKey, config.SecurityToken)
+	if err != nil {
+		return fmt.Errorf("Error initializing the RAM client: %s", err)
+	}
+	ramClient.Domain = config.serviceEndpoint("ram", client.Domain)
+	copyConnectionSettings(&ramClient.Client, client)
+	enableAPILogging(&ramClient.Client, "RAM")
+
+	getRoleRequest := ram.CreateGetRoleRequest()
+	getRoleRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	getRoleRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ram", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	getRoleRequest.RoleName = s.RamRoleName
+	roleResponse, err := ramClient.GetRole(getRoleRequest)
+	if err != nil {
+		return fmt.Errorf("Error querying RAM role %s: %s", s.RamRoleName, err)
+	}
+
+	assumable, err := ramRoleAssumableByEcs(roleResponse.Role.AssumeRolePolicyDocument)
+	if err != nil {
+		return fmt.Errorf("Error validating RAM role %s: %s", s.RamRoleName, err)
+	}
+	if !assumable {
+		return fmt.Errorf("Error: RAM role %s can't be assumed by ECS, its trust policy must allow "+
+			"sts:AssumeRole for the service %s", s.RamRoleName, ramRoleEcsPrincipal)
+	}
+
+	return nil
+}
+
+// validateInstanceType makes sure the instance type can be created in the
+// zone, or anywhere in the region when no zone is given, before any resource
+// is created for the build.
+func (s *stepPreValidate) validateInstanceType(state multistep.StateBag) error {
+	if s.SkipInstanceTypeValidation {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	ui.Say(fmt.Sprintf("Prevalidating instance type %s...", s.InstanceType))
+
+	request := ecs.CreateDescribeAvailableResourceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.ZoneId = s.ZoneId
+	request.DestinationResource = "InstanceType"
+	request.InstanceChargeType = config.InstanceChargeType
+	response, err := client.DescribeAvailableResource(request)
+	if err != nil {
+		return fmt.Errorf("Error querying available instance types: %s", err)
+	}
+
+	var offered bool
+	var alternatives []string
+	seen := make(map[string]struct{})
+	for _, zone := range response.AvailableZones.AvailableZone {
+		for _, resource := range zone.AvailableResources.AvailableResource {
+			if resource.Type != "InstanceType" {
+				continue
+			}
+			for _, supported := range resource.SupportedResources.SupportedResource {
+				if supported.Value == s.InstanceType {
+					if supported.Status == "Available" && zone.Status == "Available" {
+						return nil
+					}
+					offered = true
+					continue
+				}
+				if _, ok := seen[supported.Value]; ok || supported.Status != "Available" {
+					continue
+				}
+				seen[supported.Value] = struct{}{}
+				if len(alternatives) < maxSuggestedInstanceTypes {
+					alternatives = append(alternatives, supported.Value)
+				}
+			}
+		}
+	}
+
+	location := config.ApsaraStackRegion
+	if s.ZoneId != "" {
+		location = s.ZoneId
+	}
+	reason := "isn't offered"
+	if offered {
+		reason = "is sold out"
+	}
+	err = fmt.Errorf("Error: instance type %s %s in %s", s.InstanceType, reason, location)
+	if len(alternatives) > 0 {
+		err = fmt.Errorf("%s, available instance types include %s", err, strings.Join(alternatives, ", "))
+	}
+	return err
+}
+
+// validateCustomSize checks whether the instance type can be customized to
+// cpu and memory. When it can't, they are dropped with a warning and the
+// instance gets the size of its type.
+func (s *stepPreValidate) validateCustomSize(state multistep.StateBag) error {
+	config := state.Get("config").(*Config)
+	if config.Cpu == 0 || s.SkipInstanceTypeValidation {
+		return nil
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("client").(*ClientWrapper)
+
+	ui.Say(fmt.Sprintf("Prevalidating %d vCPUs and %d GiB for instance type %s...", config.Cpu, config.Memory, s.InstanceType))
+
+	request := ecs.CreateDescribeAvailableResourceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.ZoneId = s.ZoneId
+	request.DestinationResource = "InstanceType"
+	request.InstanceType = s.InstanceType
+	request.Cores = requests.NewInteger(config.Cpu)
+	request.Memory = requests.NewFloat(float64(config.Memory))
+	response, err := client.DescribeAvailableResource(request)
+	if err != nil {
+		return fmt.Errorf("Error querying the sizes of instance type %s: %s", s.InstanceType, err)
+	}
+
+	for _, zone := range response.AvailableZones.AvailableZone {
+		for _, resource := range zone.AvailableResources.AvailableResource {
+			for _, supported := range resource.SupportedResources.SupportedResource {
+				if supported.Value == s.InstanceType && supported.Status == "Available" {
+					return nil
+				}
+			}
+		}
+	}
+
+	ui.Error(fmt.Sprintf("Warning: instance type %s can't be customized to %d vCPUs and %d GiB, cpu and memory are ignored",
+		s.InstanceType, config.Cpu, config.Memory))
+	config.Cpu = 0
+	config.Memory = 0
+	return nil
+}
+
 func (s *stepPreValidate) validateinsecure(state multistep.StateBag) error {
 	ui := state.Get("ui").(packer.Ui)
 	config := state.Get("config").(*Config)
@@ -0,0 +1,248 @@
+package ecs
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStepPreValidate_validateNoop(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeImages" || params.Get("ImageName") != "foo" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("ImageName"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-built","ImageName":"foo",` +
+			`"Tags":{"Tag":[{"TagKey":"packer_source_image","TagValue":"m-source"}]}}]}}`
+	})
+	state := testState(client, &Config{})
+
+	step := &stepPreValidate{
+		ApsaraStackDestImageName: "foo",
+		FailIfNoop:               true,
+		SourceImageId:            "m-source",
+	}
+	err := step.validateNoop(state)
+	if err == nil || !strings.Contains(err.Error(), "m-built") {
+		t.Fatalf("should fail for an image built from the same source: %v", err)
+	}
+
+	step.SourceImageId = "m-newer"
+	if err := step.validateNoop(state); err != nil {
+		t.Fatalf("shouldn't fail for a new source image: %s", err)
+	}
+
+	step.FailIfNoop = false
+	step.SourceImageId = "m-source"
+	if err := step.validateNoop(state); err != nil {
+		t.Fatalf("shouldn't fail unless fail_if_noop is set: %s", err)
+	}
+}
+
+func TestStepPreValidate_validateRamRole(t *testing.T) {
+	document := `{"Statement":[{"Action":"sts:AssumeRole","Effect":"Allow","Principal":{"Service":["oss.aliyuncs.com"]}}],"Version":"1"}`
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "GetRole" || params.Get("RoleName") != "packer" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("RoleName"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Role":{"RoleName":"packer","AssumeRolePolicyDocument":` + strconv.Quote(document) + `}}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	config.ApsaraStackAccessKey = "foo"
+	config.ApsaraStackSecretKey = "bar"
+	state := testState(client, config)
+
+	step := &stepPreValidate{
+		RamRoleName:   "packer",
+		VerifyRamRole: true,
+	}
+	err := step.validateRamRole(state)
+	if err == nil || !strings.Contains(err.Error(), "can't be assumed by ECS") {
+		t.Fatalf("should fail for a role ECS can't assume: %v", err)
+	}
+
+	document = `{"Statement":[{"Action":"sts:AssumeRole","Effect":"Allow","Principal":{"Service":["ecs.aliyuncs.com"]}}],"Version":"1"}`
+	if err := step.validateRamRole(state); err != nil {
+		t.Fatalf("shouldn't fail for a role ECS can assume: %s", err)
+	}
+}
+
+func TestStepPreValidate_validateInstanceType(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeAvailableResource" || params.Get("ZoneId") != "cn-test-a" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("ZoneId"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","AvailableZones":{"AvailableZone":[{"ZoneId":"cn-test-a","Status":"Available",` +
+			`"AvailableResources":{"AvailableResource":[{"Type":"InstanceType","SupportedResources":{"SupportedResource":[` +
+			`{"Value":"ecs.n1.tiny","Status":"SoldOut"},{"Value":"ecs.n1.small","Status":"Available"}]}}]}}]}}`
+	})
+	state := testState(client, testCreateInstanceConfig())
+
+	step := &stepPreValidate{
+		InstanceType: "ecs.n1.small",
+		ZoneId:       "cn-test-a",
+	}
+	if err := step.validateInstanceType(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	step.InstanceType = "ecs.n1.tiny"
+	err := step.validateInstanceType(state)
+	if err == nil || !strings.Contains(err.Error(), "is sold out in cn-test-a, available instance types include ecs.n1.small") {
+		t.Fatalf("should fail for a sold out instance type: %v", err)
+	}
+
+	step.SkipInstanceTypeValidation = true
+	if err := step.validateInstanceType(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestStepPreValidate_validateKeyPair(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeKeyPairs" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		if params.Get("KeyPairName") == "packer" {
+			return http.StatusOK, `{"RequestId":"test-request","KeyPairs":{"KeyPair":[{"KeyPairName":"packer"}]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","KeyPairs":{"KeyPair":[]}}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	state := testState(client, config)
+
+	step := &stepPreValidate{KeyPairName: "packer"}
+	if err := step.validateKeyPair(state); err != nil {
+		t.Fatalf("shouldn't fail for an existing key pair: %s", err)
+	}
+
+	step.KeyPairName = "missing"
+	err := step.validateKeyPair(state)
+	if err == nil || !strings.Contains(err.Error(), "doesn't exist in region cn-test") {
+		t.Fatalf("should fail for a missing key pair: %v", err)
+	}
+}
+
+func TestStepPreValidate_validateDedicatedHost(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeDedicatedHosts" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		if params.Get("DedicatedHostIds") == `["dh-test"]` {
+			return http.StatusOK, `{"RequestId":"test-request","DedicatedHosts":{"DedicatedHost":[{"DedicatedHostId":"dh-test",` +
+				`"ZoneId":"cn-test-a","DedicatedHostType":"ddh.g6","SupportedInstanceTypeFamilies":{"SupportedInstanceTypeFamily":["ecs.g6"]}}]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","DedicatedHosts":{"DedicatedHost":[]}}`
+	})
+	config := &Config{}
+	config.ApsaraStackRegion = "cn-test"
+	state := testState(client, config)
+
+	step := &stepPreValidate{DedicatedHostId: "dh-test", InstanceType: "ecs.g6.large"}
+	if err := step.validateDedicatedHost(state); err != nil {
+		t.Fatalf("shouldn't fail for a supported instance type: %s", err)
+	}
+	if state.Get("zoneid") != "cn-test-a" {
+		t.Fatalf("the zone of the host should be used: %v", state.Get("zoneid"))
+	}
+
+	cases := []struct {
+		step   *stepPreValidate
+		reason string
+	}{
+		{&stepPreValidate{DedicatedHostId: "dh-test", InstanceType: "ecs.c6.large"}, "doesn't support the instance type ecs.c6.large"},
+		{&stepPreValidate{DedicatedHostId: "dh-test", InstanceType: "ecs.g6.large", ZoneId: "cn-test-b"}, "is in zone cn-test-a"},
+		{&stepPreValidate{DedicatedHostId: "dh-missing", InstanceType: "ecs.g6.large"}, "doesn't exist in region cn-test"},
+	}
+	for _, c := range cases {
+		err := c.step.validateDedicatedHost(state)
+		if err == nil || !strings.Contains(err.Error(), c.reason) {
+			t.Fatalf("expected an error containing %q, actual: %v", c.reason, err)
+		}
+	}
+}
+
+func TestStepPreValidate_validateDataDiskSnapshots(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeSnapshots" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Snapshots":{"Snapshot":[` +
+			`{"SnapshotId":"s-ready","Status":"accomplished","SourceDiskSize":"40"},` +
+			`{"SnapshotId":"s-progressing","Status":"progressing","SourceDiskSize":"40"},` +
+			`{"SnapshotId":"s-large","Status":"accomplished","SourceDiskSize":"3000"}]}}`
+	})
+	state := testState(client, &Config{})
+
+	step := &stepPreValidate{
+		DataDisks: []ApsaraStackDiskDevice{
+			{DiskSize: 100},
+			{SnapshotId: "s-ready", DiskSize: 40},
+			{SnapshotId: "s-ready"},
+		},
+	}
+	if err := step.validateDataDiskSnapshots(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	step.DataDisks = []ApsaraStackDiskDevice{
+		{SnapshotId: "s-ready", DiskSize: 20},
+		{SnapshotId: "s-progressing"},
+		{SnapshotId: "s-missing"},
+		{SnapshotId: "s-large", DiskCategory: DiskCategoryCloud},
+	}
+	err := step.validateDataDiskSnapshots(state)
+	if err == nil {
+		t.Fatalf("the bad snapshots should be reported")
+	}
+	for _, expected := range []string{"image_disk_mappings[0]: disk_size 20", "image_disk_mappings[1]: the snapshot s-progressing", "image_disk_mappings[2]: the snapshot s-missing",
+		"image_disk_mappings[3]: the 3000 GB of the snapshot s-large"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("%q should be reported: %s", expected, err)
+		}
+	}
+}
+
+func TestStepPreValidate_validateCustomSize(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeAvailableResource" || params.Get("InstanceType") != "ecs.n1.small" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("InstanceType"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		if params.Get("Cores") != "2" {
+			return http.StatusOK, `{"RequestId":"test-request","AvailableZones":{"AvailableZone":[]}}`
+		}
+		return http.StatusOK, `{"RequestId":"test-request","AvailableZones":{"AvailableZone":[{"ZoneId":"cn-test-a","Status":"Available",` +
+			`"AvailableResources":{"AvailableResource":[{"Type":"InstanceType","SupportedResources":{"SupportedResource":[` +
+			`{"Value":"ecs.n1.small","Status":"Available"}]}}]}}]}}`
+	})
+	config := testCreateInstanceConfig()
+	config.Cpu = 2
+	config.Memory = 6
+	state := testState(client, config)
+
+	step := &stepPreValidate{InstanceType: "ecs.n1.small"}
+	if err := step.validateCustomSize(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config.Cpu != 2 || config.Memory != 6 {
+		t.Fatalf("an offered size should be kept: %d %d", config.Cpu, config.Memory)
+	}
+
+	config.Cpu = 3
+	if err := step.validateCustomSize(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config.Cpu != 0 || config.Memory != 0 {
+		t.Fatalf("a size which isn't offered should be ignored: %d %d", config.Cpu, config.Memory)
+	}
+}
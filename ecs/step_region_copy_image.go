@@ -3,19 +3,34 @@ package ecs
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	confighelper "github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/helper/multistep"
 	"github.com/hashicorp/packer/packer"
 )
 
+// The number of regions the image is copied to at the same time, unless
+// image_copy_concurrency says otherwise.
+const defaultImageCopyConcurrency = 3
+
 type stepRegionCopyApsaraStackImage struct {
 	ApsaraStackImageDestinationRegions []string
 	ApsaraStackImageDestinationNames   []string
 	RegionId                           string
+	WaitTimeout                        int
+	Concurrency                        int
+	finished                           map[string]bool
+	lock                               sync.Mutex
+}
+
+type imageCopyTarget struct {
+	regionId  string
+	imageName string
 }
 
 func (s *stepRegionCopyApsaraStackImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -30,14 +45,13 @@ func (s *stepRegionCopyApsaraStackImage) Run(ctx context.Context, state multiste
 		return multistep.ActionContinue
 	}
 
-	client := state.Get("client").(*ClientWrapper)
 	ui := state.Get("ui").(packer.Ui)
 
 	srcImageId := state.Get("ApsaraStackimage").(string)
 	ApsaraStackImages := state.Get("ApsaraStackimages").(map[string]string)
 	numberOfName := len(s.ApsaraStackImageDestinationNames)
 
-	ui.Say(fmt.Sprintf("Coping image %s from %s...", srcImageId, s.RegionId))
+	var targets []imageCopyTarget
 	for index, destinationRegion := range s.ApsaraStackImageDestinationRegions {
 		if destinationRegion == s.RegionId && config.ImageEncrypted == confighelper.TriUnset {
 			continue
@@ -47,37 +61,198 @@ func (s *stepRegionCopyApsaraStackImage) Run(ctx context.Context, state multiste
 		if numberOfName > 0 && index < numberOfName {
 			ecsImageName = s.ApsaraStackImageDestinationNames[index]
 		}
+		targets = append(targets, imageCopyTarget{regionId: destinationRegion, imageName: ecsImageName})
+	}
 
-		copyImageRequest := ecs.CreateCopyImageRequest()
-		copyImageRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
-		copyImageRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	ui.Say(fmt.Sprintf("Coping image %s from %s...", srcImageId, s.RegionId))
 
-		copyImageRequest.RegionId = s.RegionId
-		copyImageRequest.ImageId = srcImageId
-		copyImageRequest.DestinationRegionId = destinationRegion
-		copyImageRequest.DestinationImageName = ecsImageName
-		if config.ImageEncrypted != confighelper.TriUnset {
-			copyImageRequest.Encrypted = requests.NewBoolean(config.ImageEncrypted.True())
-		}
+	// The first failed copy cancels the others, Cleanup then removes the
+	// copies which were started.
+	copyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		imageResponse, err := client.CopyImage(copyImageRequest)
-		if err != nil {
-			return halt(state, err, "Error copying images")
-		}
+	s.finished = make(map[string]bool)
+	var (
+		wg   sync.WaitGroup
+		errs *packer.MultiError
+	)
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultImageCopyConcurrency
+	}
+	targetsChan := make(chan imageCopyTarget)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The SDK changes its client on every request, every goroutine
+			// uses a client of its own.
+			client, err := state.Get("client").(*ClientWrapper).Clone()
+			if err != nil {
+				s.lock.Lock()
+				errs = packer.MultiErrorAppend(errs, err)
+				s.lock.Unlock()
+				cancel()
+			}
+			for target := range targetsChan {
+				if copyCtx.Err() != nil {
+					continue
+				}
+
+				// A build resumed from resume_state_file only waits for
+				// the copies which were started before.
+				s.lock.Lock()
+				imageId, copied := ApsaraStackImages[target.regionId]
+				s.lock.Unlock()
+				var err error
+				if !copied || target.regionId == s.RegionId {
+					imageId, err = s.copyImage(state, client, srcImageId, target)
+					if err == nil {
+						s.lock.Lock()
+						ApsaraStackImages[target.regionId] = imageId
+						saveResumeState(state)
+						s.lock.Unlock()
+						ui.Message(fmt.Sprintf("Copy image from %s(%s) to %s(%s)", s.RegionId, srcImageId, target.regionId, imageId))
+					}
+				}
+				if err == nil {
+					err = s.waitForCopy(copyCtx, state, client, target.regionId, imageId)
+				}
+				if err != nil {
+					s.lock.Lock()
+					if copyCtx.Err() == nil {
+						errs = packer.MultiErrorAppend(errs, fmt.Errorf("region %s: %s", target.regionId, err))
+					}
+					s.lock.Unlock()
+					cancel()
+					continue
+				}
 
-		ApsaraStackImages[destinationRegion] = imageResponse.ImageId
-		ui.Message(fmt.Sprintf("Copy image from %s(%s) to %s(%s)", s.RegionId, srcImageId, destinationRegion, imageResponse.ImageId))
+				s.lock.Lock()
+				s.finished[target.regionId] = true
+				s.lock.Unlock()
+				ui.Message(fmt.Sprintf("Finished copying image %s to %s", imageId, target.regionId))
+			}
+		}()
 	}
 
-	if config.ImageEncrypted != confighelper.TriUnset {
-		if _, err := client.WaitForImageStatus(s.RegionId, ApsaraStackImages[s.RegionId], ImageStatusAvailable, time.Duration(APSARASTACK_DEFAULT_LONG_TIMEOUT)*time.Second, state); err != nil {
-			return halt(state, err, fmt.Sprintf("Timeout waiting image %s finish copying", ApsaraStackImages[s.RegionId]))
-		}
+	for _, target := range targets {
+		targetsChan <- target
+	}
+	close(targetsChan)
+	wg.Wait()
+
+	if errs != nil {
+		return halt(state, errs, "Error copying images")
+	}
+	if err := ctx.Err(); err != nil {
+		return halt(state, err, "Error copying images")
 	}
 
 	return multistep.ActionContinue
 }
 
+func (s *stepRegionCopyApsaraStackImage) copyImage(state multistep.StateBag, client *ClientWrapper, srcImageId string, target imageCopyTarget) (string, error) {
+	config := state.Get("config").(*Config)
+
+	copyImageRequest := ecs.CreateCopyImageRequest()
+	copyImageRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	copyImageRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	copyImageRequest.RegionId = s.RegionId
+	copyImageRequest.ImageId = srcImageId
+	copyImageRequest.DestinationRegionId = target.regionId
+	copyImageRequest.DestinationImageName = target.imageName
+	if config.ImageEncrypted != confighelper.TriUnset {
+		copyImageRequest.Encrypted = requests.NewBoolean(config.ImageEncrypted.True())
+	}
+	// KMS keys are regional, the copies in other regions use the default key.
+	if config.ImageEncrypted.True() && target.regionId == s.RegionId {
+		copyImageRequest.KMSKeyId = config.ImageKMSKeyId
+	}
+
+	imageResponse, err := client.CopyImage(copyImageRequest)
+	if err != nil {
+		return "", err
+	}
+
+	return imageResponse.ImageId, nil
+}
+
+// waitForCopy waits until the copied image is available, it gives up as soon
+// as ctx is cancelled.
+func (s *stepRegionCopyApsaraStackImage) waitForCopy(ctx context.Context, state multistep.StateBag, client *ClientWrapper, regionId string, imageId string) error {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	timeout := s.WaitTimeout
+	if timeout <= 0 {
+		timeout = APSARASTACK_DEFAULT_LONG_TIMEOUT
+	}
+
+	var failed error
+	progress := ""
+	_, err := client.WaitForExpected(&WaitForExpectArgs{
+		Context: ctx,
+		RequestFunc: func() (responses.AcsResponse, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			request := ecs.CreateDescribeImagesRequest()
+			request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs"}
+			request.ImageOwnerAlias = "self"
+			request.RegionId = regionId
+			request.ImageId = imageId
+			request.Status = ImageStatusQueried
+
+			response, err := client.DescribeImages(request)
+			if err == nil {
+				for _, image := range response.Images.Image {
+					if image.Status == ImageStatusCreateFailed {
+						failed = fmt.Errorf("copying image %s failed", imageId)
+						return response, failed
+					}
+					if image.Progress != "" && image.Progress != progress {
+						progress = image.Progress
+						ui.Message(fmt.Sprintf("Copying image %s to %s: %s", imageId, regionId, progress))
+					}
+				}
+			}
+			return response, err
+		},
+		EvalFunc: func(response responses.AcsResponse, err error) WaitForExpectEvalResult {
+			if ctx.Err() != nil || failed != nil {
+				return WaitForExpectFailToStop
+			}
+			if err != nil {
+				return WaitForExpectToRetry
+			}
+
+			for _, image := range response.(*ecs.DescribeImagesResponse).Images.Image {
+				if image.Status == ImageStatusAvailable {
+					return WaitForExpectSuccess
+				}
+			}
+
+			return WaitForExpectToRetry
+		},
+		RetryTimeout: time.Duration(timeout) * time.Second,
+	})
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed != nil {
+		return failed
+	}
+
+	return fmt.Errorf("Timeout waiting image %s finish copying: %w", imageId, err)
+}
+
 func (s *stepRegionCopyApsaraStackImage) Cleanup(state multistep.StateBag) {
 	_, cancelled := state.GetOk(multistep.StateCancelled)
 	_, halted := state.GetOk(multistep.StateHalted)
@@ -99,14 +274,36 @@ func (s *stepRegionCopyApsaraStackImage) Cleanup(state multistep.StateBag) {
 			continue
 		}
 
+		if s.finished[copiedRegionId] {
+			if keepForResume(state, copiedImageId) {
+				ui.Message(fmt.Sprintf("Keeping copied image %s in %s for resume_state_file", copiedImageId, copiedRegionId))
+				recordCleanup(state, "image", copiedImageId, errKeptForResume)
+				continue
+			}
+
+			deleteImageRequest := ecs.CreateDeleteImageRequest()
+			deleteImageRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+			deleteImageRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+			deleteImageRequest.RegionId = copiedRegionId
+			deleteImageRequest.ImageId = copiedImageId
+			_, err := client.DeleteImage(deleteImageRequest)
+			recordCleanup(state, "image", copiedImageId, err)
+			if err != nil {
+				ui.Error(fmt.Sprintf("Error deleting copied image %s in %s, it may still be around: %s", copiedImageId, copiedRegionId, err))
+			}
+			continue
+		}
+
 		cancelCopyImageRequest := ecs.CreateCancelCopyImageRequest()
 		cancelCopyImageRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
 		cancelCopyImageRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
 
 		cancelCopyImageRequest.RegionId = copiedRegionId
 		cancelCopyImageRequest.ImageId = copiedImageId
-		if _, err := client.CancelCopyImage(cancelCopyImageRequest); err != nil {
-
+		_, err := client.CancelCopyImage(cancelCopyImageRequest)
+		recordCleanup(state, "image", copiedImageId, err)
+		if err != nil {
 			ui.Error(fmt.Sprintf("Error cancelling copy image: %v", err))
 		}
 	}
@@ -0,0 +1,170 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	confighelper "github.com/hashicorp/packer/helper/config"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func testRegionCopyState(client *ClientWrapper) multistep.StateBag {
+	state := testState(client, testCreateInstanceConfig())
+	state.Put("ApsaraStackimage", "m-source")
+	state.Put("ApsaraStackimages", map[string]string{"cn-test": "m-source"})
+	return state
+}
+
+func TestStepRegionCopyImage(t *testing.T) {
+	var lock sync.Mutex
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch action {
+		case "CopyImage":
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"m-` + params.Get("DestinationRegionId") + `"}`
+		case "DescribeImages":
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"` + params.Get("ImageId") + `","Status":"Available"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testRegionCopyState(client)
+
+	step := &stepRegionCopyApsaraStackImage{
+		ApsaraStackImageDestinationRegions: []string{"cn-test-1", "cn-test-2", "cn-test-3"},
+		RegionId:                           "cn-test",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+
+	images := state.Get("ApsaraStackimages").(map[string]string)
+	for _, region := range step.ApsaraStackImageDestinationRegions {
+		if images[region] != "m-"+region {
+			t.Fatalf("bad image in %s: %v", region, images)
+		}
+	}
+}
+
+func TestStepRegionCopyImage_concurrency(t *testing.T) {
+	var lock sync.Mutex
+	copying, maxCopying := 0, 0
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "CopyImage":
+			lock.Lock()
+			copying++
+			if copying > maxCopying {
+				maxCopying = copying
+			}
+			lock.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"m-` + params.Get("DestinationRegionId") + `"}`
+		case "DescribeImages":
+			lock.Lock()
+			copying--
+			lock.Unlock()
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"` + params.Get("ImageId") + `","Status":"Available"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testRegionCopyState(client)
+
+	step := &stepRegionCopyApsaraStackImage{
+		ApsaraStackImageDestinationRegions: []string{"cn-test-1", "cn-test-2", "cn-test-3", "cn-test-4", "cn-test-5"},
+		RegionId:                           "cn-test",
+		Concurrency:                        2,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+
+	if maxCopying > 2 {
+		t.Fatalf("at most 2 regions should be copied to at the same time, actual: %d", maxCopying)
+	}
+	if images := state.Get("ApsaraStackimages").(map[string]string); len(images) != 6 {
+		t.Fatalf("every region should get a copy: %v", images)
+	}
+}
+
+func TestStepRegionCopyImage_failure(t *testing.T) {
+	var lock sync.Mutex
+	var cleaned []string
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch action {
+		case "CopyImage":
+			if params.Get("DestinationRegionId") == "cn-test-2" {
+				return http.StatusForbidden, testErrorBody("Forbidden.RegionNotSupported")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"m-` + params.Get("DestinationRegionId") + `"}`
+		case "DescribeImages":
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"` + params.Get("ImageId") + `","Status":"Available"}]}}`
+		case "CancelCopyImage", "DeleteImage":
+			cleaned = append(cleaned, params.Get("ImageId"))
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testRegionCopyState(client)
+
+	step := &stepRegionCopyApsaraStackImage{
+		ApsaraStackImageDestinationRegions: []string{"cn-test-1", "cn-test-2"},
+		RegionId:                           "cn-test",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	images := state.Get("ApsaraStackimages").(map[string]string)
+	if len(cleaned) != len(images)-1 {
+		t.Fatalf("all the started copies should be cleaned up: %v, images: %v", cleaned, images)
+	}
+}
+
+func TestStepRegionCopyImage_kmsKeyId(t *testing.T) {
+	var lock sync.Mutex
+	keys := make(map[string]string)
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch action {
+		case "CopyImage":
+			keys[params.Get("DestinationRegionId")] = params.Get("KMSKeyId")
+			return http.StatusOK, `{"RequestId":"test-request","ImageId":"m-` + params.Get("DestinationRegionId") + `"}`
+		case "DescribeImages":
+			return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"` + params.Get("ImageId") + `","Status":"Available"}]}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testRegionCopyState(client)
+	config := state.Get("config").(*Config)
+	config.ImageEncrypted = confighelper.TriTrue
+	config.ImageKMSKeyId = "key-id"
+
+	step := &stepRegionCopyApsaraStackImage{
+		ApsaraStackImageDestinationRegions: []string{"cn-test-1"},
+		RegionId:                           "cn-test",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+
+	if keys["cn-test"] != "key-id" {
+		t.Fatalf("the image in the build region should use the key: %v", keys)
+	}
+	if keys["cn-test-1"] != "" {
+		t.Fatalf("copies in other regions shouldn't use the key: %v", keys)
+	}
+}
@@ -30,14 +30,48 @@ func (s *stepRunApsaraStackInstance) Run(ctx context.Context, state multistep.St
 
 	ui.Say(fmt.Sprintf("Starting instance: %s", instance.InstanceId))
 
-	_, err := client.WaitForInstanceStatus(instance.RegionId, instance.InstanceId, InstanceStatusRunning, state)
+	_, err := client.WaitForInstanceStatus(ctx, instance.RegionId, instance.InstanceId, InstanceStatusRunning, 0, config.StatusPollInterval, state)
 	if err != nil {
-		return halt(state, err, "Timeout waiting for instance to start")
+		return halt(state, err, "Error waiting for instance to start")
+	}
+
+	if config.Ipv6AddressCount > 0 {
+		ipv6Address, err := describeInstanceIpv6Address(client, config, instance.InstanceId)
+		if err != nil {
+			return halt(state, err, "Error querying IPv6 address of instance")
+		}
+		ui.Message(fmt.Sprintf("Instance IPv6 address: %s", ipv6Address))
+		state.Put("instance_ipv6", ipv6Address)
 	}
 
 	return multistep.ActionContinue
 }
 
+// describeInstanceIpv6Address returns the first IPv6 address assigned to the
+// network interfaces of the instance.
+func describeInstanceIpv6Address(client *ClientWrapper, config *Config, instanceId string) (string, error) {
+	request := ecs.CreateDescribeNetworkInterfacesRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.InstanceId = instanceId
+	response, err := client.DescribeNetworkInterfaces(request)
+	if err != nil {
+		return "", err
+	}
+
+	for _, networkInterface := range response.NetworkInterfaceSets.NetworkInterfaceSet {
+		for _, ipv6 := range networkInterface.Ipv6Sets.Ipv6Set {
+			if ipv6.Ipv6Address != "" {
+				return ipv6.Ipv6Address, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no IPv6 address is assigned to instance %s", instanceId)
+}
+
 func (s *stepRunApsaraStackInstance) Cleanup(state multistep.StateBag) {
 	_, cancelled := state.GetOk(multistep.StateCancelled)
 	_, halted := state.GetOk(multistep.StateHalted)
@@ -75,7 +109,7 @@ func (s *stepRunApsaraStackInstance) Cleanup(state multistep.StateBag) {
 			return
 		}
 
-		_, err := client.WaitForInstanceStatus(instance.RegionId, instance.InstanceId, InstanceStatusStopped, state)
+		_, err := client.WaitForInstanceStatus(context.Background(), instance.RegionId, instance.InstanceId, InstanceStatusStopped, 0, config.StatusPollInterval, state)
 		if err != nil {
 			ui.Say(fmt.Sprintf("Error stopping instance %s, it may still be around %s", instance.InstanceId, err))
 		}
@@ -0,0 +1,48 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepRunPreImageShutdownCommands struct {
+	Commands    []string
+	DisableStop bool
+}
+
+func (s *stepRunPreImageShutdownCommands) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if len(s.Commands) == 0 {
+		return multistep.ActionContinue
+	}
+
+	comm := state.Get("communicator").(packer.Communicator)
+	ui := state.Get("ui").(packer.Ui)
+
+	for i, command := range s.Commands {
+		ui.Say(fmt.Sprintf("Running pre-image shutdown command: %s", command))
+
+		cmd := &packer.RemoteCmd{Command: command}
+		if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+			return halt(state, err, "Error running pre-image shutdown command")
+		}
+
+		status := cmd.ExitStatus()
+		// The instance is shutting down on its own, which is expected when
+		// packer doesn't stop it.
+		if status == packer.CmdDisconnect && s.DisableStop && i == len(s.Commands)-1 {
+			ui.Message("The instance disconnected, it is most likely shutting down")
+			break
+		}
+		if status != 0 {
+			err := fmt.Errorf("command %q exited with status %d", command, status)
+			return halt(state, err, "Error running pre-image shutdown command")
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepRunPreImageShutdownCommands) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,42 @@
+package ecs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestStepRunPreImageShutdownCommands(t *testing.T) {
+	comm := &packer.MockCommunicator{}
+	state := testStateWithCommunicator(comm)
+
+	step := &stepRunPreImageShutdownCommands{Commands: []string{"rm -f /etc/ssh/ssh_host_*"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+	if comm.StartCmd == nil || comm.StartCmd.Command != "rm -f /etc/ssh/ssh_host_*" {
+		t.Fatalf("the command should be run: %#v", comm.StartCmd)
+	}
+
+	comm.StartExitStatus = 1
+	if action := step.Run(context.Background(), testStateWithCommunicator(comm)); action != multistep.ActionHalt {
+		t.Fatalf("a failed command should halt the build")
+	}
+
+	comm.StartExitStatus = packer.CmdDisconnect
+	if action := step.Run(context.Background(), testStateWithCommunicator(comm)); action != multistep.ActionHalt {
+		t.Fatalf("a disconnect should halt the build when packer stops the instance")
+	}
+	step.DisableStop = true
+	if action := step.Run(context.Background(), testStateWithCommunicator(comm)); action != multistep.ActionContinue {
+		t.Fatalf("the last command may shut the instance down with disable_stop_instance")
+	}
+}
+
+func testStateWithCommunicator(comm packer.Communicator) multistep.StateBag {
+	state := testState(nil, &Config{})
+	state.Put("communicator", comm)
+	return state
+}
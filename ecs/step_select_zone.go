@@ -0,0 +1,120 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepSelectZone struct {
+	ZoneSelection string
+}
+
+func (s *stepSelectZone) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Selecting the %s zone for instance type %s...", s.ZoneSelection, config.InstanceType))
+
+	zoneIds, checkedZoneIds, err := s.availableZones(state)
+	if err != nil {
+		return halt(state, err, "Error querying available zones")
+	}
+	if len(zoneIds) == 0 {
+		return halt(state, fmt.Errorf("The instance type %s with system disk category %q has no capacity in any zone of %s, checked zones: %v.",
+			config.InstanceType, config.ECSSystemDiskMapping.DiskCategory, config.ApsaraStackRegion, checkedZoneIds), "")
+	}
+
+	zoneId := zoneIds[0]
+	if s.ZoneSelection == ZoneSelectionCheapest {
+		var cheapest float64
+		var currency string
+		for i, candidate := range zoneIds {
+			price, err := s.describePrice(state, candidate)
+			if err != nil {
+				return halt(state, err, fmt.Sprintf("Error querying the price in zone %s", candidate))
+			}
+			ui.Message(fmt.Sprintf("Estimated price in zone %s: %.4f %s", candidate, price.TradePrice, price.Currency))
+
+			if i == 0 || price.TradePrice < cheapest {
+				zoneId, cheapest, currency = candidate, price.TradePrice, price.Currency
+			}
+		}
+		ui.Message(fmt.Sprintf("Selected zone %s with the estimated price %.4f %s", zoneId, cheapest, currency))
+	} else {
+		ui.Message(fmt.Sprintf("Selected zone %s out of the available zones %v", zoneId, zoneIds))
+	}
+
+	state.Put("zoneid", zoneId)
+	return multistep.ActionContinue
+}
+
+// availableZones returns the zones, in the order of the API, where the
+// instance type and the system disk category can be created, along with all
+// the zones which were checked.
+func (s *stepSelectZone) availableZones(state multistep.StateBag) ([]string, []string, error) {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+
+	request := ecs.CreateDescribeAvailableResourceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = config.ApsaraStackRegion
+	request.DestinationResource = "InstanceType"
+	request.InstanceType = config.InstanceType
+	request.SystemDiskCategory = config.ECSSystemDiskMapping.DiskCategory
+	request.InstanceChargeType = config.InstanceChargeType
+
+	response, err := client.DescribeAvailableResource(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var zoneIds, checkedZoneIds []string
+	for _, zone := range response.AvailableZones.AvailableZone {
+		checkedZoneIds = append(checkedZoneIds, zone.ZoneId)
+		if zone.Status != "Available" {
+			continue
+		}
+		for _, resource := range zone.AvailableResources.AvailableResource {
+			if resource.Type != "InstanceType" {
+				continue
+			}
+			for _, supported := range resource.SupportedResources.SupportedResource {
+				if supported.Value == config.InstanceType && supported.Status == "Available" {
+					zoneIds = append(zoneIds, zone.ZoneId)
+				}
+			}
+		}
+	}
+
+	return zoneIds, checkedZoneIds, nil
+}
+
+func (s *stepSelectZone) describePrice(state multistep.StateBag, zoneId string) (ecs.Price, error) {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+
+	request := ecs.CreateDescribePriceRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	// The SDK doesn't know the ZoneId parameter of DescribePrice yet.
+	request.QueryParams["ZoneId"] = zoneId
+	request.RegionId = config.ApsaraStackRegion
+	request.InstanceType = config.InstanceType
+	request.SystemDiskCategory = config.ECSSystemDiskMapping.DiskCategory
+
+	response, err := client.DescribePrice(request)
+	if err != nil {
+		return ecs.Price{}, err
+	}
+
+	return response.PriceInfo.Price, nil
+}
+
+func (s *stepSelectZone) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,74 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepSelectZone(t *testing.T) {
+	prices := map[string]string{"cn-test-a": "0.5", "cn-test-c": "0.3", "cn-test-d": "0.3"}
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeAvailableResource":
+			zone := func(zoneId, status, instanceStatus string) string {
+				return fmt.Sprintf(`{"ZoneId":"%s","Status":"%s","AvailableResources":{"AvailableResource":[{"Type":"InstanceType",`+
+					`"SupportedResources":{"SupportedResource":[{"Value":"ecs.n1.tiny","Status":"%s"}]}}]}}`, zoneId, status, instanceStatus)
+			}
+			return http.StatusOK, `{"RequestId":"test-request","AvailableZones":{"AvailableZone":[` +
+				zone("cn-test-a", "Available", "Available") + "," +
+				zone("cn-test-b", "Available", "SoldOut") + "," +
+				zone("cn-test-c", "Available", "Available") + "," +
+				zone("cn-test-d", "Available", "Available") + "]}}"
+		case "DescribePrice":
+			price, ok := prices[params.Get("ZoneId")]
+			if !ok {
+				t.Errorf("unexpected zone: %s", params.Get("ZoneId"))
+				return http.StatusBadRequest, testErrorBody("InvalidAction")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","PriceInfo":{"Price":{"TradePrice":` + price + `,"Currency":"CNY"}}}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	config := testCreateInstanceConfig()
+
+	for selection, expected := range map[string]string{
+		ZoneSelectionFirstAvailable: "cn-test-a",
+		ZoneSelectionCheapest:       "cn-test-c",
+	} {
+		state := testState(client, config)
+		step := &stepSelectZone{ZoneSelection: selection}
+		if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+			t.Fatalf("bad action: %s", state.Get("error"))
+		}
+		if zoneId := state.Get("zoneid").(string); zoneId != expected {
+			t.Fatalf("bad zone for %s, expected: %s, actual: %s", selection, expected, zoneId)
+		}
+	}
+}
+
+func TestStepSelectZone_noCapacity(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeAvailableResource" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","AvailableZones":{"AvailableZone":[` +
+			`{"ZoneId":"cn-test-a","Status":"Available"},{"ZoneId":"cn-test-b","Status":"SoldOut"}]}}`
+	})
+	state := testState(client, testCreateInstanceConfig())
+
+	step := &stepSelectZone{ZoneSelection: ZoneSelectionFirstAvailable}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "[cn-test-a cn-test-b]") {
+		t.Fatalf("error should list the checked zones: %s", err)
+	}
+}
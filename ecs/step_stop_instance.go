@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 
@@ -13,8 +14,10 @@ import (
 )
 
 type stepStopApsaraStackInstance struct {
-	ForceStop   bool
-	DisableStop bool
+	ForceStop        bool
+	DisableStop      bool
+	ShutdownBehavior string
+	StoppedMode      string
 }
 
 func (s *stepStopApsaraStackInstance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -23,8 +26,31 @@ func (s *stepStopApsaraStackInstance) Run(ctx context.Context, state multistep.S
 	instance := state.Get("instance").(*ecs.Instance)
 	ui := state.Get("ui").(packer.Ui)
 
-	if !s.DisableStop {
-		ui.Say(fmt.Sprintf("Stopping instance: %s", instance.InstanceId))
+	stopped, err := s.isStopped(state, instance.InstanceId)
+	if err != nil {
+		return halt(state, err, "Error querying ApsaraStack instance status")
+	}
+
+	if stopped && !s.DisableStop {
+		if s.ShutdownBehavior == ShutdownBehaviorRelease {
+			err := fmt.Errorf("instance %s was shut down from the OS and shutdown_behavior is %s", instance.InstanceId, ShutdownBehaviorRelease)
+			return halt(state, err, "")
+		}
+		ui.Say(fmt.Sprintf("Instance was already stopped from the OS: %s", instance.InstanceId))
+	}
+
+	// StoppedMode only applies to VPC instances stopped through the API.
+	stoppedMode := ""
+	if !s.DisableStop && !stopped && state.Get("networktype").(InstanceNetWork) == InstanceNetworkVpc {
+		stoppedMode = s.StoppedMode
+	}
+
+	if !s.DisableStop && !stopped {
+		if stoppedMode != "" {
+			ui.Say(fmt.Sprintf("Stopping instance %s in %s mode", instance.InstanceId, stoppedMode))
+		} else {
+			ui.Say(fmt.Sprintf("Stopping instance: %s", instance.InstanceId))
+		}
 
 		stopInstanceRequest := ecs.CreateStopInstanceRequest()
 		stopInstanceRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
@@ -32,6 +58,7 @@ func (s *stepStopApsaraStackInstance) Run(ctx context.Context, state multistep.S
 
 		stopInstanceRequest.InstanceId = instance.InstanceId
 		stopInstanceRequest.ForceStop = requests.Boolean(strconv.FormatBool(s.ForceStop))
+		stopInstanceRequest.StoppedMode = stoppedMode
 		if _, err := client.StopInstance(stopInstanceRequest); err != nil {
 			return halt(state, err, "Error stopping ApsaraStack instance")
 		}
@@ -39,14 +66,60 @@ func (s *stepStopApsaraStackInstance) Run(ctx context.Context, state multistep.S
 
 	ui.Say(fmt.Sprintf("Waiting instance stopped: %s", instance.InstanceId))
 
-	_, err := client.WaitForInstanceStatus(instance.RegionId, instance.InstanceId, InstanceStatusStopped, state)
+	response, err := client.WaitForInstanceStatus(ctx, instance.RegionId, instance.InstanceId, InstanceStatusStopped, 0, config.StatusPollInterval, state)
 	if err != nil {
 		return halt(state, err, "Error waiting for ApsaraStack instance to stop")
 	}
+	if stoppedMode != "" {
+		ui.Message(stoppedModeMessage(instance, response.(*ecs.DescribeInstancesResponse), stoppedMode))
+	}
 
 	return multistep.ActionContinue
 }
 
+func (s *stepStopApsaraStackInstance) isStopped(state multistep.StateBag, instanceId string) (bool, error) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	describeInstancesRequest := ecs.CreateDescribeInstancesRequest()
+	describeInstancesRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeInstancesRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs"}
+
+	describeInstancesRequest.InstanceIds = fmt.Sprintf("[\"%s\"]", instanceId)
+	instances, err := client.DescribeInstances(describeInstancesRequest)
+	if err != nil {
+		return false, err
+	}
+
+	for _, instance := range instances.Instances.Instance {
+		if instance.Status == InstanceStatusStopped {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// stoppedModeMessage tells what the stopped mode did to the instance, the
+// private IP it had before stopping is compared with the one of the stopped
+// instance.
+func stoppedModeMessage(instance *ecs.Instance, response *ecs.DescribeInstancesResponse, stoppedMode string) string {
+	if stoppedMode == StoppedModeKeepCharging {
+		return fmt.Sprintf("Instance %s was stopped in %s mode, its resources and private IP are kept", instance.InstanceId, stoppedMode)
+	}
+
+	privateIps := instance.VpcAttributes.PrivateIpAddress.IpAddress
+	var stoppedPrivateIps []string
+	for _, stopped := range response.Instances.Instance {
+		stoppedPrivateIps = append(stoppedPrivateIps, stopped.VpcAttributes.PrivateIpAddress.IpAddress...)
+	}
+	if len(privateIps) > 0 && len(stoppedPrivateIps) == 0 {
+		return fmt.Sprintf("Instance %s was stopped in %s mode, its private IP %s was released", instance.InstanceId, stoppedMode, strings.Join(privateIps, ", "))
+	}
+
+	return fmt.Sprintf("Instance %s was stopped in %s mode, its vCPUs and memory were released and its private IP is kept", instance.InstanceId, stoppedMode)
+}
+
 func (s *stepStopApsaraStackInstance) Cleanup(multistep.StateBag) {
 	// No cleanup...
 }
@@ -0,0 +1,67 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepStopInstance_stoppedMode(t *testing.T) {
+	for _, c := range []struct {
+		networkType InstanceNetWork
+		expected    string
+	}{
+		{networkType: InstanceNetworkVpc, expected: StoppedModeStopCharging},
+		{networkType: InstanceNetworkClassic, expected: ""},
+	} {
+		status := InstanceStatusRunning
+		stoppedMode := "unset"
+		client := testClient(t, func(action string, params url.Values) (int, string) {
+			switch action {
+			case "DescribeInstances":
+				return http.StatusOK, `{"RequestId":"test-request","Instances":{"Instance":[{"InstanceId":"i-test","Status":"` + status + `"}]}}`
+			case "StopInstance":
+				stoppedMode = params.Get("StoppedMode")
+				status = InstanceStatusStopped
+				return http.StatusOK, `{"RequestId":"test-request"}`
+			}
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		})
+		state := testState(client, &Config{})
+		state.Put("networktype", c.networkType)
+		state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+		step := &stepStopApsaraStackInstance{StoppedMode: StoppedModeStopCharging}
+		if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+			t.Fatalf("bad action: %s, %v", action, state.Get("error"))
+		}
+		if stoppedMode != c.expected {
+			t.Fatalf("%s: bad stopped mode, expected %q, got %q", c.networkType, c.expected, stoppedMode)
+		}
+	}
+}
+
+func TestStoppedModeMessage(t *testing.T) {
+	instance := &ecs.Instance{InstanceId: "i-test"}
+	instance.VpcAttributes.PrivateIpAddress.IpAddress = []string{"192.168.0.10"}
+	stopped := &ecs.DescribeInstancesResponse{}
+	stopped.Instances.Instance = []ecs.Instance{{InstanceId: "i-test"}}
+
+	if message := stoppedModeMessage(instance, stopped, StoppedModeStopCharging); !strings.Contains(message, "private IP 192.168.0.10 was released") {
+		t.Fatalf("the released private IP should be reported: %s", message)
+	}
+
+	stopped.Instances.Instance[0].VpcAttributes.PrivateIpAddress.IpAddress = []string{"192.168.0.10"}
+	if message := stoppedModeMessage(instance, stopped, StoppedModeStopCharging); !strings.Contains(message, "private IP is kept") {
+		t.Fatalf("the kept private IP should be reported: %s", message)
+	}
+	if message := stoppedModeMessage(instance, stopped, StoppedModeKeepCharging); !strings.Contains(message, "KeepCharging") {
+		t.Fatalf("the stopped mode should be reported: %s", message)
+	}
+}
@@ -0,0 +1,92 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// stepTagDisks tags the system disk and the data disks of the instance.
+type stepTagDisks struct {
+	Tags     map[string]string
+	RegionId string
+}
+
+func (s *stepTagDisks) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if len(s.Tags) == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	// The disks are worth less than the build, so tagging errors are only
+	// reported.
+	diskIds, err := s.describeDisks(state, instance.InstanceId)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error querying the disks of instance %s, continuing without disk tags: %s", instance.InstanceId, err))
+		return multistep.ActionContinue
+	}
+	if len(diskIds) == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Adding tags(%s) to disks: %s", s.Tags, diskIds))
+	if err := s.tagDisks(state, diskIds); err != nil {
+		ui.Error(fmt.Sprintf("Error adding tags to disks %s, continuing without them: %s", diskIds, err))
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepTagDisks) describeDisks(state multistep.StateBag, instanceId string) ([]string, error) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	request := ecs.CreateDescribeDisksRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	request.RegionId = s.RegionId
+	request.InstanceId = instanceId
+	response, err := client.DescribeDisks(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var diskIds []string
+	for _, disk := range response.Disks.Disk {
+		diskIds = append(diskIds, disk.DiskId)
+	}
+
+	return diskIds, nil
+}
+
+// tagDisks tags all disks in a single request, the tags already on the disks
+// which aren't among the tags are kept.
+func (s *stepTagDisks) tagDisks(state multistep.StateBag, diskIds []string) error {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	var tags []ecs.TagResourcesTag
+	for _, key := range sortedKeys(s.Tags) {
+		tags = append(tags, ecs.TagResourcesTag{Key: key, Value: s.Tags[key]})
+	}
+
+	request := ecs.CreateTagResourcesRequest()
+	request.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	request.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+	// The SDK has no field for RegionId yet.
+	request.QueryParams["RegionId"] = s.RegionId
+
+	request.ResourceType = TagResourceDisk
+	request.ResourceId = &diskIds
+	request.Tag = &tags
+	_, err := client.TagResources(request)
+	return err
+}
+
+func (s *stepTagDisks) Cleanup(state multistep.StateBag) {}
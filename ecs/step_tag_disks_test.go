@@ -0,0 +1,60 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepTagDisks(t *testing.T) {
+	var tagged url.Values
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		switch action {
+		case "DescribeDisks":
+			if params.Get("InstanceId") != "i-test" {
+				t.Errorf("bad describe request: %v", params)
+				return http.StatusBadRequest, testErrorBody("InvalidParameter")
+			}
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[` +
+				`{"DiskId":"d-system","Type":"system"},{"DiskId":"d-data","Type":"data"}]}}`
+		case "TagResources":
+			tagged = params
+			return http.StatusOK, `{"RequestId":"test-request"}`
+		}
+		t.Errorf("unexpected action: %s", action)
+		return http.StatusBadRequest, testErrorBody("InvalidAction")
+	})
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepTagDisks{Tags: map[string]string{"cost-center": "1234", "team": "infra"}, RegionId: "cn-test"}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", action)
+	}
+	if tagged.Get("ResourceType") != TagResourceDisk || tagged.Get("ResourceId.1") != "d-system" || tagged.Get("ResourceId.2") != "d-data" {
+		t.Fatalf("both disks should be tagged: %v", tagged)
+	}
+	if tagged.Get("Tag.1.Key") != "cost-center" || tagged.Get("Tag.2.Value") != "infra" || tagged.Get("RegionId") != "cn-test" {
+		t.Fatalf("bad tags: %v", tagged)
+	}
+}
+
+func TestStepTagDisks_error(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action == "DescribeDisks" {
+			return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[{"DiskId":"d-system"}]}}`
+		}
+		return http.StatusBadRequest, testErrorBody("InvalidTagKey.Malformed")
+	})
+	state := testState(client, &Config{})
+	state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+
+	step := &stepTagDisks{Tags: map[string]string{"team": "infra"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("failing to tag the disks shouldn't fail the build")
+	}
+}
@@ -0,0 +1,130 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepValidateResourceGroup struct {
+	Skip bool
+}
+
+// ascmResourceGroup is a resource group as listed by the ASCM API, along with
+// the department (organization) it belongs to.
+type ascmResourceGroup struct {
+	Id                int    `json:"id"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	OrganizationId    int    `json:"organizationID"`
+	OrganizationName  string `json:"organizationName"`
+}
+
+type ascmListResourceGroupResponse struct {
+	Data []ascmResourceGroup `json:"data"`
+}
+
+func (s *stepValidateResourceGroup) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	if config.ResourceGroup == "" && config.Department == "" {
+		return multistep.ActionContinue
+	}
+	if s.Skip {
+		ui.Say("Skip resource group validation flag found, skipping prevalidating resource group and department.")
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Prevalidating resource group and department...")
+
+	groups, err := listResourceGroups(state)
+	if err != nil {
+		return halt(state, err, "Error querying resource groups")
+	}
+
+	group, err := resolveResourceGroup(groups, config.ResourceGroup, config.Department)
+	if err != nil {
+		return halt(state, err, "")
+	}
+
+	// Later steps can use the resolved ids without asking ASCM again.
+	state.Put("resourcegroupid", strconv.Itoa(group.Id))
+	state.Put("departmentid", strconv.Itoa(group.OrganizationId))
+
+	return multistep.ActionContinue
+}
+
+// listResourceGroups lists the resource groups the credentials can use. The
+// SDK has no ASCM client, so it's sent as a common request.
+func listResourceGroups(state multistep.StateBag) ([]ascmResourceGroup, error) {
+	client := state.Get("client").(*ClientWrapper)
+	config := state.Get("config").(*Config)
+
+	request := requests.NewCommonRequest()
+	request.Method = requests.POST
+	request.Product = "ascm"
+	request.Version = "2019-05-10"
+	request.ApiName = "ListResourceGroup"
+	request.Domain = config.serviceEndpoint("ascm", client.Domain)
+	request.Headers["RegionId"] = config.ApsaraStackRegion
+	request.QueryParams["AccessKeySecret"] = config.ApsaraStackSecretKey
+	request.QueryParams["Product"] = "ascm"
+	request.QueryParams["Department"] = config.Department
+	request.QueryParams["ResourceGroup"] = config.ResourceGroup
+	request.QueryParams["RegionId"] = config.ApsaraStackRegion
+
+	response, err := client.ProcessCommonRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups ascmListResourceGroupResponse
+	if err := json.Unmarshal(response.GetHttpContentBytes(), &groups); err != nil {
+		return nil, fmt.Errorf("unable to parse the resource groups: %w", err)
+	}
+
+	return groups.Data, nil
+}
+
+// resolveResourceGroup finds the resource group configured by name or id,
+// in the department configured by name or id. Either may be empty.
+func resolveResourceGroup(groups []ascmResourceGroup, resourceGroup string, department string) (ascmResourceGroup, error) {
+	var inDepartment []ascmResourceGroup
+	for _, group := range groups {
+		if department == "" || department == group.OrganizationName || department == strconv.Itoa(group.OrganizationId) {
+			inDepartment = append(inDepartment, group)
+		}
+	}
+	if len(inDepartment) == 0 {
+		departments := make(map[string]string)
+		for _, group := range groups {
+			departments[fmt.Sprintf("%s (%d)", group.OrganizationName, group.OrganizationId)] = ""
+		}
+		return ascmResourceGroup{}, fmt.Errorf("the department %s isn't found, the available departments are: %s",
+			department, strings.Join(sortedKeys(departments), ", "))
+	}
+
+	if resourceGroup == "" {
+		return inDepartment[0], nil
+	}
+	for _, group := range inDepartment {
+		if resourceGroup == group.ResourceGroupName || resourceGroup == strconv.Itoa(group.Id) {
+			return group, nil
+		}
+	}
+
+	available := make(map[string]string)
+	for _, group := range inDepartment {
+		available[fmt.Sprintf("%s (%d)", group.ResourceGroupName, group.Id)] = ""
+	}
+	return ascmResourceGroup{}, fmt.Errorf("the resource group %s isn't found, the available resource groups are: %s",
+		resourceGroup, strings.Join(sortedKeys(available), ", "))
+}
+
+func (s *stepValidateResourceGroup) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,81 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func testResourceGroups() []ascmResourceGroup {
+	return []ascmResourceGroup{
+		{Id: 1, ResourceGroupName: "builds", OrganizationId: 10, OrganizationName: "platform"},
+		{Id: 2, ResourceGroupName: "images", OrganizationId: 10, OrganizationName: "platform"},
+		{Id: 3, ResourceGroupName: "builds", OrganizationId: 20, OrganizationName: "finance"},
+	}
+}
+
+func TestResolveResourceGroup(t *testing.T) {
+	cases := []struct {
+		resourceGroup string
+		department    string
+		id            int
+	}{
+		{"images", "", 2},
+		{"2", "", 2},
+		{"builds", "finance", 3},
+		{"builds", "20", 3},
+		{"", "finance", 3},
+	}
+	for _, c := range cases {
+		group, err := resolveResourceGroup(testResourceGroups(), c.resourceGroup, c.department)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if group.Id != c.id {
+			t.Fatalf("bad resource group for %q in %q: %d", c.resourceGroup, c.department, group.Id)
+		}
+	}
+}
+
+func TestResolveResourceGroup_notFound(t *testing.T) {
+	_, err := resolveResourceGroup(testResourceGroups(), "bulids", "platform")
+	if err == nil || !strings.Contains(err.Error(), "available resource groups are: builds (1), images (2)") {
+		t.Fatalf("the error should list the available resource groups: %v", err)
+	}
+
+	_, err = resolveResourceGroup(testResourceGroups(), "builds", "sales")
+	if err == nil || !strings.Contains(err.Error(), "available departments are: finance (20), platform (10)") {
+		t.Fatalf("the error should list the available departments: %v", err)
+	}
+}
+
+func TestStepValidateResourceGroup(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "ListResourceGroup" {
+			t.Errorf("unexpected action: %s", action)
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"code":"200","data":[{"id":1,"resourceGroupName":"builds","organizationID":10,"organizationName":"platform"}]}`
+	})
+	config := &Config{}
+	config.ResourceGroup = "builds"
+	config.Department = "10"
+	state := testState(client, config)
+
+	step := &stepValidateResourceGroup{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s, %s", action, state.Get("error"))
+	}
+	if state.Get("resourcegroupid") != "1" || state.Get("departmentid") != "10" {
+		t.Fatalf("the resolved ids should be in the state: %v, %v", state.Get("resourcegroupid"), state.Get("departmentid"))
+	}
+
+	config.ResourceGroup = "missing"
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %s", action)
+	}
+}
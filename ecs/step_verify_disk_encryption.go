@@ -0,0 +1,110 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepVerifyDiskEncryption struct {
+	SystemDisk ApsaraStackDiskDevice
+	Disks      []ApsaraStackDiskDevice
+	Strict     bool
+}
+
+func (s *stepVerifyDiskEncryption) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	instance := state.Get("instance").(*ecs.Instance)
+
+	ui.Say("Verifying encryption of the disks...")
+
+	describeDisksRequest := ecs.CreateDescribeDisksRequest()
+	describeDisksRequest.Headers = map[string]string{"RegionId": config.ApsaraStackRegion}
+	describeDisksRequest.QueryParams = map[string]string{"AccessKeySecret": config.ApsaraStackSecretKey, "Product": "ecs", "Department": config.Department, "ResourceGroup": config.ResourceGroup}
+
+	describeDisksRequest.RegionId = config.ApsaraStackRegion
+	describeDisksRequest.InstanceId = instance.InstanceId
+	disksResponse, err := client.DescribeDisks(describeDisksRequest)
+	if err != nil {
+		return halt(state, err, "Error describe disks")
+	}
+
+	var systemDisk *ecs.Disk
+	var dataDisks []ecs.Disk
+	for i, disk := range disksResponse.Disks.Disk {
+		if disk.Type == DiskTypeSystem {
+			systemDisk = &disksResponse.Disks.Disk[i]
+			ui.Message(fmt.Sprintf("System disk %s encrypted: %t", disk.DiskId, disk.Encrypted))
+			continue
+		}
+		dataDisks = append(dataDisks, disk)
+		ui.Message(fmt.Sprintf("Data disk %s(%s) encrypted: %t", disk.DiskId, disk.Device, disk.Encrypted))
+	}
+
+	var unencrypted []string
+	if s.SystemDisk.Encrypted.True() {
+		if systemDisk == nil {
+			unencrypted = append(unencrypted, "system disk (not found)")
+		} else if !systemDisk.Encrypted {
+			unencrypted = append(unencrypted, fmt.Sprintf("system disk (%s)", systemDisk.DiskId))
+		}
+	}
+	for _, requested := range s.Disks {
+		if !requested.Encrypted.True() {
+			continue
+		}
+
+		disk, ok := findRequestedDisk(dataDisks, requested)
+		if !ok {
+			unencrypted = append(unencrypted, fmt.Sprintf("%s (not found)", requestedDiskName(requested)))
+			continue
+		}
+		if !disk.Encrypted {
+			unencrypted = append(unencrypted, fmt.Sprintf("%s (%s)", requestedDiskName(requested), disk.DiskId))
+		}
+	}
+
+	if len(unencrypted) == 0 {
+		return multistep.ActionContinue
+	}
+
+	err = fmt.Errorf("disks requested encrypted aren't encrypted: %v", unencrypted)
+	if s.Strict {
+		return halt(state, err, "Error verifying disk encryption")
+	}
+	ui.Error(fmt.Sprintf("Warning: %s", err))
+
+	return multistep.ActionContinue
+}
+
+func (s *stepVerifyDiskEncryption) Cleanup(state multistep.StateBag) {}
+
+// findRequestedDisk looks the disk created for a disk mapping up by its
+// device, or by its name when no device was given.
+func findRequestedDisk(disks []ecs.Disk, requested ApsaraStackDiskDevice) (ecs.Disk, bool) {
+	for _, disk := range disks {
+		if requested.Device != "" && disk.Device == requested.Device {
+			return disk, true
+		}
+		if requested.Device == "" && requested.DiskName != "" && disk.DiskName == requested.DiskName {
+			return disk, true
+		}
+	}
+
+	return ecs.Disk{}, false
+}
+
+func requestedDiskName(requested ApsaraStackDiskDevice) string {
+	if requested.Device != "" {
+		return requested.Device
+	}
+	if requested.DiskName != "" {
+		return requested.DiskName
+	}
+	return "unnamed disk"
+}
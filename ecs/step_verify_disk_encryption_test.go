@@ -0,0 +1,69 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	confighelper "github.com/hashicorp/packer/helper/config"
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestStepVerifyDiskEncryption(t *testing.T) {
+	systemEncrypted := "true"
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeDisks" || params.Get("InstanceId") != "i-test" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("InstanceId"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Disks":{"Disk":[` +
+			`{"DiskId":"d-system","Device":"/dev/xvda","Type":"system","Encrypted":` + systemEncrypted + `},` +
+			`{"DiskId":"d-encrypted","Device":"/dev/xvdb","Encrypted":true},` +
+			`{"DiskId":"d-plain","Device":"/dev/xvdc","DiskName":"logs","Encrypted":false}]}}`
+	})
+
+	newState := func() multistep.StateBag {
+		state := testState(client, &Config{})
+		state.Put("instance", &ecs.Instance{InstanceId: "i-test"})
+		return state
+	}
+
+	step := &stepVerifyDiskEncryption{
+		SystemDisk: ApsaraStackDiskDevice{Encrypted: confighelper.TriTrue},
+		Disks: []ApsaraStackDiskDevice{
+			{Device: "/dev/xvdb", Encrypted: confighelper.TriTrue},
+			{DiskName: "logs", Encrypted: confighelper.TriFalse},
+		},
+		Strict: true,
+	}
+	if action := step.Run(context.Background(), newState()); action != multistep.ActionContinue {
+		t.Fatalf("encrypted disks should pass")
+	}
+
+	systemEncrypted = "false"
+	state := newState()
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("an unencrypted system disk should halt under strict_disk_encryption")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "system disk (d-system)") {
+		t.Fatalf("the unencrypted system disk should be reported: %s", err)
+	}
+	systemEncrypted = "true"
+
+	step.Disks[1].Encrypted = confighelper.TriTrue
+	state = newState()
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("an unencrypted disk should halt under strict_disk_encryption")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "d-plain") {
+		t.Fatalf("the unencrypted disk should be reported: %s", err)
+	}
+
+	step.Strict = false
+	if action := step.Run(context.Background(), newState()); action != multistep.ActionContinue {
+		t.Fatalf("an unencrypted disk should only warn without strict_disk_encryption")
+	}
+}
@@ -0,0 +1,111 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+const (
+	cloudInitStatusDone     = "done"
+	cloudInitStatusError    = "error"
+	cloudInitStatusDisabled = "disabled"
+	// Reported when the image has no cloud-init to wait for.
+	cloudInitStatusMissing = "missing"
+)
+
+// The commands print the status of cloud-init, or of cloudbase-init on
+// Windows, in the words of cloud-init.
+const (
+	cloudInitStatusCommand = "if command -v cloud-init >/dev/null 2>&1; then cloud-init status; else echo 'status: missing'; fi"
+	// cloudbase-init is a service which stops once it configured the
+	// instance.
+	cloudbaseInitStatusCommand = `powershell -NoProfile -Command "$s = Get-Service cloudbase-init -ErrorAction SilentlyContinue; ` +
+		`if (-not $s) { 'status: missing' } elseif ($s.Status -eq 'Stopped') { 'status: done' } else { 'status: running' }"`
+)
+
+type stepWaitForCloudInit struct {
+	Enabled      bool
+	Timeout      time.Duration
+	pollInterval time.Duration
+}
+
+func (s *stepWaitForCloudInit) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if !s.Enabled {
+		return multistep.ActionContinue
+	}
+
+	config := state.Get("config").(*Config)
+	comm := state.Get("communicator").(packer.Communicator)
+	ui := state.Get("ui").(packer.Ui)
+
+	command := cloudInitStatusCommand
+	if config.Comm.Type == "winrm" {
+		command = cloudbaseInitStatusCommand
+	}
+	interval := s.pollInterval
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+
+	ui.Say("Waiting for cloud-init to finish...")
+
+	waitCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	lastStatus := "unknown"
+	for {
+		status, err := s.status(waitCtx, comm, command)
+		if err == nil {
+			lastStatus = status
+		}
+
+		switch lastStatus {
+		case cloudInitStatusDone, cloudInitStatusDisabled:
+			ui.Message(fmt.Sprintf("cloud-init is %s", lastStatus))
+			return multistep.ActionContinue
+		case cloudInitStatusMissing:
+			ui.Message("The image has no cloud-init, not waiting for it")
+			return multistep.ActionContinue
+		case cloudInitStatusError:
+			return halt(state, fmt.Errorf("cloud-init finished with errors, see /var/log/cloud-init.log on the instance"), "Error waiting for cloud-init")
+		}
+
+		sleepContext(waitCtx, interval)
+		if ctx.Err() != nil {
+			return halt(state, ctx.Err(), "Error waiting for cloud-init")
+		}
+		if waitCtx.Err() != nil {
+			err := fmt.Errorf("timeout waiting %s for cloud-init to finish, last status: %s", s.Timeout, lastStatus)
+			return halt(state, err, "Error waiting for cloud-init")
+		}
+	}
+}
+
+// status runs the status command and returns the status it reports, such as
+// `running` or `done`.
+func (s *stepWaitForCloudInit) status(ctx context.Context, comm packer.Communicator, command string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{Command: command, Stdout: &stdout}
+	if err := comm.Start(ctx, cmd); err != nil {
+		return "", err
+	}
+	// cloud-init status exits with a non-zero status on errors, the output
+	// tells which.
+	cmd.Wait()
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "status:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "status:")), nil
+		}
+	}
+	return "", fmt.Errorf("unexpected output of %q: %s", command, stdout.String())
+}
+
+func (s *stepWaitForCloudInit) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,63 @@
+package ecs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestStepWaitForCloudInit(t *testing.T) {
+	for status, expected := range map[string]multistep.StepAction{
+		"status: done\n":     multistep.ActionContinue,
+		"status: disabled\n": multistep.ActionContinue,
+		"status: missing\n":  multistep.ActionContinue,
+		"\nstatus: error\n":  multistep.ActionHalt,
+	} {
+		comm := &packer.MockCommunicator{StartStdout: status}
+		state := testStateWithCommunicator(comm)
+		step := &stepWaitForCloudInit{Enabled: true, Timeout: time.Minute}
+		if action := step.Run(context.Background(), state); action != expected {
+			t.Fatalf("bad action for %q: %s", status, state.Get("error"))
+		}
+		if comm.StartCmd.Command != cloudInitStatusCommand {
+			t.Fatalf("bad command: %s", comm.StartCmd.Command)
+		}
+	}
+}
+
+func TestStepWaitForCloudInit_timeout(t *testing.T) {
+	comm := &packer.MockCommunicator{StartStdout: "status: running\n"}
+	state := testStateWithCommunicator(comm)
+	step := &stepWaitForCloudInit{Enabled: true, Timeout: 50 * time.Millisecond, pollInterval: 10 * time.Millisecond}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("the step should time out")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "last status: running") {
+		t.Fatalf("the last status should be reported: %s", err)
+	}
+}
+
+func TestStepWaitForCloudInit_winrm(t *testing.T) {
+	comm := &packer.MockCommunicator{StartStdout: "status: done\r\n"}
+	state := testStateWithCommunicator(comm)
+	state.Get("config").(*Config).Comm.Type = "winrm"
+	step := &stepWaitForCloudInit{Enabled: true, Timeout: time.Minute}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+	if comm.StartCmd.Command != cloudbaseInitStatusCommand {
+		t.Fatalf("bad command: %s", comm.StartCmd.Command)
+	}
+}
+
+func TestStepWaitForCloudInit_disabled(t *testing.T) {
+	comm := &packer.MockCommunicator{}
+	step := &stepWaitForCloudInit{}
+	if action := step.Run(context.Background(), testStateWithCommunicator(comm)); action != multistep.ActionContinue || comm.StartCalled {
+		t.Fatalf("the step should do nothing unless enabled")
+	}
+}
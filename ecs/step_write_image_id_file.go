@@ -0,0 +1,62 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+type stepWriteImageIdFile struct {
+	Path string
+}
+
+func (s *stepWriteImageIdFile) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	client := state.Get("client").(*ClientWrapper)
+	ui := state.Get("ui").(packer.Ui)
+	ApsaraStackImages := state.Get("ApsaraStackimages").(map[string]string)
+
+	// Copied images may still be in progress, the file must only point at
+	// images which can be used.
+	for region, imageId := range ApsaraStackImages {
+		ui.Message(fmt.Sprintf("Waiting for image %s in %s to be available...", imageId, region))
+		if _, err := client.WaitForImageStatus(ctx, region, imageId, ImageStatusAvailable, time.Duration(APSARASTACK_DEFAULT_LONG_TIMEOUT)*time.Second, state); err != nil {
+			return halt(state, err, fmt.Sprintf("Timeout waiting for image %s to be available", imageId))
+		}
+	}
+
+	content, err := imageIdFileContent(ApsaraStackImages)
+	if err != nil {
+		return halt(state, err, "Error building image_id_file")
+	}
+
+	ui.Say(fmt.Sprintf("Writing image ID to %s", s.Path))
+	if err := ioutil.WriteFile(s.Path, content, 0644); err != nil {
+		return halt(state, err, "Error writing image_id_file")
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepWriteImageIdFile) Cleanup(state multistep.StateBag) {}
+
+// imageIdFileContent returns the plain image ID when the image lives in a
+// single region, or a JSON map of region to image ID otherwise.
+func imageIdFileContent(images map[string]string) ([]byte, error) {
+	if len(images) == 1 {
+		for _, imageId := range images {
+			return []byte(imageId + "\n"), nil
+		}
+	}
+
+	content, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(content, '\n'), nil
+}
@@ -0,0 +1,56 @@
+package ecs
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/packer/helper/multistep"
+)
+
+func TestImageIdFileContent(t *testing.T) {
+	content, err := imageIdFileContent(map[string]string{"cn-test": "m-test"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(content) != "m-test\n" {
+		t.Fatalf("bad content: %s", content)
+	}
+
+	content, err = imageIdFileContent(map[string]string{"cn-test": "m-test", "cn-other": "m-other"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(content) != "{\n  \"cn-other\": \"m-other\",\n  \"cn-test\": \"m-test\"\n}\n" {
+		t.Fatalf("bad content: %s", content)
+	}
+}
+
+func TestStepWriteImageIdFile(t *testing.T) {
+	client := testClient(t, func(action string, params url.Values) (int, string) {
+		if action != "DescribeImages" || params.Get("ImageId") != "m-test" {
+			t.Errorf("unexpected action: %s %s", action, params.Get("ImageId"))
+			return http.StatusBadRequest, testErrorBody("InvalidAction")
+		}
+		return http.StatusOK, `{"RequestId":"test-request","Images":{"Image":[{"ImageId":"m-test","Status":"Available"}]}}`
+	})
+	state := testState(client, &Config{})
+	state.Put("ApsaraStackimages", map[string]string{"cn-test": "m-test"})
+
+	path := filepath.Join(t.TempDir(), "image_id")
+	step := &stepWriteImageIdFile{Path: path}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %s", state.Get("error"))
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(content) != "m-test\n" {
+		t.Fatalf("bad content: %s", content)
+	}
+}
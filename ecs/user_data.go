@@ -0,0 +1,158 @@
+package ecs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// The limit ECS puts on the base64 encoded user data.
+const maxUserDataSize = 16 * 1024
+
+const userDataBoundary = "==PACKER_USER_DATA_BOUNDARY=="
+
+// The content types of the user data parts cloud-init handles.
+var userDataContentTypes = []string{
+	"text/cloud-config",
+	"text/cloud-config-archive",
+	"text/cloud-boothook",
+	"text/jinja2",
+	"text/part-handler",
+	"text/upstart-job",
+	"text/x-include-once-url",
+	"text/x-include-url",
+	"text/x-shellscript",
+	"text/x-shellscript-per-boot",
+	"text/x-shellscript-per-instance",
+	"text/x-shellscript-per-once",
+}
+
+// userDataPart is a single document of a multi-part user data archive.
+type userDataPart struct {
+	contentType string
+	content     string
+}
+
+func isUserDataContentType(contentType string) bool {
+	for _, known := range userDataContentTypes {
+		if contentType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// buildBootstrapCloudConfig assembles a cloud-config document which runs the
+// given commands once at the first boot of the instance.
+func buildBootstrapCloudConfig(commands []string) string {
+	var buf bytes.Buffer
+	buf.WriteString("#cloud-config\nruncmd:\n")
+	for _, command := range commands {
+		buf.WriteString(fmt.Sprintf("  - %s\n", strconv.Quote(command)))
+	}
+
+	return buf.String()
+}
+
+// The header of user data which Windows instances run as a PowerShell script.
+const powerShellUserDataHeader = "[powershell]"
+
+// buildWinRMHttpsBootstrap assembles a PowerShell user data script which sets
+// up a WinRM HTTPS listener on the port with a self-signed certificate, and
+// opens the port in the Windows firewall.
+func buildWinRMHttpsBootstrap(port int, dnsName string, validityDays int) string {
+	var buf bytes.Buffer
+	buf.WriteString(powerShellUserDataHeader + "\n")
+	buf.WriteString("Set-Service -Name WinRM -StartupType Automatic\n")
+	buf.WriteString("Start-Service -Name WinRM\n")
+	buf.WriteString(fmt.Sprintf("$cert = New-SelfSignedCertificate -DnsName '%s' -CertStoreLocation Cert:\\LocalMachine\\My -NotAfter (Get-Date).AddDays(%d)\n",
+		strings.ReplaceAll(dnsName, "'", "''"), validityDays))
+	buf.WriteString("Get-ChildItem WSMan:\\localhost\\Listener | Where-Object { $_.Keys -contains 'Transport=HTTPS' } | Remove-Item -Recurse -Force\n")
+	buf.WriteString(fmt.Sprintf("New-WSManInstance -ResourceURI winrm/config/Listener -SelectorSet @{Address='*'; Transport='HTTPS'} "+
+		"-ValueSet @{Hostname='%s'; CertificateThumbprint=$cert.Thumbprint; Port='%d'}\n", strings.ReplaceAll(dnsName, "'", "''"), port))
+	buf.WriteString("Set-Item -Path WSMan:\\localhost\\Service\\Auth\\Basic -Value $true\n")
+	buf.WriteString(fmt.Sprintf("New-NetFirewallRule -DisplayName 'WinRM HTTPS %d' -Direction Inbound -Protocol TCP -LocalPort %d -Action Allow\n", port, port))
+	buf.WriteString("Restart-Service -Name WinRM\n")
+
+	return buf.String()
+}
+
+// gzipUserData compresses the user data, cloud-init detects and decompresses
+// gzip user data on its own.
+func gzipUserData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// userDataContentType guesses the MIME type cloud-init uses for a user data
+// document from its first line.
+func userDataContentType(userData string) string {
+	switch {
+	case strings.HasPrefix(userData, "#cloud-config"):
+		return "text/cloud-config"
+	case strings.HasPrefix(userData, "#include"):
+		return "text/x-include-url"
+	case strings.HasPrefix(userData, "#cloud-boothook"):
+		return "text/cloud-boothook"
+	default:
+		return "text/x-shellscript"
+	}
+}
+
+// mergeUserData combines several user data documents into a single MIME
+// multi-part archive, which cloud-init processes part by part in order.
+func mergeUserData(documents ...string) (string, error) {
+	parts := make([]userDataPart, 0, len(documents))
+	for _, document := range documents {
+		parts = append(parts, userDataPart{contentType: userDataContentType(document), content: document})
+	}
+
+	return mergeUserDataParts(parts)
+}
+
+// mergeUserDataParts is mergeUserData with the content type of every part
+// given explicitly.
+func mergeUserDataParts(parts []userDataPart) (string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(userDataBoundary); err != nil {
+		return "", err
+	}
+
+	buf.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n", userDataBoundary))
+	for _, part := range parts {
+		document := part.content
+		if strings.HasPrefix(document, "Content-Type: multipart/") {
+			return "", fmt.Errorf("user data which is already a multi-part archive can't be merged")
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.contentType))
+		header.Set("MIME-Version", "1.0")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write([]byte(document)); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}